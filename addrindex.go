@@ -0,0 +1,277 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/conformal/btcchain"
+	"github.com/conformal/btcdb"
+	"github.com/conformal/btcscript"
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+	"github.com/conformal/goleveldb/leveldb"
+	"github.com/conformal/goleveldb/leveldb/util"
+)
+
+const (
+	// addrIndexDirname is the name of the directory in the data directory
+	// the address index database is stored in.
+	addrIndexDirname = "addrindex"
+
+	// addrKeySize is the size of the key used to identify an address in the
+	// index.  It consists of a byte for the address type followed by the
+	// 20-byte hash of the address.
+	addrKeySize = 1 + 20
+
+	// addrIndexEntrySize is the size of the key of an index entry.  It
+	// consists of the entry prefix, the address key, and the height of the
+	// block and the index of the transaction within it.
+	addrIndexEntrySize = 1 + addrKeySize + 4 + 4
+)
+
+// These constants define the address types stored in the first byte of an
+// address key.  Pay-to-pubkey outputs are indexed under the pay-to-pubkey-hash
+// address of the public key.
+const (
+	addrKeyTypePubKeyHash byte = 0
+	addrKeyTypeScriptHash byte = 1
+)
+
+var (
+	// addrIndexEntryPrefix is the prefix of the keys of the index entries.
+	addrIndexEntryPrefix = byte('a')
+
+	// addrIndexBlockPrefix is the prefix of the keys which record the
+	// index entries written for each block so they can be removed when the
+	// block is disconnected.
+	addrIndexBlockPrefix = byte('b')
+)
+
+// errAddrIndexUnavailable is returned when searching the address index after it
+// failed to be updated with a block.  It is caught up with the main chain again
+// when btcd is restarted.
+var errAddrIndexUnavailable = errors.New("address index is unavailable " +
+	"after failing to update it -- restart btcd to catch it up")
+
+// addrIndexEntry describes a transaction which funds or spends an address.
+type addrIndexEntry struct {
+	height int64
+	txSha  btcwire.ShaHash
+}
+
+// addrIndex maintains an index of the main chain transactions which fund or
+// spend each address in a separate leveldb database.  The chain index it embeds
+// keeps it up to date with the main chain.
+type addrIndex struct {
+	*chainIndex
+}
+
+// addrIndexKey returns the address key used in the index for the passed
+// address.  It returns false for address types which are not indexed.
+func addrIndexKey(addr btcutil.Address) ([addrKeySize]byte, bool) {
+	var key [addrKeySize]byte
+	switch a := addr.(type) {
+	case *btcutil.AddressPubKeyHash:
+		key[0] = addrKeyTypePubKeyHash
+		copy(key[1:], a.ScriptAddress())
+
+	case *btcutil.AddressScriptHash:
+		key[0] = addrKeyTypeScriptHash
+		copy(key[1:], a.ScriptAddress())
+
+	case *btcutil.AddressPubKey:
+		key[0] = addrKeyTypePubKeyHash
+		copy(key[1:], a.AddressPubKeyHash().ScriptAddress())
+
+	default:
+		return key, false
+	}
+	return key, true
+}
+
+// addrIndexBlockKey returns the key which records the index entries written
+// for the block at the passed height.
+func addrIndexBlockKey(height int64) []byte {
+	key := make([]byte, 5)
+	key[0] = addrIndexBlockPrefix
+	binary.BigEndian.PutUint32(key[1:], uint32(height))
+	return key
+}
+
+// addrIndexEntryKey returns the key of the index entry for the transaction
+// with the passed index within the block at the passed height which funds or
+// spends the address with the passed address key.  The height and index are
+// big endian so the entries for an address are ordered by their position in
+// the chain.
+func addrIndexEntryKey(addrKey [addrKeySize]byte, height int64, txIdx int) []byte {
+	key := make([]byte, addrIndexEntrySize)
+	key[0] = addrIndexEntryPrefix
+	copy(key[1:], addrKey[:])
+	binary.BigEndian.PutUint32(key[1+addrKeySize:], uint32(height))
+	binary.BigEndian.PutUint32(key[1+addrKeySize+4:], uint32(txIdx))
+	return key
+}
+
+// pkScriptAddrKeys adds the address keys of the addresses paid by the passed
+// public key script to the passed set.
+func pkScriptAddrKeys(pkScript []byte, keys map[[addrKeySize]byte]struct{}) {
+	_, addrs, _, err := btcscript.ExtractPkScriptAddrs(pkScript,
+		activeNetParams.Params)
+	if err != nil {
+		return
+	}
+	for _, addr := range addrs {
+		if key, ok := addrIndexKey(addr); ok {
+			keys[key] = struct{}{}
+		}
+	}
+}
+
+// txAddrKeys returns the keys of all addresses funded or spent by the passed
+// transaction.  The transactions spent by the inputs are looked up in the
+// passed map of transactions in the same block first and the block database
+// otherwise.
+func (idx *addrIndex) txAddrKeys(tx *btcutil.Tx, blockTxns map[btcwire.ShaHash]*btcwire.MsgTx) (map[[addrKeySize]byte]struct{}, error) {
+	keys := make(map[[addrKeySize]byte]struct{})
+	msgTx := tx.MsgTx()
+	if !btcchain.IsCoinBase(tx) {
+		for _, txIn := range msgTx.TxIn {
+			prevOut := &txIn.PreviousOutpoint
+			originTx, ok := blockTxns[prevOut.Hash]
+			if !ok {
+				txList, err := idx.db.FetchTxBySha(&prevOut.Hash)
+				if err != nil || len(txList) == 0 {
+					return nil, fmt.Errorf("unable to fetch "+
+						"input transaction %v: %v",
+						prevOut.Hash, err)
+				}
+				originTx = txList[len(txList)-1].Tx
+			}
+			if prevOut.Index >= uint32(len(originTx.TxOut)) {
+				return nil, fmt.Errorf("input %v references a "+
+					"non-existent output", prevOut)
+			}
+			pkScriptAddrKeys(originTx.TxOut[prevOut.Index].PkScript,
+				keys)
+		}
+	}
+	for _, txOut := range msgTx.TxOut {
+		pkScriptAddrKeys(txOut.PkScript, keys)
+	}
+	return keys, nil
+}
+
+// indexBlock adds the entries for the transactions in the passed block to the
+// passed batch.  Part of the chainIndexer interface.
+//
+// This function MUST be called with the address index lock held.
+func (idx *addrIndex) indexBlock(batch *leveldb.Batch, block *btcutil.Block) error {
+	height := block.Height()
+	blockTxns := make(map[btcwire.ShaHash]*btcwire.MsgTx)
+	var blockEntries []byte
+	for txIdx, tx := range block.Transactions() {
+		blockTxns[*tx.Sha()] = tx.MsgTx()
+		keys, err := idx.txAddrKeys(tx, blockTxns)
+		if err != nil {
+			return err
+		}
+		for key := range keys {
+			entryKey := addrIndexEntryKey(key, height, txIdx)
+			batch.Put(entryKey, tx.Sha()[:])
+			blockEntries = append(blockEntries, entryKey...)
+		}
+	}
+	batch.Put(addrIndexBlockKey(height), blockEntries)
+	return nil
+}
+
+// unindexBlock adds the removal of all of the entries written for the passed
+// block to the passed batch.  Part of the chainIndexer interface.
+//
+// This function MUST be called with the address index lock held.
+func (idx *addrIndex) unindexBlock(batch *leveldb.Batch, block *btcutil.Block) error {
+	blockKey := addrIndexBlockKey(block.Height())
+	blockEntries, err := idx.ldb.Get(blockKey, nil)
+	if err != nil {
+		return fmt.Errorf("unable to load the entries for the block: "+
+			"%v", err)
+	}
+	for len(blockEntries) >= addrIndexEntrySize {
+		batch.Delete(blockEntries[:addrIndexEntrySize])
+		blockEntries = blockEntries[addrIndexEntrySize:]
+	}
+	batch.Delete(blockKey)
+	return nil
+}
+
+// EntriesForAddress returns the transactions which fund or spend the passed
+// address in the order they appear in the main chain.  The first skip entries
+// are skipped and at most count entries are returned.
+//
+// This function is safe for concurrent access.
+func (idx *addrIndex) EntriesForAddress(addr btcutil.Address, skip, count int) ([]addrIndexEntry, error) {
+	idx.Lock()
+	defer idx.Unlock()
+
+	if err := idx.available(); err != nil {
+		return nil, err
+	}
+
+	addrKey, ok := addrIndexKey(addr)
+	if !ok {
+		return nil, fmt.Errorf("address type %T is not indexed", addr)
+	}
+
+	// Iterate all of the entry keys which start with the address key.  The
+	// limit of the range is the prefix incremented by one, which can't
+	// overflow since the first byte is the entry prefix.
+	start := make([]byte, 1+addrKeySize)
+	start[0] = addrIndexEntryPrefix
+	copy(start[1:], addrKey[:])
+	limit := make([]byte, len(start))
+	copy(limit, start)
+	for i := len(limit) - 1; i >= 0; i-- {
+		limit[i]++
+		if limit[i] != 0 {
+			break
+		}
+	}
+
+	var entries []addrIndexEntry
+	iter := idx.ldb.NewIterator(&util.Range{Start: start, Limit: limit},
+		nil)
+	defer iter.Release()
+	for iter.Next() && len(entries) < count {
+		if skip > 0 {
+			skip--
+			continue
+		}
+
+		key := iter.Key()
+		entry := addrIndexEntry{
+			height: int64(binary.BigEndian.Uint32(key[1+addrKeySize:])),
+		}
+		copy(entry.txSha[:], iter.Value())
+		entries = append(entries, entry)
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// newAddrIndex opens (or creates when needed) the address index database for
+// the passed block database and catches it up with the main chain.
+func newAddrIndex(db btcdb.Db) (*addrIndex, error) {
+	idx := &addrIndex{}
+	idx.chainIndex = newChainIndex(db, "address index", addrIndexDirname,
+		errAddrIndexUnavailable, idx)
+	if err := idx.open(); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"github.com/conformal/btcscript"
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+	"github.com/conformal/goleveldb/leveldb"
+	"github.com/conformal/goleveldb/leveldb/storage"
+	"testing"
+)
+
+// newTestAddrIndex returns an empty address index backed by an in-memory
+// leveldb database.
+func newTestAddrIndex(t *testing.T) *addrIndex {
+	ldb, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatalf("unable to open address index database: %v", err)
+	}
+	idx := &addrIndex{}
+	idx.chainIndex = newChainIndex(nil, "address index", addrIndexDirname,
+		errAddrIndexUnavailable, idx)
+	idx.ldb = ldb
+	return idx
+}
+
+// newTestAddr returns a pay-to-pubkey-hash address with a hash made of the
+// passed byte along with the public key script which pays it.
+func newTestAddr(t *testing.T, b byte) (btcutil.Address, []byte) {
+	hash := make([]byte, 20)
+	for i := range hash {
+		hash[i] = b
+	}
+	addr, err := btcutil.NewAddressPubKeyHash(hash, activeNetParams.Params)
+	if err != nil {
+		t.Fatalf("unable to create address: %v", err)
+	}
+	pkScript, err := btcscript.PayToAddrScript(addr)
+	if err != nil {
+		t.Fatalf("unable to create script: %v", err)
+	}
+	return addr, pkScript
+}
+
+// newTestAddrIndexBlock returns a block at the passed height on top of the
+// passed block with the passed transactions.
+func newTestAddrIndexBlock(height int64, prev *btcutil.Block, txns ...*btcwire.MsgTx) *btcutil.Block {
+	var header btcwire.BlockHeader
+	header.Nonce = uint32(height)
+	if prev != nil {
+		prevHash, _ := prev.Sha()
+		header.PrevBlock = *prevHash
+	}
+	msgBlock := btcwire.NewMsgBlock(&header)
+	for _, tx := range txns {
+		msgBlock.AddTransaction(tx)
+	}
+	block := btcutil.NewBlock(msgBlock)
+	block.SetHeight(height)
+	return block
+}
+
+// newTestCoinbase returns a coinbase transaction for the block at the passed
+// height which pays the passed public key script.
+func newTestCoinbase(height int64, pkScript []byte) *btcwire.MsgTx {
+	tx := btcwire.NewMsgTx()
+	prevOut := btcwire.NewOutPoint(&btcwire.ShaHash{},
+		btcwire.MaxPrevOutIndex)
+	tx.AddTxIn(btcwire.NewTxIn(prevOut, []byte{byte(height)}))
+	tx.AddTxOut(btcwire.NewTxOut(5000000000, pkScript))
+	return tx
+}
+
+// checkAddrIndexEntries ensures the entries of the passed address in the index
+// are the passed transactions at the passed heights.
+func checkAddrIndexEntries(t *testing.T, name string, idx *addrIndex, addr btcutil.Address, heights []int64, txns []*btcwire.MsgTx) {
+	entries, err := idx.EntriesForAddress(addr, 0, 100)
+	if err != nil {
+		t.Errorf("%s: EntriesForAddress: unexpected error: %v", name,
+			err)
+		return
+	}
+	if len(entries) != len(txns) {
+		t.Errorf("%s: unexpected number of entries: got %d, want %d",
+			name, len(entries), len(txns))
+		return
+	}
+	for i, entry := range entries {
+		txSha, _ := txns[i].TxSha()
+		if entry.height != heights[i] || !entry.txSha.IsEqual(&txSha) {
+			t.Errorf("%s: unexpected entry %d: got %v at height %d, "+
+				"want %v at height %d", name, i, entry.txSha,
+				entry.height, txSha, heights[i])
+		}
+	}
+}
+
+// TestAddrIndex ensures blocks are added to and removed from the address index
+// as expected and that the index is no longer served once updating it fails.
+func TestAddrIndex(t *testing.T) {
+	addrA, pkScriptA := newTestAddr(t, 0x0a)
+	addrB, pkScriptB := newTestAddr(t, 0x0b)
+
+	// Block 0 pays address A.  Block 1 pays address B in its coinbase and
+	// has a second transaction which spends it to address A.
+	coinbase0 := newTestCoinbase(0, pkScriptA)
+	block0 := newTestAddrIndexBlock(0, nil, coinbase0)
+	coinbase1 := newTestCoinbase(1, pkScriptB)
+	coinbase1Sha, _ := coinbase1.TxSha()
+	spend := btcwire.NewMsgTx()
+	spend.AddTxIn(btcwire.NewTxIn(btcwire.NewOutPoint(&coinbase1Sha, 0),
+		nil))
+	spend.AddTxOut(btcwire.NewTxOut(5000000000, pkScriptA))
+	block1 := newTestAddrIndexBlock(1, block0, coinbase1, spend)
+
+	idx := newTestAddrIndex(t)
+	defer idx.Close()
+	idx.ConnectBlock(block0)
+	idx.ConnectBlock(block1)
+	checkAddrIndexEntries(t, "connected", idx, addrA, []int64{0, 1},
+		[]*btcwire.MsgTx{coinbase0, spend})
+	checkAddrIndexEntries(t, "connected", idx, addrB, []int64{1, 1},
+		[]*btcwire.MsgTx{coinbase1, spend})
+
+	// Ensure skip and count are applied in chain order.
+	entries, err := idx.EntriesForAddress(addrA, 1, 100)
+	if err != nil || len(entries) != 1 || entries[0].height != 1 {
+		t.Errorf("skip: unexpected entries %v (err %v)", entries, err)
+	}
+	entries, err = idx.EntriesForAddress(addrA, 0, 1)
+	if err != nil || len(entries) != 1 || entries[0].height != 0 {
+		t.Errorf("count: unexpected entries %v (err %v)", entries, err)
+	}
+
+	// Disconnecting the tip removes its entries.
+	idx.DisconnectBlock(block1)
+	checkAddrIndexEntries(t, "disconnected", idx, addrA, []int64{0},
+		[]*btcwire.MsgTx{coinbase0})
+	checkAddrIndexEntries(t, "disconnected", idx, addrB, nil, nil)
+
+	// A block which does not extend the tip fails the index, after which
+	// it is neither updated nor served.
+	idx.ConnectBlock(newTestAddrIndexBlock(5, block1,
+		newTestCoinbase(5, pkScriptA)))
+	_, err = idx.EntriesForAddress(addrA, 0, 100)
+	if err != errAddrIndexUnavailable {
+		t.Errorf("failed: unexpected error: got %v, want %v", err,
+			errAddrIndexUnavailable)
+	}
+	idx.ConnectBlock(block1)
+	if idx.tipHeight != 0 {
+		t.Errorf("failed: unexpected tip height: got %d, want 0",
+			idx.tipHeight)
+	}
+}
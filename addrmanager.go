@@ -473,14 +473,38 @@ func (a *AddrManager) savePeers() {
 	filename := "peers.json"
 	filePath := filepath.Join(cfg.DataDir, filename)
 
-	w, err := os.Create(filePath)
+	// Write the addresses to a temporary file and then rename it over the
+	// existing file so an unclean shutdown while saving never leaves a
+	// partially written file behind.
+	tmpPath := filePath + ".tmp"
+	w, err := os.Create(tmpPath)
 	if err != nil {
-		amgrLog.Error("Error opening file: ", filePath, err)
+		amgrLog.Error("Error opening file: ", tmpPath, err)
 		return
 	}
 	enc := json.NewEncoder(w)
-	defer w.Close()
-	enc.Encode(&sam)
+	if err := enc.Encode(&sam); err != nil {
+		w.Close()
+		os.Remove(tmpPath)
+		amgrLog.Errorf("Failed to encode file %s: %v", tmpPath, err)
+		return
+	}
+	if err := w.Sync(); err != nil {
+		w.Close()
+		os.Remove(tmpPath)
+		amgrLog.Errorf("Failed to sync file %s: %v", tmpPath, err)
+		return
+	}
+	if err := w.Close(); err != nil {
+		os.Remove(tmpPath)
+		amgrLog.Errorf("Failed to close file %s: %v", tmpPath, err)
+		return
+	}
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		os.Remove(tmpPath)
+		amgrLog.Errorf("Failed to rename %s to %s: %v", tmpPath,
+			filePath, err)
+	}
 }
 
 // loadPeers loads the known address from the saved file.  If empty, missing, or
@@ -496,11 +520,16 @@ func (a *AddrManager) loadPeers() {
 	err := a.deserialisePeers(filePath)
 	if err != nil {
 		amgrLog.Errorf("Failed to parse %s: %v", filePath, err)
-		// if it is invalid we nuke the old one unconditionally.
-		err = os.Remove(filePath)
+		// if it is invalid we move the old one out of the way so it
+		// can be inspected and start fresh.
+		backupPath := filePath + ".bak"
+		err = os.Rename(filePath, backupPath)
 		if err != nil {
-			amgrLog.Warn("Failed to remove corrupt peers "+
+			amgrLog.Warn("Failed to back up corrupt peers "+
 				"file: ", err)
+		} else {
+			amgrLog.Warnf("Moved corrupt peers file to %s",
+				backupPath)
 		}
 		a.reset()
 		return
@@ -535,12 +564,14 @@ func (a *AddrManager) deserialisePeers(filePath string) error {
 
 	for _, v := range sam.Addresses {
 		ka := new(knownAddress)
-		ka.na, err = deserialiseNetAddress(v.Addr)
+		ka.na, err = deserialiseNetAddress(v.Addr,
+			btcwire.SFNodeNetwork)
 		if err != nil {
 			return fmt.Errorf("failed to deserialise netaddress "+
 				"%s: %v", v.Addr, err)
 		}
-		ka.srcAddr, err = deserialiseNetAddress(v.Src)
+		ka.srcAddr, err = deserialiseNetAddress(v.Src,
+			btcwire.SFNodeNetwork)
 		if err != nil {
 			return fmt.Errorf("failed to deserialise netaddress "+
 				"%s: %v", v.Src, err)
@@ -596,7 +627,10 @@ func (a *AddrManager) deserialisePeers(filePath string) error {
 	return nil
 }
 
-func deserialiseNetAddress(addr string) (*btcwire.NetAddress, error) {
+// deserialiseNetAddress returns a netaddress for the passed host:port address
+// which advertises the passed services.  Hosts which are not IP addresses are
+// resolved.
+func deserialiseNetAddress(addr string, services btcwire.ServiceFlag) (*btcwire.NetAddress, error) {
 	host, portStr, err := net.SplitHostPort(addr)
 	if err != nil {
 		return nil, err
@@ -606,7 +640,7 @@ func deserialiseNetAddress(addr string) (*btcwire.NetAddress, error) {
 		return nil, err
 	}
 
-	return hostToNetAddress(host, uint16(port), btcwire.SFNodeNetwork)
+	return hostToNetAddress(host, uint16(port), services)
 }
 
 // Start begins the core address handler which manages a pool of known
@@ -737,6 +771,34 @@ func (a *AddrManager) AddressCache() []*btcwire.NetAddress {
 	return allAddr[:numAddresses]
 }
 
+// NodeAddresses returns up to count randomly selected addresses from the tried
+// buckets.  Only addresses which have been successfully connected to are ever
+// moved to the tried buckets, so addresses which have only been heard about from
+// other peers are never returned.  A count of 0 returns all tried addresses.
+// The returned addresses must be treated as read-only.
+func (a *AddrManager) NodeAddresses(count int) []*btcwire.NetAddress {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	addrs := make([]*btcwire.NetAddress, 0, a.nTried)
+	for _, bucket := range a.addrTried {
+		for e := bucket.Front(); e != nil; e = e.Next() {
+			addrs = append(addrs, e.Value.(*knownAddress).na)
+		}
+	}
+	if count == 0 || count > len(addrs) {
+		count = len(addrs)
+	}
+
+	// Fisher-Yates shuffle the first count addresses since the rest are
+	// thrown away.
+	for i := 0; i < count; i++ {
+		j := a.rand.Intn(len(addrs)-i) + i
+		addrs[i], addrs[j] = addrs[j], addrs[i]
+	}
+	return addrs[:count]
+}
+
 // reset resets the address manager by reinitialising the random source
 // and allocating fresh empty bucket storage.
 func (a *AddrManager) reset() {
@@ -765,23 +827,53 @@ func NewAddrManager() *AddrManager {
 	return &am
 }
 
+// isOnionHost returns whether the passed host is a tor .onion address.
+func isOnionHost(host string) bool {
+	return strings.HasSuffix(strings.ToLower(host), ".onion")
+}
+
+// onionToIP returns the OnionCat encoding of the passed tor .onion address,
+// which is how onion addresses are represented in version and addr messages.
+// Only version 2 onion addresses, which are 16 base32 characters followed by
+// .onion, fit in the 16 byte address of those messages, so an error is returned
+// for version 3 and malformed onion addresses.
+func onionToIP(host string) (net.IP, error) {
+	name := strings.ToLower(host[:len(host)-len(".onion")])
+	switch len(name) {
+	case 16:
+	case 56:
+		return nil, fmt.Errorf("version 3 onion address %s can not "+
+			"be encoded in addr messages -- only 16 character "+
+			"version 2 onion addresses are supported", host)
+	default:
+		return nil, fmt.Errorf("invalid onion address %s -- it must "+
+			"be 16 base32 characters followed by .onion", host)
+	}
+
+	// go base32 encoding uses capitals (as does the rfc
+	// but tor and bitcoind tend to user lowercase, so we switch
+	// case here.
+	data, err := base32.StdEncoding.DecodeString(strings.ToUpper(name))
+	if err != nil {
+		return nil, fmt.Errorf("invalid onion address %s -- it is "+
+			"not base32 encoded", host)
+	}
+	prefix := []byte{0xfd, 0x87, 0xd8, 0x7e, 0xeb, 0x43}
+	return net.IP(append(prefix, data...)), nil
+}
+
 // hostToNetAddress returns a netaddress given a host address. If the address is
 // a tor .onion address this will be taken care of. else if the host is not an
-// IP address it will be resolved (via tor if required).
+// IP address it will be resolved (via tor if required).  Onion addresses are
+// never resolved.
 func hostToNetAddress(host string, port uint16, services btcwire.ServiceFlag) (*btcwire.NetAddress, error) {
-	// tor address is 16 char base32 + ".onion"
 	var ip net.IP
-	if len(host) == 22 && host[16:] == ".onion" {
-		// go base32 encoding uses capitals (as does the rfc
-		// but tor and bitcoind tend to user lowercase, so we switch
-		// case here.
-		data, err := base32.StdEncoding.DecodeString(
-			strings.ToUpper(host[:16]))
+	if isOnionHost(host) {
+		var err error
+		ip, err = onionToIP(host)
 		if err != nil {
 			return nil, err
 		}
-		prefix := []byte{0xfd, 0x87, 0xd8, 0x7e, 0xeb, 0x43}
-		ip = net.IP(append(prefix, data...))
 	} else if ip = net.ParseIP(host); ip == nil {
 		ips, err := btcdLookup(host)
 		if err != nil {
@@ -1229,7 +1321,7 @@ const (
 	BoundPrio                        // Address explicitly bound to.
 	UpnpPrio                         // External IP discovered from UPnP
 	HttpPrio                         // Obtained from internet service.
-	ManualPrio                       // provided by --externalip.
+	ManualPrio                       // provided by --externalip plus its score.
 )
 
 type localAddress struct {
@@ -1243,12 +1335,11 @@ func (a *AddrManager) addLocalAddress(na *btcwire.NetAddress,
 	priority addressPrio) {
 	// sanity check.
 	if !Routable(na) {
-		amgrLog.Debugf("rejecting address %s:%d due to routability",
-			na.IP, na.Port)
+		amgrLog.Debugf("rejecting address %s due to routability",
+			NetAddressKey(na))
 		return
 	}
-	amgrLog.Debugf("adding address %s:%d",
-		na.IP, na.Port)
+	amgrLog.Debugf("adding address %s", NetAddressKey(na))
 
 	a.lamtx.Lock()
 	defer a.lamtx.Unlock()
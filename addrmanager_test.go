@@ -169,6 +169,32 @@ func TestGetAddress(t *testing.T) {
 	}
 }
 
+func TestNodeAddresses(t *testing.T) {
+	n := NewAddrManager()
+	n.AddAddressByIP("173.194.115.66:8333")
+	n.AddAddressByIP("173.194.115.67:8333")
+
+	// Addresses which have never been connected to must not be returned.
+	if rv := n.NodeAddresses(0); len(rv) != 0 {
+		t.Errorf("NodeAddresses: got %d addresses before any were "+
+			"good, want 0", len(rv))
+	}
+
+	na, err := hostToNetAddress("173.194.115.66", 8333, 0)
+	if err != nil {
+		t.Fatalf("hostToNetAddress: unexpected error: %v", err)
+	}
+	n.Good(na)
+	rv := n.NodeAddresses(0)
+	if len(rv) != 1 {
+		t.Fatalf("NodeAddresses: got %d addresses, want 1", len(rv))
+	}
+	if key := NetAddressKey(rv[0]); key != "173.194.115.66:8333" {
+		t.Errorf("NodeAddresses: got address %s, want %s", key,
+			"173.194.115.66:8333")
+	}
+}
+
 func TestIpTypes(t *testing.T) {
 	addIpTests()
 
@@ -0,0 +1,212 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// banListFilename is the name of the file in the data directory the
+	// banned IP networks are saved to.
+	banListFilename = "banlist.json"
+)
+
+// banEntry describes when a banned IP network or host was banned and when the
+// ban expires.
+type banEntry struct {
+	created time.Time
+	until   time.Time
+}
+
+// serializedBanEntry is the format a ban is saved in to the ban list file.  The
+// subnet is the host name for bans of hosts which are not IP addresses.
+type serializedBanEntry struct {
+	Subnet  string `json:"subnet"`
+	Created int64  `json:"ban_created"`
+	Until   int64  `json:"banned_until"`
+}
+
+// parseBanSubnet parses the passed IP address or IP network in CIDR notation
+// into an IP network.  A single IP address is treated as a network which only
+// contains that address.
+func parseBanSubnet(subnet string) (*net.IPNet, error) {
+	if strings.Contains(subnet, "/") {
+		_, ipNet, err := net.ParseCIDR(subnet)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subnet %q: %v", subnet,
+				err)
+		}
+		return ipNet, nil
+	}
+
+	ip := net.ParseIP(subnet)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address %q", subnet)
+	}
+	bits := 128
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		bits = 32
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// parseBanKey parses the passed IP address, IP network in CIDR notation, or
+// host name into the key the ban is stored under.  IP addresses and networks
+// are keyed by the network as returned by parseBanSubnet.  Other hosts, such
+// as .onion addresses, can't be matched by network, so they are keyed by their
+// lowercased host name instead.
+func parseBanKey(host string) (string, error) {
+	if ipNet, err := parseBanSubnet(host); err == nil {
+		return ipNet.String(), nil
+	}
+	if host == "" || strings.ContainsAny(host, "/: \t") {
+		return "", fmt.Errorf("invalid IP address, subnet, or host %q",
+			host)
+	}
+	return strings.ToLower(host), nil
+}
+
+// banListPath returns the path of the ban list file.
+func banListPath() string {
+	return filepath.Join(cfg.DataDir, banListFilename)
+}
+
+// bannedEntry returns the subnet and ban of the first unexpired ban which
+// covers the passed host.  Hosts which are not IP addresses are only covered by
+// a ban of the same host name.  Expired bans which are encountered are removed.
+// An empty subnet is returned when the host is not banned.
+func bannedEntry(banned map[string]*banEntry, host string) (string, *banEntry) {
+	now := time.Now()
+	ip := net.ParseIP(host)
+	if ip == nil {
+		key := strings.ToLower(host)
+		ban, ok := banned[key]
+		if !ok {
+			return "", nil
+		}
+		if !now.Before(ban.until) {
+			srvrLog.Infof("Ban of %s expired", key)
+			delete(banned, key)
+			return "", nil
+		}
+		return key, ban
+	}
+
+	for subnet, ban := range banned {
+		_, ipNet, err := net.ParseCIDR(subnet)
+		if err != nil || !ipNet.Contains(ip) {
+			continue
+		}
+		if !now.Before(ban.until) {
+			srvrLog.Infof("Ban of %s expired", subnet)
+			delete(banned, subnet)
+			continue
+		}
+		return subnet, ban
+	}
+	return "", nil
+}
+
+// saveBanList writes the passed unexpired bans to the ban list file in the data
+// directory.  The bans are written to a temporary file which is then renamed
+// over the existing file so an unclean shutdown while saving never leaves a
+// partially written file behind.
+func saveBanList(banned map[string]*banEntry) error {
+	now := time.Now()
+	entries := make([]serializedBanEntry, 0, len(banned))
+	for subnet, ban := range banned {
+		if !now.Before(ban.until) {
+			continue
+		}
+		entries = append(entries, serializedBanEntry{
+			Subnet:  subnet,
+			Created: ban.created.Unix(),
+			Until:   ban.until.Unix(),
+		})
+	}
+	serialized, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(cfg.DataDir, 0700); err != nil {
+		return err
+	}
+	filePath := banListPath()
+	tmpPath := filePath + ".tmp"
+	w, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC,
+		0600)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(serialized); err != nil {
+		w.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := w.Sync(); err != nil {
+		w.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := w.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// loadBanList returns the unexpired bans from the ban list file in the data
+// directory.  Bans which expired while btcd was not running are pruned.  No
+// bans are returned when the file does not exist.
+func loadBanList() (map[string]*banEntry, error) {
+	banned := make(map[string]*banEntry)
+	serialized, err := ioutil.ReadFile(banListPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return banned, nil
+		}
+		return banned, err
+	}
+
+	var entries []serializedBanEntry
+	if err := json.Unmarshal(serialized, &entries); err != nil {
+		return banned, err
+	}
+	now := time.Now()
+	var numExpired int
+	for _, entry := range entries {
+		key, err := parseBanKey(entry.Subnet)
+		if err != nil {
+			srvrLog.Warnf("Ignoring ban list entry: %v", err)
+			continue
+		}
+		until := time.Unix(entry.Until, 0)
+		if !now.Before(until) {
+			numExpired++
+			continue
+		}
+		banned[key] = &banEntry{
+			created: time.Unix(entry.Created, 0),
+			until:   until,
+		}
+	}
+	srvrLog.Infof("Loaded %d bans from %s (%d expired)", len(banned),
+		banListPath(), numExpired)
+	return banned, nil
+}
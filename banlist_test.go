@@ -0,0 +1,173 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestParseBanKey ensures IP addresses, IP networks, and other hosts are parsed
+// into the expected ban keys.
+func TestParseBanKey(t *testing.T) {
+	tests := []struct {
+		host    string
+		want    string
+		wantErr bool
+	}{
+		// IP addresses are banned as single address networks.
+		{host: "1.2.3.4", want: "1.2.3.4/32"},
+		{host: "::ffff:1.2.3.4", want: "1.2.3.4/32"},
+		{host: "2001:db8::1", want: "2001:db8::1/128"},
+
+		// Networks are normalized.
+		{host: "1.2.3.0/24", want: "1.2.3.0/24"},
+		{host: "1.2.3.4/24", want: "1.2.3.0/24"},
+		{host: "2001:db8::/32", want: "2001:db8::/32"},
+
+		// Other hosts are banned by their lowercased name.
+		{host: "abcdefghijklmnop.onion", want: "abcdefghijklmnop.onion"},
+		{host: "ABCDEFGHIJKLMNOP.onion", want: "abcdefghijklmnop.onion"},
+		{host: "seed.example.com", want: "seed.example.com"},
+
+		// Invalid.
+		{host: "", wantErr: true},
+		{host: "1.2.3.4/33", wantErr: true},
+		{host: "host/24", wantErr: true},
+		{host: "host:8333", wantErr: true},
+	}
+
+	for i, test := range tests {
+		got, err := parseBanKey(test.host)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseBanKey #%d (%s): unexpected error - got "+
+				"%v, want error %v", i, test.host, err,
+				test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("parseBanKey #%d (%s): unexpected key - got "+
+				"%q, want %q", i, test.host, got, test.want)
+		}
+	}
+}
+
+// TestBannedEntry ensures hosts are matched against the bans which cover them
+// and that expired bans are removed.
+func TestBannedEntry(t *testing.T) {
+	now := time.Now()
+	active := &banEntry{created: now, until: now.Add(time.Hour)}
+	expired := &banEntry{created: now.Add(-time.Hour), until: now}
+	banned := map[string]*banEntry{
+		"1.2.3.0/24":             active,
+		"5.6.7.8/32":             expired,
+		"abcdefghijklmnop.onion": active,
+		"qrstuvwxyz234567.onion": expired,
+	}
+
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"1.2.3.9", "1.2.3.0/24"},
+		{"1.2.4.1", ""},
+		{"5.6.7.8", ""},
+		{"abcdefghijklmnop.onion", "abcdefghijklmnop.onion"},
+		{"ABCDEFGHIJKLMNOP.ONION", "abcdefghijklmnop.onion"},
+		{"qrstuvwxyz234567.onion", ""},
+		{"bcdefghijklmnopq.onion", ""},
+	}
+
+	for i, test := range tests {
+		got, ban := bannedEntry(banned, test.host)
+		if got != test.want || (ban != nil) != (test.want != "") {
+			t.Errorf("bannedEntry #%d (%s): unexpected ban - got "+
+				"%q, want %q", i, test.host, got, test.want)
+		}
+	}
+
+	// The expired bans must have been removed.
+	for _, key := range []string{"5.6.7.8/32", "qrstuvwxyz234567.onion"} {
+		if _, ok := banned[key]; ok {
+			t.Errorf("bannedEntry: expired ban of %s was not "+
+				"removed", key)
+		}
+	}
+	if len(banned) != 2 {
+		t.Errorf("bannedEntry: unexpected number of bans - got %d, "+
+			"want 2", len(banned))
+	}
+}
+
+// TestBanListPersist ensures unexpired bans survive saving and loading the ban
+// list file while expired bans are pruned.
+func TestBanListPersist(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "banlist")
+	if err != nil {
+		t.Fatalf("unable to create data directory: %v", err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	savedCfg := cfg
+	cfg = &config{DataDir: dataDir}
+	defer func() {
+		cfg = savedCfg
+	}()
+
+	// Loading a missing ban list results in no bans.
+	banned, err := loadBanList()
+	if err != nil {
+		t.Fatalf("loadBanList: unexpected error: %v", err)
+	}
+	if len(banned) != 0 {
+		t.Fatalf("loadBanList: unexpected bans %v", banned)
+	}
+
+	// Timestamps are saved with a precision of seconds.
+	now := time.Unix(time.Now().Unix(), 0)
+	active := &banEntry{created: now, until: now.Add(time.Hour)}
+	expired := &banEntry{created: now.Add(-time.Hour), until: now}
+	banned = map[string]*banEntry{
+		"1.2.3.0/24":             active,
+		"2001:db8::1/128":        active,
+		"abcdefghijklmnop.onion": active,
+		"5.6.7.8/32":             expired,
+	}
+	if err := saveBanList(banned); err != nil {
+		t.Fatalf("saveBanList: unexpected error: %v", err)
+	}
+
+	loaded, err := loadBanList()
+	if err != nil {
+		t.Fatalf("loadBanList: unexpected error: %v", err)
+	}
+	if len(loaded) != 3 {
+		t.Errorf("loadBanList: unexpected number of bans - got %d, "+
+			"want 3", len(loaded))
+	}
+	for key, ban := range banned {
+		if ban == expired {
+			if _, ok := loaded[key]; ok {
+				t.Errorf("loadBanList: expired ban of %s was "+
+					"loaded", key)
+			}
+			continue
+		}
+		got, ok := loaded[key]
+		if !ok {
+			t.Errorf("loadBanList: ban of %s was not loaded", key)
+			continue
+		}
+		if !got.created.Equal(ban.created) ||
+			!got.until.Equal(ban.until) {
+
+			t.Errorf("loadBanList: unexpected ban of %s - got "+
+				"%v-%v, want %v-%v", key, got.created,
+				got.until, ban.created, ban.until)
+		}
+	}
+}
@@ -6,11 +6,14 @@ package main
 
 import (
 	"container/list"
+	"errors"
+	"fmt"
 	"github.com/conformal/btcchain"
 	"github.com/conformal/btcdb"
 	"github.com/conformal/btcnet"
 	"github.com/conformal/btcutil"
 	"github.com/conformal/btcwire"
+	"math"
 	"net"
 	"os"
 	"path/filepath"
@@ -31,8 +34,23 @@ const (
 	// database type is appended to this value to form the full block
 	// database name.
 	blockDbNamePrefix = "blocks"
+
+	// unrequestedBanScore is the ban score added to peers which send
+	// transactions, blocks, or headers that were not requested from them.
+	// The peers are disconnected as well, so the score only matters for
+	// banning peers which keep reconnecting and misbehaving.
+	unrequestedBanScore = 20
+
+	// maxSideChainTipAge is the number of blocks a side chain tip may be
+	// behind the main chain tip before the side chain is no longer tracked
+	// for getchaintips.
+	maxSideChainTipAge = 2016
 )
 
+// errDuplicateBlock is returned by ProcessBlock when the block is already
+// known, either as part of the block chain or as an orphan.
+var errDuplicateBlock = errors.New("already have block")
+
 // newPeerMsg signifies a newly connected peer to the block handler.
 type newPeerMsg struct {
 	peer *peer
@@ -59,6 +77,16 @@ type headersMsg struct {
 	peer    *peer
 }
 
+// headersRate tracks the rate a peer sends block headers at for the headers
+// flood limit (--maxheaderspersecond).  It is a token bucket which holds one
+// token per header.  The requested flag is set while a getheaders request sent
+// to the peer has not been answered yet.
+type headersRate struct {
+	tokens     float64
+	lastRefill time.Time
+	requested  bool
+}
+
 // donePeerMsg signifies a newly disconnected peer to the block handler.
 type donePeerMsg struct {
 	peer *peer
@@ -116,6 +144,37 @@ type processBlockMsg struct {
 	reply chan processBlockResponse
 }
 
+// chainTip describes a tip of the block tree along with the length of the
+// branch that connects it to the main chain.
+type chainTip struct {
+	hash      btcwire.ShaHash
+	height    int64
+	branchLen int64
+	status    string
+}
+
+// getChainTipsMsg is a message type to be sent across the message channel for
+// requesting all of the known chain tips.
+type getChainTipsMsg struct {
+	reply chan []chainTip
+}
+
+// invalidateBlockMsg is a message type to be sent across the message channel
+// for requesting a block be marked invalid and disconnected from the main
+// chain along with all of its descendants.
+type invalidateBlockMsg struct {
+	hash  *btcwire.ShaHash
+	reply chan error
+}
+
+// reconsiderBlockMsg is a message type to be sent across the message channel
+// for requesting a block that was previously marked invalid be considered
+// for inclusion in the main chain again.
+type reconsiderBlockMsg struct {
+	hash  *btcwire.ShaHash
+	reply chan error
+}
+
 // isCurrentMsg is a message type to be sent across the message channel for
 // requesting whether or not the block manager believes it is synced with
 // the currently connected peers.
@@ -130,18 +189,34 @@ type headerNode struct {
 	sha    *btcwire.ShaHash
 }
 
+// sideChainNode is used to track a block that has been accepted into the block
+// chain, but is not part of the main chain.  These are used to determine the
+// tips of the side chains and the lengths of their branches.  They are only
+// kept in memory, so the side chains are forgotten when btcd is restarted.  The
+// blocks themselves are kept in the side block store.
+type sideChainNode struct {
+	prevHash  btcwire.ShaHash
+	height    int64
+	validated bool // previously connected to the main chain
+	invalid   bool // manually marked invalid via invalidateblock
+}
+
 // chainState tracks the state of the best chain as blocks are inserted.  This
 // is done because btcchain is currently not safe for concurrent access and the
 // block manager is typically quite busy processing block and inventory.
 // Therefore, requesting this information from chain through the block manager
 // would not be anywhere near as efficient as simply updating it as each block
 // is inserted and protecting it with a mutex.
+//
+// The height of the highest block header downloaded in headers-first mode is
+// tracked as well since the headers are downloaded ahead of their blocks.
 type chainState struct {
 	sync.Mutex
-	newestHash        *btcwire.ShaHash
-	newestHeight      int64
-	pastMedianTime    time.Time
-	pastMedianTimeErr error
+	newestHash         *btcwire.ShaHash
+	newestHeight       int64
+	newestHeaderHeight int64
+	pastMedianTime     time.Time
+	pastMedianTimeErr  error
 }
 
 // Best returns the block hash and height known for the tip of the best known
@@ -161,6 +236,7 @@ type blockManager struct {
 	server            *server
 	started           int32
 	shutdown          int32
+	blockChainMtx     sync.RWMutex // protects the blockChain pointer.
 	blockChain        *btcchain.BlockChain
 	blockPeer         map[btcwire.ShaHash]*peer
 	requestedTxns     map[btcwire.ShaHash]bool
@@ -172,6 +248,12 @@ type blockManager struct {
 	syncPeer          *peer
 	msgChan           chan interface{}
 	chainState        chainState
+	sideChainNodes    map[btcwire.ShaHash]*sideChainNode
+	lastConnected     btcwire.ShaHash
+	invalidBlocks     map[btcwire.ShaHash]btcwire.ShaHash // parent hashes
+	sideBlocks        *sideBlockStore
+	localBlock        *btcwire.ShaHash // block submitted locally being processed
+	headersRates      map[*peer]*headersRate
 	wg                sync.WaitGroup
 	quit              chan bool
 
@@ -204,8 +286,8 @@ func (b *blockManager) resetHeaderState(newestHash *btcwire.ShaHash, newestHeigh
 // processing block and inventory.
 func (b *blockManager) updateChainState(newestHash *btcwire.ShaHash, newestHeight int64) {
 	b.chainState.Lock()
-	defer b.chainState.Unlock()
-
+	tipChanged := b.chainState.newestHash == nil ||
+		!b.chainState.newestHash.IsEqual(newestHash)
 	b.chainState.newestHash = newestHash
 	b.chainState.newestHeight = newestHeight
 	medianTime, err := b.blockChain.CalcPastMedianTime()
@@ -214,6 +296,13 @@ func (b *blockManager) updateChainState(newestHash *btcwire.ShaHash, newestHeigh
 	} else {
 		b.chainState.pastMedianTime = medianTime
 	}
+	b.chainState.Unlock()
+
+	// Wake up any getblocktemplate long polls which are waiting for the
+	// best block to change now that the chain state reflects the change.
+	if r := b.server.rpcServer; tipChanged && r != nil {
+		r.gbtWorkState.NotifyNewTip()
+	}
 }
 
 // findNextHeaderCheckpoint returns the next checkpoint after the passed height.
@@ -320,7 +409,8 @@ func (b *blockManager) startSync(peers *list.List) {
 		if b.nextCheckpoint != nil && height < b.nextCheckpoint.Height &&
 			!cfg.RegressionTest && !cfg.DisableCheckpoints {
 
-			bestPeer.PushGetHeadersMsg(locator, b.nextCheckpoint.Hash)
+			b.pushGetHeadersMsg(bestPeer, locator,
+				b.nextCheckpoint.Hash)
 			b.headersFirstMode = true
 			bmgrLog.Infof("Downloading headers for blocks %d to "+
 				"%d from peer %s", height+1,
@@ -400,6 +490,9 @@ func (b *blockManager) handleDonePeerMsg(peers *list.List, p *peer) {
 
 	bmgrLog.Infof("Lost peer %s", p)
 
+	// Stop tracking the rate the peer sent headers at.
+	delete(b.headersRates, p)
+
 	// Remove requested transactions from the global map so that they will
 	// be fetched from elsewhere next time we get an inv.
 	for k := range p.requestedTxns {
@@ -477,6 +570,8 @@ func (b *blockManager) handleTxMsg(tmsg *txMsg) {
 
 	// If we didn't ask for this transaction then the peer is misbehaving.
 	if _, ok := tmsg.peer.requestedTxns[*txHash]; !ok {
+		tmsg.peer.addBanScore(unrequestedBanScore,
+			fmt.Sprintf("sent unrequested transaction %v", txHash))
 		bmgrLog.Warnf("Got unrequested transaction %v from %s -- "+
 			"disconnecting", txHash, tmsg.peer.addr)
 		tmsg.peer.Disconnect()
@@ -484,8 +579,10 @@ func (b *blockManager) handleTxMsg(tmsg *txMsg) {
 	}
 
 	// Process the transaction to include validation, insertion in the
-	// memory pool, orphan handling, etc.
-	err := tmsg.peer.server.txMemPool.ProcessTransaction(tmsg.tx, true, true)
+	// memory pool, orphan handling, etc.  Transactions from whitelisted
+	// peers are not subject to the free transaction rate limiter.
+	err := tmsg.peer.server.txMemPool.ProcessTransaction(tmsg.tx, true,
+		!tmsg.peer.whitelisted)
 
 	// Remove transaction from request maps. Either the mempool/chain
 	// already knows about it and as such we shouldn't have any more
@@ -545,6 +642,9 @@ func (b *blockManager) handleBlockMsg(bmsg *blockMsg) {
 		// mode in this case so the chain code is actually fed the
 		// duplicate blocks.
 		if !cfg.RegressionTest {
+			bmsg.peer.addBanScore(unrequestedBanScore,
+				fmt.Sprintf("sent unrequested block %v",
+					blockSha))
 			bmgrLog.Warnf("Got unrequested block %v from %s -- "+
 				"disconnecting", blockSha, bmsg.peer.addr)
 			bmsg.peer.Disconnect()
@@ -586,8 +686,22 @@ func (b *blockManager) handleBlockMsg(bmsg *blockMsg) {
 	delete(bmsg.peer.requestedBlocks, *blockSha)
 	delete(b.requestedBlocks, *blockSha)
 
+	// Reject the block if it or its parent was manually marked invalid.
+	if b.isInvalidated(bmsg.block) {
+		delete(b.blockPeer, *blockSha)
+		bmgrLog.Infof("Rejected block %v from %s: block or one of its "+
+			"ancestors has been invalidated", blockSha, bmsg.peer)
+		return
+	}
+
 	// Process the block to include validation, best chain selection, orphan
 	// handling, etc.
+	//
+	// NOTE: The input scripts of the block are verified by btcchain while
+	// connecting it, which already spreads them across a number of
+	// goroutines based on runtime.NumCPU.  It does not provide a way to
+	// size that pool (as a --scriptverifythreads option would) or to reuse
+	// it across blocks, so those changes belong in btcchain.
 	err := b.blockChain.ProcessBlock(bmsg.block, fastAdd)
 	if err != nil {
 		delete(b.blockPeer, *blockSha)
@@ -647,7 +761,8 @@ func (b *blockManager) handleBlockMsg(bmsg *blockMsg) {
 	b.nextCheckpoint = b.findNextHeaderCheckpoint(prevHeight)
 	if b.nextCheckpoint != nil {
 		locator := btcchain.BlockLocator([]*btcwire.ShaHash{prevHash})
-		err := bmsg.peer.PushGetHeadersMsg(locator, b.nextCheckpoint.Hash)
+		err := b.pushGetHeadersMsg(bmsg.peer, locator,
+			b.nextCheckpoint.Hash)
 		if err != nil {
 			bmgrLog.Warnf("Failed to send getheaders message to "+
 				"peer %s: %v", bmsg.peer.addr, err)
@@ -712,12 +827,92 @@ func (b *blockManager) fetchHeaderBlocks() {
 	}
 }
 
+// peerHeadersRate returns the headers flood limit state of the passed peer,
+// creating it with a full bucket when needed.
+//
+// This function MUST be called from the block handler goroutine.
+func (b *blockManager) peerHeadersRate(p *peer) *headersRate {
+	hr, ok := b.headersRates[p]
+	if !ok {
+		burst := float64(cfg.MaxHeadersPerSec) +
+			btcwire.MaxBlockHeadersPerMsg
+		hr = &headersRate{tokens: burst, lastRefill: time.Now()}
+		b.headersRates[p] = hr
+	}
+	return hr
+}
+
+// pushGetHeadersMsg sends a getheaders message to the passed peer and notes
+// that a headers message answering it is expected, so the headers it contains
+// are not charged against the headers flood limit of the peer.
+//
+// This function MUST be called from the block handler goroutine.
+func (b *blockManager) pushGetHeadersMsg(p *peer, locator btcchain.BlockLocator, stopHash *btcwire.ShaHash) error {
+	b.peerHeadersRate(p).requested = true
+	return p.PushGetHeadersMsg(locator, stopHash)
+}
+
+// exceedsHeadersRate charges the passed number of headers received from the
+// peer against its headers flood limit (--maxheaderspersecond) and returns
+// whether the limit was exceeded.  A headers message which answers an
+// outstanding getheaders request is not charged at all, so a peer sending
+// full batches back to back during the initial sync is never penalized for
+// the size or the pace of the batches we asked for.  Unsolicited messages are
+// charged per header, and at least one header each so floods of empty
+// messages are limited as well.  The bucket of each peer holds a full headers
+// message worth of headers in addition to a second worth at the configured
+// rate.
+//
+// This function MUST be called from the block handler goroutine.
+func (b *blockManager) exceedsHeadersRate(p *peer, numHeaders int) bool {
+	hr := b.peerHeadersRate(p)
+	solicited := hr.requested
+	hr.requested = false
+	if cfg.MaxHeadersPerSec == 0 || solicited {
+		return false
+	}
+
+	rate := float64(cfg.MaxHeadersPerSec)
+	burst := rate + btcwire.MaxBlockHeadersPerMsg
+	now := time.Now()
+	if elapsed := now.Sub(hr.lastRefill).Seconds(); elapsed > 0 {
+		hr.tokens = math.Min(hr.tokens+elapsed*rate, burst)
+	}
+	hr.lastRefill = now
+
+	cost := float64(numHeaders)
+	if cost < 1 {
+		cost = 1
+	}
+	if hr.tokens < cost {
+		return true
+	}
+	hr.tokens -= cost
+	return false
+}
+
 // handleHeadersMsghandles headers messages from all peers.
 func (b *blockManager) handleHeadersMsg(hmsg *headersMsg) {
-	// The remote peer is misbehaving if we didn't request headers.
+	// Ban peers which flood headers faster than the limit allows.  Peers
+	// which are whitelisted or can't be banned since banning is disabled
+	// are only disconnected.
 	msg := hmsg.headers
 	numHeaders := len(msg.Headers)
+	if b.exceedsHeadersRate(hmsg.peer, numHeaders) {
+		bmgrLog.Warnf("Peer %s exceeded the limit of %d headers per "+
+			"second -- disconnecting", hmsg.peer,
+			cfg.MaxHeadersPerSec)
+		if !hmsg.peer.whitelisted && !cfg.DisableBanning {
+			b.server.BanPeer(hmsg.peer)
+		}
+		hmsg.peer.Disconnect()
+		return
+	}
+
+	// The remote peer is misbehaving if we didn't request headers.
 	if !b.headersFirstMode {
+		hmsg.peer.addBanScore(unrequestedBanScore,
+			fmt.Sprintf("sent %d unrequested headers", numHeaders))
 		bmgrLog.Warnf("Got %d unrequested headers from %s -- "+
 			"disconnecting", numHeaders, hmsg.peer.addr)
 		hmsg.peer.Disconnect()
@@ -792,6 +987,14 @@ func (b *blockManager) handleHeadersMsg(hmsg *headersMsg) {
 		}
 	}
 
+	// Keep track of the highest known header for getblockchaininfo.
+	lastNode := b.headerList.Back().Value.(*headerNode)
+	b.chainState.Lock()
+	if lastNode.height > b.chainState.newestHeaderHeight {
+		b.chainState.newestHeaderHeight = lastNode.height
+	}
+	b.chainState.Unlock()
+
 	// When this header is a checkpoint, switch to fetching the blocks for
 	// all of the headers since the last checkpoint.
 	if receivedCheckpoint {
@@ -811,7 +1014,7 @@ func (b *blockManager) handleHeadersMsg(hmsg *headersMsg) {
 	// headers starting from the latest known header and ending with the
 	// next checkpoint.
 	locator := btcchain.BlockLocator([]*btcwire.ShaHash{finalHash})
-	err := hmsg.peer.PushGetHeadersMsg(locator, b.nextCheckpoint.Hash)
+	err := b.pushGetHeadersMsg(hmsg.peer, locator, b.nextCheckpoint.Hash)
 	if err != nil {
 		bmgrLog.Warnf("Failed to send getheaders message to "+
 			"peer %s: %v", hmsg.peer.addr, err)
@@ -827,6 +1030,12 @@ func (b *blockManager) handleHeadersMsg(hmsg *headersMsg) {
 func (b *blockManager) haveInventory(invVect *btcwire.InvVect) bool {
 	switch invVect.Type {
 	case btcwire.InvTypeBlock:
+		// Blocks which were manually marked invalid are no longer known
+		// to chain, but there is no point in requesting them again.
+		if _, ok := b.invalidBlocks[invVect.Hash]; ok {
+			return true
+		}
+
 		// Ask chain if the block is known to it in any form (main
 		// chain, side chain, or orphan).
 		return b.blockChain.HaveBlock(&invVect.Hash)
@@ -879,6 +1088,14 @@ func (b *blockManager) handleInvMsg(imsg *invMsg) {
 			continue
 		}
 
+		// Ignore transaction inventory from peers that are not
+		// whitelisted when running in blocks only mode.
+		if iv.Type == btcwire.InvTypeTx && cfg.BlocksOnly &&
+			!imsg.peer.whitelisted {
+
+			continue
+		}
+
 		// Add the inventory to the cache of known inventory
 		// for the peer.
 		imsg.peer.AddKnownInventory(iv)
@@ -1026,12 +1243,39 @@ out:
 				}
 
 			case processBlockMsg:
+				if b.isInvalidated(msg.block) {
+					blockSha, _ := msg.block.Sha()
+					msg.reply <- processBlockResponse{
+						err: fmt.Errorf("block %v or one "+
+							"of its ancestors has been "+
+							"invalidated", blockSha),
+					}
+					continue
+				}
+
+				// Report blocks which are already known
+				// distinctly from blocks which are rejected.
+				blockSha, _ := msg.block.Sha()
+				if b.blockChain.HaveBlock(blockSha) {
+					msg.reply <- processBlockResponse{
+						err: errDuplicateBlock,
+					}
+					continue
+				}
+
+				// Keep track of the block while it is
+				// processed since locally submitted blocks,
+				// such as mined blocks, are never subject to
+				// the block relay delay.
+				b.localBlock = blockSha
 				err := b.blockChain.ProcessBlock(msg.block, false)
+				b.localBlock = nil
 				if err != nil {
 					msg.reply <- processBlockResponse{
 						isOrphan: false,
 						err:      err,
 					}
+					continue
 				}
 
 				// Query the db for the latest best block since
@@ -1040,13 +1284,21 @@ out:
 				newestSha, newestHeight, _ := b.server.db.NewestSha()
 				b.updateChainState(newestSha, newestHeight)
 
-				blockSha, _ := msg.block.Sha()
 				msg.reply <- processBlockResponse{
 					isOrphan: b.blockChain.IsKnownOrphan(
 						blockSha),
 					err: nil,
 				}
 
+			case getChainTipsMsg:
+				msg.reply <- b.chainTips()
+
+			case invalidateBlockMsg:
+				msg.reply <- b.invalidateBlock(msg.hash)
+
+			case reconsiderBlockMsg:
+				msg.reply <- b.reconsiderBlock(msg.hash)
+
 			case isCurrentMsg:
 				msg.reply <- b.current()
 
@@ -1063,6 +1315,416 @@ out:
 	bmgrLog.Trace("Block handler done")
 }
 
+// chainTips returns all of the known tips of the block tree.  This includes
+// the tip of the main chain along with the tip of every side chain that has
+// been seen since the block manager was started and is no more than
+// maxSideChainTipAge blocks behind the main chain.  It is invoked from the
+// blockHandler goroutine.
+func (b *blockManager) chainTips() []chainTip {
+	bestHash, bestHeight := b.chainState.Best()
+	tips := []chainTip{{
+		hash:   *bestHash,
+		height: bestHeight,
+		status: "active",
+	}}
+
+	// A side chain node is only a tip if no other side chain node builds
+	// on it.
+	hasChild := make(map[btcwire.ShaHash]bool, len(b.sideChainNodes))
+	for _, node := range b.sideChainNodes {
+		hasChild[node.prevHash] = true
+	}
+
+	for hash, node := range b.sideChainNodes {
+		if hasChild[hash] {
+			continue
+		}
+
+		// Walk backwards to the first block of the branch.  Its parent
+		// is the fork point on the main chain, so the branch length is
+		// the distance from the tip to the fork point.  This works no
+		// matter how far below the current main chain tip the branch
+		// diverges.
+		first := node
+		for {
+			parent, ok := b.sideChainNodes[first.prevHash]
+			if !ok {
+				break
+			}
+			first = parent
+		}
+
+		status := "valid-headers"
+		switch {
+		case node.invalid:
+			status = "invalid"
+		case node.validated:
+			status = "valid-fork"
+		}
+		tips = append(tips, chainTip{
+			hash:      hash,
+			height:    node.height,
+			branchLen: node.height - first.height + 1,
+			status:    status,
+		})
+	}
+
+	return tips
+}
+
+// pruneSideChains stops tracking the side chains whose tips are more than
+// maxSideChainTipAge blocks behind the passed main chain height so the side
+// chain nodes don't grow without bound.  The blocks of a pruned side chain
+// which other side chains still build on are kept.  It is invoked from the
+// blockHandler goroutine.
+func (b *blockManager) pruneSideChains(bestHeight int64) {
+	numChildren := make(map[btcwire.ShaHash]int, len(b.sideChainNodes))
+	for _, node := range b.sideChainNodes {
+		numChildren[node.prevHash]++
+	}
+
+	for hash, node := range b.sideChainNodes {
+		if numChildren[hash] != 0 ||
+			bestHeight-node.height <= maxSideChainTipAge {
+
+			continue
+		}
+
+		// Remove the tip and walk backwards removing its ancestors
+		// until reaching the main chain or a block which another side
+		// chain builds on.
+		for {
+			delete(b.sideChainNodes, hash)
+			b.removeSideBlock(&hash)
+			hash = node.prevHash
+			numChildren[hash]--
+			if numChildren[hash] != 0 {
+				break
+			}
+			var ok bool
+			node, ok = b.sideChainNodes[hash]
+			if !ok {
+				break
+			}
+		}
+	}
+}
+
+// isInvalidated returns whether or not the passed block was manually marked
+// invalid or builds on a block that was.  In the latter case, the block is
+// marked invalid as well so that any of its own descendants are also
+// rejected.  It is invoked from the blockHandler goroutine.
+func (b *blockManager) isInvalidated(block *btcutil.Block) bool {
+	// It's ok to ignore the error here since the hash of a block that
+	// has already been deserialized is always available.
+	hash, _ := block.Sha()
+	if _, ok := b.invalidBlocks[*hash]; ok {
+		return true
+	}
+
+	prevHash := &block.MsgBlock().Header.PrevBlock
+	if _, ok := b.invalidBlocks[*prevHash]; ok {
+		b.invalidBlocks[*hash] = *prevHash
+		return true
+	}
+
+	return false
+}
+
+// chain returns the current block chain instance.  It must be used to access
+// the block chain from goroutines other than the blockHandler goroutine since
+// the instance is replaced by resetBlockChain.
+//
+// This function is safe for concurrent access.
+func (b *blockManager) chain() *btcchain.BlockChain {
+	b.blockChainMtx.RLock()
+	defer b.blockChainMtx.RUnlock()
+
+	return b.blockChain
+}
+
+// resetBlockChain replaces the block chain instance with a new one which is
+// initialized from the current state of the database.  This is used after
+// blocks have been removed from the database behind the back of the chain
+// code, since btcchain provides no way to disconnect blocks from the main
+// chain on request.  Any orphan blocks and side chains known to the previous
+// instance are lost, so the side chains need to be processed again with
+// reprocessBlocks afterwards.  It is invoked from the blockHandler goroutine.
+func (b *blockManager) resetBlockChain() error {
+	chain := btcchain.New(b.server.db, b.server.netParams,
+		b.handleNotifyMsg)
+	chain.DisableCheckpoints(cfg.DisableCheckpoints)
+	if err := chain.GenerateInitialIndex(); err != nil {
+		return err
+	}
+	b.blockChainMtx.Lock()
+	b.blockChain = chain
+	b.blockChainMtx.Unlock()
+
+	newestSha, newestHeight, err := b.server.db.NewestSha()
+	if err != nil {
+		return err
+	}
+	b.updateChainState(newestSha, newestHeight)
+	return nil
+}
+
+// storeSideBlock adds the passed block, which is not part of the main chain, to
+// the side block store so it can be processed again later.  Failures are only
+// logged since the block is merely unavailable to be processed again then.  It
+// is invoked from the blockHandler goroutine.
+func (b *blockManager) storeSideBlock(block *btcutil.Block) {
+	if err := b.sideBlocks.put(block); err != nil {
+		hash, _ := block.Sha()
+		bmgrLog.Warnf("Failed to store side chain block %v: %v", hash,
+			err)
+	}
+}
+
+// removeSideBlock removes the block with the passed hash from the side block
+// store.  It is invoked from the blockHandler goroutine.
+func (b *blockManager) removeSideBlock(hash *btcwire.ShaHash) {
+	if err := b.sideBlocks.remove(hash); err != nil {
+		bmgrLog.Warnf("Failed to remove side chain block %v: %v", hash,
+			err)
+	}
+}
+
+// markInvalidDescendants marks all side chain blocks which build on a block
+// that is marked invalid as invalid as well.  It is invoked from the
+// blockHandler goroutine.
+func (b *blockManager) markInvalidDescendants() {
+	for changed := true; changed; {
+		changed = false
+		for hash, node := range b.sideChainNodes {
+			if _, ok := b.invalidBlocks[hash]; ok {
+				continue
+			}
+			if _, ok := b.invalidBlocks[node.prevHash]; ok {
+				b.invalidBlocks[hash] = node.prevHash
+				node.invalid = true
+				changed = true
+			}
+		}
+	}
+}
+
+// validSideChainBlocks returns the hashes of all side chain blocks which are
+// not marked invalid and either build on a block in the main chain or on
+// another such side chain block.  Parents are always returned before their
+// children.  It is invoked from the blockHandler goroutine.
+func (b *blockManager) validSideChainBlocks() []btcwire.ShaHash {
+	var hashes []btcwire.ShaHash
+	children := make(map[btcwire.ShaHash][]btcwire.ShaHash)
+	for hash, node := range b.sideChainNodes {
+		if node.invalid {
+			continue
+		}
+		if parent, ok := b.sideChainNodes[node.prevHash]; ok {
+			if !parent.invalid {
+				children[node.prevHash] = append(
+					children[node.prevHash], hash)
+			}
+			continue
+		}
+		if b.server.db.ExistsSha(&node.prevHash) {
+			hashes = append(hashes, hash)
+		}
+	}
+	for i := 0; i < len(hashes); i++ {
+		hashes = append(hashes, children[hashes[i]]...)
+	}
+	return hashes
+}
+
+// reprocessBlocks loads the blocks with the passed hashes from the side block
+// store and processes them again.  Parents must come before their children.
+// Chain takes care of selecting the best chain and sends the usual
+// notifications for any blocks that are connected as a result.  It returns the
+// number of blocks which were processed.  It is invoked from the blockHandler
+// goroutine.
+func (b *blockManager) reprocessBlocks(hashes []btcwire.ShaHash) (int, error) {
+	var numProcessed int
+	for i := range hashes {
+		hash := &hashes[i]
+		block, err := b.sideBlocks.fetch(hash)
+		if err != nil {
+			bmgrLog.Warnf("Block %v is not available to be "+
+				"processed again: %v", hash, err)
+			continue
+		}
+
+		// The node is recreated when the block is accepted to a side
+		// chain again, so keep track of whether it was validated.
+		node := b.sideChainNodes[*hash]
+		delete(b.sideChainNodes, *hash)
+		if err := b.blockChain.ProcessBlock(block, false); err != nil {
+			bmgrLog.Warnf("Failed to process block %v again: %v",
+				hash, err)
+			continue
+		}
+		if newNode, ok := b.sideChainNodes[*hash]; ok && node != nil {
+			newNode.validated = node.validated
+		}
+		numProcessed++
+	}
+	b.server.db.Sync()
+
+	newestSha, newestHeight, err := b.server.db.NewestSha()
+	if err != nil {
+		return numProcessed, err
+	}
+	b.updateChainState(newestSha, newestHeight)
+	return numProcessed, nil
+}
+
+// invalidateBlock marks the block with the passed hash as invalid and
+// disconnects it along with all of its descendants from the main chain.
+// Transactions from the disconnected blocks are added back to the memory pool
+// and websocket clients are notified of each disconnected block.  The side
+// chains which don't build on the invalidated block are then processed again
+// so the best remaining chain becomes the main chain.  Only blocks in the main
+// chain may be invalidated since side chains can't be disconnected.  The
+// invalid marks are only kept in memory, so they are lost on restart, and the
+// orphan blocks known to the chain are dropped when it is reset.  It is
+// invoked from the blockHandler goroutine.
+func (b *blockManager) invalidateBlock(hash *btcwire.ShaHash) error {
+	if _, ok := b.invalidBlocks[*hash]; ok {
+		return nil
+	}
+
+	db := b.server.db
+	height, err := db.FetchBlockHeightBySha(hash)
+	if err != nil {
+		return fmt.Errorf("block %v is not in the main chain", hash)
+	}
+	if height == 0 {
+		return errors.New("the genesis block can't be invalidated")
+	}
+
+	// Move all of the blocks to disconnect, starting with the current tip
+	// of the main chain and ending with the invalidated block, to the side
+	// block store so they are still available to reconsider after they are
+	// removed from the database.
+	_, bestHeight, err := db.NewestSha()
+	if err != nil {
+		return err
+	}
+	hashes := make([]btcwire.ShaHash, 0, bestHeight-height+1)
+	var prevHash btcwire.ShaHash
+	for h := bestHeight; h >= height; h-- {
+		sha, err := db.FetchBlockShaByHeight(h)
+		if err != nil {
+			return err
+		}
+		block, err := db.FetchBlockBySha(sha)
+		if err != nil {
+			return err
+		}
+		if err := b.sideBlocks.put(block); err != nil {
+			return err
+		}
+		hashes = append(hashes, *sha)
+		prevHash = block.MsgBlock().Header.PrevBlock
+	}
+
+	// Remove the blocks from the database and rebuild the chain state on
+	// top of the parent of the invalidated block.
+	if err := db.DropAfterBlockBySha(&prevHash); err != nil {
+		return err
+	}
+	db.Sync()
+	if err := b.resetBlockChain(); err != nil {
+		return err
+	}
+
+	// Handle each disconnected block the same way as if chain had
+	// disconnected it during a reorganize.  This adds the transactions
+	// back to the memory pool and notifies websocket clients.
+	for i := range hashes {
+		sha := &hashes[i]
+		block, err := b.sideBlocks.fetch(sha)
+		if err != nil {
+			return err
+		}
+		b.invalidBlocks[*sha] = block.MsgBlock().Header.PrevBlock
+		b.handleNotifyMsg(&btcchain.Notification{
+			Type: btcchain.NTBlockDisconnected,
+			Data: block,
+		})
+		b.sideChainNodes[*sha].invalid = true
+	}
+	b.markInvalidDescendants()
+
+	// The side chains were lost when the chain was reset, so process
+	// them again to make the best remaining chain the main chain.
+	if _, err := b.reprocessBlocks(b.validSideChainBlocks()); err != nil {
+		return err
+	}
+
+	bmgrLog.Infof("Invalidated block %v (height %d) and disconnected %d "+
+		"block(s)", hash, height, len(hashes))
+	return nil
+}
+
+// removeInvalidMarks removes the invalid mark from the block with the passed
+// hash along with all of its ancestors and descendants which were marked
+// invalid.  It returns the hashes of those blocks with parents before their
+// children.  It is invoked from the blockHandler goroutine.
+func (b *blockManager) removeInvalidMarks(hash *btcwire.ShaHash) []btcwire.ShaHash {
+	// Walk back to the first invalidated ancestor of the block.
+	root := *hash
+	for {
+		prevHash := b.invalidBlocks[root]
+		if _, ok := b.invalidBlocks[prevHash]; !ok {
+			break
+		}
+		root = prevHash
+	}
+
+	// Collect the root and everything that builds on it in height order
+	// so that parents are always processed before their children.
+	hashes := []btcwire.ShaHash{root}
+	delete(b.invalidBlocks, root)
+	for i := 0; i < len(hashes); i++ {
+		for sha, prevHash := range b.invalidBlocks {
+			if prevHash.IsEqual(&hashes[i]) {
+				hashes = append(hashes, sha)
+				delete(b.invalidBlocks, sha)
+			}
+		}
+	}
+	for i := range hashes {
+		if node, ok := b.sideChainNodes[hashes[i]]; ok {
+			node.invalid = false
+		}
+	}
+	return hashes
+}
+
+// reconsiderBlock removes the invalid mark from the block with the passed hash
+// along with all of its ancestors and descendants which were marked invalid,
+// and then processes those blocks again from the side block store.  This
+// reconnects them to the main chain when they form the best chain.  Blocks
+// which were rejected when they were received because they build on an
+// invalidated block were never stored, so they are accepted again once they
+// are received again.  It is invoked from the blockHandler goroutine.
+func (b *blockManager) reconsiderBlock(hash *btcwire.ShaHash) error {
+	if _, ok := b.invalidBlocks[*hash]; !ok {
+		return fmt.Errorf("block %v is not marked invalid", hash)
+	}
+
+	hashes := b.removeInvalidMarks(hash)
+	numProcessed, err := b.reprocessBlocks(hashes)
+	if err != nil {
+		return err
+	}
+
+	bmgrLog.Infof("Reconsidered %d block(s) starting at block %v",
+		numProcessed, &hashes[0])
+	return nil
+}
+
 // handleNotifyMsg handles notifications from btcchain.  It does things such
 // as request orphan block parents and relay accepted blocks to connected peers.
 func (b *blockManager) handleNotifyMsg(notification *btcchain.Notification) {
@@ -1089,13 +1751,6 @@ func (b *blockManager) handleNotifyMsg(notification *btcchain.Notification) {
 	// A block has been accepted into the block chain.  Relay it to other
 	// peers.
 	case btcchain.NTBlockAccepted:
-		// Don't relay if we are not current. Other peers that are
-		// current should already know about it.
-
-		if !b.current() {
-			return
-		}
-
 		block, ok := notification.Data.(*btcutil.Block)
 		if !ok {
 			bmgrLog.Warnf("Chain accepted notification is not a block.")
@@ -1106,9 +1761,36 @@ func (b *blockManager) handleNotifyMsg(notification *btcchain.Notification) {
 		// coming from the chain code which has already cached the hash.
 		hash, _ := block.Sha()
 
-		// Generate the inventory vector and relay it.
+		// Keep track of the block when it was accepted to a side chain
+		// rather than being connected to the main chain.  The chain
+		// sends the connected notification for blocks which are
+		// connected to the main chain before the accepted one, so the
+		// block was connected exactly when it is the last connected
+		// block.
+		if !hash.IsEqual(&b.lastConnected) {
+			b.sideChainNodes[*hash] = &sideChainNode{
+				prevHash: block.MsgBlock().Header.PrevBlock,
+				height:   block.Height(),
+			}
+			b.storeSideBlock(block)
+		}
+
+		// Don't relay if we are not current. Other peers that are
+		// current should already know about it.
+		if !b.current() {
+			return
+		}
+
+		// Generate the inventory vector and relay it.  The relay is
+		// held back when a block relay delay is configured unless the
+		// block was submitted locally.
 		iv := btcwire.NewInvVect(btcwire.InvTypeBlock, hash)
-		b.server.RelayInventory(iv)
+		if cfg.BlockRelayDelay > 0 &&
+			(b.localBlock == nil || !hash.IsEqual(b.localBlock)) {
+			b.server.RelayInventoryDelayed(iv)
+		} else {
+			b.server.RelayInventory(iv)
+		}
 
 	// A block has been connected to the main block chain.
 	case btcchain.NTBlockConnected:
@@ -1118,14 +1800,48 @@ func (b *blockManager) handleNotifyMsg(notification *btcchain.Notification) {
 			break
 		}
 
+		// The block is no longer on a side chain if it was previously.
+		// Side chains which have fallen too far behind the new tip are
+		// no longer tracked.
+		hash, _ := block.Sha()
+		b.lastConnected = *hash
+		delete(b.sideChainNodes, *hash)
+		b.removeSideBlock(hash)
+		b.pruneSideChains(block.Height())
+
+		// Update the fee estimator with the transactions in the block
+		// that were in the transaction pool before they are removed
+		// from it below.
+		txDescs := make([]*TxDesc, 0, len(block.Transactions())-1)
+		for _, tx := range block.Transactions()[1:] {
+			txD, err := b.server.txMemPool.FetchTxDesc(tx.Sha())
+			if err == nil {
+				txDescs = append(txDescs, txD)
+			}
+		}
+		b.server.feeEstimator.ProcessBlock(block.Height(), txDescs)
+
+		// Add the transactions in the block to the address index when
+		// it is enabled.
+		if idx := b.server.addrIndex; idx != nil {
+			idx.ConnectBlock(block)
+		}
+
+		// Add the compact filter for the block to the compact filter
+		// index when it is enabled.
+		if idx := b.server.cfIndex; idx != nil {
+			idx.ConnectBlock(block)
+		}
+
 		// Remove all of the transactions (except the coinbase) in the
 		// connected block from the transaction pool.  Also, remove any
 		// transactions which are now double spends as a result of these
-		// new transactions.  Note that removing a transaction from
-		// pool also removes any transactions which depend on it,
-		// recursively.
+		// new transactions.  Note that removing a double spend from
+		// the pool also removes any transactions which depend on it,
+		// recursively, while transactions which depend on a mined
+		// transaction are kept.
 		for _, tx := range block.Transactions()[1:] {
-			b.server.txMemPool.RemoveTransaction(tx)
+			b.server.txMemPool.RemoveTransaction(tx, txRemoveMined)
 			b.server.txMemPool.RemoveDoubleSpends(tx)
 		}
 
@@ -1150,6 +1866,28 @@ func (b *blockManager) handleNotifyMsg(notification *btcchain.Notification) {
 			break
 		}
 
+		// Remove the transactions in the block from the address index
+		// when it is enabled.
+		if idx := b.server.addrIndex; idx != nil {
+			idx.DisconnectBlock(block)
+		}
+
+		// Remove the compact filter for the block from the compact
+		// filter index when it is enabled.
+		if idx := b.server.cfIndex; idx != nil {
+			idx.DisconnectBlock(block)
+		}
+
+		// The block is now part of a side chain.  It was fully validated
+		// when it was connected to the main chain.
+		hash, _ := block.Sha()
+		b.sideChainNodes[*hash] = &sideChainNode{
+			prevHash:  block.MsgBlock().Header.PrevBlock,
+			height:    block.Height(),
+			validated: true,
+		}
+		b.storeSideBlock(block)
+
 		// Reinsert all of the transactions (except the coinbase) into
 		// the transaction pool.
 		for _, tx := range block.Transactions()[1:] {
@@ -1158,7 +1896,8 @@ func (b *blockManager) handleNotifyMsg(notification *btcchain.Notification) {
 				// Remove the transaction and all transactions
 				// that depend on it if it wasn't accepted into
 				// the transaction pool.
-				b.server.txMemPool.RemoveTransaction(tx)
+				b.server.txMemPool.RemoveTransaction(tx,
+					txRemoveReorg)
 			}
 		}
 
@@ -1293,7 +2032,8 @@ func (b *blockManager) CalcNextRequiredDifficulty(timestamp time.Time) (uint32,
 
 // ProcessBlock makes use of ProcessBlock on an internal instance of a block
 // chain.  It is funneled through the block manager since btcchain is not safe
-// for concurrent access.
+// for concurrent access.  errDuplicateBlock is returned when the block is
+// already known.
 func (b *blockManager) ProcessBlock(block *btcutil.Block) (bool, error) {
 	reply := make(chan processBlockResponse)
 	b.msgChan <- processBlockMsg{block: block, reply: reply}
@@ -1301,6 +2041,35 @@ func (b *blockManager) ProcessBlock(block *btcutil.Block) (bool, error) {
 	return response.isOrphan, response.err
 }
 
+// ChainTips returns all of the known tips of the block tree.  It is funneled
+// through the block manager since the side chain tracking is owned by the
+// block handler.
+func (b *blockManager) ChainTips() []chainTip {
+	reply := make(chan []chainTip)
+	b.msgChan <- getChainTipsMsg{reply: reply}
+	return <-reply
+}
+
+// InvalidateBlock marks the block with the passed hash as invalid and
+// disconnects it along with all of its descendants from the main chain.  It is
+// funneled through the block manager since btcchain is not safe for concurrent
+// access.
+func (b *blockManager) InvalidateBlock(hash *btcwire.ShaHash) error {
+	reply := make(chan error)
+	b.msgChan <- invalidateBlockMsg{hash: hash, reply: reply}
+	return <-reply
+}
+
+// ReconsiderBlock removes the invalid mark from the block with the passed hash
+// and reconnects it to the main chain when it forms the best chain.  It is
+// funneled through the block manager since btcchain is not safe for concurrent
+// access.
+func (b *blockManager) ReconsiderBlock(hash *btcwire.ShaHash) error {
+	reply := make(chan error)
+	b.msgChan <- reconsiderBlockMsg{hash: hash, reply: reply}
+	return <-reply
+}
+
 // IsCurrent returns whether or not the block manager believes it is synced with
 // the connected peers.
 func (b *blockManager) IsCurrent() bool {
@@ -1322,11 +2091,19 @@ func newBlockManager(s *server) (*blockManager, error) {
 		blockPeer:        make(map[btcwire.ShaHash]*peer),
 		requestedTxns:    make(map[btcwire.ShaHash]bool),
 		requestedBlocks:  make(map[btcwire.ShaHash]bool),
+		sideChainNodes:   make(map[btcwire.ShaHash]*sideChainNode),
+		invalidBlocks:    make(map[btcwire.ShaHash]btcwire.ShaHash),
+		headersRates:     make(map[*peer]*headersRate),
 		lastBlockLogTime: time.Now(),
 		msgChan:          make(chan interface{}, cfg.MaxPeers*3),
 		headerList:       list.New(),
 		quit:             make(chan bool),
 	}
+	bm.sideBlocks, err = newSideBlockStore(filepath.Join(cfg.DataDir,
+		sideBlocksDirname))
+	if err != nil {
+		return nil, err
+	}
 	bm.blockChain = btcchain.New(s.db, s.netParams, bm.handleNotifyMsg)
 	bm.blockChain.DisableCheckpoints(cfg.DisableCheckpoints)
 	if !cfg.DisableCheckpoints {
@@ -1431,6 +2208,11 @@ func warnMultipeDBs() {
 // such warning the user if there are multiple databases which consume space on
 // the file system and ensuring the regression test database is clean when in
 // regression test mode.
+//
+// NOTE: Pruning old block data is not supported since the btcdb backends do
+// not keep a separate unspent transaction output set.  Transaction inputs are
+// validated by loading the referenced transactions from the stored blocks, so
+// removing old blocks would make the chain impossible to validate.
 func setupBlockDB() (btcdb.Db, error) {
 	// The memdb backend does not have a file path associated with it, so
 	// handle it uniquely.  We also don't want to worry about the multiple
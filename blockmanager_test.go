@@ -0,0 +1,225 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"github.com/conformal/btcnet"
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testHash returns a hash which is unique for the passed number.
+func testHash(n byte) btcwire.ShaHash {
+	var hash btcwire.ShaHash
+	hash[0] = n
+	return hash
+}
+
+// TestInvalidateReconsider ensures the invalid marks set when a block is
+// invalidated cover the side chains and later received blocks which build on
+// it, and that reconsidering any of the marked blocks removes all of the
+// marks in an order which processes parents before their children.
+func TestInvalidateReconsider(t *testing.T) {
+	// The main chain was 0 <- 1 <- 2 <- 3 before block 1 was invalidated,
+	// which disconnected blocks 1 through 3.  Block 4 is a side chain
+	// block building on block 2 and blocks 5 and 6 form a side chain which
+	// forks off at block 0.
+	h := make([]btcwire.ShaHash, 7)
+	for i := range h {
+		h[i] = testHash(byte(i))
+	}
+	b := &blockManager{
+		invalidBlocks: map[btcwire.ShaHash]btcwire.ShaHash{
+			h[1]: h[0],
+			h[2]: h[1],
+			h[3]: h[2],
+		},
+		sideChainNodes: map[btcwire.ShaHash]*sideChainNode{
+			h[1]: {prevHash: h[0], height: 1, invalid: true},
+			h[2]: {prevHash: h[1], height: 2, invalid: true},
+			h[3]: {prevHash: h[2], height: 3, invalid: true},
+			h[4]: {prevHash: h[2], height: 3},
+			h[5]: {prevHash: h[0], height: 1, validated: true},
+			h[6]: {prevHash: h[5], height: 2},
+		},
+	}
+
+	b.markInvalidDescendants()
+	wantInvalid := []bool{false, true, true, true, true, false, false}
+	for i, want := range wantInvalid {
+		_, got := b.invalidBlocks[h[i]]
+		if got != want {
+			t.Errorf("block %d: unexpected invalid mark - got %v, "+
+				"want %v", i, got, want)
+		}
+	}
+	if !b.sideChainNodes[h[4]].invalid {
+		t.Errorf("side chain block building on an invalidated block " +
+			"is not marked invalid")
+	}
+
+	// A block received later which builds on an invalidated block must be
+	// rejected and marked invalid itself.
+	msgBlock := btcwire.MsgBlock{
+		Header: btcwire.BlockHeader{PrevBlock: h[3]},
+	}
+	block := btcutil.NewBlock(&msgBlock)
+	if !b.isInvalidated(block) {
+		t.Errorf("block building on an invalidated block is not " +
+			"rejected")
+	}
+	blockHash, _ := block.Sha()
+
+	// Reconsidering a block in the middle removes the marks from its
+	// ancestors and descendants in parent first order.
+	hashes := b.removeInvalidMarks(&h[2])
+	if len(hashes) != 5 {
+		t.Fatalf("unexpected number of reconsidered blocks - got %d, "+
+			"want %d", len(hashes), 5)
+	}
+	if !hashes[0].IsEqual(&h[1]) {
+		t.Errorf("unexpected first reconsidered block - got %v, "+
+			"want %v", hashes[0], h[1])
+	}
+	position := make(map[btcwire.ShaHash]int, len(hashes))
+	for i, hash := range hashes {
+		position[hash] = i
+	}
+	parents := map[btcwire.ShaHash]btcwire.ShaHash{
+		h[2]:       h[1],
+		h[3]:       h[2],
+		h[4]:       h[2],
+		*blockHash: h[3],
+	}
+	for child, parent := range parents {
+		if position[parent] >= position[child] {
+			t.Errorf("block %v is reconsidered before its parent "+
+				"%v", child, parent)
+		}
+	}
+	if len(b.invalidBlocks) != 0 {
+		t.Errorf("unexpected invalid marks left after reconsider: %v",
+			b.invalidBlocks)
+	}
+	for i := 1; i <= 4; i++ {
+		if b.sideChainNodes[h[i]].invalid {
+			t.Errorf("block %d is still marked invalid", i)
+		}
+	}
+	if !b.sideChainNodes[h[5]].validated {
+		t.Errorf("unrelated side chain block lost its validated mark")
+	}
+}
+
+// TestSideBlockStore ensures blocks can be stored in and fetched from the side
+// block store and that opening the store empties it.
+func TestSideBlockStore(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "sideblocks")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	dir := filepath.Join(tmpDir, sideBlocksDirname)
+
+	s, err := newSideBlockStore(dir)
+	if err != nil {
+		t.Fatalf("newSideBlockStore: %v", err)
+	}
+	block := btcutil.NewBlock(btcnet.MainNetParams.GenesisBlock)
+	hash, _ := block.Sha()
+	if err := s.put(block); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	fetched, err := s.fetch(hash)
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	fetchedHash, _ := fetched.Sha()
+	if !fetchedHash.IsEqual(hash) {
+		t.Errorf("fetch: unexpected block - got %v, want %v",
+			fetchedHash, hash)
+	}
+
+	// Removing a block which is not in the store is not an error.
+	if err := s.remove(hash); err != nil {
+		t.Errorf("remove: %v", err)
+	}
+	if err := s.remove(hash); err != nil {
+		t.Errorf("remove missing block: %v", err)
+	}
+	if _, err := s.fetch(hash); err == nil {
+		t.Errorf("fetch: removed block was returned")
+	}
+
+	// Blocks left over from a previous run are removed on open.
+	if err := s.put(block); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	s, err = newSideBlockStore(dir)
+	if err != nil {
+		t.Fatalf("newSideBlockStore: %v", err)
+	}
+	if _, err := s.fetch(hash); err == nil {
+		t.Errorf("fetch: block from a previous run was returned")
+	}
+}
+
+// TestExceedsHeadersRate ensures back to back full headers messages answering
+// our getheaders requests never trip the headers flood limit while unsolicited
+// headers, including empty messages, are limited.
+func TestExceedsHeadersRate(t *testing.T) {
+	savedCfg := cfg
+	cfg = &config{MaxHeadersPerSec: 100}
+	defer func() {
+		cfg = savedCfg
+	}()
+
+	b := &blockManager{headersRates: make(map[*peer]*headersRate)}
+	p := &peer{}
+
+	// Simulate a fast peer answering each request with a full batch
+	// immediately, which is far more headers per second than the limit.
+	for i := 0; i < 50; i++ {
+		b.peerHeadersRate(p).requested = true
+		if b.exceedsHeadersRate(p, btcwire.MaxBlockHeadersPerMsg) {
+			t.Fatalf("requested batch %d exceeded the limit", i)
+		}
+	}
+
+	// An unsolicited full batch fits the burst, but another one sent right
+	// after it does not.
+	if b.exceedsHeadersRate(p, btcwire.MaxBlockHeadersPerMsg) {
+		t.Fatalf("first unsolicited batch exceeded the limit")
+	}
+	if !b.exceedsHeadersRate(p, btcwire.MaxBlockHeadersPerMsg) {
+		t.Fatalf("second unsolicited batch did not exceed the limit")
+	}
+
+	// Each unsolicited empty message is charged one header, so a flood of
+	// them exhausts the bucket of a new peer.
+	p2 := &peer{}
+	burst := cfg.MaxHeadersPerSec + btcwire.MaxBlockHeadersPerMsg
+	exceeded := false
+	for i := 0; i < burst+10; i++ {
+		if b.exceedsHeadersRate(p2, 0) {
+			exceeded = true
+			break
+		}
+	}
+	if !exceeded {
+		t.Errorf("flood of empty headers messages did not exceed the " +
+			"limit")
+	}
+
+	// The limit does not apply once it is disabled.
+	cfg.MaxHeadersPerSec = 0
+	if b.exceedsHeadersRate(p, btcwire.MaxBlockHeadersPerMsg) {
+		t.Errorf("disabled limit was exceeded")
+	}
+}
@@ -0,0 +1,270 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"github.com/conformal/btcscript"
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+	"sync"
+)
+
+const (
+	// bloomHashSeedMultiplier is multiplied by the hash function number
+	// and added to the tweak to produce the seed for each of the hash
+	// functions used by a bloom filter as defined by BIP0037.
+	bloomHashSeedMultiplier = 0xfba4c795
+)
+
+// murmurHash3 implements the non-cryptographic 32-bit MurmurHash3 function
+// with the passed seed as required by BIP0037 for bloom filters.
+func murmurHash3(seed uint32, data []byte) uint32 {
+	const (
+		c1 = 0xcc9e2d51
+		c2 = 0x1b873593
+		r1 = 15
+		r2 = 13
+		m  = 5
+		n  = 0xe6546b64
+	)
+
+	// Process the data in 4-byte blocks.
+	dataLen := uint32(len(data))
+	hash := seed
+	numBlocks := dataLen / 4
+	for i := uint32(0); i < numBlocks; i++ {
+		k := binary.LittleEndian.Uint32(data[i*4:])
+		k *= c1
+		k = (k << r1) | (k >> (32 - r1))
+		k *= c2
+
+		hash ^= k
+		hash = (hash << r2) | (hash >> (32 - r2))
+		hash = hash*m + n
+	}
+
+	// Process any remaining bytes.
+	tailIdx := numBlocks * 4
+	var k uint32
+	switch dataLen & 3 {
+	case 3:
+		k ^= uint32(data[tailIdx+2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(data[tailIdx+1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(data[tailIdx])
+		k *= c1
+		k = (k << r1) | (k >> (32 - r1))
+		k *= c2
+		hash ^= k
+	}
+
+	// Finalization.
+	hash ^= dataLen
+	hash ^= hash >> 16
+	hash *= 0x85ebca6b
+	hash ^= hash >> 13
+	hash *= 0xc2b2ae35
+	hash ^= hash >> 16
+	return hash
+}
+
+// bloomFilter houses the BIP0037 bloom filter loaded by a remote peer along
+// with the associated update behavior.  A filter which has not been loaded
+// does not match anything.
+type bloomFilter struct {
+	sync.Mutex
+	msgFilterLoad *btcwire.MsgFilterLoad
+}
+
+// IsLoaded returns whether or not a filter is currently loaded.
+//
+// This function is safe for concurrent access.
+func (bf *bloomFilter) IsLoaded() bool {
+	bf.Lock()
+	defer bf.Unlock()
+
+	return bf.msgFilterLoad != nil
+}
+
+// Reload replaces the current filter with the one in the passed filterload
+// message.
+//
+// This function is safe for concurrent access.
+func (bf *bloomFilter) Reload(msg *btcwire.MsgFilterLoad) {
+	bf.Lock()
+	defer bf.Unlock()
+
+	bf.msgFilterLoad = msg
+}
+
+// Unload clears the current filter, if any, so it no longer matches
+// anything.
+//
+// This function is safe for concurrent access.
+func (bf *bloomFilter) Unload() {
+	bf.Lock()
+	defer bf.Unlock()
+
+	bf.msgFilterLoad = nil
+}
+
+// hash returns the bit offset in the filter which corresponds to the passed
+// data for the given hash function number.
+//
+// This function MUST be called with the filter lock held.
+func (bf *bloomFilter) hash(hashNum uint32, data []byte) uint32 {
+	seed := hashNum*bloomHashSeedMultiplier + bf.msgFilterLoad.Tweak
+	numBits := uint32(len(bf.msgFilterLoad.Filter)) * 8
+	return murmurHash3(seed, data) % numBits
+}
+
+// matches returns whether or not the filter matches the passed data.
+//
+// This function MUST be called with the filter lock held.
+func (bf *bloomFilter) matches(data []byte) bool {
+	if bf.msgFilterLoad == nil || len(bf.msgFilterLoad.Filter) == 0 {
+		return false
+	}
+
+	// The data only matches when every bit selected by the hash functions
+	// is set.
+	for i := uint32(0); i < bf.msgFilterLoad.HashFuncs; i++ {
+		idx := bf.hash(i, data)
+		if bf.msgFilterLoad.Filter[idx>>3]&(1<<(idx&7)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// add adds the passed data to the filter.
+//
+// This function MUST be called with the filter lock held.
+func (bf *bloomFilter) add(data []byte) {
+	if bf.msgFilterLoad == nil || len(bf.msgFilterLoad.Filter) == 0 {
+		return
+	}
+
+	for i := uint32(0); i < bf.msgFilterLoad.HashFuncs; i++ {
+		idx := bf.hash(i, data)
+		bf.msgFilterLoad.Filter[idx>>3] |= 1 << (idx & 7)
+	}
+}
+
+// Add adds the passed data to the filter.
+//
+// This function is safe for concurrent access.
+func (bf *bloomFilter) Add(data []byte) {
+	bf.Lock()
+	defer bf.Unlock()
+
+	bf.add(data)
+}
+
+// serializeOutPoint returns the serialization of the passed outpoint used when
+// matching and adding outpoints to a filter, which is the hash followed by the
+// little-endian output index.
+func serializeOutPoint(outpoint *btcwire.OutPoint) []byte {
+	var buf [btcwire.HashSize + 4]byte
+	copy(buf[:], outpoint.Hash[:])
+	binary.LittleEndian.PutUint32(buf[btcwire.HashSize:], outpoint.Index)
+	return buf[:]
+}
+
+// maybeAddOutPoint adds the outpoint for the passed output to the filter
+// depending on the update flags of the filter and the type of the output
+// script.
+//
+// This function MUST be called with the filter lock held.
+func (bf *bloomFilter) maybeAddOutPoint(pkScript []byte, txHash *btcwire.ShaHash, idx uint32) {
+	switch bf.msgFilterLoad.Flags {
+	case btcwire.BloomUpdateAll:
+		outpoint := btcwire.NewOutPoint(txHash, idx)
+		bf.add(serializeOutPoint(outpoint))
+
+	case btcwire.BloomUpdateP2PubkeyOnly:
+		class := btcscript.GetScriptClass(pkScript)
+		if class == btcscript.PubKeyTy || class == btcscript.MultiSigTy {
+			outpoint := btcwire.NewOutPoint(txHash, idx)
+			bf.add(serializeOutPoint(outpoint))
+		}
+	}
+}
+
+// MatchTxAndUpdate returns whether or not the passed transaction matches the
+// filter per the rules in BIP0037.  The transaction matches when the filter
+// matches its hash, any data pushed by its output scripts, any of the
+// outpoints it spends, or any data pushed by its input scripts.  The
+// outpoints of matched outputs are added to the filter according to its
+// update flags so transactions which later spend them match as well.
+//
+// This function is safe for concurrent access.
+func (bf *bloomFilter) MatchTxAndUpdate(tx *btcutil.Tx) bool {
+	bf.Lock()
+	defer bf.Unlock()
+
+	if bf.msgFilterLoad == nil {
+		return false
+	}
+
+	// Check if the filter matches the hash of the transaction.
+	matched := bf.matches(tx.Sha()[:])
+
+	// Check if the filter matches any data elements pushed by the output
+	// scripts.  Every output is checked, even when the transaction has
+	// already matched, so the outpoints of all matching outputs are added
+	// to the filter.
+	for i, txOut := range tx.MsgTx().TxOut {
+		pushedData, err := btcscript.PushedData(txOut.PkScript)
+		if err != nil {
+			continue
+		}
+
+		for _, data := range pushedData {
+			if !bf.matches(data) {
+				continue
+			}
+
+			matched = true
+			bf.maybeAddOutPoint(txOut.PkScript, tx.Sha(), uint32(i))
+			break
+		}
+	}
+
+	// Nothing more to do if a match has already been made.
+	if matched {
+		return true
+	}
+
+	// Check if the filter matches any outpoints spent by the transaction
+	// or any data elements pushed by the input scripts.
+	for _, txIn := range tx.MsgTx().TxIn {
+		if bf.matches(serializeOutPoint(&txIn.PreviousOutpoint)) {
+			return true
+		}
+
+		pushedData, err := btcscript.PushedData(txIn.SignatureScript)
+		if err != nil {
+			continue
+		}
+		for _, data := range pushedData {
+			if bf.matches(data) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// newBloomFilter returns a new bloom filter which does not match anything
+// until a filter is loaded.
+func newBloomFilter() *bloomFilter {
+	return &bloomFilter{}
+}
@@ -0,0 +1,250 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+	"testing"
+)
+
+// TestMurmurHash3 ensures the MurmurHash3 function used for bloom filters
+// produces the correct hash for known test vectors.
+func TestMurmurHash3(t *testing.T) {
+	tests := []struct {
+		seed uint32
+		data []byte
+		want uint32
+	}{
+		{0x00000000, []byte{}, 0x00000000},
+		{0xfba4c795, []byte{}, 0x6a396f08},
+		{0xffffffff, []byte{}, 0x81f16f39},
+		{0x00000000, []byte{0x00}, 0x514e28b7},
+		{0xfba4c795, []byte{0x00}, 0xea3f0b17},
+		{0x00000000, []byte{0xff}, 0xfd6cf10d},
+		{0x00000000, []byte{0x00, 0x11}, 0x16c6b7ab},
+		{0x00000000, []byte{0x00, 0x11, 0x22}, 0x8eb51c3d},
+		{0x00000000, []byte{0x00, 0x11, 0x22, 0x33}, 0xb4471bf8},
+		{0x00000000, []byte{0x00, 0x11, 0x22, 0x33, 0x44}, 0xe2301fa8},
+		{0x00000000, []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}, 0xfc2e4a15},
+		{0x00000000, []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66}, 0xb074502c},
+		{0x00000000, []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77}, 0x8034d2a0},
+		{0x00000000, []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88}, 0xb4698def},
+	}
+
+	for i, test := range tests {
+		got := murmurHash3(test.seed, test.data)
+		if got != test.want {
+			t.Errorf("murmurHash3 #%d: unexpected hash - got %08x, "+
+				"want %08x", i, got, test.want)
+		}
+	}
+}
+
+// TestBloomFilterInsert ensures inserting data into a filter sets the bits
+// defined by the BIP0037 test vectors and that the inserted data matches the
+// filter afterwards.
+func TestBloomFilterInsert(t *testing.T) {
+	tests := []struct {
+		tweak uint32
+		want  string
+	}{
+		{0, "614e9b"},
+		{2147483649, "ce4299"},
+	}
+
+	inserted := []string{
+		"99108ad8ed9bb6274d3980bab5a85c048f0950c8",
+		"b5a2c786d9ef4658287ced5914b37a1b4aa32eee",
+		"b9300670b4c5366e95b2699e8b18bc75e5f729c5",
+	}
+	notInserted := "19108ad8ed9bb6274d3980bab5a85c048f0950c8"
+
+	for i, test := range tests {
+		// The filter size and number of hash functions are those for
+		// 3 elements with a false positive rate of 0.01.
+		bf := newBloomFilter()
+		bf.Reload(&btcwire.MsgFilterLoad{
+			Filter:    make([]byte, 3),
+			HashFuncs: 5,
+			Tweak:     test.tweak,
+			Flags:     btcwire.BloomUpdateAll,
+		})
+		for _, str := range inserted {
+			data, _ := hex.DecodeString(str)
+			bf.Add(data)
+		}
+
+		want, _ := hex.DecodeString(test.want)
+		if !bytes.Equal(bf.msgFilterLoad.Filter, want) {
+			t.Errorf("Add #%d: unexpected filter - got %x, want %x",
+				i, bf.msgFilterLoad.Filter, want)
+			continue
+		}
+		for _, str := range inserted {
+			data, _ := hex.DecodeString(str)
+			if !bf.matches(data) {
+				t.Errorf("matches #%d: inserted data %s does "+
+					"not match", i, str)
+			}
+		}
+		data, _ := hex.DecodeString(notInserted)
+		if bf.matches(data) {
+			t.Errorf("matches #%d: data %s which was not inserted "+
+				"matches", i, notInserted)
+		}
+	}
+}
+
+// pushData returns a script which pushes the passed data, which must be less
+// than 76 bytes.
+func pushData(data []byte) []byte {
+	return append([]byte{byte(len(data))}, data...)
+}
+
+// repeatByte returns a slice of the passed length filled with the passed byte.
+func repeatByte(b byte, n int) []byte {
+	return bytes.Repeat([]byte{b}, n)
+}
+
+// newBloomTestFilter returns a bloom filter large enough for the filter tests to
+// not have false positives with the passed update flags and data added.
+func newBloomTestFilter(flags btcwire.BloomUpdateType, data ...[]byte) *bloomFilter {
+	bf := newBloomFilter()
+	bf.Reload(&btcwire.MsgFilterLoad{
+		Filter:    make([]byte, 512),
+		HashFuncs: 10,
+		Flags:     flags,
+	})
+	for _, d := range data {
+		bf.Add(d)
+	}
+	return bf
+}
+
+// TestBloomFilterMatchTxAndUpdate ensures transactions match a filter per the
+// rules in BIP0037 and that the outpoints of matched outputs are added to the
+// filter as required by each of its update flags.
+func TestBloomFilterMatchTxAndUpdate(t *testing.T) {
+	// The funding transaction pays a pay-to-pubkey-hash output and a
+	// pay-to-pubkey output.
+	pubKeyHash := repeatByte(0x22, 20)
+	pubKey := append([]byte{0x02}, repeatByte(0x11, 32)...)
+	p2pkhScript := append([]byte{0x76, 0xa9}, pushData(pubKeyHash)...)
+	p2pkhScript = append(p2pkhScript, 0x88, 0xac)
+	p2pkScript := append(pushData(pubKey), 0xac)
+
+	fundMsgTx := btcwire.NewMsgTx()
+	fundMsgTx.AddTxIn(btcwire.NewTxIn(btcwire.NewOutPoint(
+		&btcwire.ShaHash{0x01}, 0), pushData(repeatByte(0x33, 8))))
+	fundMsgTx.AddTxOut(btcwire.NewTxOut(1000, p2pkhScript))
+	fundMsgTx.AddTxOut(btcwire.NewTxOut(2000, p2pkScript))
+	fundTx := btcutil.NewTx(fundMsgTx)
+
+	// newSpendTx returns a transaction which spends the passed output of
+	// the funding transaction with a signature script which pushes data
+	// that is not in any of the filters.
+	newSpendTx := func(index uint32) *btcutil.Tx {
+		msgTx := btcwire.NewMsgTx()
+		outpoint := btcwire.NewOutPoint(fundTx.Sha(), index)
+		msgTx.AddTxIn(btcwire.NewTxIn(outpoint,
+			pushData(repeatByte(0x44, 8))))
+		msgTx.AddTxOut(btcwire.NewTxOut(500, nil))
+		return btcutil.NewTx(msgTx)
+	}
+	spendP2PKH := newSpendTx(0)
+	spendP2PK := newSpendTx(1)
+
+	// Ensure the funding transaction matches on its outputs with each of
+	// the update flags and that only the expected outpoints are added.
+	updateTests := []struct {
+		name      string
+		flags     btcwire.BloomUpdateType
+		wantP2PKH bool
+		wantP2PK  bool
+	}{
+		{"none", btcwire.BloomUpdateNone, false, false},
+		{"all", btcwire.BloomUpdateAll, true, true},
+		{"p2pubkey only", btcwire.BloomUpdateP2PubkeyOnly, false, true},
+	}
+	for _, test := range updateTests {
+		bf := newBloomTestFilter(test.flags, pubKeyHash, pubKey)
+		if !bf.MatchTxAndUpdate(fundTx) {
+			t.Errorf("MatchTxAndUpdate (%s): funding transaction "+
+				"does not match", test.name)
+			continue
+		}
+		if got := bf.MatchTxAndUpdate(spendP2PKH); got != test.wantP2PKH {
+			t.Errorf("MatchTxAndUpdate (%s): unexpected match for "+
+				"spend of pay-to-pubkey-hash output - got %v, "+
+				"want %v", test.name, got, test.wantP2PKH)
+		}
+		if got := bf.MatchTxAndUpdate(spendP2PK); got != test.wantP2PK {
+			t.Errorf("MatchTxAndUpdate (%s): unexpected match for "+
+				"spend of pay-to-pubkey output - got %v, want %v",
+				test.name, got, test.wantP2PK)
+		}
+	}
+
+	// Ensure each of the parts of a transaction the filter is matched
+	// against results in a match.
+	matchTests := []struct {
+		name string
+		bf   *bloomFilter
+		tx   *btcutil.Tx
+		want bool
+	}{
+		{
+			name: "transaction hash",
+			bf: newBloomTestFilter(btcwire.BloomUpdateNone,
+				fundTx.Sha()[:]),
+			tx:   fundTx,
+			want: true,
+		},
+		{
+			name: "output script data",
+			bf: newBloomTestFilter(btcwire.BloomUpdateNone,
+				pubKeyHash),
+			tx:   fundTx,
+			want: true,
+		},
+		{
+			name: "spent outpoint",
+			bf: newBloomTestFilter(btcwire.BloomUpdateNone,
+				serializeOutPoint(btcwire.NewOutPoint(
+					fundTx.Sha(), 1))),
+			tx:   spendP2PK,
+			want: true,
+		},
+		{
+			name: "input script data",
+			bf: newBloomTestFilter(btcwire.BloomUpdateNone,
+				repeatByte(0x44, 8)),
+			tx:   spendP2PKH,
+			want: true,
+		},
+		{
+			name: "no match",
+			bf: newBloomTestFilter(btcwire.BloomUpdateNone,
+				repeatByte(0x55, 20)),
+			tx:   fundTx,
+			want: false,
+		},
+		{
+			name: "not loaded",
+			bf:   newBloomFilter(),
+			tx:   fundTx,
+			want: false,
+		},
+	}
+	for _, test := range matchTests {
+		if got := test.bf.MatchTxAndUpdate(test.tx); got != test.want {
+			t.Errorf("MatchTxAndUpdate (%s): unexpected match - "+
+				"got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
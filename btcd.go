@@ -13,11 +13,17 @@ import (
 	"os"
 	"runtime"
 	"runtime/pprof"
+	"time"
 )
 
 var (
 	cfg             *config
 	shutdownChannel = make(chan bool)
+
+	// processStartTime is the time the process started.  It is captured
+	// when the package is initialized so it is not affected by how long
+	// loading the configuration and block database takes.
+	processStartTime = time.Now()
 )
 
 // winServiceMain is only invoked on Windows.  It detects when btcd is running
@@ -72,6 +78,30 @@ func btcdMain(serverChan chan<- *server) error {
 		return err
 	}
 
+	// Drop the compact filter index and exit if requested.
+	if cfg.DropCFIndex {
+		if err := dropCFIndex(); err != nil {
+			btcdLog.Errorf("%v", err)
+			return err
+		}
+		btcdLog.Infof("Compact filter index dropped")
+		return nil
+	}
+
+	// Rebuild the block database from the blocks stored in it when
+	// requested.  This is done before the server is started so it never
+	// runs concurrently with a normal sync.  Otherwise, refuse to start
+	// when a previous reindex did not complete.
+	if cfg.Reindex {
+		if err := reindexBlockDB(); err != nil {
+			btcdLog.Errorf("%v", err)
+			return err
+		}
+	} else if err := checkIncompleteReindex(); err != nil {
+		btcdLog.Errorf("%v", err)
+		return err
+	}
+
 	// Load the block database.
 	db, err := loadBlockDB()
 	if err != nil {
@@ -86,6 +116,21 @@ func btcdMain(serverChan chan<- *server) error {
 		db.RollbackClose()
 	})
 
+	// Re-validate the blocks at the tip of the main chain when requested
+	// and refuse to start with a database which doesn't match them.
+	if cfg.CheckBlocks > 0 {
+		err := verifyChain(db, cfg.CheckLevel, cfg.CheckBlocks)
+		if err != nil {
+			btcdLog.Errorf("Check of the last %d blocks at level %d "+
+				"failed: %v -- the block database may be "+
+				"corrupt, restart with --reindex to rebuild it",
+				cfg.CheckBlocks, cfg.CheckLevel, err)
+			return err
+		}
+		btcdLog.Infof("Verified the last %d blocks at level %d",
+			cfg.CheckBlocks, cfg.CheckLevel)
+	}
+
 	// Create server and start it.
 	server, err := newServer(cfg.Listeners, db, activeNetParams.Params)
 	if err != nil {
@@ -104,6 +149,10 @@ func btcdMain(serverChan chan<- *server) error {
 		serverChan <- server
 	}
 
+	// Reload the debug level from the configuration file on SIGHUP where
+	// supported.
+	startReloadHandler()
+
 	// Monitor for graceful server shutdown and signal the main goroutine
 	// when done.  This is done in a separate goroutine rather than waiting
 	// directly so the main goroutine can be signaled for shutdown by either
@@ -0,0 +1,243 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/conformal/btcchain"
+	"github.com/conformal/btcdb"
+	"github.com/conformal/btcscript"
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+	"github.com/conformal/goleveldb/leveldb"
+	"os"
+	"path/filepath"
+)
+
+// cfIndexDirname is the name of the directory in the data directory the compact
+// filter index database is stored in.
+const cfIndexDirname = "cfindex"
+
+var (
+	// cfIndexFilterPrefix is the prefix of the keys of the serialized
+	// filter of each block.
+	cfIndexFilterPrefix = byte('f')
+
+	// cfIndexHeaderPrefix is the prefix of the keys of the filter header of
+	// each block.
+	cfIndexHeaderPrefix = byte('h')
+)
+
+// errCFIndexUnavailable is returned when reading from the compact filter index
+// after it failed to be updated.  It is caught up with the main chain again
+// when btcd is restarted.
+var errCFIndexUnavailable = errors.New("compact filter index is " +
+	"unavailable after failing to update it -- restart btcd to catch it up")
+
+// cfIndex maintains an index of the BIP0158 basic compact filters, along with
+// their filter headers, for the main chain blocks in a separate leveldb
+// database.  The chain index it embeds keeps it up to date with the main chain.
+//
+// NOTE: The filters are currently only served over RPC.  Serving them to peers
+// with the getcfilters, getcfheaders, and getcfcheckpt messages requires the
+// messages to be supported by btcwire first.
+type cfIndex struct {
+	*chainIndex
+}
+
+// cfIndexKey returns the key with the passed prefix for the block with the
+// passed hash.
+func cfIndexKey(prefix byte, hash *btcwire.ShaHash) []byte {
+	key := make([]byte, 1+btcwire.HashSize)
+	key[0] = prefix
+	copy(key[1:], hash[:])
+	return key
+}
+
+// basicFilterItems returns the items of the BIP0158 basic filter for the passed
+// block, which are the unique public key scripts of all of the outputs created
+// and spent by the transactions in the block.  Empty scripts and the scripts
+// of provably unspendable (OP_RETURN) outputs are excluded.
+func (idx *cfIndex) basicFilterItems(block *btcutil.Block) ([][]byte, error) {
+	seen := make(map[string]struct{})
+	var items [][]byte
+	addItem := func(pkScript []byte) {
+		if len(pkScript) == 0 || pkScript[0] == btcscript.OP_RETURN {
+			return
+		}
+		if _, exists := seen[string(pkScript)]; exists {
+			return
+		}
+		seen[string(pkScript)] = struct{}{}
+		items = append(items, pkScript)
+	}
+
+	// The transactions spent by the inputs are looked up in the block
+	// first and the block database otherwise.
+	blockTxns := make(map[btcwire.ShaHash]*btcwire.MsgTx)
+	for _, tx := range block.Transactions() {
+		msgTx := tx.MsgTx()
+		blockTxns[*tx.Sha()] = msgTx
+		for _, txOut := range msgTx.TxOut {
+			addItem(txOut.PkScript)
+		}
+
+		if btcchain.IsCoinBase(tx) {
+			continue
+		}
+		for _, txIn := range msgTx.TxIn {
+			prevOut := &txIn.PreviousOutpoint
+			originTx, ok := blockTxns[prevOut.Hash]
+			if !ok {
+				txList, err := idx.db.FetchTxBySha(&prevOut.Hash)
+				if err != nil || len(txList) == 0 {
+					return nil, fmt.Errorf("unable to fetch "+
+						"input transaction %v: %v",
+						prevOut.Hash, err)
+				}
+				originTx = txList[len(txList)-1].Tx
+			}
+			if prevOut.Index >= uint32(len(originTx.TxOut)) {
+				return nil, fmt.Errorf("input %v references a "+
+					"non-existent output", prevOut)
+			}
+			addItem(originTx.TxOut[prevOut.Index].PkScript)
+		}
+	}
+	return items, nil
+}
+
+// buildBasicFilter returns the serialized BIP0158 basic filter for the passed
+// block.  The filter consists of the number of items as a variable length
+// integer followed by the Golomb-coded set of the items keyed by the first 16
+// bytes of the block hash.
+func (idx *cfIndex) buildBasicFilter(block *btcutil.Block) ([]byte, error) {
+	hash, err := block.Sha()
+	if err != nil {
+		return nil, err
+	}
+	items, err := idx.basicFilterItems(block)
+	if err != nil {
+		return nil, err
+	}
+
+	var key [16]byte
+	copy(key[:], hash[:16])
+	var buf bytes.Buffer
+	err = btcwire.WriteVarInt(&buf, 0, uint64(len(items)))
+	if err != nil {
+		return nil, err
+	}
+	buf.Write(gcsEncode(key, items, gcsBasicP, gcsBasicM))
+	return buf.Bytes(), nil
+}
+
+// calcFilterHeader returns the header for the passed serialized filter which
+// commits to it and the header of the filter for the previous block.
+func calcFilterHeader(filter []byte, prevHeader *btcwire.ShaHash) *btcwire.ShaHash {
+	var buf [btcwire.HashSize * 2]byte
+	copy(buf[:], btcwire.DoubleSha256(filter))
+	copy(buf[btcwire.HashSize:], prevHeader[:])
+
+	header, _ := btcwire.NewShaHash(btcwire.DoubleSha256(buf[:]))
+	return header
+}
+
+// indexBlock adds the filter and filter header for the passed block to the
+// passed batch.  Part of the chainIndexer interface.
+//
+// This function MUST be called with the compact filter index lock held.
+func (idx *cfIndex) indexBlock(batch *leveldb.Batch, block *btcutil.Block) error {
+	filter, err := idx.buildBasicFilter(block)
+	if err != nil {
+		return err
+	}
+
+	// The header of the filter for the genesis block commits to a
+	// previous header of all zeros.
+	var prevHeader btcwire.ShaHash
+	if block.Height() > 0 {
+		prevHash := &block.MsgBlock().Header.PrevBlock
+		header, err := idx.ldb.Get(cfIndexKey(cfIndexHeaderPrefix,
+			prevHash), nil)
+		if err != nil {
+			return fmt.Errorf("unable to load filter header for "+
+				"block %v: %v", prevHash, err)
+		}
+		copy(prevHeader[:], header)
+	}
+	header := calcFilterHeader(filter, &prevHeader)
+
+	hash, err := block.Sha()
+	if err != nil {
+		return err
+	}
+	batch.Put(cfIndexKey(cfIndexFilterPrefix, hash), filter)
+	batch.Put(cfIndexKey(cfIndexHeaderPrefix, hash), header[:])
+	return nil
+}
+
+// unindexBlock adds the removal of the filter and filter header for the passed
+// block to the passed batch.  Part of the chainIndexer interface.
+//
+// This function MUST be called with the compact filter index lock held.
+func (idx *cfIndex) unindexBlock(batch *leveldb.Batch, block *btcutil.Block) error {
+	hash, err := block.Sha()
+	if err != nil {
+		return err
+	}
+	batch.Delete(cfIndexKey(cfIndexFilterPrefix, hash))
+	batch.Delete(cfIndexKey(cfIndexHeaderPrefix, hash))
+	return nil
+}
+
+// FilterByBlockHash returns the serialized basic filter and the filter header
+// for the main chain block with the passed hash.
+//
+// This function is safe for concurrent access.
+func (idx *cfIndex) FilterByBlockHash(hash *btcwire.ShaHash) ([]byte, *btcwire.ShaHash, error) {
+	idx.Lock()
+	defer idx.Unlock()
+
+	if err := idx.available(); err != nil {
+		return nil, nil, err
+	}
+
+	filter, err := idx.ldb.Get(cfIndexKey(cfIndexFilterPrefix, hash), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	headerBytes, err := idx.ldb.Get(cfIndexKey(cfIndexHeaderPrefix, hash),
+		nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	header, err := btcwire.NewShaHash(headerBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return filter, header, nil
+}
+
+// newCFIndex opens (or creates when needed) the compact filter index database
+// for the passed block database and catches it up with the main chain.  The
+// index is rebuilt from scratch when its tip is no longer part of the main
+// chain since the filter headers commit to all of the previous filters.
+func newCFIndex(db btcdb.Db) (*cfIndex, error) {
+	idx := &cfIndex{}
+	idx.chainIndex = newChainIndex(db, "compact filter index",
+		cfIndexDirname, errCFIndexUnavailable, idx)
+	if err := idx.open(); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// dropCFIndex removes the compact filter index database, if any.
+func dropCFIndex() error {
+	return os.RemoveAll(filepath.Join(cfg.DataDir, cfIndexDirname))
+}
@@ -0,0 +1,320 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/conformal/btcdb"
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+	"github.com/conformal/goleveldb/leveldb"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// chainIndexLogInterval is the number of blocks between progress messages while
+// an index catches up with the block database.
+const chainIndexLogInterval = 10000
+
+// chainIndexTipKey is the key of the hash and height of the most recent block
+// in an index.
+var chainIndexTipKey = []byte("tip")
+
+// chainIndexer is implemented by the optional indexes which are maintained by
+// a chainIndex.  It is only responsible for the entries of the index itself
+// while the chainIndex tracks the tip of the index and keeps it in sync with
+// the main chain.
+type chainIndexer interface {
+	// indexBlock adds the entries for the passed block, which extends the
+	// tip of the index, to the passed batch.
+	indexBlock(batch *leveldb.Batch, block *btcutil.Block) error
+
+	// unindexBlock adds the removal of the entries for the passed block,
+	// which is the tip of the index, to the passed batch.
+	unindexBlock(batch *leveldb.Batch, block *btcutil.Block) error
+}
+
+// chainIndex maintains an optional index of the main chain blocks, such as the
+// address index, in a separate leveldb database.  It is kept up to date as
+// blocks are connected to and disconnected from the main chain and is caught
+// up with the block database when it is opened.  The index is no longer updated
+// or served once updating it fails since it would otherwise silently return
+// incomplete results.
+type chainIndex struct {
+	sync.Mutex
+	db        btcdb.Db
+	ldb       *leveldb.DB
+	tipHash   btcwire.ShaHash
+	tipHeight int64
+	failed    bool
+
+	// name is the name of the index used in messages, dirname is the name
+	// of the directory in the data directory the index database is stored
+	// in, and errUnavailable is returned when reading from the index after
+	// it failed to be updated.
+	name           string
+	dirname        string
+	errUnavailable error
+	indexer        chainIndexer
+}
+
+// newChainIndex returns a new chain index for the passed block database which
+// maintains its entries with the passed indexer.  The index database is not
+// opened until open is called.
+func newChainIndex(db btcdb.Db, name, dirname string, errUnavailable error, indexer chainIndexer) *chainIndex {
+	return &chainIndex{
+		db:             db,
+		tipHeight:      -1,
+		name:           name,
+		dirname:        dirname,
+		errUnavailable: errUnavailable,
+		indexer:        indexer,
+	}
+}
+
+// path returns the path of the index database.
+func (idx *chainIndex) path() string {
+	return filepath.Join(cfg.DataDir, idx.dirname)
+}
+
+// putChainIndexTip adds the passed block hash and height as the tip of an
+// index to the passed batch.
+func putChainIndexTip(batch *leveldb.Batch, hash *btcwire.ShaHash, height int64) {
+	tip := make([]byte, btcwire.HashSize+8)
+	copy(tip, hash[:])
+	binary.BigEndian.PutUint64(tip[btcwire.HashSize:], uint64(height))
+	batch.Put(chainIndexTipKey, tip)
+}
+
+// connectBlock adds the passed block to the index.  The block must extend the
+// current tip of the index.
+//
+// This function MUST be called with the index lock held.
+func (idx *chainIndex) connectBlock(block *btcutil.Block) error {
+	height := block.Height()
+	if height != idx.tipHeight+1 {
+		return fmt.Errorf("block at height %d does not extend the "+
+			"%s tip at height %d", height, idx.name, idx.tipHeight)
+	}
+	hash, err := block.Sha()
+	if err != nil {
+		return err
+	}
+
+	batch := new(leveldb.Batch)
+	if err := idx.indexer.indexBlock(batch, block); err != nil {
+		return err
+	}
+	putChainIndexTip(batch, hash, height)
+	if err := idx.ldb.Write(batch, nil); err != nil {
+		return err
+	}
+
+	idx.tipHash = *hash
+	idx.tipHeight = height
+	return nil
+}
+
+// disconnectBlock removes the passed block from the index and makes its parent
+// the new tip.  The block must be the current tip of the index.
+//
+// This function MUST be called with the index lock held.
+func (idx *chainIndex) disconnectBlock(block *btcutil.Block) error {
+	height := block.Height()
+	hash, err := block.Sha()
+	if err != nil {
+		return err
+	}
+	if !hash.IsEqual(&idx.tipHash) {
+		return fmt.Errorf("block at height %d is not the %s tip",
+			height, idx.name)
+	}
+
+	batch := new(leveldb.Batch)
+	if err := idx.indexer.unindexBlock(batch, block); err != nil {
+		return err
+	}
+	prevHash := &block.MsgBlock().Header.PrevBlock
+	putChainIndexTip(batch, prevHash, height-1)
+	if err := idx.ldb.Write(batch, nil); err != nil {
+		return err
+	}
+
+	idx.tipHash = *prevHash
+	idx.tipHeight = height - 1
+	return nil
+}
+
+// ConnectBlock adds the passed block, which was connected to the main chain,
+// to the index.  The index stops being updated and served when the block can't
+// be added.
+//
+// This function is safe for concurrent access.
+func (idx *chainIndex) ConnectBlock(block *btcutil.Block) {
+	idx.Lock()
+	defer idx.Unlock()
+
+	// Nothing to do when the index has been closed or has failed.
+	if idx.ldb == nil || idx.failed {
+		return
+	}
+
+	if err := idx.connectBlock(block); err != nil {
+		idx.failed = true
+		indxLog.Errorf("Failed to add block at height %d to the %s -- "+
+			"it is unavailable until btcd is restarted: %v",
+			block.Height(), idx.name, err)
+	}
+}
+
+// DisconnectBlock removes the passed block, which was disconnected from the
+// main chain, from the index.  The index stops being updated and served when
+// the block can't be removed.
+//
+// This function is safe for concurrent access.
+func (idx *chainIndex) DisconnectBlock(block *btcutil.Block) {
+	idx.Lock()
+	defer idx.Unlock()
+
+	// Nothing to do when the index has been closed or has failed.
+	if idx.ldb == nil || idx.failed {
+		return
+	}
+
+	if err := idx.disconnectBlock(block); err != nil {
+		idx.failed = true
+		indxLog.Errorf("Failed to remove block at height %d from the "+
+			"%s -- it is unavailable until btcd is restarted: %v",
+			block.Height(), idx.name, err)
+	}
+}
+
+// available returns an error when the index can't be read from because it
+// has been closed or failed to be updated.
+//
+// This function MUST be called with the index lock held.
+func (idx *chainIndex) available() error {
+	if idx.ldb == nil {
+		return fmt.Errorf("%s is closed", idx.name)
+	}
+	if idx.failed {
+		return idx.errUnavailable
+	}
+	return nil
+}
+
+// reset removes the index database and creates a new empty one.
+//
+// This function MUST be called with the index lock held.
+func (idx *chainIndex) reset() error {
+	idx.ldb.Close()
+	if err := os.RemoveAll(idx.path()); err != nil {
+		return err
+	}
+	ldb, err := leveldb.OpenFile(idx.path(), nil)
+	if err != nil {
+		return err
+	}
+	idx.ldb = ldb
+	idx.tipHash = btcwire.ShaHash{}
+	idx.tipHeight = -1
+	return nil
+}
+
+// catchUp adds all main chain blocks in the block database which are not yet
+// in the index, such as when the index is enabled on an already synced node.
+// The index is rebuilt from scratch when its tip is no longer part of the main
+// chain.
+//
+// This function MUST be called with the index lock held.
+func (idx *chainIndex) catchUp() error {
+	if idx.tipHeight >= 0 {
+		hash, err := idx.db.FetchBlockShaByHeight(idx.tipHeight)
+		if err != nil || !hash.IsEqual(&idx.tipHash) {
+			indxLog.Infof("The %s does not match the block "+
+				"database -- rebuilding", idx.name)
+			if err := idx.reset(); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, bestHeight, err := idx.db.NewestSha()
+	if err != nil {
+		return err
+	}
+	if idx.tipHeight >= bestHeight {
+		return nil
+	}
+
+	indxLog.Infof("Catching up %s from height %d to %d", idx.name,
+		idx.tipHeight+1, bestHeight)
+	for height := idx.tipHeight + 1; height <= bestHeight; height++ {
+		sha, err := idx.db.FetchBlockShaByHeight(height)
+		if err != nil {
+			return err
+		}
+		block, err := idx.db.FetchBlockBySha(sha)
+		if err != nil {
+			return err
+		}
+		if err := idx.connectBlock(block); err != nil {
+			return err
+		}
+		if height%chainIndexLogInterval == 0 {
+			indxLog.Infof("Caught up %s to height %d", idx.name,
+				height)
+		}
+	}
+	indxLog.Infof("The %s is caught up to height %d", idx.name,
+		bestHeight)
+	return nil
+}
+
+// open opens (or creates when needed) the index database and catches it up
+// with the main chain.
+func (idx *chainIndex) open() error {
+	ldb, err := leveldb.OpenFile(idx.path(), nil)
+	if err != nil {
+		return err
+	}
+
+	tip, err := ldb.Get(chainIndexTipKey, nil)
+	switch {
+	case err == nil && len(tip) == btcwire.HashSize+8:
+		copy(idx.tipHash[:], tip)
+		idx.tipHeight = int64(binary.BigEndian.Uint64(
+			tip[btcwire.HashSize:]))
+
+	case err != nil && err != leveldb.ErrNotFound:
+		ldb.Close()
+		return err
+	}
+
+	idx.Lock()
+	idx.ldb = ldb
+	err = idx.catchUp()
+	idx.Unlock()
+	if err != nil {
+		idx.Close()
+		return err
+	}
+	return nil
+}
+
+// Close closes the index database.
+//
+// This function is safe for concurrent access.
+func (idx *chainIndex) Close() {
+	idx.Lock()
+	defer idx.Unlock()
+
+	if idx.ldb != nil {
+		idx.ldb.Close()
+		idx.ldb = nil
+	}
+}
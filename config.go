@@ -5,11 +5,14 @@
 package main
 
 import (
+	"crypto/tls"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/conformal/btcdb"
 	_ "github.com/conformal/btcdb/ldb"
 	_ "github.com/conformal/btcdb/memdb"
+	"github.com/conformal/btcscript"
 	"github.com/conformal/btcutil"
 	"github.com/conformal/btcwire"
 	"github.com/conformal/go-flags"
@@ -32,8 +35,10 @@ const (
 	defaultLogFilename       = "btcd.log"
 	defaultMaxPeers          = 125
 	defaultBanDuration       = time.Hour * 24
+	defaultBanThreshold      = 100
 	defaultMaxRPCClients     = 10
 	defaultMaxRPCWebsockets  = 25
+	defaultMaxRPCRequestSize = 1024 * 1024 * 8
 	defaultVerifyEnabled     = false
 	defaultDbType            = "leveldb"
 	defaultFreeTxRelayLimit  = 15.0
@@ -42,6 +47,22 @@ const (
 	blockMaxSizeMin          = 1000
 	blockMaxSizeMax          = btcwire.MaxBlockPayload - 1000
 	defaultBlockPrioritySize = 50000
+	defaultMaxOrphanTxs      = 10000
+	defaultProxyType         = "socks5"
+	defaultMaxMempool        = 300
+	defaultDataCarrierSize   = 80
+	defaultShutdownTimeout   = time.Second * 5
+	defaultMaxSendBuffer     = 5000
+	defaultMaxOutbound       = 8
+	defaultMaxInbound        = -1
+	defaultConnRetryInterval = time.Second * 5
+	defaultPeerTimeout       = time.Second * 60
+	defaultMaxHeadersPerSec  = 10000
+	defaultMaxAddrsPerSec    = 0.1
+	defaultCheckLevel        = 3
+	defaultMaxTimeOffset     = time.Minute * 70
+	defaultRebroadcastInt    = time.Minute * 30
+	minRebroadcastInterval   = time.Second
 )
 
 var (
@@ -68,46 +89,107 @@ type config struct {
 	LogDir             string        `long:"logdir" description:"Directory to log output."`
 	AddPeers           []string      `short:"a" long:"addpeer" description:"Add a peer to connect with at startup"`
 	ConnectPeers       []string      `long:"connect" description:"Connect only to the specified peers at startup"`
-	DisableListen      bool          `long:"nolisten" description:"Disable listening for incoming connections -- NOTE: Listening is automatically disabled if the --connect or --proxy options are used without also specifying listen interfaces via --listen"`
+	BlockRelayPeers    []string      `long:"blockrelaypeer" description:"Relay blocks and transactions only to the specified added peer -- Must also be given with --addpeer or --connect and may be specified multiple times.  No other peers, including inbound ones, receive relayed inventory"`
+	DisableListen      bool          `long:"nolisten" description:"Disable listening for incoming connections -- NOTE: Listening is automatically disabled if the --connect or --proxy options are used without also specifying listen interfaces via --listen, so --connect may be combined with --listen to accept incoming connections"`
 	Listeners          []string      `long:"listen" description:"Add an interface/port to listen for connections (default all interfaces port: 8333, testnet: 18333)"`
 	MaxPeers           int           `long:"maxpeers" description:"Max number of inbound and outbound peers"`
+	MaxOutbound        int           `long:"maxoutbound" description:"Number of outbound peers to maintain connections to -- Limited by --maxpeers"`
+	MaxInbound         int           `long:"maxinbound" description:"Max number of inbound peers -- Limited by --maxpeers.  -1 reserves the slots needed by --maxoutbound and the peers specified via --addpeer or --connect and allows inbound peers to use the rest.  0 rejects all inbound peers"`
+	ConnRetryInterval  time.Duration `long:"connretryinterval" description:"Initial time to wait between attempts to connect to a persistent peer -- The interval doubles with each failed attempt up to 5 minutes.  Valid time units are {s, m, h}.  Minimum 1 second"`
+	MaxAddrsPerSec     float64       `long:"maxaddrspersecond" description:"Max number of addresses per second accepted from a peer -- Peers may always send a full addr message worth in addition to the limit and the reply to our getaddr request is not counted.  Addresses beyond it are discarded and add to the ban score of the peer.  0 disables the limit"`
+	MaxHeadersPerSec   int           `long:"maxheaderspersecond" description:"Max number of block headers per second a peer may send before it is banned for flooding -- Headers answering our requests are not counted and peers may always send a full headers message worth in addition to the limit.  0 disables the limit"`
+	PeerTimeout        time.Duration `long:"peertimeout" description:"How long to wait for a connection to a peer, including through a proxy, and for the peer to complete the version handshake before disconnecting it.  Valid time units are {s, m, h}.  Minimum 1 second"`
+	MaxUploadTarget    uint64        `long:"maxuploadtarget" description:"Try to keep the data sent to peers under the given target in MiB per 24h cycle -- Historical blocks are no longer served to peers which are not whitelisted once it is nearly reached.  Each cycle starts when the previous one ends rather than being a rolling window.  Minimum 138 MiB.  0 disables the target"`
+	MaxSendBuffer      int           `long:"maxsendbuffer" description:"Max number of messages queued to be sent to a peer before it is disconnected -- 0 disables the limit"`
+	DisableBanning     bool          `long:"nobanning" description:"Disable banning of misbehaving peers"`
+	NoPeerBloomFilters bool          `long:"nopeerbloomfilters" description:"Disable bloom filtering support (BIP0037), which is enabled by default on all networks -- The bloom service bit is not advertised and peers which send filter messages are disconnected"`
+	AdvertiseServices  []string      `long:"advertiseservice" description:"Only advertise the specified service to peers {none, network, bloom} -- May be specified multiple times.  All enabled services are advertised when not specified"`
 	BanDuration        time.Duration `long:"banduration" description:"How long to ban misbehaving peers.  Valid time units are {s, m, h}.  Minimum 1 second"`
+	BanThreshold       uint32        `long:"banthreshold" description:"Ban score at which misbehaving peers are banned and disconnected -- Whitelisted peers, and all peers when banning is disabled, are never disconnected for their score"`
+	BanScoreDecay      float64       `long:"banscoredecay" description:"Number of points per hour the ban score of a peer decays toward zero.  0 disables the decay"`
+	Whitelists         []string      `long:"whitelist" description:"Add an IP network or IP that will not be banned or rate limited. (eg. 192.168.1.0/24 or ::1)"`
+	MaxTimeOffset      time.Duration `long:"maxtimeoffset" description:"Max amount the time reported by peers may adjust the local clock in either direction -- The offset reported by a single peer is capped to this amount and no adjustment is made when the median offset of all peers is not within it.  Valid time units are {s, m, h}.  0 disables adjusting the local clock"`
+	RejectTimeOffset   time.Duration `long:"rejecttimeoffset" description:"Disconnect peers which report a time differing from the local clock by more than this amount in their version message instead of using it to adjust the time used for block templates.  Valid time units are {s, m, h}.  0 accepts peers regardless of their time"`
+	BlockRelayDelay    time.Duration `long:"blockrelaydelay" description:"How long to hold back the relay of newly accepted blocks to peers so their inventory is coalesced -- Blocks submitted locally, such as mined blocks, are never delayed.  Valid time units are {ms, s, m, h}.  0 relays immediately"`
+	RebroadcastInt     time.Duration `long:"rebroadcastinterval" description:"Max interval between rebroadcasts of the inventory of transactions submitted via RPC which have not been mined yet -- Transactions which are mined or leave the memory pool, such as due to a conflict, are no longer rebroadcast.  Valid time units are {s, m, h}.  0 disables rebroadcasting"`
+	ShutdownTimeout    time.Duration `long:"shutdowntimeout" description:"How long to wait for queued messages to be sent to peers on shutdown before forcibly closing the connections.  Valid time units are {ms, s, m, h}.  0 disconnects immediately"`
 	RPCUser            string        `short:"u" long:"rpcuser" description:"Username for RPC connections"`
 	RPCPass            string        `short:"P" long:"rpcpass" default-mask:"-" description:"Password for RPC connections"`
-	RPCListeners       []string      `long:"rpclisten" description:"Add an interface/port to listen for RPC connections (default port: 8334, testnet: 18334)"`
+	RPCLimitUser       string        `long:"rpclimituser" description:"Username for limited RPC connections"`
+	RPCLimitPass       string        `long:"rpclimitpass" default-mask:"-" description:"Password for limited RPC connections"`
+	RPCCookie          bool          `long:"rpccookie" description:"Generate a random password written to the .cookie file in the data directory for RPC connections -- May not be combined with --rpcuser/--rpcpass"`
+	RPCListeners       []string      `long:"rpclisten" description:"Add an interface/port to listen for RPC connections (default port: 8334, testnet: 18334) -- Use unix:/path/to/socket for a Unix domain socket"`
 	RPCCert            string        `long:"rpccert" description:"File containing the certificate file"`
 	RPCKey             string        `long:"rpckey" description:"File containing the certificate key"`
+	RPCCertPEM         string        `long:"rpccertpem" default-mask:"-" description:"PEM encoded certificate to use instead of --rpccert"`
+	RPCKeyPEM          string        `long:"rpckeypem" default-mask:"-" description:"PEM encoded certificate key to use instead of --rpckey"`
+	RPCAllowIPs        []string      `long:"rpcallowip" description:"Allow RPC connections from an IP network or IP in addition to localhost (eg. 192.168.1.0/24 or ::1) -- All addresses are allowed when not specified"`
 	RPCMaxClients      int           `long:"rpcmaxclients" description:"Max number of RPC clients for standard connections"`
 	RPCMaxWebsockets   int           `long:"rpcmaxwebsockets" description:"Max number of RPC websocket connections"`
+	MaxRPCRequestSize  int64         `long:"maxrpcrequestsize" description:"Max size in bytes of an RPC request body or websocket message -- Larger requests are rejected before they are read into memory.  0 disables the limit"`
+	RPCRateLimit       float64       `long:"rpcratelimit" description:"Max number of requests per second each RPC user may make for each command -- Short bursts of up to a second worth of requests are allowed and requests over the limit are rejected with an error.  The admin user is limited as well since admin clients are just as able to starve the node.  0 disables the limit"`
+	RPCQuirks          bool          `long:"rpcquirks" description:"Mirror some JSON-RPC quirks of Bitcoin Core -- NOTE: Discouraged unless interoperability issues need to be worked around"`
 	DisableRPC         bool          `long:"norpc" description:"Disable built-in RPC server -- NOTE: The RPC server is disabled by default if no rpcuser/rpcpass is specified"`
+	MetricsListeners   []string      `long:"metricslisten" description:"Add an interface/port to serve metrics in the Prometheus text format on over plain HTTP at /metrics (eg. 127.0.0.1:9332) -- NOTE: The metrics are not authenticated and are not served unless this option is specified"`
 	DisableDNSSeed     bool          `long:"nodnsseed" description:"Disable DNS seeding for peers"`
-	ExternalIPs        []string      `long:"externalip" description:"Add an ip to the list of local addresses we claim to listen on to peers"`
-	Proxy              string        `long:"proxy" description:"Connect via SOCKS5 proxy (eg. 127.0.0.1:9050)"`
+	DNSSeeds           []string      `long:"dnsseed" description:"Add a DNS seed to query for peers instead of the built-in seeds for the network"`
+	ExternalIPs        []string      `long:"externalip" description:"Add an ip to the list of local addresses we claim to listen on to peers -- Use host:port to specify a port other than the default and append ,score to prefer some addresses over others (eg. 1.2.3.4:8336,10)"`
+	UserAgentComments  []string      `long:"uacomment" description:"Comment to add to the user agent advertised to peers -- See BIP 14 for more information"`
+	Proxy              string        `long:"proxy" description:"Connect via SOCKS5 or SOCKS4a proxy as selected by --proxytype (eg. 127.0.0.1:9050)"`
+	ProxyType          string        `long:"proxytype" description:"Type of proxy used for --proxy and --onion {socks5, socks4a} -- NOTE: SOCKS4a does not support authentication or DNS lookups, so DNS seeds and hostnames are looked up with the system DNS resolver while connections to hostnames are still resolved by the proxy"`
 	ProxyUser          string        `long:"proxyuser" description:"Username for proxy server"`
 	ProxyPass          string        `long:"proxypass" default-mask:"-" description:"Password for proxy server"`
-	OnionProxy         string        `long:"onion" description:"Connect to tor hidden services via SOCKS5 proxy (eg. 127.0.0.1:9050)"`
+	OnionProxies       []string      `long:"onion" description:"Connect to tor hidden services via SOCKS5 or SOCKS4a proxy as selected by --proxytype (eg. 127.0.0.1:9050) -- May be specified multiple times to spread connections across several proxies"`
 	OnionProxyUser     string        `long:"onionuser" description:"Username for onion proxy server"`
 	OnionProxyPass     string        `long:"onionpass" default-mask:"-" description:"Password for onion proxy server"`
 	NoOnion            bool          `long:"noonion" description:"Disable connecting to tor hidden services"`
+	DNSFallback        bool          `long:"dnsfallback" description:"Retry DNS lookups which fail through the tor proxy directly with the system DNS resolver, bypassing the proxy -- This reveals the looked up hosts outside of tor and is never done for .onion addresses.  It has no effect with a socks4a proxy or --noonion since lookups already use the system resolver then"`
+	BindAddr           string        `long:"bindaddr" description:"Local IP address outbound connections to peers originate from -- When a proxy is used, this applies to the connections to the proxy.  Must be the address of a local interface"`
+	OnlyNets           []string      `long:"onlynet" description:"Only make outbound connections to peers on the specified network {ipv4, ipv6, onion} -- May be specified multiple times"`
 	TestNet3           bool          `long:"testnet" description:"Use the test network"`
 	RegressionTest     bool          `long:"regtest" description:"Use the regression test network"`
 	SimNet             bool          `long:"simnet" description:"Use the simulation test network"`
 	DisableCheckpoints bool          `long:"nocheckpoints" description:"Disable built-in checkpoints.  Don't do this unless you know what you're doing."`
 	DbType             string        `long:"dbtype" description:"Database backend to use for the Block Chain"`
+	AddrIndex          bool          `long:"addrindex" description:"Maintain a full address index which makes the searchrawtransactions RPC available"`
+	CFilters           bool          `long:"cfilters" description:"Maintain an index of BIP0158 compact block filters which makes the getcfilter RPC available"`
+	Reindex            bool          `long:"reindex" description:"Rebuild the block database from the blocks stored in it on start up by validating and connecting each block again -- Resumes a previous reindex which was interrupted"`
+	CheckBlocks        int32         `long:"checkblocks" description:"Number of blocks at the tip of the main chain to re-validate on start up -- btcd refuses to start when the check fails.  0 disables the check"`
+	CheckLevel         int32         `long:"checklevel" description:"How thorough the check of --checkblocks is {0-4} -- Each level includes the checks of the lower ones: 0 reads the blocks, 1 checks their sanity, 2 checks they connect, 3 validates the scripts, and 4 reconnects the blocks and checks the spent outputs match the database"`
+	DropCFIndex        bool          `long:"dropcfindex" description:"Deletes the compact block filter index from the database on start up and then exits"`
 	Profile            string        `long:"profile" description:"Enable HTTP profiling on given port -- NOTE port must be between 1024 and 65536"`
 	CpuProfile         string        `long:"cpuprofile" description:"Write CPU profile to the specified file"`
 	DebugLevel         string        `short:"d" long:"debuglevel" description:"Logging level for all subsystems {trace, debug, info, warn, error, critical} -- You may also specify <subsystem>=<level>,<subsystem2>=<level>,... to set the log level for individual subsystems -- Use show to list available subsystems"`
 	Upnp               bool          `long:"upnp" description:"Use UPnP to map our listening port outside of NAT"`
 	FreeTxRelayLimit   float64       `long:"limitfreerelay" description:"Limit relay of transactions with no transaction fee to the given amount in thousands of bytes per minute"`
+	MinRelayTxFee      float64       `long:"minrelaytxfee" description:"The minimum transaction fee in BTC/kB for a transaction to not be considered free for relay and mining purposes -- Free transactions are only accepted when small enough and within --limitfreerelay"`
+	MaxOrphanTxs       int           `long:"maxorphantxs" description:"Max number of orphan transactions to keep in memory -- 0 disables orphan transaction handling"`
+	MaxMempool         int           `long:"maxmempool" description:"Max size of the transaction memory pool in megabytes -- 0 disables the limit"`
+	NoDataCarrier      bool          `long:"nodatacarrier" description:"Do not accept or relay transactions with outputs which only carry data (OP_RETURN) -- Applies even when non-standard transactions are accepted"`
+	DataCarrierSize    int           `long:"datacarriersize" description:"Max number of bytes of data carried by an OP_RETURN output in transactions which are accepted and relayed -- Applies even when non-standard transactions are accepted"`
+	BlocksOnly         bool          `long:"blocksonly" description:"Do not accept or relay transactions from or to peers other than whitelisted ones"`
+	AcceptNonStd       bool          `long:"acceptnonstd" description:"Accept and relay non-standard transactions to the network regardless of the default settings for the active network"`
+	RejectNonStd       bool          `long:"rejectnonstd" description:"Reject non-standard transactions regardless of the default settings for the active network"`
 	BlockMinSize       uint32        `long:"blockminsize" description:"Mininum block size in bytes to be used when creating a block"`
 	BlockMaxSize       uint32        `long:"blockmaxsize" description:"Maximum block size in bytes to be used when creating a block"`
 	BlockPrioritySize  uint32        `long:"blockprioritysize" description:"Size in bytes for high-priority/low-fee transactions when creating a block"`
+	BlockVersion       int32         `long:"blockversion" description:"Override the version of generated blocks, such as to signal soft forks (1-4) -- Only allowed on the test networks"`
 	GetWorkKeys        []string      `long:"getworkkey" description:"Use the specified payment address for blocks generated by getwork."`
+	MiningScripts      []string      `long:"miningscript" description:"Use the specified hex-encoded output script as the payment script for blocks generated by getwork -- May be combined with getworkkey"`
 	onionlookup        func(string) ([]net.IP, error)
 	lookup             func(string) ([]net.IP, error)
 	oniondial          func(string, string) (net.Conn, error)
 	dial               func(string, string) (net.Conn, error)
+	bindAddr           net.IP
 	miningKeys         []btcutil.Address
+	miningScripts      [][]byte
+	whitelists         []*net.IPNet
+	rpcKeyPair         *tls.Certificate
+	rpcAllowIPs        []*net.IPNet
+	onlyNets           map[string]bool
+	services           btcwire.ServiceFlag
+	minRelayTxFee      int64
+	relayNonStd        bool
 }
 
 // serviceOptions defines the configuration options for btcd as a service on
@@ -130,6 +212,13 @@ func cleanAndExpandPath(path string) string {
 	return filepath.Clean(os.ExpandEnv(path))
 }
 
+// pemData returns the raw bytes of the passed PEM encoded data.  Since values
+// in the config file can't span multiple lines, escaped newlines (\n) are
+// converted to real newlines first.
+func pemData(pem string) []byte {
+	return []byte(strings.Replace(pem, `\n`, "\n", -1))
+}
+
 // validLogLevel returns whether or not logLevel is a valid debug log level.
 func validLogLevel(logLevel string) bool {
 	switch logLevel {
@@ -165,7 +254,8 @@ func supportedSubsystems() []string {
 
 // parseAndSetDebugLevels attempts to parse the specified debug level and set
 // the levels accordingly.  An appropriate error is returned if anything is
-// invalid.
+// invalid.  The entire string is validated before any levels are changed, so
+// the existing levels are left untouched when an error is returned.
 func parseAndSetDebugLevels(debugLevel string) error {
 	// When the specified string doesn't have any delimters, treat it as
 	// the log level for all subsystems.
@@ -183,8 +273,10 @@ func parseAndSetDebugLevels(debugLevel string) error {
 	}
 
 	// Split the specified string into subsystem/level pairs while detecting
-	// issues and update the log levels accordingly.
-	for _, logLevelPair := range strings.Split(debugLevel, ",") {
+	// issues.
+	logLevelPairs := strings.Split(debugLevel, ",")
+	levels := make(map[string]string, len(logLevelPairs))
+	for _, logLevelPair := range logLevelPairs {
 		if !strings.Contains(logLevelPair, "=") {
 			str := "The specified debug level contains an invalid " +
 				"subsystem/level pair [%v]"
@@ -208,12 +300,37 @@ func parseAndSetDebugLevels(debugLevel string) error {
 			return fmt.Errorf(str, logLevel)
 		}
 
+		levels[subsysID] = logLevel
+	}
+
+	// Update the log levels now that everything has been validated.
+	for subsysID, logLevel := range levels {
 		setLogLevel(subsysID, logLevel)
 	}
 
 	return nil
 }
 
+// reloadDebugLevel re-reads the debug level from the configuration file and
+// applies it to the subsystem loggers.  The current debug level is retained
+// when the configuration file does not specify one.  When the configuration
+// file can't be read or the debug level it contains is invalid, an error is
+// returned and the existing log levels remain in effect.
+func reloadDebugLevel() error {
+	reloadCfg := config{DebugLevel: cfg.DebugLevel}
+	parser := newConfigParser(&reloadCfg, &serviceOptions{}, flags.None)
+	err := flags.NewIniParser(parser).ParseFile(cfg.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	if err := parseAndSetDebugLevels(reloadCfg.DebugLevel); err != nil {
+		return err
+	}
+	cfg.DebugLevel = reloadCfg.DebugLevel
+	return nil
+}
+
 // validDbType returns whether or not dbType is a supported database type.
 func validDbType(dbType string) bool {
 	for _, knownType := range knownDbTypes {
@@ -240,19 +357,143 @@ func removeDuplicateAddresses(addrs []string) []string {
 }
 
 // normalizeAddress returns addr with the passed default port appended if
-// there is not already a port specified.
+// there is not already a port specified.  IPv6 addresses may optionally be
+// enclosed in brackets and include a zone identifier (e.g. fe80::1%eth0), both
+// of which are preserved.
 func normalizeAddress(addr, defaultPort string) string {
 	_, _, err := net.SplitHostPort(addr)
 	if err != nil {
-		return net.JoinHostPort(addr, defaultPort)
+		// Remove any brackets around an IPv6 address that does not have
+		// a port so they are not doubled up when the port is added.
+		host := addr
+		if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+			host = host[1 : len(host)-1]
+		}
+		return net.JoinHostPort(host, defaultPort)
 	}
 	return addr
 }
 
+// parseExternalIP parses the passed externalip option value of the form
+// host[:port][,score] into the host, port, and score.  The passed default port
+// is used when no port is specified and the score, which is used to prefer
+// some advertised addresses over others, defaults to 0.  Onion hosts must be
+// addresses which can be advertised in addr messages.
+func parseExternalIP(value, defaultPort string) (string, uint16, int, error) {
+	addr := value
+	score := 0
+	if i := strings.LastIndex(value, ","); i != -1 {
+		s, err := strconv.ParseUint(value[i+1:], 10, 8)
+		if err != nil {
+			return "", 0, 0, fmt.Errorf("invalid score '%s'",
+				value[i+1:])
+		}
+		addr, score = value[:i], int(s)
+	}
+
+	host, portStr, err := net.SplitHostPort(normalizeAddress(addr,
+		defaultPort))
+	if err != nil {
+		return "", 0, 0, err
+	}
+	if host == "" {
+		return "", 0, 0, errors.New("missing host")
+	}
+	if isOnionHost(host) {
+		if _, err := onionToIP(host); err != nil {
+			return "", 0, 0, err
+		}
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("invalid port '%s'", portStr)
+	}
+	return host, uint16(port), score, nil
+}
+
+// parseIPNet parses the passed string as either an IP network in CIDR notation
+// or a bare IP address.  A bare IP address is treated as a network containing
+// only that address.
+func parseIPNet(addr string) (*net.IPNet, error) {
+	_, ipnet, err := net.ParseCIDR(addr)
+	if err == nil {
+		return ipnet, nil
+	}
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address or network '%s'",
+			addr)
+	}
+	var bits int
+	if ip.To4() == nil {
+		// IPv6
+		bits = 128
+	} else {
+		bits = 32
+	}
+	return &net.IPNet{
+		IP:   ip,
+		Mask: net.CIDRMask(bits, bits),
+	}, nil
+}
+
+// isValidHostname returns whether or not the passed string is a valid hostname
+// as defined by RFC 1123.  A single trailing dot denoting a fully qualified
+// name is allowed.
+func isValidHostname(host string) bool {
+	host = strings.TrimSuffix(host, ".")
+	if len(host) == 0 || len(host) > 253 {
+		return false
+	}
+
+	for _, label := range strings.Split(host, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return false
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			switch {
+			case c >= 'a' && c <= 'z':
+			case c >= 'A' && c <= 'Z':
+			case c >= '0' && c <= '9':
+			case c == '-':
+			default:
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// listenDisabled returns whether listening for incoming connections is disabled
+// by the passed options.  The precedence is deterministic: --nolisten always
+// disables listening, explicit listen interfaces via --listen otherwise always
+// enable it, and when neither is specified, using a proxy via --proxy or
+// connecting only to specific peers via --connect disables it.  This allows
+// --connect to be combined with --listen to restrict the outbound peers while
+// still accepting inbound connections.
+func listenDisabled(noListen bool, proxy string, connectPeers, listeners []string) bool {
+	if noListen {
+		return true
+	}
+	if len(listeners) > 0 {
+		return false
+	}
+	return proxy != "" || len(connectPeers) > 0
+}
+
 // normalizeAddresses returns a new slice with all the passed peer addresses
-// normalized with the given default port, and all duplicates removed.
+// normalized with the given default port, and all duplicates removed.  Unix
+// domain socket addresses are left untouched.
 func normalizeAddresses(addrs []string, defaultPort string) []string {
 	for i, addr := range addrs {
+		if strings.HasPrefix(addr, unixSocketPrefix) {
+			continue
+		}
 		addrs[i] = normalizeAddress(addr, defaultPort)
 	}
 
@@ -296,18 +537,37 @@ func loadConfig() (*config, []string, error) {
 		ConfigFile:        defaultConfigFile,
 		DebugLevel:        defaultLogLevel,
 		MaxPeers:          defaultMaxPeers,
+		MaxSendBuffer:     defaultMaxSendBuffer,
+		MaxOutbound:       defaultMaxOutbound,
+		MaxInbound:        defaultMaxInbound,
+		ConnRetryInterval: defaultConnRetryInterval,
+		PeerTimeout:       defaultPeerTimeout,
+		MaxHeadersPerSec:  defaultMaxHeadersPerSec,
+		MaxAddrsPerSec:    defaultMaxAddrsPerSec,
+		MaxTimeOffset:     defaultMaxTimeOffset,
+		RebroadcastInt:    defaultRebroadcastInt,
 		BanDuration:       defaultBanDuration,
+		BanThreshold:      defaultBanThreshold,
 		RPCMaxClients:     defaultMaxRPCClients,
 		RPCMaxWebsockets:  defaultMaxRPCWebsockets,
+		MaxRPCRequestSize: defaultMaxRPCRequestSize,
 		DataDir:           defaultDataDir,
 		LogDir:            defaultLogDir,
 		DbType:            defaultDbType,
 		RPCKey:            defaultRPCKeyFile,
 		RPCCert:           defaultRPCCertFile,
 		FreeTxRelayLimit:  defaultFreeTxRelayLimit,
+		MinRelayTxFee:     float64(defaultMinRelayTxFee) / float64(btcutil.SatoshiPerBitcoin),
+		MaxOrphanTxs:      defaultMaxOrphanTxs,
+		ProxyType:         defaultProxyType,
+		MaxMempool:        defaultMaxMempool,
+		DataCarrierSize:   defaultDataCarrierSize,
+		ShutdownTimeout:   defaultShutdownTimeout,
 		BlockMinSize:      defaultBlockMinSize,
 		BlockMaxSize:      defaultBlockMaxSize,
 		BlockPrioritySize: defaultBlockPrioritySize,
+		BlockVersion:      generatedBlockVersion,
+		CheckLevel:        defaultCheckLevel,
 	}
 
 	// Service options which are only added on Windows.
@@ -404,9 +664,68 @@ func loadConfig() (*config, []string, error) {
 	case cfg.RegressionTest:
 		activeNetParams = &regressionNetParams
 	case cfg.SimNet:
-		// Also disable dns seeding on the simulation test network.
+		// Also disable dns seeding on the simulation test network
+		// unless seeds were explicitly provided since there are no
+		// built-in seeds for it.
 		activeNetParams = &simNetParams
-		cfg.DisableDNSSeed = true
+		if len(cfg.DNSSeeds) == 0 {
+			cfg.DisableDNSSeed = true
+		}
+	}
+
+	// The memory database does not persist blocks to reindex.
+	if cfg.Reindex && cfg.DbType == "memdb" {
+		str := "%s: The reindex option can't be used with the memdb " +
+			"database type"
+		err := fmt.Errorf(str, "loadConfig")
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// Validate the start up block check options.
+	if cfg.CheckBlocks < 0 {
+		str := "%s: The checkblocks option may not be less than 0 " +
+			"-- parsed [%d]"
+		err := fmt.Errorf(str, "loadConfig", cfg.CheckBlocks)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+	if cfg.CheckLevel < 0 || cfg.CheckLevel > 4 {
+		str := "%s: The checklevel option must be between 0 and 4 " +
+			"-- parsed [%d]"
+		err := fmt.Errorf(str, "loadConfig", cfg.CheckLevel)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// The compact filter index can't be both maintained and dropped.
+	if cfg.CFilters && cfg.DropCFIndex {
+		str := "%s: The cfilters and dropcfindex options can't be " +
+			"used together -- choose only one"
+		err := fmt.Errorf(str, "loadConfig")
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// Set the policy for accepting and relaying non-standard transactions
+	// according to the default of the active network unless overridden.
+	cfg.relayNonStd = activeNetParams.RelayNonStdTxs
+	switch {
+	case cfg.AcceptNonStd && cfg.RejectNonStd:
+		str := "%s: The acceptnonstd and rejectnonstd options can't " +
+			"be used together -- choose only one"
+		err := fmt.Errorf(str, "loadConfig")
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	case cfg.AcceptNonStd:
+		cfg.relayNonStd = true
+	case cfg.RejectNonStd:
+		cfg.relayNonStd = false
 	}
 
 	// Append the network type to the data directory so it is "namespaced"
@@ -463,6 +782,18 @@ func loadConfig() (*config, []string, error) {
 		}
 	}
 
+	// Metrics listeners must specify a port since there is no default.
+	for _, addr := range cfg.MetricsListeners {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			str := "%s: The metricslisten value of '%s' is not a " +
+				"valid interface/port: %v"
+			err := fmt.Errorf(str, "loadConfig", addr, err)
+			fmt.Fprintln(os.Stderr, err)
+			parser.WriteHelp(os.Stderr)
+			return nil, nil, err
+		}
+	}
+
 	// Don't allow ban durations that are too short.
 	if cfg.BanDuration < time.Duration(time.Second) {
 		str := "%s: The banduration option may not be less than 1s -- parsed [%v]"
@@ -472,6 +803,392 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
+	// Validate the ban score options.
+	if cfg.BanThreshold == 0 {
+		str := "%s: The banthreshold option may not be 0"
+		err := fmt.Errorf(str, "loadConfig")
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+	if cfg.BanScoreDecay < 0 {
+		str := "%s: The banscoredecay option may not be less than 0 " +
+			"-- parsed [%v]"
+		err := fmt.Errorf(str, "loadConfig", cfg.BanScoreDecay)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// Don't allow a negative number of outbound peers.
+	if cfg.MaxOutbound < 0 {
+		str := "%s: The maxoutbound option may not be less than 0 " +
+			"-- parsed [%d]"
+		err := fmt.Errorf(str, "loadConfig", cfg.MaxOutbound)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// Don't allow a negative number of inbound peers other than -1, which
+	// selects the number automatically.
+	if cfg.MaxInbound < -1 {
+		str := "%s: The maxinbound option may not be less than -1 " +
+			"-- parsed [%d]"
+		err := fmt.Errorf(str, "loadConfig", cfg.MaxInbound)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// Don't allow connection retry intervals that are too short.
+	if cfg.ConnRetryInterval < time.Second {
+		str := "%s: The connretryinterval option may not be less " +
+			"than 1s -- parsed [%v]"
+		err := fmt.Errorf(str, "loadConfig", cfg.ConnRetryInterval)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// Don't allow a negative address rate limit.
+	if cfg.MaxAddrsPerSec < 0 {
+		str := "%s: The maxaddrspersecond option may not be less " +
+			"than 0 -- parsed [%v]"
+		err := fmt.Errorf(str, "loadConfig", cfg.MaxAddrsPerSec)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// Don't allow a negative headers flood limit.
+	if cfg.MaxHeadersPerSec < 0 {
+		str := "%s: The maxheaderspersecond option may not be less " +
+			"than 0 -- parsed [%d]"
+		err := fmt.Errorf(str, "loadConfig", cfg.MaxHeadersPerSec)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// Don't allow an upload target which doesn't leave room beyond the
+	// portion reserved for relaying recent blocks since historical blocks
+	// would never be served.
+	minUploadTarget := uploadTargetBuffer/(1024*1024) + 1
+	if cfg.MaxUploadTarget != 0 && cfg.MaxUploadTarget < minUploadTarget {
+		str := "%s: The maxuploadtarget option may not be less than " +
+			"%d MiB since that much is reserved for relaying " +
+			"recent blocks -- parsed [%d]"
+		err := fmt.Errorf(str, "loadConfig", minUploadTarget,
+			cfg.MaxUploadTarget)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// Don't allow a peer timeout shorter than a second.
+	if cfg.PeerTimeout < time.Second {
+		str := "%s: The peertimeout option may not be less than 1s " +
+			"-- parsed [%v]"
+		err := fmt.Errorf(str, "loadConfig", cfg.PeerTimeout)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// Don't allow a negative send buffer limit.
+	if cfg.MaxSendBuffer < 0 {
+		str := "%s: The maxsendbuffer option may not be less than 0 " +
+			"-- parsed [%d]"
+		err := fmt.Errorf(str, "loadConfig", cfg.MaxSendBuffer)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// Don't allow a negative max time offset.
+	if cfg.MaxTimeOffset < 0 {
+		str := "%s: The maxtimeoffset option may not be negative " +
+			"-- parsed [%v]"
+		err := fmt.Errorf(str, "loadConfig", cfg.MaxTimeOffset)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// Don't allow a negative time offset for rejecting peers.
+	if cfg.RejectTimeOffset < 0 {
+		str := "%s: The rejecttimeoffset option may not be negative " +
+			"-- parsed [%v]"
+		err := fmt.Errorf(str, "loadConfig", cfg.RejectTimeOffset)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// Don't allow a negative block relay delay.
+	if cfg.BlockRelayDelay < 0 {
+		str := "%s: The blockrelaydelay option may not be negative " +
+			"-- parsed [%v]"
+		err := fmt.Errorf(str, "loadConfig", cfg.BlockRelayDelay)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// Don't allow a rebroadcast interval which is negative or so short
+	// that rebroadcasting would flood peers.
+	if cfg.RebroadcastInt != 0 && cfg.RebroadcastInt < minRebroadcastInterval {
+		str := "%s: The rebroadcastinterval option must be 0 or at " +
+			"least %v -- parsed [%v]"
+		err := fmt.Errorf(str, "loadConfig", minRebroadcastInterval,
+			cfg.RebroadcastInt)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// Don't allow a negative shutdown timeout.
+	if cfg.ShutdownTimeout < 0 {
+		str := "%s: The shutdowntimeout option may not be negative " +
+			"-- parsed [%v]"
+		err := fmt.Errorf(str, "loadConfig", cfg.ShutdownTimeout)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// Don't allow a negative number of orphan transactions.
+	if cfg.MaxOrphanTxs < 0 {
+		str := "%s: The maxorphantxs option may not be less than 0 " +
+			"-- parsed [%d]"
+		err := fmt.Errorf(str, "loadConfig", cfg.MaxOrphanTxs)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// Don't allow a negative data carrier size.
+	if cfg.DataCarrierSize < 0 {
+		str := "%s: The datacarriersize option may not be less than 0 " +
+			"-- parsed [%d]"
+		err := fmt.Errorf(str, "loadConfig", cfg.DataCarrierSize)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// Don't allow a negative max memory pool size.
+	if cfg.MaxMempool < 0 {
+		str := "%s: The maxmempool option may not be less than 0 " +
+			"-- parsed [%d]"
+		err := fmt.Errorf(str, "loadConfig", cfg.MaxMempool)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// Validate the minimum relay transaction fee and convert it from BTC/kB
+	// to satoshi/kB.
+	minRelayTxFee := cfg.MinRelayTxFee * float64(btcutil.SatoshiPerBitcoin)
+	if cfg.MinRelayTxFee < 0 || minRelayTxFee > float64(btcutil.MaxSatoshi) {
+		str := "%s: The minrelaytxfee option must be in the range " +
+			"[0, %v] -- parsed [%v]"
+		err := fmt.Errorf(str, "loadConfig",
+			float64(btcutil.MaxSatoshi)/float64(btcutil.SatoshiPerBitcoin),
+			cfg.MinRelayTxFee)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+	cfg.minRelayTxFee = int64(minRelayTxFee + 0.5)
+
+	// Validate any given whitelisted IP addresses and networks.  A bare IP
+	// address is treated as a network containing only that address.
+	if len(cfg.Whitelists) > 0 {
+		cfg.whitelists = make([]*net.IPNet, 0, len(cfg.Whitelists))
+
+		for _, addr := range cfg.Whitelists {
+			ipnet, err := parseIPNet(addr)
+			if err != nil {
+				str := "%s: The whitelist value of '%s' is " +
+					"invalid"
+				err = fmt.Errorf(str, "loadConfig", addr)
+				fmt.Fprintln(os.Stderr, err)
+				parser.WriteHelp(os.Stderr)
+				return nil, nil, err
+			}
+			cfg.whitelists = append(cfg.whitelists, ipnet)
+		}
+	}
+
+	// Validate any given IP addresses and networks allowed to connect to
+	// the RPC server in the same way.
+	if len(cfg.RPCAllowIPs) > 0 {
+		cfg.rpcAllowIPs = make([]*net.IPNet, 0, len(cfg.RPCAllowIPs))
+
+		for _, addr := range cfg.RPCAllowIPs {
+			ipnet, err := parseIPNet(addr)
+			if err != nil {
+				str := "%s: The rpcallowip value of '%s' is " +
+					"invalid"
+				err = fmt.Errorf(str, "loadConfig", addr)
+				fmt.Fprintln(os.Stderr, err)
+				parser.WriteHelp(os.Stderr)
+				return nil, nil, err
+			}
+			cfg.rpcAllowIPs = append(cfg.rpcAllowIPs, ipnet)
+		}
+	}
+
+	// Ensure the user agent comments don't contain any characters which
+	// are reserved by the user agent format defined by BIP 14 and that the
+	// resulting user agent does not exceed the maximum allowed length.
+	for _, comment := range cfg.UserAgentComments {
+		if strings.ContainsAny(comment, "/:()") {
+			str := "%s: The uacomment value of '%s' contains one " +
+				"of the reserved characters '/', ':', '(', or ')'"
+			err := fmt.Errorf(str, "loadConfig", comment)
+			fmt.Fprintln(os.Stderr, err)
+			parser.WriteHelp(os.Stderr)
+			return nil, nil, err
+		}
+	}
+	if len(cfg.UserAgentComments) > 0 {
+		userAgent := fmt.Sprintf("%s%s:%s(%s)/", btcwire.DefaultUserAgent,
+			userAgentName, userAgentVersion,
+			strings.Join(cfg.UserAgentComments, "; "))
+		if len(userAgent) > btcwire.MaxUserAgentLen {
+			str := "%s: The user agent with the uacomment values " +
+				"added is %d bytes which exceeds the maximum " +
+				"allowed length of %d bytes"
+			err := fmt.Errorf(str, "loadConfig", len(userAgent),
+				btcwire.MaxUserAgentLen)
+			fmt.Fprintln(os.Stderr, err)
+			parser.WriteHelp(os.Stderr)
+			return nil, nil, err
+		}
+	}
+
+	// Validate any given external IPs.
+	for _, value := range cfg.ExternalIPs {
+		_, _, _, err := parseExternalIP(value,
+			activeNetParams.DefaultPort)
+		if err != nil {
+			str := "%s: The externalip value of '%s' is invalid: %v"
+			err := fmt.Errorf(str, "loadConfig", value, err)
+			fmt.Fprintln(os.Stderr, err)
+			parser.WriteHelp(os.Stderr)
+			return nil, nil, err
+		}
+	}
+
+	// Validate the bind address for outbound connections, if any, and
+	// ensure it belongs to a local interface.
+	if cfg.BindAddr != "" {
+		cfg.bindAddr = net.ParseIP(cfg.BindAddr)
+		if cfg.bindAddr == nil {
+			str := "%s: The bindaddr option '%s' is not a valid IP " +
+				"address"
+			err := fmt.Errorf(str, "loadConfig", cfg.BindAddr)
+			fmt.Fprintln(os.Stderr, err)
+			parser.WriteHelp(os.Stderr)
+			return nil, nil, err
+		}
+		if !isLocalInterfaceIP(cfg.bindAddr) {
+			str := "%s: The bindaddr option '%s' is not the " +
+				"address of a local interface"
+			err := fmt.Errorf(str, "loadConfig", cfg.BindAddr)
+			fmt.Fprintln(os.Stderr, err)
+			parser.WriteHelp(os.Stderr)
+			return nil, nil, err
+		}
+	}
+
+	// Validate the networks outbound connections are restricted to, if
+	// any.
+	if len(cfg.OnlyNets) > 0 {
+		cfg.onlyNets = make(map[string]bool, len(cfg.OnlyNets))
+		for _, network := range cfg.OnlyNets {
+			network = strings.ToLower(network)
+			switch network {
+			case "ipv4", "ipv6", "onion":
+			default:
+				str := "%s: The onlynet value of '%s' is " +
+					"invalid -- supported networks are " +
+					"{ipv4, ipv6, onion}"
+				err := fmt.Errorf(str, "loadConfig", network)
+				fmt.Fprintln(os.Stderr, err)
+				parser.WriteHelp(os.Stderr)
+				return nil, nil, err
+			}
+			cfg.onlyNets[network] = true
+		}
+	}
+
+	// Determine the services advertised to peers.  By default, all of the
+	// enabled services are advertised.  Otherwise, only the specified ones
+	// are, and services which are disabled may not be specified since peers
+	// would then be told the server provides a service it refuses.
+	cfg.services = btcwire.SFNodeNetwork
+	if !cfg.NoPeerBloomFilters {
+		cfg.services |= sfNodeBloom
+	}
+	if len(cfg.AdvertiseServices) > 0 {
+		cfg.services = 0
+		for _, service := range cfg.AdvertiseServices {
+			service = strings.ToLower(service)
+			switch service {
+			case "none":
+			case "network":
+				cfg.services |= btcwire.SFNodeNetwork
+			case "bloom":
+				if cfg.NoPeerBloomFilters {
+					str := "%s: The advertiseservice " +
+						"option may not specify bloom " +
+						"when the nopeerbloomfilters " +
+						"option is also specified"
+					err := fmt.Errorf(str, "loadConfig")
+					fmt.Fprintln(os.Stderr, err)
+					parser.WriteHelp(os.Stderr)
+					return nil, nil, err
+				}
+				cfg.services |= sfNodeBloom
+			default:
+				str := "%s: The advertiseservice value of " +
+					"'%s' is invalid -- supported services " +
+					"are {none, network, bloom}"
+				err := fmt.Errorf(str, "loadConfig", service)
+				fmt.Fprintln(os.Stderr, err)
+				parser.WriteHelp(os.Stderr)
+				return nil, nil, err
+			}
+		}
+	}
+
+	// Restricting outbound connections to onion addresses only is
+	// pointless when connecting to tor hidden services is disabled.
+	if cfg.NoOnion && len(cfg.onlyNets) == 1 && cfg.onlyNets["onion"] {
+		str := "%s: The onlynet option may not only specify onion " +
+			"when the noonion option is also specified"
+		err := fmt.Errorf(str, "loadConfig")
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// Validate any given DNS seeds.
+	for _, seed := range cfg.DNSSeeds {
+		if !isValidHostname(seed) {
+			str := "%s: The dnsseed value of '%s' is not a valid " +
+				"hostname"
+			err := fmt.Errorf(str, "loadConfig", seed)
+			fmt.Fprintln(os.Stderr, err)
+			parser.WriteHelp(os.Stderr)
+			return nil, nil, err
+		}
+	}
+
 	// --addPeer and --connect do not mix.
 	if len(cfg.AddPeers) > 0 && len(cfg.ConnectPeers) > 0 {
 		str := "%s: the --addpeer and --connect options can not be " +
@@ -482,12 +1199,50 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
-	// --proxy or --connect without --listen disables listening.
-	if (cfg.Proxy != "" || len(cfg.ConnectPeers) > 0) &&
-		len(cfg.Listeners) == 0 {
-		cfg.DisableListen = true
+	// Validate the proxy type and credentials.  SOCKS4a does not support
+	// authentication, so reject any credentials rather than silently
+	// ignoring them.
+	switch cfg.ProxyType {
+	case "socks5":
+		// SOCKS5 sends the length of the credentials as a single byte.
+		if len(cfg.ProxyUser) > 255 || len(cfg.ProxyPass) > 255 ||
+			len(cfg.OnionProxyUser) > 255 ||
+			len(cfg.OnionProxyPass) > 255 {
+
+			str := "%s: The proxyuser, proxypass, onionuser, and " +
+				"onionpass options may not be longer than 255 " +
+				"bytes"
+			err := fmt.Errorf(str, "loadConfig")
+			fmt.Fprintln(os.Stderr, err)
+			parser.WriteHelp(os.Stderr)
+			return nil, nil, err
+		}
+	case "socks4a":
+		if cfg.ProxyUser != "" || cfg.ProxyPass != "" ||
+			cfg.OnionProxyUser != "" || cfg.OnionProxyPass != "" {
+
+			str := "%s: The proxyuser, proxypass, onionuser, and " +
+				"onionpass options may not be used with a proxy " +
+				"type of socks4a since it does not support " +
+				"authentication"
+			err := fmt.Errorf(str, "loadConfig")
+			fmt.Fprintln(os.Stderr, err)
+			parser.WriteHelp(os.Stderr)
+			return nil, nil, err
+		}
+	default:
+		str := "%s: The specified proxy type [%v] is invalid -- " +
+			"supported types [socks5 socks4a]"
+		err := fmt.Errorf(str, "loadConfig", cfg.ProxyType)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
 	}
 
+	// --proxy or --connect without --listen disables listening.
+	cfg.DisableListen = listenDisabled(cfg.DisableListen, cfg.Proxy,
+		cfg.ConnectPeers, cfg.Listeners)
+
 	// Connect means no DNS seeding.
 	if len(cfg.ConnectPeers) > 0 {
 		cfg.DisableDNSSeed = true
@@ -502,11 +1257,103 @@ func loadConfig() (*config, []string, error) {
 		}
 	}
 
-	// The RPC server is disabled if no username or password is provided.
-	if cfg.RPCUser == "" || cfg.RPCPass == "" {
+	// Check to make sure limited and admin users don't have the same
+	// username.
+	if cfg.RPCUser != "" && cfg.RPCUser == cfg.RPCLimitUser {
+		str := "%s: --rpcuser and --rpclimituser must not specify the " +
+			"same username"
+		err := fmt.Errorf(str, "loadConfig")
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// Don't allow a negative RPC rate limit.
+	if cfg.RPCRateLimit < 0 {
+		str := "%s: The rpcratelimit option may not be negative " +
+			"-- parsed [%v]"
+		err := fmt.Errorf(str, "loadConfig", cfg.RPCRateLimit)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// Don't allow a negative RPC request size limit.
+	if cfg.MaxRPCRequestSize < 0 {
+		str := "%s: The maxrpcrequestsize option may not be negative " +
+			"-- parsed [%d]"
+		err := fmt.Errorf(str, "loadConfig", cfg.MaxRPCRequestSize)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// The limited user credentials must be specified together.
+	if (cfg.RPCLimitUser == "") != (cfg.RPCLimitPass == "") {
+		str := "%s: --rpclimituser and --rpclimitpass must be " +
+			"specified together"
+		err := fmt.Errorf(str, "loadConfig")
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// The cookie is used in place of an explicitly configured username and
+	// password for the admin user.
+	if cfg.RPCCookie && (cfg.RPCUser != "" || cfg.RPCPass != "") {
+		str := "%s: --rpccookie may not be combined with --rpcuser " +
+			"or --rpcpass"
+		err := fmt.Errorf(str, "loadConfig")
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// The RPC server is disabled if no username or password is provided
+	// for either the admin or the limited user and no cookie is to be
+	// generated.
+	if (cfg.RPCUser == "" || cfg.RPCPass == "") &&
+		(cfg.RPCLimitUser == "" || cfg.RPCLimitPass == "") &&
+		!cfg.RPCCookie {
 		cfg.DisableRPC = true
 	}
 
+	// The RPC certificate and key may either be loaded from files or
+	// provided as PEM data, but not both.  The PEM data is parsed now so a
+	// malformed certificate is reported at startup.
+	if cfg.RPCCertPEM != "" || cfg.RPCKeyPEM != "" {
+		if cfg.RPCCertPEM == "" || cfg.RPCKeyPEM == "" {
+			str := "%s: --rpccertpem and --rpckeypem must be " +
+				"specified together"
+			err := fmt.Errorf(str, "loadConfig")
+			fmt.Fprintln(os.Stderr, err)
+			parser.WriteHelp(os.Stderr)
+			return nil, nil, err
+		}
+		if cfg.RPCCert != defaultRPCCertFile ||
+			cfg.RPCKey != defaultRPCKeyFile {
+
+			str := "%s: --rpccertpem and --rpckeypem may not be " +
+				"used with --rpccert or --rpckey"
+			err := fmt.Errorf(str, "loadConfig")
+			fmt.Fprintln(os.Stderr, err)
+			parser.WriteHelp(os.Stderr)
+			return nil, nil, err
+		}
+
+		keyPair, err := tls.X509KeyPair(pemData(cfg.RPCCertPEM),
+			pemData(cfg.RPCKeyPEM))
+		if err != nil {
+			str := "%s: Unable to parse the RPC certificate and " +
+				"key PEM data: %v"
+			err := fmt.Errorf(str, "loadConfig", err)
+			fmt.Fprintln(os.Stderr, err)
+			parser.WriteHelp(os.Stderr)
+			return nil, nil, err
+		}
+		cfg.rpcKeyPair = &keyPair
+	}
+
 	// Default RPC to listen on localhost only.
 	if !cfg.DisableRPC && len(cfg.RPCListeners) == 0 {
 		addrs, err := net.LookupHost("localhost")
@@ -538,6 +1385,29 @@ func loadConfig() (*config, []string, error) {
 	cfg.BlockPrioritySize = minUint32(cfg.BlockPrioritySize, cfg.BlockMaxSize)
 	cfg.BlockMinSize = minUint32(cfg.BlockMinSize, cfg.BlockMaxSize)
 
+	// The version of generated blocks must be a known version and may
+	// only be overridden on the test networks since blocks with an
+	// unexpected version could be rejected by the main network.
+	if cfg.BlockVersion < 1 || cfg.BlockVersion > maxGeneratedBlockVersion {
+		str := "%s: The blockversion option must be between 1 and %d " +
+			"-- parsed [%d]"
+		err := fmt.Errorf(str, "loadConfig", maxGeneratedBlockVersion,
+			cfg.BlockVersion)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+	if cfg.BlockVersion != generatedBlockVersion &&
+		activeNetParams == &mainNetParams {
+
+		str := "%s: The blockversion option may only be used on the " +
+			"test networks"
+		err := fmt.Errorf(str, "loadConfig")
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
 	// Check keys are valid and saved parsed versions.
 	cfg.miningKeys = make([]btcutil.Address, 0, len(cfg.GetWorkKeys))
 	for _, strAddr := range cfg.GetWorkKeys {
@@ -559,13 +1429,68 @@ func loadConfig() (*config, []string, error) {
 		cfg.miningKeys = append(cfg.miningKeys, addr)
 	}
 
+	// Check the mining scripts are valid standard payment scripts and
+	// save the scripts for all of the mining keys and scripts so the
+	// generated blocks can pay to any of them.
+	cfg.miningScripts = make([][]byte, 0, len(cfg.miningKeys)+
+		len(cfg.MiningScripts))
+	for _, addr := range cfg.miningKeys {
+		pkScript, err := btcscript.PayToAddrScript(addr)
+		if err != nil {
+			str := "%s: the specified getworkkey '%s' can't be paid " +
+				"to: %v"
+			err := fmt.Errorf(str, "loadConfig", addr, err)
+			fmt.Fprintln(os.Stderr, err)
+			parser.WriteHelp(os.Stderr)
+			return nil, nil, err
+		}
+		cfg.miningScripts = append(cfg.miningScripts, pkScript)
+	}
+	for _, strScript := range cfg.MiningScripts {
+		pkScript, err := hex.DecodeString(strScript)
+		if err != nil {
+			str := "%s: the specified miningscript '%s' failed to " +
+				"decode: %v"
+			err := fmt.Errorf(str, "loadConfig", strScript, err)
+			fmt.Fprintln(os.Stderr, err)
+			parser.WriteHelp(os.Stderr)
+			return nil, nil, err
+		}
+		err = checkMiningScript(pkScript)
+		if err != nil {
+			str := "%s: the specified miningscript '%s' is not a " +
+				"valid payment script: %v"
+			err := fmt.Errorf(str, "loadConfig", strScript, err)
+			fmt.Fprintln(os.Stderr, err)
+			parser.WriteHelp(os.Stderr)
+			return nil, nil, err
+		}
+		cfg.miningScripts = append(cfg.miningScripts, pkScript)
+	}
+
 	// Add default port to all listener addresses if needed and remove
 	// duplicate addresses.
 	cfg.Listeners = normalizeAddresses(cfg.Listeners,
 		activeNetParams.DefaultPort)
 
 	// Add default port to all rpc listener addresses if needed and remove
-	// duplicate addresses.
+	// duplicate addresses.  The paths of Unix domain socket listeners are
+	// expanded instead.
+	for i, addr := range cfg.RPCListeners {
+		if !strings.HasPrefix(addr, unixSocketPrefix) {
+			continue
+		}
+		path := strings.TrimPrefix(addr, unixSocketPrefix)
+		if path == "" {
+			str := "%s: The rpclisten value of '%s' does not " +
+				"specify a socket path"
+			err := fmt.Errorf(str, "loadConfig", addr)
+			fmt.Fprintln(os.Stderr, err)
+			parser.WriteHelp(os.Stderr)
+			return nil, nil, err
+		}
+		cfg.RPCListeners[i] = unixSocketPrefix + cleanAndExpandPath(path)
+	}
 	cfg.RPCListeners = normalizeAddresses(cfg.RPCListeners,
 		activeNetParams.rpcPort)
 
@@ -575,26 +1500,63 @@ func loadConfig() (*config, []string, error) {
 		activeNetParams.DefaultPort)
 	cfg.ConnectPeers = normalizeAddresses(cfg.ConnectPeers,
 		activeNetParams.DefaultPort)
+	cfg.BlockRelayPeers = normalizeAddresses(cfg.BlockRelayPeers,
+		activeNetParams.DefaultPort)
+
+	// Relaying can only be restricted to peers which are connected to as
+	// added peers since inbound and automatically selected peers are never
+	// marked as block relay peers.
+	addedPeers := make(map[string]struct{})
+	for _, addr := range cfg.AddPeers {
+		addedPeers[addr] = struct{}{}
+	}
+	for _, addr := range cfg.ConnectPeers {
+		addedPeers[addr] = struct{}{}
+	}
+	for _, addr := range cfg.BlockRelayPeers {
+		if _, ok := addedPeers[addr]; !ok {
+			str := "%s: the block relay peer %s must also be " +
+				"specified with --addpeer or --connect"
+			err := fmt.Errorf(str, "loadConfig", addr)
+			fmt.Fprintln(os.Stderr, err)
+			parser.WriteHelp(os.Stderr)
+			return nil, nil, err
+		}
+	}
 
 	// Setup dial and DNS resolution (lookup) functions depending on the
-	// specified options.  The default is to use the standard net.Dial
-	// function as well as the system DNS resolver.  When a proxy is
-	// specified, the dial function is set to the proxy specific dial
-	// function and the lookup is set to use tor (unless --noonion is
-	// specified in which case the system DNS resolver is used).
-	cfg.dial = net.Dial
+	// specified options.  The default is to connect directly from the bind
+	// address, if any, as well as to use the system DNS resolver.  When a
+	// proxy is specified, the dial function is set to the proxy specific
+	// dial function and the lookup is set to use tor (unless --noonion is
+	// specified in which case the system DNS resolver is used).  SOCKS4a
+	// proxies can't be used for DNS lookups, so the system DNS resolver is
+	// used for them as well.  Hostnames are still passed through to the
+	// proxy when dialing so it resolves the hosts that are connected to.
+	cfg.dial = directDial
 	cfg.lookup = net.LookupIP
 	if cfg.Proxy != "" {
-		proxy := &socks.Proxy{
-			Addr:     cfg.Proxy,
-			Username: cfg.ProxyUser,
-			Password: cfg.ProxyPass,
-		}
-		cfg.dial = proxy.Dial
-		if !cfg.NoOnion {
+		cfg.dial = proxyDial(cfg.ProxyType, cfg.Proxy, cfg.ProxyUser,
+			cfg.ProxyPass)
+		switch {
+		case cfg.NoOnion:
+		case cfg.ProxyType == "socks4a":
+			if !cfg.DisableDNSSeed {
+				btcdLog.Warnf("DNS seeds are looked up with " +
+					"the system DNS resolver since " +
+					"SOCKS4a proxies don't support DNS " +
+					"lookups -- use --nodnsseed to " +
+					"avoid it")
+			}
+		default:
+			directLookup := cfg.lookup
 			cfg.lookup = func(host string) ([]net.IP, error) {
 				return torLookupIP(host, cfg.Proxy)
 			}
+			if cfg.DNSFallback {
+				cfg.lookup = lookupWithFallback(cfg.lookup,
+					directLookup)
+			}
 		}
 	}
 
@@ -605,18 +1567,15 @@ func loadConfig() (*config, []string, error) {
 	// lookup functions are set to use the onion-specific proxy while
 	// leaving the normal dial and lookup functions as selected above.
 	// This allows .onion address traffic to be routed through a different
-	// proxy than normal traffic.
-	if cfg.OnionProxy != "" {
-		cfg.oniondial = func(a, b string) (net.Conn, error) {
-			proxy := &socks.Proxy{
-				Addr:     cfg.OnionProxy,
-				Username: cfg.OnionProxyUser,
-				Password: cfg.OnionProxyPass,
-			}
-			return proxy.Dial(a, b)
-		}
-		cfg.onionlookup = func(host string) ([]net.IP, error) {
-			return torLookupIP(host, cfg.OnionProxy)
+	// proxy than normal traffic.  When multiple onion-specific proxies are
+	// specified, the connections and lookups are distributed among them.
+	if len(cfg.OnionProxies) > 0 {
+		pool := newOnionProxyPool(cfg.ProxyType, cfg.OnionProxies,
+			cfg.OnionProxyUser, cfg.OnionProxyPass)
+		cfg.oniondial = pool.Dial
+		cfg.onionlookup = pool.LookupIP
+		if cfg.ProxyType == "socks4a" {
+			cfg.onionlookup = cfg.lookup
 		}
 	} else {
 		cfg.oniondial = cfg.dial
@@ -634,6 +1593,22 @@ func loadConfig() (*config, []string, error) {
 		}
 	}
 
+	// Warn when the DNS fallback is requested without using tor for DNS
+	// resolution since the system DNS resolver is already used then.
+	if cfg.DNSFallback && (cfg.Proxy == "" || cfg.NoOnion ||
+		cfg.ProxyType == "socks4a") {
+
+		btcdLog.Warnf("The dnsfallback option has no effect unless DNS " +
+			"lookups are done through tor with a socks5 --proxy")
+	}
+
+	// Warn when a ban duration is specified along with disabled banning
+	// since it has no effect.
+	if cfg.DisableBanning && cfg.BanDuration != defaultBanDuration {
+		btcdLog.Warnf("The banduration option has no effect when " +
+			"banning is disabled with --nobanning")
+	}
+
 	// Warn about missing config file only after all other configuration is
 	// done.  This prevents the warning on help messages and invalid
 	// options.  Note this should go directly before the return.
@@ -644,18 +1619,139 @@ func loadConfig() (*config, []string, error) {
 	return &cfg, remainingArgs, nil
 }
 
+// isLocalInterfaceIP returns whether or not the passed IP address is assigned
+// to one of the local network interfaces.
+func isLocalInterfaceIP(ip net.IP) bool {
+	ifaceAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, ifaceAddr := range ifaceAddrs {
+		if ipNet, ok := ifaceAddr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// directDial connects to the address on the named network without a proxy.
+// The connection originates from the bind address (--bindaddr) when one was
+// specified and the attempt is abandoned after the peer timeout
+// (--peertimeout).  It has the same signature as net.Dial so it may be used
+// in its place.
+func directDial(network, address string) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: cfg.PeerTimeout}
+	if cfg.bindAddr != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: cfg.bindAddr}
+	}
+	return dialer.Dial(network, address)
+}
+
+// proxyDial returns a dial function which connects through the proxy at the
+// passed address using the protocol specified by proxyType.  The credentials
+// are only used for SOCKS5 since SOCKS4a does not support authentication.
+// The connection to the proxy originates from the bind address (--bindaddr)
+// when one was specified.  SOCKS5 proxies are dialed with go-socks unless a
+// bind address was specified since it doesn't support one.
+func proxyDial(proxyType, addr, user, pass string) func(string, string) (net.Conn, error) {
+	if proxyType == "socks4a" {
+		proxy := &socks4aProxy{Addr: addr}
+		return proxy.Dial
+	}
+
+	if cfg.bindAddr == nil {
+		proxy := &socks.Proxy{
+			Addr:     addr,
+			Username: user,
+			Password: pass,
+		}
+		return proxy.Dial
+	}
+	proxy := &socks5Proxy{
+		Addr:     addr,
+		Username: user,
+		Password: pass,
+	}
+	return proxy.Dial
+}
+
 // btcdDial connects to the address on the named network using the appropriate
 // dial function depending on the address and configuration options.  For
 // example, .onion addresses will be dialed using the onion specific proxy if
 // one was specified, but will otherwise use the normal dial function (which
 // could itself use a proxy or not).
 func btcdDial(network, address string) (net.Conn, error) {
+	if !isAddrAllowed(address) {
+		return nil, fmt.Errorf("connections to %s are not permitted "+
+			"by the onlynet option", address)
+	}
 	if strings.HasSuffix(address, ".onion") {
 		return cfg.oniondial(network, address)
 	}
 	return cfg.dial(network, address)
 }
 
+// isNetAllowed returns whether outbound connections to the passed network, one
+// of ipv4, ipv6, or onion, are permitted by the onlynet option.  All networks
+// are permitted when the option is not specified.
+func isNetAllowed(network string) bool {
+	return len(cfg.onlyNets) == 0 || cfg.onlyNets[network]
+}
+
+// netAddressNetwork returns the network, as used by the onlynet option, the
+// passed address belongs to.
+func netAddressNetwork(na *btcwire.NetAddress) string {
+	switch {
+	case Tor(na):
+		return "onion"
+	case na.IP.To4() != nil:
+		return "ipv4"
+	}
+	return "ipv6"
+}
+
+// isAddrAllowed returns whether outbound connections to the passed host:port
+// address are permitted by the onlynet option.  Host names other than .onion
+// addresses are permitted as long as either ipv4 or ipv6 is since they can't
+// be classified until they are resolved.
+func isAddrAllowed(address string) bool {
+	if len(cfg.onlyNets) == 0 {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	if strings.HasSuffix(host, ".onion") {
+		return isNetAllowed("onion")
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return isNetAllowed("ipv4") || isNetAllowed("ipv6")
+	}
+	return isNetAllowed(netAddressNetwork(&btcwire.NetAddress{IP: ip}))
+}
+
+// lookupWithFallback returns a DNS resolution (lookup) function which resolves
+// hosts with the passed lookup function and retries a failed lookup with the
+// passed fallback lookup function, which is the one used when there is no
+// proxy.  Since doing so reveals the host outside of tor, a warning is logged
+// whenever the fallback is taken and .onion addresses, regardless of case, are
+// never retried.
+func lookupWithFallback(lookup, fallback func(string) ([]net.IP, error)) func(string) ([]net.IP, error) {
+	return func(host string) ([]net.IP, error) {
+		ips, err := lookup(host)
+		if err == nil || isOnionHost(host) {
+			return ips, err
+		}
+
+		btcdLog.Warnf("DNS lookup of %s through tor failed (%v) -- "+
+			"falling back to the direct DNS resolver", host, err)
+		return fallback(host)
+	}
+}
+
 // btcdLookup returns the correct DNS lookup function to use depending on the
 // passed host and configuration options.  For example, .onion addresses will be
 // resolved using the onion specific proxy if one was specified, but will
@@ -0,0 +1,201 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestNormalizeAddress ensures the default port is added to addresses which
+// do not already specify one while preserving brackets and IPv6 zones.
+func TestNormalizeAddress(t *testing.T) {
+	tests := []struct {
+		addr        string
+		defaultPort string
+		want        string
+	}{
+		// Bare and ported IPv4.
+		{"127.0.0.1", "8333", "127.0.0.1:8333"},
+		{"127.0.0.1:8336", "8333", "127.0.0.1:8336"},
+
+		// Hostnames.
+		{"localhost", "8333", "localhost:8333"},
+		{"localhost:8336", "8333", "localhost:8336"},
+
+		// Wildcard.
+		{"", "8333", ":8333"},
+		{":8336", "8333", ":8336"},
+
+		// Bare, bracketed, and ported IPv6.
+		{"::1", "8333", "[::1]:8333"},
+		{"[::1]", "8333", "[::1]:8333"},
+		{"[::1]:8336", "8333", "[::1]:8336"},
+		{"::", "8333", "[::]:8333"},
+		{"[::]", "8333", "[::]:8333"},
+
+		// Zoned IPv6.
+		{"fe80::1%eth0", "8333", "[fe80::1%eth0]:8333"},
+		{"[fe80::1%eth0]", "8333", "[fe80::1%eth0]:8333"},
+		{"[fe80::1%eth0]:8336", "8333", "[fe80::1%eth0]:8336"},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		got := normalizeAddress(test.addr, test.defaultPort)
+		if got != test.want {
+			t.Errorf("normalizeAddress #%d (%s)\n got: %s want: %s",
+				i, test.addr, got, test.want)
+			continue
+		}
+	}
+}
+
+// TestParseListenersZone ensures listen addresses for IPv6 link-local
+// addresses with a zone identifier are accepted and classified as IPv6.
+func TestParseListenersZone(t *testing.T) {
+	addrs := []string{
+		"127.0.0.1:8333",
+		"[::1]:8333",
+		"[fe80::1%eth0]:8333",
+	}
+	ipv4Addrs, ipv6Addrs, haveWildcard, err := parseListeners(addrs)
+	if err != nil {
+		t.Fatalf("parseListeners: unexpected error: %v", err)
+	}
+	if haveWildcard {
+		t.Errorf("parseListeners: unexpected wildcard")
+	}
+	if len(ipv4Addrs) != 1 || ipv4Addrs[0] != addrs[0] {
+		t.Errorf("parseListeners: unexpected IPv4 addresses - got %v",
+			ipv4Addrs)
+	}
+	if len(ipv6Addrs) != 2 || ipv6Addrs[0] != addrs[1] ||
+		ipv6Addrs[1] != addrs[2] {
+
+		t.Errorf("parseListeners: unexpected IPv6 addresses - got %v",
+			ipv6Addrs)
+	}
+}
+
+// TestIsValidHostname ensures hostnames provided for DNS seeds are validated
+// according to RFC 1123.
+func TestIsValidHostname(t *testing.T) {
+	tests := []struct {
+		host  string
+		valid bool
+	}{
+		{"seed.bitcoin.sipa.be", true},
+		{"dnsseed.bluematt.me.", true},
+		{"localhost", true},
+		{"1.example.com", true},
+		{"a-b.example.com", true},
+		{"", false},
+		{".", false},
+		{"example..com", false},
+		{"-example.com", false},
+		{"example-.com", false},
+		{"exa_mple.com", false},
+		{"example.com:53", false},
+		{strings.Repeat("a", 64) + ".com", false},
+		{strings.Repeat("a.", 127) + "a", false},
+	}
+
+	for i, test := range tests {
+		valid := isValidHostname(test.host)
+		if valid != test.valid {
+			t.Errorf("isValidHostname #%d (%s): got %v want %v", i,
+				test.host, valid, test.valid)
+		}
+	}
+}
+
+// TestParseExternalIP ensures externalip values are split into their host,
+// port, and score with the defaults applied and invalid values rejected.
+func TestParseExternalIP(t *testing.T) {
+	tests := []struct {
+		value string
+		host  string
+		port  uint16
+		score int
+		valid bool
+	}{
+		{"1.2.3.4", "1.2.3.4", 8333, 0, true},
+		{"1.2.3.4:8336", "1.2.3.4", 8336, 0, true},
+		{"1.2.3.4,10", "1.2.3.4", 8333, 10, true},
+		{"1.2.3.4:8336,10", "1.2.3.4", 8336, 10, true},
+		{"example.com:8336", "example.com", 8336, 0, true},
+		{"2001:db8::1", "2001:db8::1", 8333, 0, true},
+		{"[2001:db8::1]", "2001:db8::1", 8333, 0, true},
+		{"[2001:db8::1]:8336,5", "2001:db8::1", 8336, 5, true},
+		{"expyuzz4wqqyqhjn.onion", "expyuzz4wqqyqhjn.onion", 8333, 0, true},
+		{"EXPYUZZ4WQQYQHJN.onion:8336,3", "EXPYUZZ4WQQYQHJN.onion", 8336, 3, true},
+		{"pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion", "", 0, 0, false},
+		{"expyuzz4wqqyqhj.onion", "", 0, 0, false},
+		{"expyuzz4wqqyqhj1.onion", "", 0, 0, false},
+		{".onion", "", 0, 0, false},
+		{"", "", 0, 0, false},
+		{":8336", "", 0, 0, false},
+		{"1.2.3.4:port", "", 0, 0, false},
+		{"1.2.3.4:70000", "", 0, 0, false},
+		{"1.2.3.4,high", "", 0, 0, false},
+		{"1.2.3.4,-1", "", 0, 0, false},
+		{"1.2.3.4,256", "", 0, 0, false},
+	}
+
+	for i, test := range tests {
+		host, port, score, err := parseExternalIP(test.value, "8333")
+		if (err == nil) != test.valid {
+			t.Errorf("parseExternalIP #%d (%s): unexpected error "+
+				"result - got %v, want valid %v", i, test.value,
+				err, test.valid)
+			continue
+		}
+		if !test.valid {
+			continue
+		}
+		if host != test.host || port != test.port ||
+			score != test.score {
+			t.Errorf("parseExternalIP #%d (%s): got %s %d %d, "+
+				"want %s %d %d", i, test.value, host, port,
+				score, test.host, test.port, test.score)
+		}
+	}
+}
+
+// TestListenDisabled ensures the precedence of the options which control
+// whether listening for incoming connections is disabled.
+func TestListenDisabled(t *testing.T) {
+	connect := []string{"10.0.0.1:8333"}
+	listen := []string{":8333"}
+	tests := []struct {
+		name         string
+		noListen     bool
+		proxy        string
+		connectPeers []string
+		listeners    []string
+		disabled     bool
+	}{
+		{"defaults", false, "", nil, nil, false},
+		{"nolisten", true, "", nil, nil, true},
+		{"nolisten with listen", true, "", nil, listen, true},
+		{"connect", false, "", connect, nil, true},
+		{"connect with listen", false, "", connect, listen, false},
+		{"connect with listen and nolisten", true, "", connect, listen,
+			true},
+		{"proxy", false, "127.0.0.1:9050", nil, nil, true},
+		{"proxy with listen", false, "127.0.0.1:9050", nil, listen,
+			false},
+	}
+
+	for i, test := range tests {
+		disabled := listenDisabled(test.noListen, test.proxy,
+			test.connectPeers, test.listeners)
+		if disabled != test.disabled {
+			t.Errorf("listenDisabled #%d (%s): got %v want %v", i,
+				test.name, disabled, test.disabled)
+		}
+	}
+}
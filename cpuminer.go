@@ -0,0 +1,477 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/conformal/btcchain"
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxExtraNonce is the maximum value an extra nonce used in a coinbase
+	// transaction can be.
+	maxExtraNonce = ^uint64(0) // 2^64 - 1
+
+	// maxNonce is the maximum value a nonce can be in a block header.
+	maxNonce = ^uint32(0) // 2^32 - 1
+
+	// maxNumWorkers is the maximum number of workers the CPU miner may be
+	// configured to run.
+	maxNumWorkers = 256
+
+	// hashUpdateInterval is the number of hashes a worker completes between
+	// checks for whether it should stop working on its current block.
+	hashUpdateInterval = 1 << 16
+
+	// hashRateInterval is how often the hash rate reported by the CPU
+	// miner is recalculated.
+	hashRateInterval = time.Second * 10
+
+	// workerRetryInterval is how long a worker waits before trying again
+	// when it is not able to work on a block, such as while the chain is
+	// not synced or when a block template could not be created.
+	workerRetryInterval = time.Second
+)
+
+var (
+	// errMinerRunning is returned when blocks are requested from the CPU
+	// miner while it is already mining.
+	errMinerRunning = errors.New("the CPU miner is already running")
+
+	// errNoMiningScripts is returned when the CPU miner is asked to
+	// generate blocks without any scripts to pay them to.
+	errNoMiningScripts = errors.New("no payment addresses or scripts " +
+		"specified via --getworkkey or --miningscript")
+)
+
+// CPUMiner provides facilities for solving blocks (mining) using the CPU.  The
+// block templates are created from the memory pool of the server, so the
+// solved blocks include its transactions as normal mining would, and the
+// coinbase pays to one of the configured mining scripts.
+//
+// Blocks are either generated on demand via GenerateNBlocks or continuously by
+// a number of workers once the miner is started.  Only one of the two may be in
+// progress at a time.
+type CPUMiner struct {
+	sync.Mutex
+	server     *server
+	generating bool
+	started    bool
+	quit       chan struct{}
+	wg         sync.WaitGroup
+
+	// The following fields are accessed atomically since they are used
+	// by the worker controller while the mutex may be held waiting for it
+	// to stop.
+	numWorkers      int32
+	hashesCompleted uint64
+	hashesPerSec    int64
+
+	// updateNumWorkers notifies the worker controller that the number of
+	// workers changed.
+	updateNumWorkers chan struct{}
+}
+
+// solveBlock attempts to find a nonce and extra nonce which make the hash of
+// the passed block less than or equal to its target difficulty.  The block is
+// updated in place.  It returns whether or not the block was solved within the
+// passed maximum number of hashes.  A maximum of 0 means there is no limit.
+//
+// A non-nil quit channel causes the search to be abandoned as unsolved when
+// the channel is closed or when the best block changes, since the block would
+// then be stale.
+func (m *CPUMiner) solveBlock(msgBlock *btcwire.MsgBlock, blockHeight int64, maxTries uint64, quit <-chan struct{}) (bool, error) {
+	header := &msgBlock.Header
+	targetDifficulty := btcchain.CompactToBig(header.Bits)
+
+	// Start the extra nonce at a random offset since every worker solves
+	// a block created from the same template.  Otherwise all workers would
+	// search the exact same hashes.
+	extraNonceOffset := uint64(rand.Int63())
+
+	var tries uint64
+	for n := uint64(0); n < maxExtraNonce; n++ {
+		// Update the extra nonce in the coinbase script, which also
+		// updates the merkle root in the header, so the whole nonce
+		// range can be searched again.
+		extraNonce := extraNonceOffset + n
+		err := UpdateExtraNonce(msgBlock, blockHeight, extraNonce)
+		if err != nil {
+			return false, err
+		}
+
+		for i := uint32(0); i <= maxNonce; i++ {
+			if maxTries != 0 && tries >= maxTries {
+				return false, nil
+			}
+			tries++
+
+			// Periodically account for the completed hashes and
+			// check whether the work should be abandoned.
+			if tries%hashUpdateInterval == 0 {
+				atomic.AddUint64(&m.hashesCompleted,
+					hashUpdateInterval)
+				if quit != nil && m.isStale(header, quit) {
+					return false, nil
+				}
+			}
+
+			header.Nonce = i
+			hash, err := header.BlockSha()
+			if err != nil {
+				return false, err
+			}
+			if btcchain.ShaHashToBig(&hash).Cmp(targetDifficulty) <= 0 {
+				atomic.AddUint64(&m.hashesCompleted,
+					tries%hashUpdateInterval)
+				return true, nil
+			}
+
+			// Avoid wrapping the nonce back around to 0.
+			if i == maxNonce {
+				break
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// isStale returns whether or not work on the block with the passed header
+// should be abandoned because the passed quit channel was closed or the best
+// block changed.
+func (m *CPUMiner) isStale(header *btcwire.BlockHeader, quit <-chan struct{}) bool {
+	select {
+	case <-quit:
+		return true
+	default:
+	}
+
+	latestHash, _ := m.server.blockManager.chainState.Best()
+	return !header.PrevBlock.IsEqual(latestHash)
+}
+
+// processGeneratedBlock processes the passed solved block using the same rules
+// as blocks coming from other nodes, which will in turn relay it to the network
+// like normal.  It returns the hash of the block.
+func (m *CPUMiner) processGeneratedBlock(block *btcutil.Block) (*btcwire.ShaHash, error) {
+	isOrphan, err := m.server.blockManager.ProcessBlock(block)
+	if err != nil {
+		return nil, fmt.Errorf("generated block rejected: %v", err)
+	}
+	if isOrphan {
+		return nil, errors.New("generated block is an orphan")
+	}
+
+	blockHash, err := block.Sha()
+	if err != nil {
+		return nil, err
+	}
+	minrLog.Infof("Generated block %s at height %d", blockHash,
+		block.Height())
+	return blockHash, nil
+}
+
+// GenerateNBlocks creates and solves the passed number of blocks on top of the
+// current best chain and processes them as if they had been received from the
+// network.  It returns the hashes of the generated blocks.  An error is
+// returned when a block can't be solved within the passed maximum number of
+// hashes, where 0 means there is no limit.
+//
+// This function is safe for concurrent access.
+func (m *CPUMiner) GenerateNBlocks(n uint32, maxTries uint64) ([]*btcwire.ShaHash, error) {
+	m.Lock()
+	if m.started || m.generating {
+		m.Unlock()
+		return nil, errMinerRunning
+	}
+	if len(cfg.miningScripts) == 0 {
+		m.Unlock()
+		return nil, errNoMiningScripts
+	}
+	m.generating = true
+	m.Unlock()
+
+	defer func() {
+		m.Lock()
+		m.generating = false
+		m.Unlock()
+	}()
+
+	blockHashes := make([]*btcwire.ShaHash, 0, n)
+	for i := uint32(0); i < n; i++ {
+		// Create a new block template paying to a random payment
+		// script.
+		payToScript := cfg.miningScripts[rand.Intn(len(cfg.miningScripts))]
+		template, err := NewBlockTemplate(payToScript, m.server.txMemPool)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create new block "+
+				"template: %v", err)
+		}
+
+		solved, err := m.solveBlock(template.block, template.height,
+			maxTries, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to solve block: %v", err)
+		}
+		if !solved {
+			return nil, fmt.Errorf("failed to solve block within %d "+
+				"tries", maxTries)
+		}
+
+		block := btcutil.NewBlock(template.block)
+		block.SetHeight(template.height)
+		blockHash, err := m.processGeneratedBlock(block)
+		if err != nil {
+			return nil, err
+		}
+		blockHashes = append(blockHashes, blockHash)
+	}
+
+	return blockHashes, nil
+}
+
+// generateBlocks is a worker which continuously creates and solves blocks on
+// top of the current best chain until the passed quit channel is closed.  Work
+// on a block is abandoned as soon as the best block changes so a new block can
+// be created on top of it.
+//
+// It must be run as a goroutine.
+func (m *CPUMiner) generateBlocks(quit chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for {
+		select {
+		case <-quit:
+			return
+		default:
+		}
+
+		// Wait until there are peers to relay the blocks to and the
+		// chain is synced since mining on top of an old block is
+		// pointless.  This is not required when running in the
+		// regression test or simulation test mode.
+		if !(cfg.RegressionTest || cfg.SimNet) &&
+			(m.server.ConnectedCount() == 0 ||
+				!m.server.blockManager.IsCurrent()) {
+
+			if !m.waitRetry(quit) {
+				return
+			}
+			continue
+		}
+
+		payToScript := cfg.miningScripts[rand.Intn(len(cfg.miningScripts))]
+		template, err := NewBlockTemplate(payToScript, m.server.txMemPool)
+		if err != nil {
+			minrLog.Errorf("Failed to create new block template: %v",
+				err)
+			if !m.waitRetry(quit) {
+				return
+			}
+			continue
+		}
+
+		solved, err := m.solveBlock(template.block, template.height, 0,
+			quit)
+		if err != nil {
+			minrLog.Errorf("Failed to solve block: %v", err)
+			continue
+		}
+		if !solved {
+			continue
+		}
+
+		block := btcutil.NewBlock(template.block)
+		block.SetHeight(template.height)
+		if _, err := m.processGeneratedBlock(block); err != nil {
+			minrLog.Errorf("%v", err)
+		}
+	}
+}
+
+// waitRetry waits for workerRetryInterval before a worker tries again.  It
+// returns false when the passed quit channel was closed while waiting.
+func (m *CPUMiner) waitRetry(quit chan struct{}) bool {
+	timer := time.NewTimer(workerRetryInterval)
+	defer timer.Stop()
+	select {
+	case <-quit:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// workerController launches the configured number of workers and adjusts how
+// many are running when the number changes.  It also periodically calculates
+// the hash rate of the miner.  All workers are stopped before it returns when
+// the miner is stopped.
+//
+// It must be run as a goroutine.
+func (m *CPUMiner) workerController(quit chan struct{}) {
+	var workerWg sync.WaitGroup
+	var runningWorkers []chan struct{}
+	adjustWorkers := func() {
+		numWorkers := int(atomic.LoadInt32(&m.numWorkers))
+		for len(runningWorkers) < numWorkers {
+			workerQuit := make(chan struct{})
+			runningWorkers = append(runningWorkers, workerQuit)
+			workerWg.Add(1)
+			go m.generateBlocks(workerQuit, &workerWg)
+		}
+		for len(runningWorkers) > numWorkers {
+			last := len(runningWorkers) - 1
+			close(runningWorkers[last])
+			runningWorkers = runningWorkers[:last]
+		}
+		minrLog.Infof("CPU miner running %d workers", numWorkers)
+	}
+	adjustWorkers()
+
+	ticker := time.NewTicker(hashRateInterval)
+	defer ticker.Stop()
+	lastUpdate := time.Now()
+out:
+	for {
+		select {
+		case <-m.updateNumWorkers:
+			adjustWorkers()
+
+		case now := <-ticker.C:
+			hashes := atomic.SwapUint64(&m.hashesCompleted, 0)
+			elapsed := now.Sub(lastUpdate).Seconds()
+			lastUpdate = now
+			if elapsed > 0 {
+				atomic.StoreInt64(&m.hashesPerSec,
+					int64(float64(hashes)/elapsed))
+			}
+
+		case <-quit:
+			break out
+		}
+	}
+
+	for _, workerQuit := range runningWorkers {
+		close(workerQuit)
+	}
+	workerWg.Wait()
+	atomic.StoreInt64(&m.hashesPerSec, 0)
+	atomic.StoreUint64(&m.hashesCompleted, 0)
+	m.wg.Done()
+}
+
+// Start begins continuously generating blocks with the configured number of
+// workers.  Calling it while the miner is already running has no effect.
+//
+// This function is safe for concurrent access.
+func (m *CPUMiner) Start() error {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.started {
+		return nil
+	}
+	if m.generating {
+		return errMinerRunning
+	}
+	if len(cfg.miningScripts) == 0 {
+		return errNoMiningScripts
+	}
+
+	atomic.StoreUint64(&m.hashesCompleted, 0)
+	m.quit = make(chan struct{})
+	m.wg.Add(1)
+	go m.workerController(m.quit)
+	m.started = true
+	minrLog.Infof("CPU miner started")
+	return nil
+}
+
+// Stop stops all workers and waits for them to finish.  Calling it while the
+// miner is not running has no effect.
+//
+// This function is safe for concurrent access.
+func (m *CPUMiner) Stop() {
+	m.Lock()
+	defer m.Unlock()
+
+	if !m.started {
+		return
+	}
+	close(m.quit)
+	m.wg.Wait()
+	m.started = false
+	minrLog.Infof("CPU miner stopped")
+}
+
+// SetNumWorkers sets the number of workers used while continuously generating
+// blocks.  A negative number uses one worker per CPU.  The number of running
+// workers is adjusted immediately when the miner is running.
+//
+// This function is safe for concurrent access.
+func (m *CPUMiner) SetNumWorkers(numWorkers int32) {
+	if numWorkers < 0 {
+		numWorkers = int32(runtime.NumCPU())
+	}
+	if numWorkers > maxNumWorkers {
+		numWorkers = maxNumWorkers
+	}
+	atomic.StoreInt32(&m.numWorkers, numWorkers)
+
+	// Notify the worker controller without blocking.  A pending
+	// notification already causes it to pick up the new number.
+	select {
+	case m.updateNumWorkers <- struct{}{}:
+	default:
+	}
+}
+
+// NumWorkers returns the number of workers used while continuously generating
+// blocks.
+//
+// This function is safe for concurrent access.
+func (m *CPUMiner) NumWorkers() int32 {
+	return atomic.LoadInt32(&m.numWorkers)
+}
+
+// IsMining returns whether or not the CPU miner is currently generating blocks,
+// either continuously or on demand.
+//
+// This function is safe for concurrent access.
+func (m *CPUMiner) IsMining() bool {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.started || m.generating
+}
+
+// HashesPerSecond returns the number of hashes per second the CPU miner is
+// completing while continuously generating blocks.  It is 0 when the miner is
+// not running.
+//
+// This function is safe for concurrent access.
+func (m *CPUMiner) HashesPerSecond() int64 {
+	return atomic.LoadInt64(&m.hashesPerSec)
+}
+
+// newCPUMiner returns a new CPU miner which generates blocks for the passed
+// server.  It uses one worker per CPU until the number of workers is changed.
+func newCPUMiner(s *server) *CPUMiner {
+	rand.Seed(time.Now().UnixNano())
+	return &CPUMiner{
+		server:           s,
+		numWorkers:       int32(runtime.NumCPU()),
+		updateNumWorkers: make(chan struct{}, 1),
+	}
+}
@@ -44,7 +44,7 @@ var (
 // resolution over the Tor network. Tor itself doesnt support ipv6 so this
 // doesn't either.
 func torLookupIP(host, proxy string) ([]net.IP, error) {
-	conn, err := net.Dial("tcp", proxy)
+	conn, err := directDial("tcp", proxy)
 	if err != nil {
 		return nil, err
 	}
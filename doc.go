@@ -26,45 +26,214 @@ Application Options:
   -b, --datadir=           Directory to store data
   -a, --addpeer=           Add a peer to connect with at startup
       --connect=           Connect only to the specified peers at startup
+      --blockrelaypeer=    Relay blocks and transactions only to the specified
+                           added peer -- Must also be given with --addpeer or
+                           --connect and may be specified multiple times.  No
+                           other peers, including inbound ones, receive
+                           relayed inventory
       --nolisten           Disable listening for incoming connections -- NOTE:
                            Listening is automatically disabled if the --connect
                            or --proxy options are used without also specifying
-                           listen interfaces via --listen
+                           listen interfaces via --listen, so --connect may be
+                           combined with --listen to accept incoming
+                           connections
       --listen=            Add an interface/port to listen for connections
                            (default all interfaces port: 8333, testnet: 18333)
       --maxpeers=          Max number of inbound and outbound peers (125)
+      --maxoutbound=       Number of outbound peers to maintain connections to
+                           -- Limited by --maxpeers (8)
+      --maxinbound=        Max number of inbound peers -- Limited by
+                           --maxpeers.  -1 reserves the slots needed by
+                           --maxoutbound and the peers specified via --addpeer
+                           or --connect and allows inbound peers to use the
+                           rest.  0 rejects all inbound peers (-1)
+      --connretryinterval= Initial time to wait between attempts to connect to
+                           a persistent peer -- The interval doubles with each
+                           failed attempt up to 5 minutes.  Valid time units
+                           are {s, m, h}.  Minimum 1 second (5s)
+      --maxaddrspersecond= Max number of addresses per second accepted from a
+                           peer -- Peers may always send a full addr message
+                           worth in addition to the limit and the reply to our
+                           getaddr request is not counted.  Addresses beyond it
+                           are discarded and add to the ban score of the peer.
+                           0 disables the limit (0.1)
+      --maxheaderspersecond=
+                           Max number of block headers per second a peer may
+                           send before it is banned for flooding -- Headers
+                           answering our requests are not counted and peers may
+                           always send a full headers message worth in addition
+                           to the limit.  0 disables the limit (10000)
+      --peertimeout=       How long to wait for a connection to a peer,
+                           including through a proxy, and for the peer to
+                           complete the version handshake before disconnecting
+                           it.  Valid time units are {s, m, h}.  Minimum 1
+                           second (1m0s)
+      --maxuploadtarget=   Try to keep the data sent to peers under the given
+                           target in MiB per 24h cycle -- Historical blocks are
+                           no longer served to peers which are not whitelisted
+                           once it is nearly reached.  Each cycle starts when
+                           the previous one ends rather than being a rolling
+                           window.  Minimum 138 MiB.  0 disables the target
+      --maxsendbuffer=     Max number of messages queued to be sent to a peer
+                           before it is disconnected -- 0 disables the limit
+                           (5000)
+      --nobanning          Disable banning of misbehaving peers
+      --nopeerbloomfilters Disable bloom filtering support (BIP0037), which is
+                           enabled by default on all networks -- The bloom
+                           service bit is not advertised and peers which send
+                           filter messages are disconnected
+      --advertiseservice=  Only advertise the specified service to peers {none,
+                           network, bloom} -- May be specified multiple times.
+                           All enabled services are advertised when not
+                           specified
       --banduration=       How long to ban misbehaving peers.  Valid time units
                            are {s, m, h}.  Minimum 1 second (24h0m0s)
+      --banthreshold=      Ban score at which misbehaving peers are banned and
+                           disconnected -- Whitelisted peers, and all peers
+                           when banning is disabled, are never disconnected
+                           for their score (100)
+      --banscoredecay=     Number of points per hour the ban score of a peer
+                           decays toward zero.  0 disables the decay
+      --whitelist=         Add an IP network or IP that will not be banned or
+                           rate limited. (eg. 192.168.1.0/24 or ::1)
+      --maxtimeoffset=     Max amount the time reported by peers may adjust the
+                           local clock in either direction -- The offset
+                           reported by a single peer is capped to this amount
+                           and no adjustment is made when the median offset of
+                           all peers is not within it.  Valid time units are
+                           {s, m, h}.  0 disables adjusting the local clock
+                           (1h10m0s)
+      --rejecttimeoffset=  Disconnect peers which report a time differing from
+                           the local clock by more than this amount in their
+                           version message instead of using it to adjust the
+                           time used for block templates.  Valid time units
+                           are {s, m, h}.  0 accepts peers regardless of their
+                           time
+      --blockrelaydelay=   How long to hold back the relay of newly accepted
+                           blocks to peers so their inventory is coalesced --
+                           Blocks submitted locally, such as mined blocks, are
+                           never delayed.  Valid time units are {ms, s, m, h}.
+                           0 relays immediately
+      --rebroadcastinterval=
+                           Max interval between rebroadcasts of the inventory
+                           of transactions submitted via RPC which have not
+                           been mined yet -- Transactions which are mined or
+                           leave the memory pool, such as due to a conflict,
+                           are no longer rebroadcast.  Valid time units are
+                           {s, m, h}.  0 disables rebroadcasting (30m)
+      --shutdowntimeout=   How long to wait for queued messages to be sent to
+                           peers on shutdown before forcibly closing the
+                           connections.  Valid time units are {ms, s, m, h}.
+                           0 disconnects immediately (5s)
   -u, --rpcuser=           Username for RPC connections
   -P, --rpcpass=           Password for RPC connections
+      --rpclimituser=      Username for limited RPC connections
+      --rpclimitpass=      Password for limited RPC connections
+      --rpccookie          Generate a random password written to the .cookie
+                           file in the data directory for RPC connections --
+                           May not be combined with --rpcuser/--rpcpass
       --rpclisten=         Add an interface/port to listen for RPC connections
-                           (default port: 8334, testnet: 18334)
+                           (default port: 8334, testnet: 18334) -- Use
+                           unix:/path/to/socket for a Unix domain socket
       --rpccert=           File containing the certificate file
       --rpckey=            File containing the certificate key
+      --rpccertpem=        PEM encoded certificate to use instead of --rpccert
+      --rpckeypem=         PEM encoded certificate key to use instead of
+                           --rpckey
+      --rpcallowip=        Allow RPC connections from an IP network or IP in
+                           addition to localhost (eg. 192.168.1.0/24 or ::1) --
+                           All addresses are allowed when not specified
       --rpcmaxclients=     Max number of RPC clients for standard connections
                            (10)
       --rpcmaxwebsockets=  Max number of RPC clients for standard connections
                            (25)
+      --maxrpcrequestsize= Max size in bytes of an RPC request body or
+                           websocket message -- Larger requests are rejected
+                           before they are read into memory.  0 disables the
+                           limit (8388608)
+      --rpcratelimit=      Max number of requests per second each RPC user may
+                           make for each command -- Short bursts of up to a
+                           second worth of requests are allowed and requests
+                           over the limit are rejected with an error.  The
+                           admin user is limited as well since admin clients
+                           are just as able to starve the node.  0 disables the
+                           limit (0)
+      --rpcquirks          Mirror some JSON-RPC quirks of Bitcoin Core -- NOTE:
+                           Discouraged unless interoperability issues need to
+                           be worked around
       --norpc              Disable built-in RPC server -- NOTE: The RPC server
                            is disabled by default if no rpcuser/rpcpass is
                            specified
+      --metricslisten=     Add an interface/port to serve metrics in the
+                           Prometheus text format on over plain HTTP at
+                           /metrics (eg. 127.0.0.1:9332) -- NOTE: The metrics
+                           are not authenticated and are not served unless this
+                           option is specified
       --nodnsseed          Disable DNS seeding for peers
+      --dnsseed=           Add a DNS seed to query for peers instead of the
+                           built-in seeds for the network
       --externalip:        Add an ip to the list of local addresses we claim to
-                           listen on to peers
-      --proxy=             Connect via SOCKS5 proxy (eg. 127.0.0.1:9050)
+                           listen on to peers -- Use host:port to specify a
+                           port other than the default and append ,score to
+                           prefer some addresses over others (eg.
+                           1.2.3.4:8336,10)
+      --uacomment=         Comment to add to the user agent advertised to
+                           peers -- See BIP 14 for more information
+      --proxy=             Connect via SOCKS5 or SOCKS4a proxy as selected by
+                           --proxytype (eg. 127.0.0.1:9050)
+      --proxytype=         Type of proxy used for --proxy and --onion {socks5,
+                           socks4a} -- NOTE: SOCKS4a does not support
+                           authentication or DNS lookups, so DNS seeds and
+                           hostnames are looked up with the system DNS
+                           resolver while connections to hostnames are still
+                           resolved by the proxy (socks5)
       --proxyuser=         Username for proxy server
       --proxypass=         Password for proxy server
-      --onion=             Connect to tor hidden services via SOCKS5 proxy (eg.
-                           127.0.0.1:9050)
+      --onion=             Connect to tor hidden services via SOCKS5 or SOCKS4a
+                           proxy as selected by --proxytype (eg.
+                           127.0.0.1:9050) -- May be specified multiple times
+                           to spread connections across several proxies
       --onionuser=         Username for onion proxy server
       --onionpass=         Password for onion proxy server
       --noonion=           Disable connecting to tor hidden services
+      --dnsfallback        Retry DNS lookups which fail through the tor proxy
+                           directly with the system DNS resolver, bypassing the
+                           proxy -- This reveals the looked up hosts outside of
+                           tor and is never done for .onion addresses.  It has
+                           no effect with a socks4a proxy or --noonion since
+                           lookups already use the system resolver then
+      --bindaddr=          Local IP address outbound connections to peers
+                           originate from -- When a proxy is used, this
+                           applies to the connections to the proxy.  Must be
+                           the address of a local interface
+      --onlynet=           Only make outbound connections to peers on the
+                           specified network {ipv4, ipv6, onion} -- May be
+                           specified multiple times
       --tor=               Specifies the proxy server used is a Tor node
       --testnet=           Use the test network
       --regtest=           Use the regression test network
       --nocheckpoints=     Disable built-in checkpoints.  Don't do this unless
                            you know what you're doing.
       --dbtype=            Database backend to use for the Block Chain (leveldb)
+      --addrindex          Maintain a full address index which makes the
+                           searchrawtransactions RPC available
+      --reindex            Rebuild the block database from the blocks stored in
+                           it on start up by validating and connecting each
+                           block again -- Resumes a previous reindex which was
+                           interrupted
+      --checkblocks=       Number of blocks at the tip of the main chain to
+                           re-validate on start up -- btcd refuses to start
+                           when the check fails.  0 disables the check
+      --checklevel=        How thorough the check of --checkblocks is {0-4} --
+                           Each level includes the checks of the lower ones: 0
+                           reads the blocks, 1 checks their sanity, 2 checks
+                           they connect, 3 validates the scripts, and 4
+                           reconnects the blocks and checks the spent outputs
+                           match the database (3)
+      --cfilters           Maintain an index of BIP0158 compact block filters
+                           which makes the getcfilter RPC available
+      --dropcfindex        Deletes the compact block filter index from the
+                           database on start up and then exits
       --profile=           Enable HTTP profiling on given port -- NOTE port must
                            be between 1024 and 65536 (6060)
       --cpuprofile=        Write CPU profile to the specified file
@@ -77,15 +246,44 @@ Application Options:
       --limitfreerelay=    Limit relay of transactions with no transaction fee
                            to the given amount in thousands of bytes per minute
                            (15)
+      --minrelaytxfee=     The minimum transaction fee in BTC/kB for a
+                           transaction to not be considered free for relay and
+                           mining purposes -- Free transactions are only
+                           accepted when small enough and within
+                           --limitfreerelay (0.00001)
+      --maxorphantxs=      Max number of orphan transactions to keep in memory
+                           -- 0 disables orphan transaction handling (10000)
+      --maxmempool=        Max size of the transaction memory pool in megabytes
+                           -- 0 disables the limit (300)
+      --nodatacarrier      Do not accept or relay transactions with outputs
+                           which only carry data (OP_RETURN) -- Applies even
+                           when non-standard transactions are accepted
+      --datacarriersize=   Max number of bytes of data carried by an OP_RETURN
+                           output in transactions which are accepted and
+                           relayed -- Applies even when non-standard
+                           transactions are accepted (80)
+      --blocksonly         Do not accept or relay transactions from or to peers
+                           other than whitelisted ones
+      --acceptnonstd       Accept and relay non-standard transactions to the
+                           network regardless of the default settings for the
+                           active network
+      --rejectnonstd       Reject non-standard transactions regardless of the
+                           default settings for the active network
       --blockminsize=      Mininum block size in bytes to be used when creating
                            a block
       --blockmaxsize=      Maximum block size in bytes to be used when creating
                            a block (750000)
       --blockprioritysize= Size in bytes for high-priority/low-fee transactions
                            when creating a block (50000)
+      --blockversion=      Override the version of generated blocks, such as to
+                           signal soft forks (1-4) -- Only allowed on the test
+                           networks (2)
       --getworkkey=        Use the specified hex-encoded serialized public keys
                            as the payment address for blocks generated by
                            getwork.
+      --miningscript=      Use the specified hex-encoded output script as the
+                           payment script for blocks generated by getwork --
+                           May be combined with getworkkey
 
 Help Options:
   -h, --help           Show this help message
@@ -0,0 +1,296 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// feeEstimatesFilename is the name of the file in the data directory
+	// the fee estimator state is saved to.
+	feeEstimatesFilename = "feeestimates.json"
+
+	// feeEstimatesVersion is the version of the saved fee estimator state.
+	// Saved state with a different version is discarded.
+	feeEstimatesVersion = 1
+
+	// maxConfirmTarget is the maximum number of blocks a fee estimate can
+	// be requested for.
+	maxConfirmTarget = 25
+
+	// minFeeBucketRate is the fee rate in satoshi per kilobyte of the
+	// lowest fee rate bucket.
+	minFeeBucketRate = 1000
+
+	// feeBucketSpacing is the factor between the fee rates of adjacent
+	// buckets.
+	feeBucketSpacing = 1.1
+
+	// numFeeBuckets is the number of fee rate buckets.  With the above
+	// values, the highest bucket starts at roughly 0.1 BTC/kB.
+	numFeeBuckets = 97
+
+	// feeEstimateDecay is the factor the data for all buckets is decayed by
+	// for each block so more recent blocks carry more weight.  It results
+	// in a half life of roughly 350 blocks.
+	feeEstimateDecay = 0.998
+
+	// minEstimateTxns is the minimum (decayed) number of transactions a
+	// group of buckets must have for an estimate to be based on it.
+	minEstimateTxns = 10.0
+
+	// estimateSuccessRate is the minimum fraction of transactions in a
+	// group of buckets that must have confirmed within the target number
+	// of blocks for the group to be considered sufficient.
+	estimateSuccessRate = 0.85
+)
+
+// feeBucket houses the data tracked for a single range of fee rates.
+type feeBucket struct {
+	// Confirmed holds the (decayed) number of transactions that confirmed
+	// within i+1 blocks at index i.
+	Confirmed [maxConfirmTarget]float64
+
+	// Total is the (decayed) number of transactions that confirmed.
+	Total float64
+}
+
+// feeEstimator tracks how many blocks transactions with different fee rates
+// spent in the memory pool before they were mined, so it can provide estimates
+// of the fee rate required for a transaction to be mined within a given number
+// of blocks.  It only learns from transactions which were in the memory pool
+// before the block that contained them was connected.
+type feeEstimator struct {
+	sync.Mutex
+	bestHeight int64
+	buckets    [numFeeBuckets]feeBucket
+}
+
+// serializedFeeEstimator is the format the fee estimator state is saved in.
+type serializedFeeEstimator struct {
+	Version    int
+	BestHeight int64
+	Buckets    []feeBucket
+}
+
+// feeBucketIndex returns the index of the bucket for the passed fee rate in
+// satoshi per kilobyte.
+func feeBucketIndex(feeRate int64) int {
+	if feeRate < minFeeBucketRate {
+		return 0
+	}
+	idx := int(math.Log(float64(feeRate)/minFeeBucketRate) /
+		math.Log(feeBucketSpacing))
+	if idx >= numFeeBuckets {
+		return numFeeBuckets - 1
+	}
+	return idx
+}
+
+// feeBucketRate returns the lowest fee rate in satoshi per kilobyte of the
+// bucket with the passed index.
+func feeBucketRate(idx int) int64 {
+	return int64(math.Ceil(minFeeBucketRate *
+		math.Pow(feeBucketSpacing, float64(idx))))
+}
+
+// ProcessBlock updates the estimator with the transactions of a newly
+// connected block at the passed height.  The passed transaction descriptors
+// must be those of the transactions in the block which were in the memory
+// pool.  Blocks at or below a height that was already processed, such as those
+// reconnected during a reorganize, are ignored.
+//
+// This function is safe for concurrent access.
+func (fe *feeEstimator) ProcessBlock(height int64, txDescs []*TxDesc) {
+	fe.Lock()
+	defer fe.Unlock()
+
+	if height <= fe.bestHeight {
+		return
+	}
+	fe.bestHeight = height
+
+	// Decay the existing data before adding the new block.
+	for i := range fe.buckets {
+		bucket := &fe.buckets[i]
+		for j := range bucket.Confirmed {
+			bucket.Confirmed[j] *= feeEstimateDecay
+		}
+		bucket.Total *= feeEstimateDecay
+	}
+
+	for _, txD := range txDescs {
+		// The height of the descriptor is the height of the main chain
+		// when the transaction was added to the pool, so a transaction
+		// mined in the very next block waited for one block.
+		blocksWaited := height - txD.Height
+		if blocksWaited < 1 {
+			continue
+		}
+
+		bucket := &fe.buckets[feeBucketIndex(txD.FeePerKB)]
+		bucket.Total++
+		for i := blocksWaited - 1; i < maxConfirmTarget; i++ {
+			bucket.Confirmed[i]++
+		}
+	}
+}
+
+// EstimateFee returns the estimated fee rate in satoshi per kilobyte needed
+// for a transaction to be mined within the passed number of blocks, which must
+// be between 1 and maxConfirmTarget.  It returns -1 when there is not enough
+// data for a confident estimate.
+//
+// The buckets are examined starting with the highest fee rate, combining
+// adjacent buckets until they contain enough transactions.  The estimate is
+// the lowest fee rate of the last group for which enough of the transactions
+// confirmed within the target.
+//
+// This function is safe for concurrent access.
+func (fe *feeEstimator) EstimateFee(target int) int64 {
+	fe.Lock()
+	defer fe.Unlock()
+
+	estimate := int64(-1)
+	var confirmed, total float64
+	for i := numFeeBuckets - 1; i >= 0; i-- {
+		confirmed += fe.buckets[i].Confirmed[target-1]
+		total += fe.buckets[i].Total
+		if total < minEstimateTxns {
+			continue
+		}
+
+		if confirmed/total < estimateSuccessRate {
+			break
+		}
+		estimate = feeBucketRate(i)
+		confirmed, total = 0, 0
+	}
+
+	return estimate
+}
+
+// Save writes the state of the fee estimator to the data directory so the
+// estimates survive restarts.
+//
+// This function is safe for concurrent access.
+func (fe *feeEstimator) Save() {
+	fe.Lock()
+	defer fe.Unlock()
+
+	filePath := filepath.Join(cfg.DataDir, feeEstimatesFilename)
+	if err := fe.serialize(filePath); err != nil {
+		txmpLog.Errorf("Failed to save fee estimates to %s: %v",
+			filePath, err)
+	}
+}
+
+// serialize writes the state of the fee estimator to the passed file.  The
+// state is written to a temporary file which is then renamed over the existing
+// file so an unclean shutdown while saving never leaves a partially written
+// file behind.
+//
+// This function MUST be called with the fee estimator lock held.
+func (fe *feeEstimator) serialize(filePath string) error {
+	sfe := serializedFeeEstimator{
+		Version:    feeEstimatesVersion,
+		BestHeight: fe.bestHeight,
+		Buckets:    fe.buckets[:],
+	}
+	serialized, err := json.Marshal(&sfe)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := filePath + ".tmp"
+	w, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC,
+		0600)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(serialized); err != nil {
+		w.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := w.Sync(); err != nil {
+		w.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := w.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// Load restores the state of the fee estimator from the data directory.  A
+// missing file is not an error, and a malformed one is removed so the
+// estimator starts fresh.
+//
+// This function is safe for concurrent access.
+func (fe *feeEstimator) Load() {
+	fe.Lock()
+	defer fe.Unlock()
+
+	filePath := filepath.Join(cfg.DataDir, feeEstimatesFilename)
+	err := fe.deserialize(filePath)
+	if err != nil {
+		txmpLog.Errorf("Failed to parse %s: %v", filePath, err)
+		if err := os.Remove(filePath); err != nil {
+			txmpLog.Warnf("Failed to remove corrupt fee estimates "+
+				"file: %v", err)
+		}
+		fe.bestHeight = 0
+		fe.buckets = [numFeeBuckets]feeBucket{}
+	}
+}
+
+// deserialize restores the state of the fee estimator from the passed file.
+//
+// This function MUST be called with the fee estimator lock held.
+func (fe *feeEstimator) deserialize(filePath string) error {
+	r, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error opening file: %v", err)
+	}
+	defer r.Close()
+
+	var sfe serializedFeeEstimator
+	if err := json.NewDecoder(r).Decode(&sfe); err != nil {
+		return fmt.Errorf("error reading %s: %v", filePath, err)
+	}
+	if sfe.Version != feeEstimatesVersion {
+		return fmt.Errorf("unknown version %d in serialized fee "+
+			"estimates", sfe.Version)
+	}
+	if len(sfe.Buckets) != numFeeBuckets {
+		return fmt.Errorf("unexpected number of fee buckets %d "+
+			"(expected %d)", len(sfe.Buckets), numFeeBuckets)
+	}
+
+	fe.bestHeight = sfe.BestHeight
+	copy(fe.buckets[:], sfe.Buckets)
+	return nil
+}
+
+// newFeeEstimator returns a new fee estimator with no data.
+func newFeeEstimator() *feeEstimator {
+	return &feeEstimator{}
+}
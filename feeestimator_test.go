@@ -0,0 +1,214 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestFeeBuckets ensures every fee rate maps to the bucket whose range contains
+// it.
+func TestFeeBuckets(t *testing.T) {
+	tests := []struct {
+		feeRate int64
+		want    int
+	}{
+		{0, 0},
+		{999, 0},
+		{1000, 0},
+		{1100, 1},
+		{2000, 7},
+		{10000, 24},
+		{1e9, numFeeBuckets - 1},
+	}
+	for _, test := range tests {
+		got := feeBucketIndex(test.feeRate)
+		if got != test.want {
+			t.Errorf("feeBucketIndex(%d): unexpected bucket - "+
+				"got %d, want %d", test.feeRate, got, test.want)
+		}
+	}
+
+	// The lowest fee rate of each bucket must map to the bucket while the
+	// fee rate just below it must map to the previous one.
+	for i := 0; i < numFeeBuckets; i++ {
+		rate := feeBucketRate(i)
+		if got := feeBucketIndex(rate); got != i {
+			t.Errorf("feeBucketIndex(%d): unexpected bucket - "+
+				"got %d, want %d", rate, got, i)
+		}
+		if i == 0 {
+			continue
+		}
+		if got := feeBucketIndex(rate - 1); got != i-1 {
+			t.Errorf("feeBucketIndex(%d): unexpected bucket - "+
+				"got %d, want %d", rate-1, got, i-1)
+		}
+	}
+}
+
+// testTxDescs returns the passed number of transaction descriptors with the
+// passed fee rate which were added to the memory pool at the passed height.
+func testTxDescs(n int, height, feePerKB int64) []*TxDesc {
+	txDescs := make([]*TxDesc, n)
+	for i := range txDescs {
+		txDescs[i] = &TxDesc{Height: height, FeePerKB: feePerKB}
+	}
+	return txDescs
+}
+
+// TestEstimateFee ensures the fee estimates are the lowest fee rate at which
+// enough transactions confirmed within the target number of blocks.
+func TestEstimateFee(t *testing.T) {
+	type testBlock struct {
+		height  int64
+		txDescs []*TxDesc
+	}
+
+	// Transactions paying 10000 satoshi per kilobyte confirmed in the next
+	// block while transactions paying 2000 satoshi per kilobyte took five
+	// blocks to confirm.
+	fast := testTxDescs(20, 99, 10000)
+	slow := testTxDescs(20, 95, 2000)
+	mixed := append(append([]*TxDesc{}, fast...), slow...)
+
+	tests := []struct {
+		name   string
+		blocks []testBlock
+		target int
+		want   int64
+	}{
+		{
+			name:   "no data",
+			target: 1,
+			want:   -1,
+		},
+		{
+			name:   "too few transactions",
+			blocks: []testBlock{{100, testTxDescs(5, 99, 10000)}},
+			target: 1,
+			want:   -1,
+		},
+		{
+			name:   "next block",
+			blocks: []testBlock{{100, fast}},
+			target: 1,
+			want:   feeBucketRate(24),
+		},
+		{
+			name:   "fast within later target",
+			blocks: []testBlock{{100, fast}},
+			target: maxConfirmTarget,
+			want:   feeBucketRate(24),
+		},
+		{
+			name:   "slow transactions miss the target",
+			blocks: []testBlock{{100, mixed}},
+			target: 4,
+			want:   feeBucketRate(24),
+		},
+		{
+			name:   "slow transactions meet the target",
+			blocks: []testBlock{{100, mixed}},
+			target: 5,
+			want:   feeBucketRate(7),
+		},
+		{
+			name: "decayed data",
+			blocks: []testBlock{
+				{100, mixed},
+				{101, nil},
+				{102, nil},
+			},
+			target: 5,
+			want:   feeBucketRate(7),
+		},
+		{
+			name:   "transactions added in the same block",
+			blocks: []testBlock{{99, fast}},
+			target: 1,
+			want:   -1,
+		},
+		{
+			name: "reconnected blocks are ignored",
+			blocks: []testBlock{
+				{100, fast},
+				{100, testTxDescs(40, 95, 2000)},
+			},
+			target: 1,
+			want:   feeBucketRate(24),
+		},
+	}
+
+	for _, test := range tests {
+		fe := newFeeEstimator()
+		for _, block := range test.blocks {
+			fe.ProcessBlock(block.height, block.txDescs)
+		}
+		got := fe.EstimateFee(test.target)
+		if got != test.want {
+			t.Errorf("%s: unexpected estimate - got %d, want %d",
+				test.name, got, test.want)
+		}
+	}
+}
+
+// TestFeeEstimatorSaveLoad ensures the state of the fee estimator survives
+// being saved and loaded and that a corrupt saved state is discarded.
+func TestFeeEstimatorSaveLoad(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "feeestimator")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	savedCfg := cfg
+	cfg = &config{DataDir: dataDir}
+	defer func() {
+		cfg = savedCfg
+	}()
+
+	fe := newFeeEstimator()
+	fe.ProcessBlock(100, testTxDescs(20, 99, 10000))
+	fe.ProcessBlock(101, testTxDescs(20, 96, 2000))
+	fe.Save()
+
+	filePath := filepath.Join(dataDir, feeEstimatesFilename)
+	if _, err := os.Stat(filePath + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file was left behind: %v", err)
+	}
+
+	loaded := newFeeEstimator()
+	loaded.Load()
+	if loaded.bestHeight != fe.bestHeight {
+		t.Errorf("unexpected best height - got %d, want %d",
+			loaded.bestHeight, fe.bestHeight)
+	}
+	if loaded.buckets != fe.buckets {
+		t.Errorf("loaded buckets do not match the saved buckets")
+	}
+	for target := 1; target <= maxConfirmTarget; target++ {
+		got, want := loaded.EstimateFee(target), fe.EstimateFee(target)
+		if got != want {
+			t.Errorf("target %d: unexpected estimate - got %d, "+
+				"want %d", target, got, want)
+		}
+	}
+
+	// A corrupt file is removed and the estimator starts fresh.
+	if err := ioutil.WriteFile(filePath, []byte("{"), 0600); err != nil {
+		t.Fatalf("unable to write corrupt file: %v", err)
+	}
+	loaded.Load()
+	if loaded.bestHeight != 0 || loaded.EstimateFee(1) != -1 {
+		t.Errorf("corrupt state was not discarded")
+	}
+	if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+		t.Errorf("corrupt file was not removed: %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+const (
+	// gcsBasicP is the Golomb-Rice coding parameter (the number of
+	// remainder bits) of BIP0158 basic filters.
+	gcsBasicP = 19
+
+	// gcsBasicM is the inverse of the false positive rate of BIP0158 basic
+	// filters.
+	gcsBasicM = 784931
+)
+
+// sipRound performs a single SipHash round on the passed state.
+func sipRound(v0, v1, v2, v3 uint64) (uint64, uint64, uint64, uint64) {
+	v0 += v1
+	v1 = v1<<13 | v1>>(64-13)
+	v1 ^= v0
+	v0 = v0<<32 | v0>>(64-32)
+	v2 += v3
+	v3 = v3<<16 | v3>>(64-16)
+	v3 ^= v2
+	v0 += v3
+	v3 = v3<<21 | v3>>(64-21)
+	v3 ^= v0
+	v2 += v1
+	v1 = v1<<17 | v1>>(64-17)
+	v1 ^= v2
+	v2 = v2<<32 | v2>>(64-32)
+	return v0, v1, v2, v3
+}
+
+// sipHash24 returns the SipHash-2-4 of the passed data using the 128-bit key
+// formed by k0 and k1.
+func sipHash24(k0, k1 uint64, data []byte) uint64 {
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+
+	// Compress the data in 8-byte blocks.
+	b := uint64(len(data)) << 56
+	for len(data) >= 8 {
+		m := binary.LittleEndian.Uint64(data)
+		v3 ^= m
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+		v0 ^= m
+		data = data[8:]
+	}
+
+	// Compress the final block which consists of the remaining bytes and
+	// the length of the data.
+	for i := len(data) - 1; i >= 0; i-- {
+		b |= uint64(data[i]) << uint(8*i)
+	}
+	v3 ^= b
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0 ^= b
+
+	// Finalization.
+	v2 ^= 0xff
+	for i := 0; i < 4; i++ {
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	}
+	return v0 ^ v1 ^ v2 ^ v3
+}
+
+// mulHi64 returns the high 64 bits of the 128-bit product of the passed
+// values.
+func mulHi64(a, b uint64) uint64 {
+	aLo, aHi := a&0xffffffff, a>>32
+	bLo, bHi := b&0xffffffff, b>>32
+
+	loLo := aLo * bLo
+	hiLo := aHi * bLo
+	loHi := aLo * bHi
+	hiHi := aHi * bHi
+
+	cross := loLo>>32 + hiLo&0xffffffff + loHi
+	return hiHi + hiLo>>32 + cross>>32
+}
+
+// uint64Sorter implements sort.Interface to allow a slice of 64-bit unsigned
+// integers to be sorted.
+type uint64Sorter []uint64
+
+// Len returns the number of 64-bit unsigned integers in the slice.  It is part
+// of the sort.Interface implementation.
+func (s uint64Sorter) Len() int {
+	return len(s)
+}
+
+// Swap swaps the 64-bit unsigned integers at the passed indices.  It is part of
+// the sort.Interface implementation.
+func (s uint64Sorter) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+// Less returns whether the 64-bit unsigned integer with index i should sort
+// before the 64-bit unsigned integer with index j.  It is part of the
+// sort.Interface implementation.
+func (s uint64Sorter) Less(i, j int) bool {
+	return s[i] < s[j]
+}
+
+// bitWriter writes a stream of bits, most significant bit first, to a byte
+// slice.
+type bitWriter struct {
+	bytes []byte
+	next  uint8 // mask of the next bit to write in the last byte
+}
+
+// writeBit appends the passed bit to the stream.
+func (w *bitWriter) writeBit(bit bool) {
+	if w.next == 0 {
+		w.bytes = append(w.bytes, 0)
+		w.next = 0x80
+	}
+	if bit {
+		w.bytes[len(w.bytes)-1] |= w.next
+	}
+	w.next >>= 1
+}
+
+// writeBits appends the least significant count bits of the passed value to the
+// stream, most significant bit first.
+func (w *bitWriter) writeBits(value uint64, count uint) {
+	for i := count; i > 0; i-- {
+		w.writeBit(value&(1<<(i-1)) != 0)
+	}
+}
+
+// gcsEncode returns the Golomb-coded set of the passed items as defined by
+// BIP0158 using the passed 128-bit SipHash key and coding parameters p and m.
+// The items must not contain duplicates.  The returned bytes only contain the
+// compressed set and not the number of items which prefixes it in a
+// serialized filter.
+func gcsEncode(key [16]byte, items [][]byte, p uint, m uint64) []byte {
+	if len(items) == 0 {
+		return nil
+	}
+
+	// Hash each item into the range [0, N*M) and sort the results.
+	k0 := binary.LittleEndian.Uint64(key[0:8])
+	k1 := binary.LittleEndian.Uint64(key[8:16])
+	modulus := uint64(len(items)) * m
+	values := make([]uint64, 0, len(items))
+	for _, item := range items {
+		values = append(values, mulHi64(sipHash24(k0, k1, item), modulus))
+	}
+	sort.Sort(uint64Sorter(values))
+
+	// Golomb-Rice encode the differences between the sorted values.  The
+	// quotient is written in unary followed by the p-bit remainder.
+	var w bitWriter
+	var lastValue uint64
+	for _, value := range values {
+		delta := value - lastValue
+		lastValue = value
+
+		for q := delta >> p; q > 0; q-- {
+			w.writeBit(true)
+		}
+		w.writeBit(false)
+		w.writeBits(delta, p)
+	}
+	return w.bytes
+}
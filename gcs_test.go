@@ -0,0 +1,49 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// TestSipHash24 ensures the SipHash-2-4 implementation produces the reference
+// output from the SipHash paper.
+func TestSipHash24(t *testing.T) {
+	data := make([]byte, 15)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	got := sipHash24(0x0706050403020100, 0x0f0e0d0c0b0a0908, data)
+	if want := uint64(0xa129ca6149be45e5); got != want {
+		t.Errorf("sipHash24: got %x want %x", got, want)
+	}
+}
+
+// TestGCSEncode ensures the Golomb-coded set of the basic filter for the
+// testnet genesis block matches the BIP0158 test vector.
+func TestGCSEncode(t *testing.T) {
+	// The first 16 bytes of the testnet genesis block hash in internal
+	// byte order.
+	key := [16]byte{
+		0x43, 0x49, 0x7f, 0xd7, 0xf8, 0x26, 0x95, 0x71,
+		0x08, 0xf4, 0xa3, 0x0f, 0xd9, 0xce, 0xc3, 0xae,
+	}
+
+	// The only item is the public key script of the coinbase output.
+	pkScript, _ := hex.DecodeString("4104678afdb0fe5548271967f1a67130b7" +
+		"105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51e" +
+		"c112de5c384df7ba0b8d578a4c702b6bf11d5fac")
+	want, _ := hex.DecodeString("9dfca8")
+	got := gcsEncode(key, [][]byte{pkScript}, gcsBasicP, gcsBasicM)
+	if !bytes.Equal(got, want) {
+		t.Errorf("gcsEncode: got %x want %x", got, want)
+	}
+
+	if got := gcsEncode(key, nil, gcsBasicP, gcsBasicM); len(got) != 0 {
+		t.Errorf("gcsEncode: got %x for empty set", got)
+	}
+}
@@ -37,6 +37,7 @@ var (
 	btcdLog    = btclog.Disabled
 	chanLog    = btclog.Disabled
 	discLog    = btclog.Disabled
+	indxLog    = btclog.Disabled
 	minrLog    = btclog.Disabled
 	peerLog    = btclog.Disabled
 	rpcsLog    = btclog.Disabled
@@ -53,6 +54,7 @@ var subsystemLoggers = map[string]btclog.Logger{
 	"BTCD": btcdLog,
 	"CHAN": chanLog,
 	"DISC": discLog,
+	"INDX": indxLog,
 	"MINR": minrLog,
 	"PEER": peerLog,
 	"RPCS": rpcsLog,
@@ -106,6 +108,9 @@ func useLogger(subsystemID string, logger btclog.Logger) {
 	case "DISC":
 		discLog = logger
 
+	case "INDX":
+		indxLog = logger
+
 	case "MINR":
 		minrLog = logger
 
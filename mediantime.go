@@ -0,0 +1,175 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// maxMedianTimeEntries is the maximum number of entries allowed in the
+	// median time data.
+	maxMedianTimeEntries = 200
+
+	// minMedianTimeEntries is the minimum number of entries required
+	// before the median offset is used to adjust the local time.
+	minMedianTimeEntries = 5
+
+	// similarTimeSecs is the number of seconds in either direction from the
+	// local clock that is used to determine that it is likely wrong and
+	// hence to show a warning.
+	similarTimeSecs = 5 * 60 // 5 minutes
+)
+
+// medianTime tracks the offsets between the local clock and the time reported
+// by remote peers in their version messages and provides the median of those
+// offsets.  Only a single sample is accepted per peer host and the number of
+// samples is limited to maxMedianTimeEntries.
+//
+// The median offset is applied the same way as the reference implementation
+// does: it is only updated once at least minMedianTimeEntries samples have
+// been gathered and there is an odd number of them, and it is ignored entirely
+// when it is not within the configured max offset (--maxtimeoffset).  The
+// offset of each sample is capped to the max offset as well so a single peer
+// can't shift the median further than that.
+//
+// The adjusted time is used for the timestamps of block templates and to check
+// the timestamps of block proposals.  Blocks received from the network are
+// still checked against the local clock since the chain validation code does
+// not accept a time source.
+type medianTime struct {
+	sync.Mutex
+	knownIDs           map[string]struct{}
+	offsets            []int64
+	offsetSecs         int64
+	maxOffsetSecs      int64
+	invalidTimeChecked bool
+}
+
+// AddTimeSample adds a time sample reported by the peer identified by the
+// passed id.  Samples from ids which have already provided one are ignored.
+// It returns the offset in seconds between the passed time and the local
+// clock.
+//
+// This function is safe for concurrent access.
+func (m *medianTime) AddTimeSample(id string, timeVal time.Time) int64 {
+	m.Lock()
+	defer m.Unlock()
+
+	// Truncate to seconds since that is the precision of the timestamps
+	// in the version messages.
+	now := time.Unix(time.Now().Unix(), 0)
+	offsetSecs := int64(timeVal.Sub(now).Seconds())
+
+	// Don't add time data from the same source or once the maximum
+	// number of samples has been reached.
+	if _, exists := m.knownIDs[id]; exists {
+		return offsetSecs
+	}
+	if len(m.offsets) >= maxMedianTimeEntries {
+		return offsetSecs
+	}
+	m.knownIDs[id] = struct{}{}
+
+	// Cap the offset of the sample to the max offset.  Since capping does
+	// not change the order of the samples, the median is only affected
+	// when it would be outside of the max offset anyways.
+	sampleSecs := offsetSecs
+	if sampleSecs > m.maxOffsetSecs {
+		sampleSecs = m.maxOffsetSecs
+	} else if sampleSecs < -m.maxOffsetSecs {
+		sampleSecs = -m.maxOffsetSecs
+	}
+	m.offsets = append(m.offsets, sampleSecs)
+
+	// Recalculate the median offset from a sorted copy of the samples.
+	sortedOffsets := make([]int64, len(m.offsets))
+	copy(sortedOffsets, m.offsets)
+	sort.Sort(int64Sorter(sortedOffsets))
+	median := sortedOffsets[len(sortedOffsets)/2]
+
+	srvrLog.Debugf("Added time sample of %v (total: %v, median offset: "+
+		"%ds)", time.Duration(offsetSecs)*time.Second, len(m.offsets),
+		median)
+
+	// Only update the applied offset once there are enough samples and
+	// there is an odd number of them so the median is an actual sample.
+	// This mirrors the reference implementation and keeps a minority of
+	// peers from steering the adjusted time.
+	numOffsets := len(sortedOffsets)
+	if numOffsets < minMedianTimeEntries || numOffsets%2 != 1 {
+		return offsetSecs
+	}
+
+	// Apply the median offset as long as it is within the maximum allowed
+	// range.  Otherwise, don't adjust the local time at all since either
+	// the local clock or the network time is badly wrong.
+	if math.Abs(float64(median)) < float64(m.maxOffsetSecs) {
+		m.offsetSecs = median
+		return offsetSecs
+	}
+	m.offsetSecs = 0
+
+	// Warn the user, only once, when none of the peers have a time similar
+	// to the local clock since it is most likely the local clock which is
+	// wrong.
+	if !m.invalidTimeChecked {
+		m.invalidTimeChecked = true
+
+		var remoteHasCloseTime bool
+		for _, offset := range sortedOffsets {
+			if math.Abs(float64(offset)) < similarTimeSecs {
+				remoteHasCloseTime = true
+				break
+			}
+		}
+		if !remoteHasCloseTime {
+			srvrLog.Warnf("Please check your date and time are " +
+				"correct!  btcd will not work properly with an " +
+				"invalid time")
+		}
+	}
+
+	return offsetSecs
+}
+
+// Offset returns the offset applied to the local clock to obtain the adjusted
+// time.  It is the median offset between the local clock and the time reported
+// by remote peers subject to the limits described on medianTime.
+//
+// This function is safe for concurrent access.
+func (m *medianTime) Offset() time.Duration {
+	m.Lock()
+	defer m.Unlock()
+
+	return time.Duration(m.offsetSecs) * time.Second
+}
+
+// AdjustedTime returns the current time adjusted by the offset returned by
+// Offset.
+//
+// This function is safe for concurrent access.
+func (m *medianTime) AdjustedTime() time.Time {
+	m.Lock()
+	defer m.Unlock()
+
+	// Limit the adjusted time to 1 second precision.
+	now := time.Unix(time.Now().Unix(), 0)
+	return now.Add(time.Duration(m.offsetSecs) * time.Second)
+}
+
+// newMedianTime returns a new instance of a median time source which is
+// ready to accept time samples.  The local clock is never adjusted by more than
+// the passed max offset in either direction.
+func newMedianTime(maxOffset time.Duration) *medianTime {
+	return &medianTime{
+		knownIDs:      make(map[string]struct{}),
+		offsets:       make([]int64, 0, maxMedianTimeEntries),
+		maxOffsetSecs: int64(maxOffset / time.Second),
+	}
+}
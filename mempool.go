@@ -5,8 +5,8 @@
 package main
 
 import (
+	"container/heap"
 	"container/list"
-	"crypto/rand"
 	"fmt"
 	"github.com/conformal/btcchain"
 	"github.com/conformal/btcdb"
@@ -14,7 +14,6 @@ import (
 	"github.com/conformal/btcutil"
 	"github.com/conformal/btcwire"
 	"math"
-	"math/big"
 	"sync"
 	"time"
 )
@@ -35,12 +34,6 @@ const (
 	// contextual transaction information provided in a transaction store.
 	mempoolHeight = 0x7fffffff
 
-	// maxOrphanTransactions is the maximum number of orphan transactions
-	// that can be queued.  At the time this comment was written, this
-	// equates to 10,000 transactions, but will increase if the max allowed
-	// block payload increases.
-	maxOrphanTransactions = btcwire.MaxBlockPayload / 100
-
 	// maxOrphanTxSize is the maximum size allowed for orphan transactions.
 	// This helps prevent memory exhaustion attacks from sending a lot of
 	// of big orphans.
@@ -75,21 +68,148 @@ const (
 	// considered standard.
 	maxStandardMultiSigKeys = 3
 
-	// minTxRelayFee is the minimum fee in satoshi that is required for a
-	// transaction to be treated as free for relay and mining purposes.  It
-	// is also used to help determine if a transaction is considered dust
-	// and as a base for calculating minimum required fees for larger
-	// transactions.  This value is in Satoshi/1000 bytes.
-	minTxRelayFee = 1000
+	// defaultMinRelayTxFee is the default minimum fee in satoshi that is
+	// required for a transaction to not be treated as free for relay and
+	// mining purposes.  The minimum in effect is configured with the
+	// --minrelaytxfee option.  It is also used to help determine if a
+	// transaction is considered dust and as a base for calculating minimum
+	// required fees.  This value is in Satoshi/1000 bytes.
+	defaultMinRelayTxFee = 1000
+
+	// maxSigOpsPerTx is the maximum number of signature operations allowed
+	// in a transaction accepted into the memory pool.  It is 1/5 of the
+	// maximum allowed in a block.
+	maxSigOpsPerTx = btcchain.MaxSigOpsPerBlock / 5
+
+	// maxRelativesWalk is the maximum number of transactions visited when
+	// summarizing the ancestors or descendants of every transaction in the
+	// pool at once.  This bounds the cost of the summaries when the pool
+	// contains long chains of unconfirmed transactions.
+	maxRelativesWalk = 1000
+)
+
+// txRemoveReason describes why a transaction was removed from the memory pool.
+type txRemoveReason int
+
+// These constants define the reasons a transaction can be removed from the
+// memory pool.
+const (
+	// txRemoveMined indicates the transaction was included in a block
+	// connected to the main chain.
+	txRemoveMined txRemoveReason = iota
+
+	// txRemoveConflict indicates the transaction spends an output which was
+	// spent by a transaction included in a block connected to the main
+	// chain.
+	txRemoveConflict
+
+	// txRemoveEvicted indicates the transaction was evicted to limit the
+	// size of the memory pool.
+	txRemoveEvicted
+
+	// txRemoveReorg indicates the transaction is no longer valid after a
+	// block was disconnected from the main chain.
+	txRemoveReorg
 )
 
+// txRemoveReasonStrings is a map of transaction removal reasons back to their
+// constant names for pretty printing.
+var txRemoveReasonStrings = map[txRemoveReason]string{
+	txRemoveMined:    "mined",
+	txRemoveConflict: "conflict",
+	txRemoveEvicted:  "evicted",
+	txRemoveReorg:    "reorg",
+}
+
+// String returns the txRemoveReason in human-readable form.
+func (r txRemoveReason) String() string {
+	if s, ok := txRemoveReasonStrings[r]; ok {
+		return s
+	}
+	return fmt.Sprintf("Unknown txRemoveReason (%d)", int(r))
+}
+
 // TxDesc is a descriptor containing a transaction in the mempool and the
 // metadata we store about it.
 type TxDesc struct {
-	Tx     *btcutil.Tx // Transaction.
-	Added  time.Time   // Time when added to pool.
-	Height int64       // Blockheight when added to pool.
-	Fee    int64       // Transaction fees.
+	Tx               *btcutil.Tx // Transaction.
+	Added            time.Time   // Time when added to pool.
+	Height           int64       // Blockheight when added to pool.
+	Fee              int64       // Transaction fees.
+	FeePerKB         int64       // Fee rate in satoshi per 1000 bytes.
+	StartingPriority float64     // Priority when added to pool.
+
+	feeRateIndex int // index in the pool fee rate heap.
+}
+
+// txFeeRateHeap implements a min-heap of the transaction descriptors in the
+// main pool ordered by fee rate so the transaction with the lowest fee rate
+// can be found without scanning the whole pool when it is full.
+type txFeeRateHeap []*TxDesc
+
+// Len returns the number of descriptors in the heap.  It is part of the
+// heap.Interface implementation.
+func (h txFeeRateHeap) Len() int {
+	return len(h)
+}
+
+// Less returns whether the descriptor with index i has a lower fee rate than
+// the descriptor with index j.  It is part of the heap.Interface
+// implementation.
+func (h txFeeRateHeap) Less(i, j int) bool {
+	return h[i].FeePerKB < h[j].FeePerKB
+}
+
+// Swap swaps the descriptors at the passed indices in the heap.  It is part of
+// the heap.Interface implementation.
+func (h txFeeRateHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].feeRateIndex = i
+	h[j].feeRateIndex = j
+}
+
+// Push pushes the passed descriptor onto the heap.  It is part of the
+// heap.Interface implementation.
+func (h *txFeeRateHeap) Push(x interface{}) {
+	txDesc := x.(*TxDesc)
+	txDesc.feeRateIndex = len(*h)
+	*h = append(*h, txDesc)
+}
+
+// Pop removes the last descriptor from the heap and returns it.  It is part of
+// the heap.Interface implementation.
+func (h *txFeeRateHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	txDesc := old[n-1]
+	old[n-1] = nil
+	*h = old[0 : n-1]
+	return txDesc
+}
+
+// TxPoolEntry describes a transaction in the memory pool along with its
+// current priority and its relationships with the other transactions in the
+// pool.  The ancestor and descendant totals include the transaction itself.
+type TxPoolEntry struct {
+	*TxDesc
+	CurrentPriority float64            // Priority as of the next block.
+	Depends         []*btcwire.ShaHash // Pool transactions it spends.
+	SpentBy         []*btcwire.ShaHash // Pool transactions spending it.
+	AncestorCount   int                // Number of in-pool ancestors.
+	AncestorSize    int64              // Serialized size of ancestors.
+	AncestorFees    int64              // Fees of ancestors.
+	DescendantCount int                // Number of in-pool descendants.
+	DescendantSize  int64              // Serialized size of descendants.
+	DescendantFees  int64              // Fees of descendants.
+}
+
+// TxRelatives summarizes the in-pool ancestors and descendants of a transaction
+// in the memory pool.  The counts and sizes include the transaction itself.
+type TxRelatives struct {
+	AncestorCount   int
+	AncestorSize    int64
+	DescendantCount int
+	DescendantSize  int64
 }
 
 // txMemPool is used as a source of transactions that need to be mined into
@@ -99,12 +219,35 @@ type txMemPool struct {
 	sync.RWMutex
 	server        *server
 	pool          map[btcwire.ShaHash]*TxDesc
-	orphans       map[btcwire.ShaHash]*btcutil.Tx
+	orphans       map[btcwire.ShaHash]*list.Element
+	orphanList    *list.List // orphans in the order they were added
 	orphansByPrev map[btcwire.ShaHash]*list.List
 	outpoints     map[btcwire.OutPoint]*btcutil.Tx
+	feeRates      txFeeRateHeap
+	totalBytes    int64     // serialized size of all txns in the pool
 	lastUpdated   time.Time // last time pool was updated
 	pennyTotal    float64   // exponentially decaying total for penny spends.
 	lastPennyUnix int64     // unix time of last ``penny spend''
+
+	// relativesCached is set while TxRelatives has summaries cached and
+	// staleRelatives holds the hashes of the transactions whose cached
+	// summaries have changed since.  They are protected by the mempool
+	// lock.
+	relativesCached bool
+	staleRelatives  map[btcwire.ShaHash]struct{}
+
+	// relativesMtx protects the summaries cached by TxRelatives.
+	relativesMtx sync.Mutex
+	relatives    map[btcwire.ShaHash]TxRelatives
+}
+
+// txGraphNode is a transaction in a snapshot of the graph of the transactions
+// in the main pool which is used to summarize relatives without holding the
+// mempool lock.
+type txGraphNode struct {
+	size     int64
+	parents  []*btcwire.ShaHash
+	children []*btcwire.ShaHash
 }
 
 // isDust returns whether or not the passed transaction output amount is
@@ -168,7 +311,31 @@ func isDust(txOut *btcwire.TxOut) bool {
 	//
 	// The following is equivalent to (value/totalSize) * (1/3) * 1000
 	// without needing to do floating point math.
-	return txOut.Value*1000/(3*int64(totalSize)) < minTxRelayFee
+	return txOut.Value*1000/(3*int64(totalSize)) < cfg.minRelayTxFee
+}
+
+// nullDataPayloadSize returns the number of bytes of data carried by the passed
+// public key script along with whether or not it is a null data script.  A null
+// data script is one that starts with OP_RETURN followed only by data pushes.
+// Unlike the null data class determined by btcscript, the size of the pushed
+// data is not limited so the configured data carrier size can be applied to
+// it.
+func nullDataPayloadSize(pkScript []byte) (int, bool) {
+	if len(pkScript) == 0 || pkScript[0] != btcscript.OP_RETURN {
+		return 0, false
+	}
+	if !btcscript.IsPushOnlyScript(pkScript[1:]) {
+		return 0, false
+	}
+	pushedData, err := btcscript.PushedData(pkScript[1:])
+	if err != nil {
+		return 0, false
+	}
+	size := 0
+	for _, data := range pushedData {
+		size += len(data)
+	}
+	return size, true
 }
 
 // checkPkScriptStandard performs a series of checks on a transaction ouput
@@ -287,6 +454,16 @@ func checkTransactionStandard(tx *btcutil.Tx, height int64) error {
 	// be "dust".
 	numNullDataOutputs := 0
 	for i, txOut := range msgTx.TxOut {
+		// Outputs which only carry data are subject to the configured
+		// data carrier policy, which is checked separately by
+		// checkDataCarrier, rather than the usual script checks.  They
+		// are provably unspendable, so they are not subject to the dust
+		// check either.  Accumulate the number of them.
+		if _, ok := nullDataPayloadSize(txOut.PkScript); ok {
+			numNullDataOutputs++
+			continue
+		}
+
 		scriptClass := btcscript.GetScriptClass(txOut.PkScript)
 		err := checkPkScriptStandard(txOut.PkScript, scriptClass)
 		if err != nil {
@@ -294,11 +471,6 @@ func checkTransactionStandard(tx *btcutil.Tx, height int64) error {
 			return TxRuleError(str)
 		}
 
-		// Accumulate the number of outputs which only carry data.
-		if scriptClass == btcscript.NullDataTy {
-			numNullDataOutputs++
-		}
-
 		if isDust(txOut) {
 			str := fmt.Sprintf("transaction output %d: payment "+
 				"of %d is dust", i, txOut.Value)
@@ -316,6 +488,34 @@ func checkTransactionStandard(tx *btcutil.Tx, height int64) error {
 	return nil
 }
 
+// checkDataCarrier ensures the outputs of the passed transaction which only
+// carry data (OP_RETURN) conform to the configured data carrier policy
+// (--nodatacarrier and --datacarriersize).  Unlike the other standardness
+// checks, the policy is enforced even when non-standard transactions are
+// accepted since the operator configured it explicitly.
+func checkDataCarrier(tx *btcutil.Tx) error {
+	for i, txOut := range tx.MsgTx().TxOut {
+		size, ok := nullDataPayloadSize(txOut.PkScript)
+		if !ok {
+			continue
+		}
+		if cfg.NoDataCarrier {
+			str := fmt.Sprintf("transaction output %d: nulldata "+
+				"outputs are not relayed", i)
+			return TxRuleError(str)
+		}
+		if size > cfg.DataCarrierSize {
+			str := fmt.Sprintf("transaction output %d: nulldata "+
+				"payload of %d bytes exceeds the max allowed "+
+				"size of %d bytes", i, size,
+				cfg.DataCarrierSize)
+			return TxRuleError(str)
+		}
+	}
+
+	return nil
+}
+
 // checkInputsStandard performs a series of checks on a transaction's inputs
 // to ensure they are "standard".  A standard transaction input is one that
 // that consumes the expected number of elements from the stack and that number
@@ -340,7 +540,10 @@ func checkInputsStandard(tx *btcutil.Tx, txStore btcchain.TxStore) error {
 		scriptInfo, err := btcscript.CalcScriptInfo(txIn.SignatureScript,
 			originPkScript, true)
 		if err != nil {
-			return err
+			str := fmt.Sprintf("transaction input #%d has a "+
+				"signature script and referenced output script "+
+				"which can't be analyzed: %v", i, err)
+			return TxRuleError(str)
 		}
 
 		// A negative value for expected inputs indicates the script is
@@ -368,27 +571,14 @@ func checkInputsStandard(tx *btcutil.Tx, txStore btcchain.TxStore) error {
 // calcMinRelayFee retuns the minimum transaction fee required for the passed
 // transaction to be accepted into the memory pool and relayed.
 func calcMinRelayFee(tx *btcutil.Tx) int64 {
-	// Most miners allow a free transaction area in blocks they mine to go
-	// alongside the area used for high-priority transactions as well as
-	// transactions with fees.  A transaction size of up to 1000 bytes is
-	// considered safe to go into this section.  Further, the minimum fee
-	// calculated below on its own would encourage several small
-	// transactions to avoid fees rather than one single larger transaction
-	// which is more desirable.  Therefore, as long as the size of the
-	// transaction does not exceeed 1000 less than the reserved space for
-	// high-priority transactions, don't require a fee for it.
-	serializedLen := int64(tx.MsgTx().SerializeSize())
-	if serializedLen < (defaultBlockPrioritySize - 1000) {
-		return 0
-	}
-
 	// Calculate the minimum fee for a transaction to be allowed into the
 	// mempool and relayed by scaling the base fee (which is the minimum
-	// free transaction relay fee).  minTxRelayFee is in Satoshi/KB, so
-	// divide the transaction size by 1000 to convert to kilobytes.  Also,
-	// integer division is used so fees only increase on full kilobyte
-	// boundaries.
-	minFee := (1 + serializedLen/1000) * minTxRelayFee
+	// free transaction relay fee).  The minimum relay fee is in
+	// Satoshi/KB, so divide the transaction size by 1000 to convert to
+	// kilobytes.  Also, integer division is used so fees only increase on
+	// full kilobyte boundaries.
+	serializedLen := int64(tx.MsgTx().SerializeSize())
+	minFee := (1 + serializedLen/1000) * cfg.minRelayTxFee
 
 	// Set the minimum fee to the maximum possible value if the calculated
 	// fee is not in the valid range for monetary amounts.
@@ -399,16 +589,34 @@ func calcMinRelayFee(tx *btcutil.Tx) int64 {
 	return minFee
 }
 
+// isFreeTxCandidate returns whether or not the passed transaction is small
+// enough to be relayed without paying the minimum relay fee.
+//
+// Most miners allow a free transaction area in blocks they mine to go alongside
+// the area used for high-priority transactions as well as transactions with
+// fees.  A transaction size of up to 1000 bytes is considered safe to go into
+// this section.  Further, requiring the minimum fee on its own would encourage
+// several small transactions to avoid fees rather than one single larger
+// transaction which is more desirable.  Therefore, as long as the size of the
+// transaction does not exceeed 1000 less than the reserved space for
+// high-priority transactions, it may be relayed for free, subject to the free
+// transaction rate limiter.
+func isFreeTxCandidate(tx *btcutil.Tx) bool {
+	serializedLen := int64(tx.MsgTx().SerializeSize())
+	return serializedLen < (defaultBlockPrioritySize - 1000)
+}
+
 // removeOrphan removes the passed orphan transaction from the orphan pool and
 // previous orphan index.
 //
 // This function MUST be called with the mempool lock held (for writes).
 func (mp *txMemPool) removeOrphan(txHash *btcwire.ShaHash) {
 	// Nothing to do if passed tx is not an orphan.
-	tx, exists := mp.orphans[*txHash]
+	elem, exists := mp.orphans[*txHash]
 	if !exists {
 		return
 	}
+	tx := elem.Value.(*btcutil.Tx)
 
 	// Remove the reference from the previous orphan index.
 	for _, txIn := range tx.MsgTx().TxIn {
@@ -430,55 +638,33 @@ func (mp *txMemPool) removeOrphan(txHash *btcwire.ShaHash) {
 	}
 
 	// Remove the transaction from the orphan pool.
+	mp.orphanList.Remove(elem)
 	delete(mp.orphans, *txHash)
 }
 
-// limitNumOrphans limits the number of orphan transactions by evicting a random
-// orphan if adding a new one would cause it to overflow the max allowed.
+// limitNumOrphans limits the number of orphan transactions by evicting the
+// oldest orphans until adding a new one would no longer cause the pool to
+// exceed the max allowed.
 //
 // This function MUST be called with the mempool lock held (for writes).
-func (mp *txMemPool) limitNumOrphans() error {
-	if len(mp.orphans)+1 > maxOrphanTransactions {
-		// Generate a cryptographically random hash.
-		randHashBytes := make([]byte, btcwire.HashSize)
-		_, err := rand.Read(randHashBytes)
-		if err != nil {
-			return err
-		}
-		randHashNum := new(big.Int).SetBytes(randHashBytes)
-
-		// Try to find the first entry that is greater than the random
-		// hash.  Use the first entry (which is already pseudorandom due
-		// to Go's range statement over maps) as a fallback if none of
-		// the hashes in the orphan pool are larger than the random
-		// hash.
-		var foundHash *btcwire.ShaHash
-		for txHash := range mp.orphans {
-			if foundHash == nil {
-				foundHash = &txHash
-			}
-			txHashNum := btcchain.ShaHashToBig(&txHash)
-			if txHashNum.Cmp(randHashNum) > 0 {
-				foundHash = &txHash
-				break
-			}
-		}
-
-		mp.removeOrphan(foundHash)
+func (mp *txMemPool) limitNumOrphans() {
+	for len(mp.orphans) > 0 && len(mp.orphans)+1 > cfg.MaxOrphanTxs {
+		oldest := mp.orphanList.Front().Value.(*btcutil.Tx)
+		txmpLog.Debugf("Evicting orphan transaction %v to make room "+
+			"(max %d)", oldest.Sha(), cfg.MaxOrphanTxs)
+		mp.removeOrphan(oldest.Sha())
 	}
-
-	return nil
 }
 
 // addOrphan adds an orphan transaction to the orphan pool.
 //
 // This function MUST be called with the mempool lock held (for writes).
 func (mp *txMemPool) addOrphan(tx *btcutil.Tx) {
-	// Limit the number orphan transactions to prevent memory exhaustion.  A
-	// random orphan is evicted to make room if needed.
+	// Limit the number orphan transactions to prevent memory exhaustion.
+	// The oldest orphan is evicted to make room if needed.
 	mp.limitNumOrphans()
 
-	mp.orphans[*tx.Sha()] = tx
+	mp.orphans[*tx.Sha()] = mp.orphanList.PushBack(tx)
 	for _, txIn := range tx.MsgTx().TxIn {
 		originTxHash := txIn.PreviousOutpoint.Hash
 		if mp.orphansByPrev[originTxHash] == nil {
@@ -495,6 +681,12 @@ func (mp *txMemPool) addOrphan(tx *btcutil.Tx) {
 //
 // This function MUST be called with the mempool lock held (for writes).
 func (mp *txMemPool) maybeAddOrphan(tx *btcutil.Tx) error {
+	// Orphan transaction handling is disabled entirely when the max number
+	// of orphans is set to zero.
+	if cfg.MaxOrphanTxs <= 0 {
+		return TxRuleError("orphan transactions are not being accepted")
+	}
+
 	// Ignore orphan transactions that are too large.  This helps avoid
 	// a memory exhaustion attack based on sending a lot of really large
 	// orphans.  In the case there is a valid transaction larger than this,
@@ -503,8 +695,8 @@ func (mp *txMemPool) maybeAddOrphan(tx *btcutil.Tx) error {
 	//
 	// Note that the number of orphan transactions in the orphan pool is
 	// also limited, so this equates to a maximum memory used of
-	// maxOrphanTxSize * maxOrphanTransactions (which is 500MB as of the
-	// time this comment was written).
+	// maxOrphanTxSize * cfg.MaxOrphanTxs (which is 50MB with the default
+	// value).
 	serializedLen := tx.MsgTx().SerializeSize()
 	if serializedLen > maxOrphanTxSize {
 		str := fmt.Sprintf("orphan transaction size of %d bytes is "+
@@ -519,6 +711,85 @@ func (mp *txMemPool) maybeAddOrphan(tx *btcutil.Tx) error {
 	return nil
 }
 
+// maxPoolBytes returns the maximum allowed serialized size of all transactions
+// in the main pool as specified by the maxmempool option.  Zero means there is
+// no limit.
+func maxPoolBytes() int64 {
+	return int64(cfg.MaxMempool) * 1000 * 1000
+}
+
+// feePerKB returns the fee rate of the passed transaction in satoshi per 1000
+// bytes.
+func feePerKB(tx *btcutil.Tx, fee int64) int64 {
+	serializedSize := int64(tx.MsgTx().SerializeSize())
+	return fee * 1000 / serializedSize
+}
+
+// lowestFeeTx returns the descriptor of the transaction in the main pool with
+// the lowest fee rate along with that fee rate.  The descriptor is nil when
+// the pool is empty.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *txMemPool) lowestFeeTx() (*TxDesc, int64) {
+	if len(mp.feeRates) == 0 {
+		return nil, 0
+	}
+	lowest := mp.feeRates[0]
+	return lowest, lowest.FeePerKB
+}
+
+// checkPoolLimit ensures there is room in the main pool for the passed
+// transaction.  When adding the transaction would cause the pool to exceed
+// the max allowed size, it is rejected unless its fee rate is higher than the
+// lowest fee rate in the pool since it would otherwise be evicted again as
+// soon as it was added.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *txMemPool) checkPoolLimit(tx *btcutil.Tx, fee int64) error {
+	maxBytes := maxPoolBytes()
+	if maxBytes == 0 {
+		return nil
+	}
+	txSize := int64(tx.MsgTx().SerializeSize())
+	if mp.totalBytes+txSize <= maxBytes {
+		return nil
+	}
+
+	_, floor := mp.lowestFeeTx()
+	if rate := feePerKB(tx, fee); rate <= floor {
+		str := fmt.Sprintf("transaction %v has a fee rate of %d "+
+			"satoshi/kB which is not above the mempool eviction "+
+			"floor of %d satoshi/kB", tx.Sha(), rate, floor)
+		return TxRuleError(str)
+	}
+
+	return nil
+}
+
+// limitPoolSize evicts the transactions with the lowest fee rate, along with
+// any transactions which depend on them, until the main pool no longer exceeds
+// the max allowed size.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *txMemPool) limitPoolSize() {
+	maxBytes := maxPoolBytes()
+	if maxBytes == 0 {
+		return
+	}
+
+	for mp.totalBytes > maxBytes {
+		txDesc, rate := mp.lowestFeeTx()
+		if txDesc == nil {
+			return
+		}
+
+		txmpLog.Debugf("Evicting transaction %v with fee rate %d "+
+			"satoshi/kB (pool size: %d bytes, max: %d bytes)",
+			txDesc.Tx.Sha(), rate, mp.totalBytes, maxBytes)
+		mp.removeTransaction(txDesc.Tx, txRemoveEvicted)
+	}
+}
+
 // isTransactionInPool returns whether or not the passed transaction already
 // exists in the main pool.
 //
@@ -591,13 +862,18 @@ func (mp *txMemPool) HaveTransaction(hash *btcwire.ShaHash) bool {
 // RemoveTransaction.  See the comment for RemoveTransaction for more details.
 //
 // This function MUST be called with the mempool lock held (for writes).
-func (mp *txMemPool) removeTransaction(tx *btcutil.Tx) {
-	// Remove any transactions which rely on this one.
+func (mp *txMemPool) removeTransaction(tx *btcutil.Tx, reason txRemoveReason) {
+	// Remove any transactions which rely on this one for the same reason.
+	// Transactions which rely on a mined transaction are still valid, so
+	// they are kept.
 	txHash := tx.Sha()
-	for i := uint32(0); i < uint32(len(tx.MsgTx().TxOut)); i++ {
-		outpoint := btcwire.NewOutPoint(txHash, i)
-		if txRedeemer, exists := mp.outpoints[*outpoint]; exists {
-			mp.removeTransaction(txRedeemer)
+	if reason != txRemoveMined {
+		for i := uint32(0); i < uint32(len(tx.MsgTx().TxOut)); i++ {
+			outpoint := btcwire.NewOutPoint(txHash, i)
+			txRedeemer, exists := mp.outpoints[*outpoint]
+			if exists {
+				mp.removeTransaction(txRedeemer, reason)
+			}
 		}
 	}
 
@@ -608,20 +884,30 @@ func (mp *txMemPool) removeTransaction(tx *btcutil.Tx) {
 			delete(mp.outpoints, txIn.PreviousOutpoint)
 		}
 		delete(mp.pool, *txHash)
+		heap.Remove(&mp.feeRates, txDesc.feeRateIndex)
+		mp.totalBytes -= int64(txDesc.Tx.MsgTx().SerializeSize())
 		mp.lastUpdated = time.Now()
+		mp.markRelativesStale(tx)
+
+		// Notify websocket clients about transactions which left the
+		// pool without being mined.
+		if reason != txRemoveMined && mp.server.rpcServer != nil {
+			mp.server.rpcServer.ntfnMgr.NotifyTxRemoved(tx, reason)
+		}
 	}
 }
 
-// RemoveTransaction removes the passed transaction and any transactions which
-// depend on it from the memory pool.
+// RemoveTransaction removes the passed transaction from the memory pool for
+// the passed reason.  Unless the transaction was mined, any transactions which
+// depend on it are removed as well.
 //
 // This function is safe for concurrent access.
-func (mp *txMemPool) RemoveTransaction(tx *btcutil.Tx) {
+func (mp *txMemPool) RemoveTransaction(tx *btcutil.Tx, reason txRemoveReason) {
 	// Protect concurrent access.
 	mp.Lock()
 	defer mp.Unlock()
 
-	mp.removeTransaction(tx)
+	mp.removeTransaction(tx, reason)
 }
 
 // RemoveDoubleSpends removes all transactions which spend outputs spent by the
@@ -639,7 +925,8 @@ func (mp *txMemPool) RemoveDoubleSpends(tx *btcutil.Tx) {
 	for _, txIn := range tx.MsgTx().TxIn {
 		if txRedeemer, ok := mp.outpoints[txIn.PreviousOutpoint]; ok {
 			if !txRedeemer.Sha().IsEqual(tx.Sha()) {
-				mp.removeTransaction(txRedeemer)
+				mp.removeTransaction(txRedeemer,
+					txRemoveConflict)
 			}
 		}
 	}
@@ -650,19 +937,25 @@ func (mp *txMemPool) RemoveDoubleSpends(tx *btcutil.Tx) {
 // helper for maybeAcceptTransaction.
 //
 // This function MUST be called with the mempool lock held (for writes).
-func (mp *txMemPool) addTransaction(tx *btcutil.Tx, height, fee int64) {
+func (mp *txMemPool) addTransaction(tx *btcutil.Tx, height, fee int64, priority float64) {
 	// Add the transaction to the pool and mark the referenced outpoints
 	// as spent by the pool.
-	mp.pool[*tx.Sha()] = &TxDesc{
-		Tx:     tx,
-		Added:  time.Now(),
-		Height: height,
-		Fee:    fee,
+	txDesc := &TxDesc{
+		Tx:               tx,
+		Added:            time.Now(),
+		Height:           height,
+		Fee:              fee,
+		FeePerKB:         feePerKB(tx, fee),
+		StartingPriority: priority,
 	}
+	mp.pool[*tx.Sha()] = txDesc
+	heap.Push(&mp.feeRates, txDesc)
 	for _, txIn := range tx.MsgTx().TxIn {
 		mp.outpoints[txIn.PreviousOutpoint] = tx
 	}
+	mp.totalBytes += int64(tx.MsgTx().SerializeSize())
 	mp.lastUpdated = time.Now()
+	mp.markRelativesStale(tx)
 }
 
 // checkPoolDoubleSpend checks whether or not the passed transaction is
@@ -689,7 +982,7 @@ func (mp *txMemPool) checkPoolDoubleSpend(tx *btcutil.Tx) error {
 //
 // This function MUST be called with the mempool lock held (for reads).
 func (mp *txMemPool) fetchInputTransactions(tx *btcutil.Tx) (btcchain.TxStore, error) {
-	txStore, err := mp.server.blockManager.blockChain.FetchTransactionStore(tx)
+	txStore, err := mp.server.blockManager.chain().FetchTransactionStore(tx)
 	if err != nil {
 		return nil, err
 	}
@@ -727,12 +1020,316 @@ func (mp *txMemPool) FetchTransaction(txHash *btcwire.ShaHash) (*btcutil.Tx, err
 	return nil, fmt.Errorf("transaction is not in the pool")
 }
 
+// FetchTxDesc returns the descriptor of the requested transaction from the
+// transaction pool.  This only fetches from the main transaction pool and does
+// not include orphans.
+//
+// This function is safe for concurrent access.
+func (mp *txMemPool) FetchTxDesc(txHash *btcwire.ShaHash) (*TxDesc, error) {
+	// Protect concurrent access.
+	mp.RLock()
+	defer mp.RUnlock()
+
+	if txDesc, exists := mp.pool[*txHash]; exists {
+		return txDesc, nil
+	}
+
+	return nil, fmt.Errorf("transaction is not in the pool")
+}
+
+// parents returns the hashes of the transactions in the pool which are spent by
+// the passed transaction.  Each hash is only included once.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *txMemPool) parents(tx *btcutil.Tx) []*btcwire.ShaHash {
+	var hashes []*btcwire.ShaHash
+	for _, txIn := range tx.MsgTx().TxIn {
+		hash := &txIn.PreviousOutpoint.Hash
+		if _, exists := mp.pool[*hash]; !exists {
+			continue
+		}
+		if containsHash(hashes, hash) {
+			continue
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes
+}
+
+// children returns the hashes of the transactions in the pool which spend the
+// passed transaction.  Each hash is only included once.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *txMemPool) children(tx *btcutil.Tx) []*btcwire.ShaHash {
+	var hashes []*btcwire.ShaHash
+	prevOut := btcwire.OutPoint{Hash: *tx.Sha()}
+	for i := range tx.MsgTx().TxOut {
+		prevOut.Index = uint32(i)
+		txSpender, exists := mp.outpoints[prevOut]
+		if !exists {
+			continue
+		}
+		hash := txSpender.Sha()
+		if containsHash(hashes, hash) {
+			continue
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes
+}
+
+// CheckSpend returns the transaction in the pool which spends the passed
+// outpoint or nil if no transaction in the pool spends it.  Orphans are not
+// included.
+//
+// This function is safe for concurrent access.
+func (mp *txMemPool) CheckSpend(op btcwire.OutPoint) *btcutil.Tx {
+	// Protect concurrent access.
+	mp.RLock()
+	defer mp.RUnlock()
+
+	return mp.outpoints[op]
+}
+
+// containsHash returns whether or not the passed hash is in the passed slice.
+func containsHash(hashes []*btcwire.ShaHash, hash *btcwire.ShaHash) bool {
+	for _, h := range hashes {
+		if h.IsEqual(hash) {
+			return true
+		}
+	}
+	return false
+}
+
+// sumRelatives walks the graph of transactions in the pool starting with the
+// passed transaction descriptor using the passed function to find the next
+// transactions to visit, and returns the number, total serialized size, and
+// total fees of all of the transactions visited, including the starting one.
+// Each transaction is visited once regardless of how many paths lead to it and
+// the walk is iterative, so long chains of unconfirmed transactions don't
+// consume excessive stack or memory.  The walk stops once limit transactions
+// have been visited unless the limit is 0.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *txMemPool) sumRelatives(txDesc *TxDesc, next func(*btcutil.Tx) []*btcwire.ShaHash, limit int) (int, int64, int64) {
+	visited := map[btcwire.ShaHash]struct{}{*txDesc.Tx.Sha(): struct{}{}}
+	toVisit := []*TxDesc{txDesc}
+	var count int
+	var size, fees int64
+	for len(toVisit) > 0 && (limit == 0 || count < limit) {
+		desc := toVisit[len(toVisit)-1]
+		toVisit = toVisit[:len(toVisit)-1]
+
+		count++
+		size += int64(desc.Tx.MsgTx().SerializeSize())
+		fees += desc.Fee
+
+		for _, hash := range next(desc.Tx) {
+			if _, exists := visited[*hash]; exists {
+				continue
+			}
+			visited[*hash] = struct{}{}
+			if relative, exists := mp.pool[*hash]; exists {
+				toVisit = append(toVisit, relative)
+			}
+		}
+	}
+
+	return count, size, fees
+}
+
+// FetchTxPoolEntry returns details about the requested transaction in the
+// transaction pool, including its current priority and its relationships with
+// the other transactions in the pool.  This only fetches from the main
+// transaction pool and does not include orphans.
+//
+// This function is safe for concurrent access.
+func (mp *txMemPool) FetchTxPoolEntry(txHash *btcwire.ShaHash) (*TxPoolEntry, error) {
+	// Protect concurrent access.
+	mp.RLock()
+	defer mp.RUnlock()
+
+	txDesc, exists := mp.pool[*txHash]
+	if !exists {
+		return nil, fmt.Errorf("transaction is not in the pool")
+	}
+
+	// Calculate the priority the transaction would have if it were
+	// included in the next block.
+	_, curHeight, err := mp.server.db.NewestSha()
+	if err != nil {
+		return nil, err
+	}
+	txStore, err := mp.fetchInputTransactions(txDesc.Tx)
+	if err != nil {
+		return nil, err
+	}
+	tx := txDesc.Tx
+	inputValueAge := calcInputValueAge(tx, txStore, curHeight+1)
+	priority := calcPriority(tx, tx.MsgTx().SerializeSize(), inputValueAge)
+
+	entry := &TxPoolEntry{
+		TxDesc:          txDesc,
+		CurrentPriority: priority,
+		Depends:         mp.parents(tx),
+		SpentBy:         mp.children(tx),
+	}
+	entry.AncestorCount, entry.AncestorSize, entry.AncestorFees =
+		mp.sumRelatives(txDesc, mp.parents, 0)
+	entry.DescendantCount, entry.DescendantSize, entry.DescendantFees =
+		mp.sumRelatives(txDesc, mp.children, 0)
+
+	return entry, nil
+}
+
+// markRelativesStale marks the summaries cached by TxRelatives of the passed
+// transaction, which was just added to or removed from the pool, along with the
+// summaries of all of its in-pool ancestors and descendants as stale since they
+// are the only ones which change.  The cache is dropped entirely once there are
+// more stale summaries than transactions in the pool since recomputing all of
+// them is cheaper then and the stale set can't grow without bound while
+// TxRelatives is not called.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *txMemPool) markRelativesStale(tx *btcutil.Tx) {
+	if !mp.relativesCached {
+		return
+	}
+
+	mp.staleRelatives[*tx.Sha()] = struct{}{}
+	for _, next := range []func(*btcutil.Tx) []*btcwire.ShaHash{
+		mp.parents, mp.children,
+	} {
+		visited := make(map[btcwire.ShaHash]struct{})
+		toVisit := next(tx)
+		for len(toVisit) > 0 {
+			hash := toVisit[len(toVisit)-1]
+			toVisit = toVisit[:len(toVisit)-1]
+			if _, exists := visited[*hash]; exists {
+				continue
+			}
+			visited[*hash] = struct{}{}
+			txDesc, exists := mp.pool[*hash]
+			if !exists {
+				continue
+			}
+			mp.staleRelatives[*hash] = struct{}{}
+			toVisit = append(toVisit, next(txDesc.Tx)...)
+		}
+	}
+
+	if len(mp.staleRelatives) > len(mp.pool) {
+		mp.relativesCached = false
+		mp.staleRelatives = nil
+	}
+}
+
+// sumGraphRelatives walks the passed snapshot of the graph of transactions in
+// the pool starting with the passed transaction using the passed function to
+// find the next transactions to visit, and returns the number and total
+// serialized size of the transactions visited, including the starting one.  It
+// visits transactions the same way as sumRelatives, however it does not
+// require the mempool lock.
+func sumGraphRelatives(graph map[btcwire.ShaHash]*txGraphNode, txHash *btcwire.ShaHash, next func(*txGraphNode) []*btcwire.ShaHash, limit int) (int, int64) {
+	visited := map[btcwire.ShaHash]struct{}{*txHash: struct{}{}}
+	toVisit := []*txGraphNode{graph[*txHash]}
+	var count int
+	var size int64
+	for len(toVisit) > 0 && (limit == 0 || count < limit) {
+		node := toVisit[len(toVisit)-1]
+		toVisit = toVisit[:len(toVisit)-1]
+
+		count++
+		size += node.size
+
+		for _, hash := range next(node) {
+			if _, exists := visited[*hash]; exists {
+				continue
+			}
+			visited[*hash] = struct{}{}
+			if relative, exists := graph[*hash]; exists {
+				toVisit = append(toVisit, relative)
+			}
+		}
+	}
+
+	return count, size
+}
+
+// TxRelatives returns summaries of the in-pool ancestors and descendants of
+// every transaction in the main pool keyed by transaction hash.  Each walk of
+// the relatives of a transaction is limited to maxRelativesWalk transactions,
+// so the summaries of transactions in longer chains are truncated.  The
+// summaries are cached and only the ones which changed since the previous call
+// are recomputed.  The returned map must not be modified.
+//
+// The mempool lock is only held while taking a snapshot of the graph of the
+// transactions in the pool, so the walks don't block transactions from being
+// accepted.
+//
+// This function is safe for concurrent access.
+func (mp *txMemPool) TxRelatives() map[btcwire.ShaHash]TxRelatives {
+	mp.relativesMtx.Lock()
+	defer mp.relativesMtx.Unlock()
+
+	// Take the set of stale summaries along with a snapshot of the graph
+	// and start tracking the summaries which become stale from now on.
+	mp.Lock()
+	cached, stale := mp.relativesCached, mp.staleRelatives
+	if cached && len(stale) == 0 {
+		mp.Unlock()
+		return mp.relatives
+	}
+	mp.relativesCached = true
+	mp.staleRelatives = make(map[btcwire.ShaHash]struct{})
+	graph := make(map[btcwire.ShaHash]*txGraphNode, len(mp.pool))
+	for hash, txDesc := range mp.pool {
+		graph[hash] = &txGraphNode{
+			size:     int64(txDesc.Tx.MsgTx().SerializeSize()),
+			parents:  mp.parents(txDesc.Tx),
+			children: mp.children(txDesc.Tx),
+		}
+	}
+	mp.Unlock()
+
+	parents := func(node *txGraphNode) []*btcwire.ShaHash {
+		return node.parents
+	}
+	children := func(node *txGraphNode) []*btcwire.ShaHash {
+		return node.children
+	}
+	// Reuse the cached summaries which are not stale and summarize the
+	// rest.  A new map is built so the maps returned previously never
+	// change.
+	relatives := make(map[btcwire.ShaHash]TxRelatives, len(graph))
+	for hash := range graph {
+		if _, isStale := stale[hash]; cached && !isStale {
+			if r, ok := mp.relatives[hash]; ok {
+				relatives[hash] = r
+				continue
+			}
+		}
+
+		hash := hash
+		var r TxRelatives
+		r.AncestorCount, r.AncestorSize = sumGraphRelatives(graph,
+			&hash, parents, maxRelativesWalk)
+		r.DescendantCount, r.DescendantSize = sumGraphRelatives(graph,
+			&hash, children, maxRelativesWalk)
+		relatives[hash] = r
+	}
+	mp.relatives = relatives
+	return relatives
+}
+
 // maybeAcceptTransaction is the internal function which implements the public
-// MaybeAcceptTransaction.  See the comment for MaybeAcceptTransaction for
-// more details.
+// MaybeAcceptTransaction and TestAcceptTransaction.  See the comment for
+// MaybeAcceptTransaction for more details.  When dryRun is set, all of the
+// same checks are performed, but the transaction is not added to the pool, the
+// rate limiter state is not updated, and no notifications are sent.
 //
 // This function MUST be called with the mempool lock held (for writes).
-func (mp *txMemPool) maybeAcceptTransaction(tx *btcutil.Tx, isOrphan *bool, isNew, rateLimit bool) error {
+func (mp *txMemPool) maybeAcceptTransaction(tx *btcutil.Tx, isOrphan *bool, isNew, rateLimit, dryRun bool) error {
 	if isOrphan != nil {
 		*isOrphan = false
 	}
@@ -783,9 +1380,9 @@ func (mp *txMemPool) maybeAcceptTransaction(tx *btcutil.Tx, isOrphan *bool, isNe
 	}
 	nextBlockHeight := curHeight + 1
 
-	// Don't allow non-standard transactions if the network parameters
-	// forbid their relaying.
-	if !activeNetParams.RelayNonStdTxs {
+	// Don't allow non-standard transactions unless relaying them has been
+	// enabled for the active network or via --acceptnonstd.
+	if !cfg.relayNonStd {
 		err := checkTransactionStandard(tx, nextBlockHeight)
 		if err != nil {
 			str := fmt.Sprintf("transaction %v is not a standard "+
@@ -794,6 +1391,14 @@ func (mp *txMemPool) maybeAcceptTransaction(tx *btcutil.Tx, isOrphan *bool, isNe
 		}
 	}
 
+	// Don't allow transactions which violate the data carrier policy.
+	// This is enforced even when non-standard transactions are accepted.
+	if err := checkDataCarrier(tx); err != nil {
+		str := fmt.Sprintf("transaction %v violates the data carrier "+
+			"policy: %v", txHash, err)
+		return TxRuleError(str)
+	}
+
 	// The transaction may not use any of the same outputs as other
 	// transactions already in the pool as that would ultimately result in a
 	// double spend.  This check is intended to be quick and therefore only
@@ -849,9 +1454,9 @@ func (mp *txMemPool) maybeAcceptTransaction(tx *btcutil.Tx, isOrphan *bool, isNe
 		return err
 	}
 
-	// Don't allow transactions with non-standard inputs if the network
-	// parameters forbid their relaying.
-	if !activeNetParams.RelayNonStdTxs {
+	// Don't allow transactions with non-standard inputs unless relaying
+	// them has been enabled for the active network or via --acceptnonstd.
+	if !cfg.relayNonStd {
 		err := checkInputsStandard(tx, txStore)
 		if err != nil {
 			str := fmt.Sprintf("transaction %v has a non-standard "+
@@ -860,13 +1465,33 @@ func (mp *txMemPool) maybeAcceptTransaction(tx *btcutil.Tx, isOrphan *bool, isNe
 		}
 	}
 
-	// NOTE: if you modify this code to accept non-standard transactions,
-	// you should add code here to check that the transaction does a
-	// reasonable number of ECDSA signature verifications.
+	// Don't allow transactions with an excessive number of signature
+	// operations since they would be expensive to validate.  This is
+	// enforced even when non-standard transactions are accepted.
+	numSigOps := btcchain.CountSigOps(tx)
+	numP2SHSigOps, err := btcchain.CountP2SHSigOps(tx, false, txStore)
+	if err != nil {
+		if _, ok := err.(btcchain.RuleError); ok {
+			return TxRuleError(err.Error())
+		}
+		return err
+	}
+	numSigOps += numP2SHSigOps
+	if numSigOps > maxSigOpsPerTx {
+		str := fmt.Sprintf("transaction %v has too many signature "+
+			"operations: %d > %d", txHash, numSigOps,
+			maxSigOpsPerTx)
+		return TxRuleError(str)
+	}
 
-	// Don't allow transactions with fees too low to get into a mined block.
+	// Don't allow transactions with fees too low to get into a mined block
+	// unless they are small enough to be relayed for free.  Transactions
+	// which pay at least the minimum relay fee are never treated as free,
+	// so only the transactions which rely on the free allowance count
+	// towards the free transaction rate limiter below.
 	minRequiredFee := calcMinRelayFee(tx)
-	if txFee < minRequiredFee {
+	isFree := txFee < minRequiredFee
+	if isFree && !isFreeTxCandidate(tx) {
 		str := fmt.Sprintf("transaction %v has %d fees which is under "+
 			"the required amount of %d", txHash, txFee,
 			minRequiredFee)
@@ -875,7 +1500,7 @@ func (mp *txMemPool) maybeAcceptTransaction(tx *btcutil.Tx, isOrphan *bool, isNe
 
 	// Free-to-relay transactions are rate limited here to prevent
 	// penny-flooding with tiny transactions as a form of attack.
-	if rateLimit && minRequiredFee == 0 {
+	if rateLimit && !dryRun && isFree {
 		nowUnix := time.Now().Unix()
 		// we decay passed data with an exponentially decaying ~10
 		// minutes window - matches bitcoind handling.
@@ -897,16 +1522,49 @@ func (mp *txMemPool) maybeAcceptTransaction(tx *btcutil.Tx, isOrphan *bool, isNe
 			cfg.FreeTxRelayLimit*10*1000)
 	}
 
+	// Don't allow transactions which would only be evicted again right
+	// away because the pool is full and their fee rate is too low.
+	if err := mp.checkPoolLimit(tx, txFee); err != nil {
+		return err
+	}
+
 	// Verify crypto signatures for each input and reject the transaction if
 	// any don't verify.
+	//
+	// NOTE: The signatures are verified again when the transaction is
+	// included in a block since the block scripts are validated inside
+	// btcchain.ProcessBlock.  Avoiding that by caching the signatures
+	// verified here (along with a --sigcachesize option to bound it)
+	// requires btcscript to accept a signature cache for its checksig
+	// operations and btcchain to pass one through when connecting blocks.
+	// Neither provides a hook for it, so it can't be done from btcd alone.
 	err = btcchain.ValidateTransactionScripts(tx, txStore,
 		standardScriptVerifyFlags)
 	if err != nil {
 		return err
 	}
 
-	// Add to transaction pool.
-	mp.addTransaction(tx, curHeight, txFee)
+	// Nothing more to do when only testing whether or not the transaction
+	// would be accepted.
+	if dryRun {
+		return nil
+	}
+
+	// Add to transaction pool and evict the lowest fee rate transactions
+	// if doing so caused the pool to exceed its max allowed size.  The
+	// transaction itself can still be evicted when it depends on one of
+	// the evicted transactions.
+	txSize := tx.MsgTx().SerializeSize()
+	priority := calcPriority(tx, txSize, calcInputValueAge(tx, txStore,
+		nextBlockHeight))
+	mp.addTransaction(tx, curHeight, txFee, priority)
+	mp.limitPoolSize()
+	if !mp.isTransactionInPool(txHash) {
+		str := fmt.Sprintf("transaction %v was evicted from the "+
+			"full mempool along with a transaction it depends on",
+			txHash)
+		return TxRuleError(str)
+	}
 
 	txmpLog.Debugf("Accepted transaction %v (pool size: %v)", txHash,
 		len(mp.pool))
@@ -932,7 +1590,24 @@ func (mp *txMemPool) MaybeAcceptTransaction(tx *btcutil.Tx, isOrphan *bool, isNe
 	mp.Lock()
 	defer mp.Unlock()
 
-	return mp.maybeAcceptTransaction(tx, isOrphan, isNew, rateLimit)
+	return mp.maybeAcceptTransaction(tx, isOrphan, isNew, rateLimit, false)
+}
+
+// TestAcceptTransaction performs all of the same checks MaybeAcceptTransaction
+// does to determine whether or not the passed transaction would be accepted
+// into the memory pool, but without actually adding it to the pool or relaying
+// it.  The returned bool indicates whether or not the transaction is an orphan
+// (has inputs missing), in which case it would not be accepted either.
+//
+// This function is safe for concurrent access.
+func (mp *txMemPool) TestAcceptTransaction(tx *btcutil.Tx) (bool, error) {
+	// Protect concurrent access.
+	mp.Lock()
+	defer mp.Unlock()
+
+	var isOrphan bool
+	err := mp.maybeAcceptTransaction(tx, &isOrphan, false, false, true)
+	return isOrphan, err
 }
 
 // processOrphans determines if there are any orphans which depend on the passed
@@ -972,7 +1647,8 @@ func (mp *txMemPool) processOrphans(hash *btcwire.ShaHash) error {
 			// Potentially accept the transaction into the
 			// transaction pool.
 			var isOrphan bool
-			err := mp.maybeAcceptTransaction(tx, &isOrphan, true, true)
+			err := mp.maybeAcceptTransaction(tx, &isOrphan, true, true,
+				false)
 			if err != nil {
 				return err
 			}
@@ -1010,7 +1686,7 @@ func (mp *txMemPool) ProcessTransaction(tx *btcutil.Tx, allowOrphan, rateLimit b
 
 	// Potentially accept the transaction to the memory pool.
 	var isOrphan bool
-	err := mp.maybeAcceptTransaction(tx, &isOrphan, true, rateLimit)
+	err := mp.maybeAcceptTransaction(tx, &isOrphan, true, rateLimit, false)
 	if err != nil {
 		return err
 	}
@@ -1055,6 +1731,27 @@ func (mp *txMemPool) Count() int {
 	return len(mp.pool)
 }
 
+// OrphanCount returns the number of transactions in the orphan pool.
+//
+// This function is safe for concurrent access.
+func (mp *txMemPool) OrphanCount() int {
+	mp.RLock()
+	defer mp.RUnlock()
+
+	return len(mp.orphans)
+}
+
+// Info returns the number of transactions in the main pool along with the sum
+// of their serialized sizes in bytes.  It does not include the orphan pool.
+//
+// This function is safe for concurrent access.
+func (mp *txMemPool) Info() (int, int64) {
+	mp.RLock()
+	defer mp.RUnlock()
+
+	return len(mp.pool), mp.totalBytes
+}
+
 // TxShas returns a slice of hashes for all of the transactions in the memory
 // pool.
 //
@@ -1109,7 +1806,8 @@ func newTxMemPool(server *server) *txMemPool {
 	return &txMemPool{
 		server:        server,
 		pool:          make(map[btcwire.ShaHash]*TxDesc),
-		orphans:       make(map[btcwire.ShaHash]*btcutil.Tx),
+		orphans:       make(map[btcwire.ShaHash]*list.Element),
+		orphanList:    list.New(),
 		orphansByPrev: make(map[btcwire.ShaHash]*list.List),
 		outpoints:     make(map[btcwire.OutPoint]*btcutil.Tx),
 	}
@@ -0,0 +1,179 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"container/list"
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+	"reflect"
+	"testing"
+)
+
+// TestSumGraphRelatives ensures the walks over a snapshot of the transaction
+// graph visit each relative once, including when the graph contains a cycle,
+// ignore transactions which are not in the snapshot, and stop at the limit.
+func TestSumGraphRelatives(t *testing.T) {
+	// Transaction 0 is spent by 1 and 2, which are both spent by 3, which
+	// is spent by 4.  Transactions 5 and 6 form a cycle and 6 also spends
+	// transaction 7, which is not in the snapshot.
+	h := make([]btcwire.ShaHash, 8)
+	for i := range h {
+		h[i] = testHash(byte(i))
+	}
+	node := func(size int64, parents, children []int) *txGraphNode {
+		n := &txGraphNode{size: size}
+		for _, i := range parents {
+			n.parents = append(n.parents, &h[i])
+		}
+		for _, i := range children {
+			n.children = append(n.children, &h[i])
+		}
+		return n
+	}
+	graph := map[btcwire.ShaHash]*txGraphNode{
+		h[0]: node(100, nil, []int{1, 2}),
+		h[1]: node(200, []int{0}, []int{3}),
+		h[2]: node(300, []int{0}, []int{3}),
+		h[3]: node(400, []int{1, 2}, []int{4}),
+		h[4]: node(500, []int{3}, nil),
+		h[5]: node(600, []int{6}, []int{6}),
+		h[6]: node(700, []int{5, 7}, []int{5}),
+	}
+	parents := func(n *txGraphNode) []*btcwire.ShaHash {
+		return n.parents
+	}
+	children := func(n *txGraphNode) []*btcwire.ShaHash {
+		return n.children
+	}
+
+	tests := []struct {
+		name      string
+		tx        int
+		next      func(*txGraphNode) []*btcwire.ShaHash
+		limit     int
+		wantCount int
+		wantSize  int64
+	}{
+		{"ancestors of root", 0, parents, 0, 1, 100},
+		{"descendants of root", 0, children, 0, 5, 1500},
+		{"ancestors of diamond", 3, parents, 0, 4, 1000},
+		{"descendants of leaf", 4, children, 0, 1, 500},
+		{"limited descendants", 0, children, 2, 2, 400},
+		{"ancestors in cycle", 5, parents, 0, 2, 1300},
+		{"descendants in cycle", 6, children, 0, 2, 1300},
+	}
+
+	for _, test := range tests {
+		count, size := sumGraphRelatives(graph, &h[test.tx], test.next,
+			test.limit)
+		if count != test.wantCount || size != test.wantSize {
+			t.Errorf("%s: unexpected summary - got %d txns of %d "+
+				"bytes, want %d txns of %d bytes", test.name,
+				count, size, test.wantCount, test.wantSize)
+		}
+	}
+}
+
+// newTestPoolTx returns a transaction which spends the first output of each of
+// the passed transaction hashes.
+func newTestPoolTx(prevHashes ...btcwire.ShaHash) *btcutil.Tx {
+	msgTx := btcwire.NewMsgTx()
+	for i := range prevHashes {
+		prevOut := btcwire.NewOutPoint(&prevHashes[i], 0)
+		msgTx.AddTxIn(btcwire.NewTxIn(prevOut, nil))
+	}
+	msgTx.AddTxOut(btcwire.NewTxOut(1000, nil))
+	return btcutil.NewTx(msgTx)
+}
+
+// checkTxRelatives ensures the passed summaries hold the expected ancestor and
+// descendant counts for the passed transactions.
+func checkTxRelatives(t *testing.T, name string, relatives map[btcwire.ShaHash]TxRelatives, txns []*btcutil.Tx, want [][2]int) {
+	if len(relatives) != len(txns) {
+		t.Errorf("%s: unexpected number of summaries - got %d, want %d",
+			name, len(relatives), len(txns))
+	}
+	for i, tx := range txns {
+		r := relatives[*tx.Sha()]
+		if r.AncestorCount != want[i][0] ||
+			r.DescendantCount != want[i][1] {
+
+			t.Errorf("%s: tx %d: unexpected relatives - got %d "+
+				"ancestors and %d descendants, want %d and %d",
+				name, i, r.AncestorCount, r.DescendantCount,
+				want[i][0], want[i][1])
+		}
+	}
+}
+
+// TestTxRelativesCache ensures the summaries cached by TxRelatives are reused
+// until the pool changes, only the summaries of the relatives of a changed
+// transaction are marked stale, previously returned summaries never change,
+// and the cache is dropped once it is mostly stale.
+func TestTxRelativesCache(t *testing.T) {
+	mp := &txMemPool{
+		server:        &server{},
+		pool:          make(map[btcwire.ShaHash]*TxDesc),
+		orphans:       make(map[btcwire.ShaHash]*list.Element),
+		orphanList:    list.New(),
+		orphansByPrev: make(map[btcwire.ShaHash]*list.List),
+		outpoints:     make(map[btcwire.OutPoint]*btcutil.Tx),
+	}
+
+	// Transaction a is spent by b and d is unrelated to both.
+	a := newTestPoolTx(testHash(1))
+	b := newTestPoolTx(*a.Sha())
+	d := newTestPoolTx(testHash(2))
+	for _, tx := range []*btcutil.Tx{a, b, d} {
+		mp.addTransaction(tx, 1, 1000, 0)
+	}
+	first := mp.TxRelatives()
+	checkTxRelatives(t, "initial", first, []*btcutil.Tx{a, b, d},
+		[][2]int{{1, 2}, {2, 1}, {1, 1}})
+
+	// The cached summaries are returned while the pool is unchanged.
+	cached := reflect.ValueOf(mp.TxRelatives()).Pointer()
+	if cached != reflect.ValueOf(first).Pointer() {
+		t.Errorf("summaries recomputed for an unchanged pool")
+	}
+
+	// Adding c, which spends b, only makes the summaries of c and its
+	// ancestors stale.
+	c := newTestPoolTx(*b.Sha())
+	mp.addTransaction(c, 1, 1000, 0)
+	wantStale := map[btcwire.ShaHash]struct{}{
+		*a.Sha(): struct{}{},
+		*b.Sha(): struct{}{},
+		*c.Sha(): struct{}{},
+	}
+	if !reflect.DeepEqual(mp.staleRelatives, wantStale) {
+		t.Errorf("unexpected stale summaries - got %v, want %v",
+			mp.staleRelatives, wantStale)
+	}
+	second := mp.TxRelatives()
+	checkTxRelatives(t, "after add", second, []*btcutil.Tx{a, b, c, d},
+		[][2]int{{1, 3}, {2, 2}, {3, 1}, {1, 1}})
+	checkTxRelatives(t, "snapshot", first, []*btcutil.Tx{a, b, d},
+		[][2]int{{1, 2}, {2, 1}, {1, 1}})
+
+	// Removing c marks the same summaries stale again.
+	mp.removeTransaction(c, txRemoveEvicted)
+	if !reflect.DeepEqual(mp.staleRelatives, wantStale) {
+		t.Errorf("unexpected stale summaries after removal - got %v, "+
+			"want %v", mp.staleRelatives, wantStale)
+	}
+	checkTxRelatives(t, "after removal", mp.TxRelatives(),
+		[]*btcutil.Tx{a, b, d}, [][2]int{{1, 2}, {2, 1}, {1, 1}})
+
+	// Removing a along with b leaves more stale summaries than
+	// transactions in the pool, so the cache is dropped.
+	mp.removeTransaction(a, txRemoveEvicted)
+	if mp.relativesCached || mp.staleRelatives != nil {
+		t.Errorf("mostly stale cache was not dropped")
+	}
+	checkTxRelatives(t, "after dropping the cache", mp.TxRelatives(),
+		[]*btcutil.Tx{d}, [][2]int{{1, 1}})
+}
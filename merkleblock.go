@@ -0,0 +1,127 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+)
+
+// hashMerkleBranches takes two hashes, treated as the left and right tree
+// nodes, and returns the hash of their concatenation.
+func hashMerkleBranches(left, right *btcwire.ShaHash) *btcwire.ShaHash {
+	var sha [btcwire.HashSize * 2]byte
+	copy(sha[:btcwire.HashSize], left[:])
+	copy(sha[btcwire.HashSize:], right[:])
+
+	newSha, _ := btcwire.NewShaHash(btcwire.DoubleSha256(sha[:]))
+	return newSha
+}
+
+// merkleBlock is used to house intermediate information needed to generate a
+// partial merkle tree as defined by BIP0037.
+type merkleBlock struct {
+	numTx       uint32
+	allHashes   []*btcwire.ShaHash
+	finalHashes []*btcwire.ShaHash
+	matchedBits []byte
+	bits        []byte
+}
+
+// calcTreeWidth calculates and returns the the number of nodes (width) of a
+// merkle tree at the given depth-first height.
+func (m *merkleBlock) calcTreeWidth(height uint32) uint32 {
+	return (m.numTx + (1 << height) - 1) >> height
+}
+
+// calcHash returns the hash for a sub-tree given a depth-first height and
+// node position.  When the right child is missing, the left child is hashed
+// with itself as is done when calculating the merkle root of a block.
+func (m *merkleBlock) calcHash(height, pos uint32) *btcwire.ShaHash {
+	if height == 0 {
+		return m.allHashes[pos]
+	}
+
+	var right *btcwire.ShaHash
+	left := m.calcHash(height-1, pos*2)
+	if pos*2+1 < m.calcTreeWidth(height-1) {
+		right = m.calcHash(height-1, pos*2+1)
+	} else {
+		right = left
+	}
+	return hashMerkleBranches(left, right)
+}
+
+// traverseAndBuild builds a partial merkle tree using a recursive depth-first
+// approach.  See BIP0037 for more details on the format.
+func (m *merkleBlock) traverseAndBuild(height, pos uint32) {
+	// Determine whether this node is a parent of a matched node.
+	var isParent byte
+	for i := pos << height; i < (pos+1)<<height && i < m.numTx; i++ {
+		isParent |= m.matchedBits[i]
+	}
+	m.bits = append(m.bits, isParent)
+
+	// When the node is a leaf or isn't a parent of a matched node, store
+	// its hash and stop descending.
+	if height == 0 || isParent == 0 {
+		m.finalHashes = append(m.finalHashes, m.calcHash(height, pos))
+		return
+	}
+
+	// Descend into the left child and the right child when it exists.
+	m.traverseAndBuild(height-1, pos*2)
+	if pos*2+1 < m.calcTreeWidth(height-1) {
+		m.traverseAndBuild(height-1, pos*2+1)
+	}
+}
+
+// newMerkleBlock returns a new merkleblock message for the passed block which
+// proves the inclusion of the transactions which match the passed filter.  The
+// filter is updated as the transactions are matched.  It also returns the
+// indices of the matched transactions within the block so they can be sent
+// along with the merkleblock.
+func newMerkleBlock(block *btcutil.Block, filter *bloomFilter) (*btcwire.MsgMerkleBlock, []int) {
+	transactions := block.Transactions()
+	numTx := uint32(len(transactions))
+	mBlock := merkleBlock{
+		numTx:       numTx,
+		allHashes:   make([]*btcwire.ShaHash, 0, numTx),
+		matchedBits: make([]byte, 0, numTx),
+	}
+
+	// Find and keep track of any transactions that match the filter.
+	var matchedIndices []int
+	for i, tx := range transactions {
+		if filter.MatchTxAndUpdate(tx) {
+			mBlock.matchedBits = append(mBlock.matchedBits, 1)
+			matchedIndices = append(matchedIndices, i)
+		} else {
+			mBlock.matchedBits = append(mBlock.matchedBits, 0)
+		}
+		mBlock.allHashes = append(mBlock.allHashes, tx.Sha())
+	}
+
+	// Calculate the number of merkle branches (height) in the tree and
+	// build the partial tree starting from the root.
+	height := uint32(0)
+	for mBlock.calcTreeWidth(height) > 1 {
+		height++
+	}
+	mBlock.traverseAndBuild(height, 0)
+
+	// Create and return the merkleblock with the flag bits packed into
+	// bytes in little-endian bit order.
+	msgMerkleBlock := btcwire.MsgMerkleBlock{
+		Header:       block.MsgBlock().Header,
+		Transactions: numTx,
+		Hashes:       mBlock.finalHashes,
+		Flags:        make([]byte, (len(mBlock.bits)+7)/8),
+	}
+	for i, bit := range mBlock.bits {
+		msgMerkleBlock.Flags[i/8] |= bit << uint(i%8)
+	}
+	return &msgMerkleBlock, matchedIndices
+}
@@ -0,0 +1,156 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+	"testing"
+)
+
+// newMerkleTestBlock returns a block with the passed number of distinct
+// transactions.
+func newMerkleTestBlock(numTx int) *btcutil.Block {
+	msgBlock := btcwire.NewMsgBlock(&btcwire.BlockHeader{})
+	for i := 0; i < numTx; i++ {
+		msgTx := btcwire.NewMsgTx()
+		msgTx.AddTxIn(btcwire.NewTxIn(btcwire.NewOutPoint(
+			&btcwire.ShaHash{}, uint32(i)), nil))
+		msgTx.AddTxOut(btcwire.NewTxOut(int64(i), nil))
+		msgBlock.AddTransaction(msgTx)
+	}
+	return btcutil.NewBlock(msgBlock)
+}
+
+// TestNewMerkleBlock ensures the partial merkle trees created for merkleblock
+// messages have the flag bits and hashes defined by BIP0037 for the
+// transactions which match the filter.
+func TestNewMerkleBlock(t *testing.T) {
+	tests := []struct {
+		name    string
+		numTx   int
+		matched []int
+		flags   []byte
+
+		// hashes returns the expected hashes given the hashes of the
+		// transactions in the block.
+		hashes func(txHashes []*btcwire.ShaHash) []*btcwire.ShaHash
+	}{
+		{
+			name:    "single transaction matched",
+			numTx:   1,
+			matched: []int{0},
+			flags:   []byte{0x01},
+			hashes: func(h []*btcwire.ShaHash) []*btcwire.ShaHash {
+				return []*btcwire.ShaHash{h[0]}
+			},
+		},
+		{
+			name:    "no match",
+			numTx:   4,
+			matched: nil,
+			flags:   []byte{0x00},
+			hashes: func(h []*btcwire.ShaHash) []*btcwire.ShaHash {
+				return []*btcwire.ShaHash{hashMerkleBranches(
+					hashMerkleBranches(h[0], h[1]),
+					hashMerkleBranches(h[2], h[3]))}
+			},
+		},
+		{
+			// Bits: root (1), left subtree (0), right subtree (1),
+			// matched leaf (1), unmatched leaf (0).
+			name:    "third of four matched",
+			numTx:   4,
+			matched: []int{2},
+			flags:   []byte{0x0d},
+			hashes: func(h []*btcwire.ShaHash) []*btcwire.ShaHash {
+				return []*btcwire.ShaHash{
+					hashMerkleBranches(h[0], h[1]), h[2], h[3],
+				}
+			},
+		},
+		{
+			// Bits: root (1), left subtree (1), matched leaf (1),
+			// unmatched leaf (0), right subtree without a right
+			// child (0).
+			name:    "first of three matched",
+			numTx:   3,
+			matched: []int{0},
+			flags:   []byte{0x07},
+			hashes: func(h []*btcwire.ShaHash) []*btcwire.ShaHash {
+				return []*btcwire.ShaHash{
+					h[0], h[1], hashMerkleBranches(h[2], h[2]),
+				}
+			},
+		},
+		{
+			// Bits: root (1), left subtree (1), two matched leaves
+			// (1, 1), right subtree (1), matched leaf (1), and the
+			// unmatched leaf (0).
+			name:    "all but the last of four matched",
+			numTx:   4,
+			matched: []int{0, 1, 2},
+			flags:   []byte{0x3f},
+			hashes: func(h []*btcwire.ShaHash) []*btcwire.ShaHash {
+				return []*btcwire.ShaHash{h[0], h[1], h[2], h[3]}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		block := newMerkleTestBlock(test.numTx)
+		txHashes := make([]*btcwire.ShaHash, 0, test.numTx)
+		for _, tx := range block.Transactions() {
+			txHashes = append(txHashes, tx.Sha())
+		}
+
+		// Load a filter which matches the hashes of the transactions
+		// which are expected to match.
+		bf := newBloomTestFilter(btcwire.BloomUpdateNone)
+		for _, idx := range test.matched {
+			bf.Add(txHashes[idx][:])
+		}
+
+		msg, matched := newMerkleBlock(block, bf)
+		if msg.Transactions != uint32(test.numTx) {
+			t.Errorf("newMerkleBlock (%s): unexpected number of "+
+				"transactions - got %d, want %d", test.name,
+				msg.Transactions, test.numTx)
+		}
+		if len(matched) != len(test.matched) {
+			t.Errorf("newMerkleBlock (%s): unexpected matched "+
+				"indices - got %v, want %v", test.name, matched,
+				test.matched)
+			continue
+		}
+		for i := range matched {
+			if matched[i] != test.matched[i] {
+				t.Errorf("newMerkleBlock (%s): unexpected "+
+					"matched indices - got %v, want %v",
+					test.name, matched, test.matched)
+				break
+			}
+		}
+		if !bytes.Equal(msg.Flags, test.flags) {
+			t.Errorf("newMerkleBlock (%s): unexpected flags - got "+
+				"%x, want %x", test.name, msg.Flags, test.flags)
+		}
+		wantHashes := test.hashes(txHashes)
+		if len(msg.Hashes) != len(wantHashes) {
+			t.Errorf("newMerkleBlock (%s): unexpected number of "+
+				"hashes - got %d, want %d", test.name,
+				len(msg.Hashes), len(wantHashes))
+			continue
+		}
+		for i := range wantHashes {
+			if !msg.Hashes[i].IsEqual(wantHashes[i]) {
+				t.Errorf("newMerkleBlock (%s): unexpected hash "+
+					"%d - got %v, want %v", test.name, i,
+					msg.Hashes[i], wantHashes[i])
+			}
+		}
+	}
+}
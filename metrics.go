@@ -0,0 +1,157 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// metricsContentType is the content type of the Prometheus text
+	// exposition format served by the metrics server.
+	metricsContentType = "text/plain; version=0.0.4"
+
+	// metricsReadTimeout is the amount of time a metrics client has to send
+	// its request before the connection is closed.
+	metricsReadTimeout = time.Second * 10
+
+	// metricsWriteTimeout is the amount of time the metrics server has to
+	// write a response before the connection is closed so clients which
+	// don't read the response can't hold connections open indefinitely.
+	metricsWriteTimeout = time.Second * 10
+)
+
+// metricsServer serves metrics about the state of the server in the Prometheus
+// text format over plain HTTP on the metrics listeners (--metricslisten).  It
+// is independent of the RPC server and does not require authentication, so the
+// listeners should only be bound to trusted interfaces.
+type metricsServer struct {
+	started   int32
+	shutdown  int32
+	server    *server
+	listeners []net.Listener
+	wg        sync.WaitGroup
+}
+
+// writeMetric writes a single metric with its help text and type to the passed
+// buffer in the Prometheus text format.
+func writeMetric(buf *bytes.Buffer, name, metricType, help string, value interface{}) {
+	fmt.Fprintf(buf, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(buf, "# TYPE %s %s\n", name, metricType)
+	fmt.Fprintf(buf, "%s %v\n", name, value)
+}
+
+// handleMetrics writes the current metrics to the passed response writer.  The
+// metrics are gathered from the same state the RPC server reports so they
+// agree with the results of the corresponding RPC commands.
+func (m *metricsServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "405 Method Not Allowed.",
+			http.StatusMethodNotAllowed)
+		return
+	}
+
+	s := m.server
+	_, height, err := s.db.NewestSha()
+	if err != nil {
+		srvrLog.Errorf("Unable to fetch best height for metrics: %v",
+			err)
+		http.Error(w, "500 Internal Server Error.",
+			http.StatusInternalServerError)
+		return
+	}
+	numTxns, numBytes := s.txMemPool.Info()
+	bytesRecv, bytesSent := s.NetTotals()
+
+	var buf bytes.Buffer
+	writeMetric(&buf, "btcd_peers", "gauge",
+		"Number of connected peers.", s.ConnectedCount())
+	writeMetric(&buf, "btcd_best_height", "gauge",
+		"Height of the best block in the main chain.", height)
+	writeMetric(&buf, "btcd_mempool_transactions", "gauge",
+		"Number of transactions in the memory pool.", numTxns)
+	writeMetric(&buf, "btcd_mempool_bytes", "gauge",
+		"Total serialized size of the transactions in the memory "+
+			"pool.", numBytes)
+	writeMetric(&buf, "btcd_mempool_orphans", "gauge",
+		"Number of orphan transactions in the memory pool.",
+		s.txMemPool.OrphanCount())
+	writeMetric(&buf, "btcd_bytes_received_total", "counter",
+		"Total bytes received from peers since startup.", bytesRecv)
+	writeMetric(&buf, "btcd_bytes_sent_total", "counter",
+		"Total bytes sent to peers since startup.", bytesSent)
+
+	w.Header().Set("Content-Type", metricsContentType)
+	w.Write(buf.Bytes())
+}
+
+// Start begins serving metrics on all of the metrics listeners.
+func (m *metricsServer) Start() {
+	if atomic.AddInt32(&m.started, 1) != 1 {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", m.handleMetrics)
+	httpServer := &http.Server{
+		Handler:      mux,
+		ReadTimeout:  metricsReadTimeout,
+		WriteTimeout: metricsWriteTimeout,
+	}
+	for _, listener := range m.listeners {
+		m.wg.Add(1)
+		go func(listener net.Listener) {
+			srvrLog.Infof("Metrics server listening on %s",
+				listener.Addr())
+			httpServer.Serve(listener)
+			srvrLog.Tracef("Metrics listener done for %s",
+				listener.Addr())
+			m.wg.Done()
+		}(listener)
+	}
+}
+
+// Stop closes all of the metrics listeners and waits for them to finish.
+func (m *metricsServer) Stop() {
+	if atomic.AddInt32(&m.shutdown, 1) != 1 {
+		return
+	}
+
+	for _, listener := range m.listeners {
+		if err := listener.Close(); err != nil {
+			srvrLog.Errorf("Unable to close metrics listener %s: %v",
+				listener.Addr(), err)
+		}
+	}
+	m.wg.Wait()
+}
+
+// newMetricsServer returns a new metrics server for the passed server which
+// listens on the passed addresses.
+func newMetricsServer(listenAddrs []string, s *server) (*metricsServer, error) {
+	listeners := make([]net.Listener, 0, len(listenAddrs))
+	for _, addr := range listenAddrs {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("unable to listen for metrics "+
+				"on %s: %v", addr, err)
+		}
+		listeners = append(listeners, listener)
+	}
+
+	return &metricsServer{
+		server:    s,
+		listeners: listeners,
+	}, nil
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"container/list"
+	"errors"
+	"github.com/conformal/btcdb"
+	"github.com/conformal/btcwire"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// metricsTestDb is a block database which only implements NewestSha, which is
+// all the metrics server uses.
+type metricsTestDb struct {
+	btcdb.Db
+	height int64
+	err    error
+}
+
+// NewestSha returns the configured best height or error.
+func (db *metricsTestDb) NewestSha() (*btcwire.ShaHash, int64, error) {
+	if db.err != nil {
+		return nil, 0, db.err
+	}
+	return &btcwire.ShaHash{}, db.height, nil
+}
+
+// newMetricsTestServer returns a server with the state reported by the metrics
+// server set to known values.
+func newMetricsTestServer(db btcdb.Db) *server {
+	return &server{
+		db:            db,
+		query:         make(chan interface{}),
+		bytesReceived: 1000,
+		bytesSent:     2000,
+		txMemPool: &txMemPool{
+			pool:       make(map[btcwire.ShaHash]*TxDesc),
+			orphans:    make(map[btcwire.ShaHash]*list.Element),
+			totalBytes: 300,
+		},
+	}
+}
+
+// TestHandleMetrics ensures the metrics are served in the Prometheus text
+// format and that unsupported methods and failures are reported with the
+// appropriate status codes.
+func TestHandleMetrics(t *testing.T) {
+	s := newMetricsTestServer(&metricsTestDb{height: 12345})
+	m := &metricsServer{server: s}
+
+	// Answer the connected peer count query normally answered by the peer
+	// handler.
+	go func() {
+		msg := (<-s.query).(getConnCountMsg)
+		msg.reply <- 8
+	}()
+
+	r, _ := http.NewRequest("GET", "http://127.0.0.1/metrics", nil)
+	w := httptest.NewRecorder()
+	m.handleMetrics(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code - got %d, want %d", w.Code,
+			http.StatusOK)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != metricsContentType {
+		t.Errorf("unexpected content type - got %q, want %q", ct,
+			metricsContentType)
+	}
+	want := "# HELP btcd_peers Number of connected peers.\n" +
+		"# TYPE btcd_peers gauge\n" +
+		"btcd_peers 8\n" +
+		"# HELP btcd_best_height Height of the best block in the " +
+		"main chain.\n" +
+		"# TYPE btcd_best_height gauge\n" +
+		"btcd_best_height 12345\n" +
+		"# HELP btcd_mempool_transactions Number of transactions in " +
+		"the memory pool.\n" +
+		"# TYPE btcd_mempool_transactions gauge\n" +
+		"btcd_mempool_transactions 0\n" +
+		"# HELP btcd_mempool_bytes Total serialized size of the " +
+		"transactions in the memory pool.\n" +
+		"# TYPE btcd_mempool_bytes gauge\n" +
+		"btcd_mempool_bytes 300\n" +
+		"# HELP btcd_mempool_orphans Number of orphan transactions " +
+		"in the memory pool.\n" +
+		"# TYPE btcd_mempool_orphans gauge\n" +
+		"btcd_mempool_orphans 0\n" +
+		"# HELP btcd_bytes_received_total Total bytes received from " +
+		"peers since startup.\n" +
+		"# TYPE btcd_bytes_received_total counter\n" +
+		"btcd_bytes_received_total 1000\n" +
+		"# HELP btcd_bytes_sent_total Total bytes sent to peers " +
+		"since startup.\n" +
+		"# TYPE btcd_bytes_sent_total counter\n" +
+		"btcd_bytes_sent_total 2000\n"
+	if got := w.Body.String(); got != want {
+		t.Errorf("unexpected metrics - got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Only GET and HEAD requests are allowed.
+	r, _ = http.NewRequest("POST", "http://127.0.0.1/metrics", nil)
+	w = httptest.NewRecorder()
+	m.handleMetrics(w, r)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("unexpected status code for POST - got %d, want %d",
+			w.Code, http.StatusMethodNotAllowed)
+	}
+
+	// A failure to fetch the best height is reported as a server error.
+	m.server = newMetricsTestServer(&metricsTestDb{
+		err: errors.New("db failure"),
+	})
+	r, _ = http.NewRequest("GET", "http://127.0.0.1/metrics", nil)
+	w = httptest.NewRecorder()
+	m.handleMetrics(w, r)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("unexpected status code for a database failure - got "+
+			"%d, want %d", w.Code, http.StatusInternalServerError)
+	}
+}
@@ -7,6 +7,7 @@ package main
 import (
 	"container/heap"
 	"container/list"
+	"errors"
 	"fmt"
 	"github.com/conformal/btcchain"
 	"github.com/conformal/btcdb"
@@ -17,14 +18,21 @@ import (
 )
 
 const (
-	// generatedBlockVersion is the version of the block being generated.
-	// It is defined as a constant here rather than using the
-	// btcwire.BlockVersion constant since a change in the block version
-	// will require changes to the generated block.  Using the btcwire
-	// constant for generated block version could allow creation of invalid
-	// blocks for the updated version.
+	// generatedBlockVersion is the default version of the block being
+	// generated.  It may be overridden on the test networks with the
+	// --blockversion option.  It is defined as a constant here rather than
+	// using the btcwire.BlockVersion constant since a change in the block
+	// version will require changes to the generated block.  Using the
+	// btcwire constant for generated block version could allow creation of
+	// invalid blocks for the updated version.
 	generatedBlockVersion = 2
 
+	// maxGeneratedBlockVersion is the highest version the --blockversion
+	// option may set.  Versions 2 through 4 signal the BIP0034, BIP0066,
+	// and BIP0065 soft forks respectively, so higher versions have no
+	// known meaning.
+	maxGeneratedBlockVersion = 4
+
 	// minHighPriority is the minimum priority value that allows a
 	// transaction to be considered high priority.
 	minHighPriority = btcutil.SatoshiPerBitcoin * 144 / 250
@@ -162,10 +170,11 @@ func newTxPriorityQueue(reserve int, sortByFee bool) *txPriorityQueue {
 }
 
 // BlockTemplate houses a block that has yet to be solved along with additional
-// details about the fees and the number of signature operations for each
-// transaction in the block.
+// details about its height, the fees, and the number of signature operations
+// for each transaction in the block.
 type BlockTemplate struct {
 	block       *btcwire.MsgBlock
+	height      int64
 	fees        []int64
 	sigOpCounts []int64
 }
@@ -202,19 +211,25 @@ func standardCoinbaseScript(nextBlockHeight int64, extraNonce uint64) []byte {
 		AddUint64(extraNonce).AddData([]byte(coinbaseFlags)).Script()
 }
 
-// createCoinbaseTx returns a coinbase transaction paying an appropriate subsidy
-// based on the passed block height to the passed public key.  It also accepts
-// an extra nonce value for the signature script.  This extra nonce helps ensure
-// the transaction is not a duplicate transaction (paying the same value to the
-// same public key address would otherwise be an identical transaction for
-// block version 1).
-func createCoinbaseTx(coinbaseScript []byte, nextBlockHeight int64, addr btcutil.Address) (*btcutil.Tx, error) {
-	// Create a script to pay to the specific address.
-	pkScript, err := btcscript.PayToAddrScript(addr)
-	if err != nil {
-		return nil, err
+// checkMiningScript returns an error if the passed public key script is not
+// suitable to be paid by the coinbase transaction of generated blocks.  Only
+// standard scripts are allowed, and null data scripts are rejected since their
+// outputs are provably unspendable.
+func checkMiningScript(pkScript []byte) error {
+	scriptClass := btcscript.GetScriptClass(pkScript)
+	if scriptClass == btcscript.NullDataTy {
+		return errors.New("null data scripts are unspendable")
 	}
+	return checkPkScriptStandard(pkScript, scriptClass)
+}
 
+// createCoinbaseTx returns a coinbase transaction paying an appropriate subsidy
+// based on the passed block height to the passed public key script.  It also
+// accepts an extra nonce value for the signature script.  This extra nonce
+// helps ensure the transaction is not a duplicate transaction (paying the same
+// value to the same public key script would otherwise be an identical
+// transaction for block version 1).
+func createCoinbaseTx(coinbaseScript []byte, nextBlockHeight int64, pkScript []byte) (*btcutil.Tx, error) {
 	tx := btcwire.NewMsgTx()
 	tx.AddTxIn(&btcwire.TxIn{
 		// Coinbase transactions have no inputs, so previous outpoint is
@@ -270,6 +285,34 @@ func calcPriority(tx *btcutil.Tx, serializedTxSize int, inputValueAge float64) f
 	return inputValueAge / float64(serializedTxSize-overhead)
 }
 
+// calcInputValueAge returns the sum of each input value of the passed
+// transaction multiplied by its age (# of confirmations) as of the passed
+// block height.  Inputs which are not available in the passed transaction
+// store or which reference transactions in the memory pool, and are therefore
+// unconfirmed, contribute nothing to the sum.
+func calcInputValueAge(tx *btcutil.Tx, txStore btcchain.TxStore, nextBlockHeight int64) float64 {
+	var inputValueAge float64
+	for _, txIn := range tx.MsgTx().TxIn {
+		originHash := &txIn.PreviousOutpoint.Hash
+		originIndex := txIn.PreviousOutpoint.Index
+		txData, exists := txStore[*originHash]
+		if !exists || txData.Err != nil || txData.Tx == nil ||
+			txData.BlockHeight == mempoolHeight {
+			continue
+		}
+
+		msgTx := txData.Tx.MsgTx()
+		if originIndex >= uint32(len(msgTx.TxOut)) {
+			continue
+		}
+		inputValue := msgTx.TxOut[originIndex].Value
+		inputAge := nextBlockHeight - txData.BlockHeight
+		inputValueAge += float64(inputValue * inputAge)
+	}
+
+	return inputValueAge
+}
+
 // spendTransaction updates the passed transaction store by marking the inputs
 // to the passed transaction as spent.  It also adds the passed transaction to
 // the store at the provided height.
@@ -307,10 +350,11 @@ func logSkippedDeps(tx *btcutil.Tx, deps *list.List) {
 	}
 }
 
-// medianAdjustedTime returns the current time adjusted to ensure it is at least
-// one second after the median timestamp of the last several blocks per the
-// chain consensus rules.
-func medianAdjustedTime(chainState *chainState) (time.Time, error) {
+// medianAdjustedTime returns the current time, adjusted by the median offset of
+// the passed time source, adjusted further to ensure it is at least one second
+// after the median timestamp of the last several blocks per the chain
+// consensus rules.
+func medianAdjustedTime(chainState *chainState, timeSource *medianTime) (time.Time, error) {
 	chainState.Lock()
 	defer chainState.Unlock()
 	if chainState.pastMedianTimeErr != nil {
@@ -323,7 +367,7 @@ func medianAdjustedTime(chainState *chainState) (time.Time, error) {
 	// timestamp is truncated to a second boundary before comparison since a
 	// block timestamp does not supported a precision greater than one
 	// second.
-	newTimestamp := time.Unix(time.Now().Unix(), 0)
+	newTimestamp := timeSource.AdjustedTime()
 	minTimestamp := chainState.pastMedianTime.Add(time.Second)
 	if newTimestamp.Before(minTimestamp) {
 		newTimestamp = minTimestamp
@@ -334,9 +378,10 @@ func medianAdjustedTime(chainState *chainState) (time.Time, error) {
 
 // NewBlockTemplate returns a new block template using the transactions from the
 // passed transaction memory pool with a coinbase that pays to the passed
-// address and is ready to be solved.  The transactions selected and included
-// are prioritized according to several factors.  First, each transaction has a
-// priority calculated based on its value, age of inputs, and size.
+// public key script and is ready to be solved.  The transactions selected and
+// included are prioritized according to several factors.  First, each
+// transaction has a priority calculated based on its value, age of inputs, and
+// size.
 // Transactions which consist of larger amounts, older inputs, and small sizes
 // have the highest priority.  Second, a fee per kilobyte is calculated for each
 // transaction.  Transactions with a higher fee per kilobyte are preferred.
@@ -387,10 +432,10 @@ func medianAdjustedTime(chainState *chainState) (time.Time, error) {
 //  |  transactions (while block size   |   |
 //  |  <= cfg.BlockMinSize)             |   |
 //   -----------------------------------  --
-func NewBlockTemplate(payToAddress btcutil.Address, mempool *txMemPool) (*BlockTemplate, error) {
+func NewBlockTemplate(payToScript []byte, mempool *txMemPool) (*BlockTemplate, error) {
 	blockManager := mempool.server.blockManager
 	chainState := &blockManager.chainState
-	chain := blockManager.blockChain
+	chain := blockManager.chain()
 
 	// Extend the most recently known best block.
 	chainState.Lock()
@@ -399,14 +444,14 @@ func NewBlockTemplate(payToAddress btcutil.Address, mempool *txMemPool) (*BlockT
 	chainState.Unlock()
 
 	// Create a standard coinbase transaction paying to the provided
-	// address.  NOTE: The coinbase value will be updated to include the
-	// fees from the selected transactions later after they have actually
-	// been selected.  It is created here to detect any errors early
-	// before potentially doing a lot of work below.
+	// public key script.  NOTE: The coinbase value will be updated to
+	// include the fees from the selected transactions later after they
+	// have actually been selected.  It is created here to detect any
+	// errors early before potentially doing a lot of work below.
 	extraNonce := uint64(0)
 	coinbaseScript := standardCoinbaseScript(nextBlockHeight, extraNonce)
 	coinbaseTx, err := createCoinbaseTx(coinbaseScript, nextBlockHeight,
-		payToAddress)
+		payToScript)
 	if err != nil {
 		return nil, err
 	}
@@ -444,8 +489,8 @@ func NewBlockTemplate(payToAddress btcutil.Address, mempool *txMemPool) (*BlockT
 	// a transaction as it is selected for inclusion in the final block.
 	// However, since the total fees aren't known yet, use a dummy value for
 	// the coinbase fee which will be updated later.
-	txFees := make([]int64, len(mempoolTxns))
-	txSigOpCounts := make([]int64, len(mempoolTxns))
+	txFees := make([]int64, 0, len(mempoolTxns))
+	txSigOpCounts := make([]int64, 0, len(mempoolTxns))
 	txFees = append(txFees, -1) // Updated once known
 	txSigOpCounts = append(txSigOpCounts, numCoinbaseSigOps)
 
@@ -629,13 +674,14 @@ mempoolLoop:
 
 		// Skip free transactions once the block is larger than the
 		// minimum block size.
-		if sortedByFee && prioItem.feePerKB < minTxRelayFee &&
+		if sortedByFee &&
+			prioItem.feePerKB < float64(cfg.minRelayTxFee) &&
 			blockPlusTxSize >= cfg.BlockMinSize {
 
 			minrLog.Tracef("Skipping tx %s with feePerKB %.2f "+
-				"< minTxRelayFee %d and block size %d >= "+
+				"< minRelayTxFee %d and block size %d >= "+
 				"minBlockSize %d", tx.Sha(), prioItem.feePerKB,
-				minTxRelayFee, blockPlusTxSize,
+				cfg.minRelayTxFee, blockPlusTxSize,
 				cfg.BlockMinSize)
 			logSkippedDeps(tx, deps)
 			continue
@@ -736,7 +782,7 @@ mempoolLoop:
 	// Calculate the required difficulty for the block.  The timestamp
 	// is potentially adjusted to ensure it comes after the median time of
 	// the last several blocks per the chain consensus rules.
-	ts, err := medianAdjustedTime(chainState)
+	ts, err := medianAdjustedTime(chainState, mempool.server.timeSource)
 	if err != nil {
 		return nil, err
 	}
@@ -749,7 +795,7 @@ mempoolLoop:
 	merkles := btcchain.BuildMerkleTreeStore(blockTxns)
 	var msgBlock btcwire.MsgBlock
 	msgBlock.Header = btcwire.BlockHeader{
-		Version:    generatedBlockVersion,
+		Version:    cfg.BlockVersion,
 		PrevBlock:  *prevHash,
 		MerkleRoot: *merkles[len(merkles)-1],
 		Timestamp:  ts,
@@ -777,6 +823,7 @@ mempoolLoop:
 
 	return &BlockTemplate{
 		block:       &msgBlock,
+		height:      nextBlockHeight,
 		fees:        txFees,
 		sigOpCounts: txSigOpCounts,
 	}, nil
@@ -792,7 +839,8 @@ func UpdateBlockTime(msgBlock *btcwire.MsgBlock, bManager *blockManager) error {
 	// The new timestamp is potentially adjusted to ensure it comes after
 	// the median time of the last several blocks per the chain consensus
 	// rules.
-	newTimestamp, err := medianAdjustedTime(&bManager.chainState)
+	newTimestamp, err := medianAdjustedTime(&bManager.chainState,
+		bManager.server.timeSource)
 	if err != nil {
 		return err
 	}
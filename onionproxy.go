@@ -0,0 +1,143 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// onionProxyRetryInterval is how long an onion proxy which could not be
+	// reached is skipped before it is tried again.
+	onionProxyRetryInterval = time.Minute
+)
+
+// onionProxy houses an individual proxy used to reach tor hidden services
+// along with when it may be used again after it could not be reached.
+type onionProxy struct {
+	addr        string
+	dial        func(string, string) (net.Conn, error)
+	failedUntil time.Time
+}
+
+// onionProxyPool distributes the connections to and lookups of onion addresses
+// across multiple proxies in a round-robin fashion.  This allows the proxies to
+// be backed by different tor circuits so all traffic does not share a single
+// circuit.  Proxies which can't be reached are skipped for
+// onionProxyRetryInterval while any other proxy is available.
+type onionProxyPool struct {
+	sync.Mutex
+	proxies []*onionProxy
+	next    int
+}
+
+// candidates returns the proxies in the order they should be tried for the
+// next connection or lookup.  The order starts at the next proxy in the
+// round-robin rotation and the proxies which could not be reached recently are
+// moved to the end so they are only used as a last resort.
+//
+// This function is safe for concurrent access.
+func (pp *onionProxyPool) candidates() []*onionProxy {
+	pp.Lock()
+	defer pp.Unlock()
+
+	now := time.Now()
+	numProxies := len(pp.proxies)
+	available := make([]*onionProxy, 0, numProxies)
+	var failed []*onionProxy
+	for i := 0; i < numProxies; i++ {
+		proxy := pp.proxies[(pp.next+i)%numProxies]
+		if now.Before(proxy.failedUntil) {
+			failed = append(failed, proxy)
+			continue
+		}
+		available = append(available, proxy)
+	}
+	pp.next = (pp.next + 1) % numProxies
+
+	return append(available, failed...)
+}
+
+// markFailed marks the passed proxy as unreachable so it is skipped for
+// onionProxyRetryInterval.
+//
+// This function is safe for concurrent access.
+func (pp *onionProxyPool) markFailed(proxy *onionProxy) {
+	pp.Lock()
+	defer pp.Unlock()
+
+	proxy.failedUntil = time.Now().Add(onionProxyRetryInterval)
+}
+
+// isProxyUnreachable returns whether or not the passed error from a proxied
+// connection or lookup indicates the proxy itself could not be reached as
+// opposed to the proxy failing to reach the requested destination.
+func isProxyUnreachable(err error) bool {
+	opErr, ok := err.(*net.OpError)
+	return ok && opErr.Op == "dial"
+}
+
+// try invokes the passed function with each of the candidate proxies in turn
+// until it succeeds or fails for a reason other than the proxy being
+// unreachable.  Proxies which can't be reached are marked as failed.  The
+// error from the final attempt is returned.
+func (pp *onionProxyPool) try(f func(proxy *onionProxy) error) error {
+	var err error
+	for _, proxy := range pp.candidates() {
+		err = f(proxy)
+		if err != nil && isProxyUnreachable(err) {
+			srvrLog.Debugf("Onion proxy %s is unreachable: %v",
+				proxy.addr, err)
+			pp.markFailed(proxy)
+			continue
+		}
+		return err
+	}
+	return err
+}
+
+// Dial connects to the passed address via one of the proxies in the pool.  It
+// has the same signature as net.Dial so it may be used in its place.
+//
+// This function is safe for concurrent access.
+func (pp *onionProxyPool) Dial(network, addr string) (net.Conn, error) {
+	var conn net.Conn
+	err := pp.try(func(proxy *onionProxy) error {
+		var err error
+		conn, err = proxy.dial(network, addr)
+		return err
+	})
+	return conn, err
+}
+
+// LookupIP resolves the passed host via one of the proxies in the pool using
+// tor.  It has the same signature as net.LookupIP so it may be used in its
+// place.
+//
+// This function is safe for concurrent access.
+func (pp *onionProxyPool) LookupIP(host string) ([]net.IP, error) {
+	var ips []net.IP
+	err := pp.try(func(proxy *onionProxy) error {
+		var err error
+		ips, err = torLookupIP(host, proxy.addr)
+		return err
+	})
+	return ips, err
+}
+
+// newOnionProxyPool returns a new onion proxy pool which uses the passed proxy
+// addresses of the given type and credentials.
+func newOnionProxyPool(proxyType string, addrs []string, user, pass string) *onionProxyPool {
+	proxies := make([]*onionProxy, 0, len(addrs))
+	for _, addr := range addrs {
+		proxies = append(proxies, &onionProxy{
+			addr: addr,
+			dial: proxyDial(proxyType, addr, user, pass),
+		})
+	}
+	return &onionProxyPool{proxies: proxies}
+}
@@ -0,0 +1,205 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestOnionProxyPool returns an onion proxy pool with the passed number of
+// proxies named proxy0, proxy1, and so on.  The proxies with the passed indices
+// are marked as failed until the passed time.
+func newTestOnionProxyPool(numProxies int, failed []int, failedUntil time.Time) *onionProxyPool {
+	pp := &onionProxyPool{}
+	for i := 0; i < numProxies; i++ {
+		pp.proxies = append(pp.proxies, &onionProxy{
+			addr: fmt.Sprintf("proxy%d", i),
+		})
+	}
+	for _, i := range failed {
+		pp.proxies[i].failedUntil = failedUntil
+	}
+	return pp
+}
+
+// proxyAddrs returns the addresses of the passed proxies.
+func proxyAddrs(proxies []*onionProxy) []string {
+	addrs := make([]string, 0, len(proxies))
+	for _, proxy := range proxies {
+		addrs = append(addrs, proxy.addr)
+	}
+	return addrs
+}
+
+// TestOnionProxyCandidates ensures the candidate proxies rotate in round-robin
+// order and recently failed proxies are moved to the end until their retry
+// interval has passed.
+func TestOnionProxyCandidates(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name        string
+		failed      []int
+		failedUntil time.Time
+		want        [][]string
+	}{
+		{
+			name: "rotation",
+			want: [][]string{
+				{"proxy0", "proxy1", "proxy2"},
+				{"proxy1", "proxy2", "proxy0"},
+				{"proxy2", "proxy0", "proxy1"},
+				{"proxy0", "proxy1", "proxy2"},
+			},
+		},
+		{
+			name:        "failed proxy last",
+			failed:      []int{1},
+			failedUntil: now.Add(time.Hour),
+			want: [][]string{
+				{"proxy0", "proxy2", "proxy1"},
+				{"proxy2", "proxy0", "proxy1"},
+				{"proxy2", "proxy0", "proxy1"},
+			},
+		},
+		{
+			name:        "all failed",
+			failed:      []int{0, 1, 2},
+			failedUntil: now.Add(time.Hour),
+			want: [][]string{
+				{"proxy0", "proxy1", "proxy2"},
+				{"proxy1", "proxy2", "proxy0"},
+			},
+		},
+		{
+			name:        "retry interval passed",
+			failed:      []int{0},
+			failedUntil: now.Add(-time.Second),
+			want: [][]string{
+				{"proxy0", "proxy1", "proxy2"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		pp := newTestOnionProxyPool(3, test.failed, test.failedUntil)
+		for i, want := range test.want {
+			got := proxyAddrs(pp.candidates())
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: call %d: unexpected candidates "+
+					"- got %v, want %v", test.name, i, got,
+					want)
+			}
+		}
+	}
+}
+
+// TestOnionProxyTry ensures proxies are tried in turn only while they are
+// unreachable, the unreachable proxies are marked as failed, and the error from
+// the final attempt is returned.
+func TestOnionProxyTry(t *testing.T) {
+	errUnreachable := &net.OpError{Op: "dial", Net: "tcp",
+		Err: errors.New("connection refused")}
+	errRemote := errors.New("proxy failed to reach the destination")
+	unreachable := func(addrs ...string) map[string]error {
+		errs := make(map[string]error, len(addrs))
+		for _, addr := range addrs {
+			errs[addr] = errUnreachable
+		}
+		return errs
+	}
+
+	tests := []struct {
+		name         string
+		failed       []int
+		errs         map[string]error
+		wantAttempts []string
+		wantErr      error
+		wantFailed   []string
+	}{
+		{
+			name:         "first proxy succeeds",
+			wantAttempts: []string{"proxy0"},
+		},
+		{
+			name:         "unreachable proxy skipped",
+			errs:         unreachable("proxy0"),
+			wantAttempts: []string{"proxy0", "proxy1"},
+			wantFailed:   []string{"proxy0"},
+		},
+		{
+			name:         "destination error not retried",
+			errs:         map[string]error{"proxy0": errRemote},
+			wantAttempts: []string{"proxy0"},
+			wantErr:      errRemote,
+		},
+		{
+			name:         "all unreachable",
+			errs:         unreachable("proxy0", "proxy1", "proxy2"),
+			wantAttempts: []string{"proxy0", "proxy1", "proxy2"},
+			wantErr:      errUnreachable,
+			wantFailed:   []string{"proxy0", "proxy1", "proxy2"},
+		},
+		{
+			name:         "recently failed proxy tried last",
+			failed:       []int{0},
+			errs:         unreachable("proxy1"),
+			wantAttempts: []string{"proxy1", "proxy2"},
+			wantFailed:   []string{"proxy0", "proxy1"},
+		},
+	}
+
+	for _, test := range tests {
+		pp := newTestOnionProxyPool(3, test.failed,
+			time.Now().Add(time.Hour))
+		var attempts []string
+		err := pp.try(func(proxy *onionProxy) error {
+			attempts = append(attempts, proxy.addr)
+			return test.errs[proxy.addr]
+		})
+		if err != test.wantErr {
+			t.Errorf("%s: unexpected error - got %v, want %v",
+				test.name, err, test.wantErr)
+		}
+		if !reflect.DeepEqual(attempts, test.wantAttempts) {
+			t.Errorf("%s: unexpected attempts - got %v, want %v",
+				test.name, attempts, test.wantAttempts)
+		}
+
+		var failed []string
+		now := time.Now()
+		for _, proxy := range pp.proxies {
+			if now.Before(proxy.failedUntil) {
+				failed = append(failed, proxy.addr)
+			}
+		}
+		if !reflect.DeepEqual(failed, test.wantFailed) {
+			t.Errorf("%s: unexpected failed proxies - got %v, "+
+				"want %v", test.name, failed, test.wantFailed)
+		}
+	}
+}
+
+// TestOnionProxyMarkFailed ensures a proxy marked as failed is skipped for the
+// retry interval.
+func TestOnionProxyMarkFailed(t *testing.T) {
+	pp := newTestOnionProxyPool(1, nil, time.Time{})
+	proxy := pp.proxies[0]
+	before := time.Now()
+	pp.markFailed(proxy)
+	after := time.Now()
+
+	earliest := before.Add(onionProxyRetryInterval)
+	latest := after.Add(onionProxyRetryInterval)
+	until := proxy.failedUntil
+	if until.Before(earliest) || until.After(latest) {
+		t.Errorf("unexpected failed until time - got %v, want between "+
+			"%v and %v", until, earliest, latest)
+	}
+}
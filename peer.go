@@ -7,6 +7,7 @@ package main
 import (
 	"bytes"
 	"container/list"
+	"errors"
 	"fmt"
 	"github.com/conformal/btcchain"
 	"github.com/conformal/btcdb"
@@ -14,6 +15,7 @@ import (
 	"github.com/conformal/btcwire"
 	"github.com/conformal/go-socks"
 	"github.com/davecgh/go-spew/spew"
+	"math"
 	"net"
 	"strconv"
 	"sync"
@@ -23,6 +25,14 @@ import (
 
 const (
 	// maxProtocolVersion is the max protocol version the peer supports.
+	//
+	// NOTE: This must not be raised beyond what btcwire is able to decode.
+	// Remote peers start sending the messages introduced by newer protocol
+	// versions (for example sendheaders, feefilter, and the BIP0152
+	// sendcmpct/cmpctblock/getblocktxn/blocktxn compact block messages)
+	// once they see a high enough version, and readMessage treats any
+	// command btcwire does not know about as a fatal error which
+	// disconnects the peer.
 	maxProtocolVersion = 70001
 
 	// outputBufferSize is the number of elements the output channels use.
@@ -36,11 +46,6 @@ const (
 	// inventory cache.
 	maxKnownInventory = 1000
 
-	// negotiateTimeoutSeconds is the number of seconds of inactivity before
-	// we timeout a peer that hasn't completed the initial version
-	// negotiation.
-	negotiateTimeoutSeconds = 30
-
 	// idleTimeoutMinutes is the number of minutes of inactivity before
 	// we time out a peer.
 	idleTimeoutMinutes = 5
@@ -48,6 +53,24 @@ const (
 	// pingTimeoutMinutes is the number of minutes since we last sent a
 	// message requiring a reply before we will ping a host.
 	pingTimeoutMinutes = 2
+
+	// otherCommand is the command bytes are tallied under in the per
+	// command network totals when they can't be attributed to a message
+	// command.
+	otherCommand = "*other*"
+
+	// pingStallTimeoutMinutes is the number of minutes a ping may remain
+	// unanswered before the peer is considered stalled and disconnected.
+	pingStallTimeoutMinutes = 5
+
+	// pingStallCheckSeconds is the number of seconds between checks for
+	// unanswered pings.
+	pingStallCheckSeconds = 30
+
+	// addrsPerBanScore is the number of addresses discarded by the address
+	// rate limit (--maxaddrspersecond) for each point of ban score added to
+	// the peer which sent them.
+	addrsPerBanScore = 100
 )
 
 var (
@@ -60,6 +83,10 @@ var (
 	userAgentVersion = fmt.Sprintf("%d.%d.%d", appMajor, appMinor, appPatch)
 )
 
+// nodeCount is the total number of peers which have been created and is used
+// to assign each peer a unique id.  It must be accessed atomically.
+var nodeCount int32
+
 // zeroHash is the zero value hash (all zeros).  It is defined as a convenience.
 var zeroHash btcwire.ShaHash
 
@@ -136,6 +163,7 @@ type outMsg struct {
 // to push messages to the peer.  Internally they use QueueMessage.
 type peer struct {
 	server             *server
+	id                 int32
 	btcnet             btcwire.BitcoinNet
 	started            int32
 	conn               net.Conn
@@ -146,6 +174,10 @@ type peer struct {
 	disconnect         int32 // only to be used atomically
 	persistent         bool
 	knownAddresses     map[string]bool
+	addrTokens         float64   // owned by inHandler
+	addrTokensTime     time.Time // owned by inHandler
+	addrsDiscarded     uint64    // owned by inHandler
+	addrsRequested     int       // owned by inHandler
 	knownInventory     *MruInventoryMap
 	knownInvMutex      sync.Mutex
 	requestedTxns      map[btcwire.ShaHash]bool // owned by blockmanager
@@ -165,11 +197,18 @@ type peer struct {
 	txProcessed        chan bool
 	blockProcessed     chan bool
 	quit               chan bool
+	filter             *bloomFilter
+	relayMtx           sync.Mutex // protects disableRelayTx.
+	disableRelayTx     bool
+	banScoreMtx        sync.Mutex // protects banScore and banScoreTime.
+	banScore           float64
+	banScoreTime       time.Time
 	StatsMtx           sync.Mutex // protects all statistics below here.
 	versionKnown       bool
 	protocolVersion    uint32
 	services           btcwire.ServiceFlag
 	timeConnected      time.Time
+	timeOffset         int64
 	lastSend           time.Time
 	lastRecv           time.Time
 	bytesReceived      uint64
@@ -179,6 +218,8 @@ type peer struct {
 	lastPingNonce      uint64    // Set to nonce if we have a pending ping.
 	lastPingTime       time.Time // Time we sent last ping.
 	lastPingMicros     int64     // Time for last ping to return.
+	whitelisted        bool
+	blockRelayPeer     bool
 }
 
 // String returns the peer's address and directionality as a human-readable
@@ -254,7 +295,8 @@ func (p *peer) pushVersionMsg() error {
 	msg := btcwire.NewMsgVersion(
 		p.server.addrManager.getBestLocalAddress(p.na), theirNa,
 		p.server.nonce, int32(blockNum))
-	msg.AddUserAgent(userAgentName, userAgentVersion)
+	msg.AddUserAgent(userAgentName, userAgentVersion,
+		cfg.UserAgentComments...)
 
 	// XXX: bitcoind appears to always enable the full node services flag
 	// of the remote peer netaddress field in the version message regardless
@@ -274,12 +316,17 @@ func (p *peer) pushVersionMsg() error {
 	//      by the remote peer in its version message
 	msg.AddrYou.Services = btcwire.SFNodeNetwork
 
-	// Advertise that we're a full node.
-	msg.Services = btcwire.SFNodeNetwork
+	// Advertise that we're a full node along with the other services the
+	// server supports.
+	msg.Services = p.server.services
 
 	// Advertise our max supported protocol version.
 	msg.ProtocolVersion = maxProtocolVersion
 
+	// Ask the remote peer not to announce transactions when running in
+	// blocks only mode (BIP0037 relay flag).
+	msg.DisableRelayTx = cfg.BlocksOnly
+
 	p.QueueMessage(msg, nil)
 	return nil
 }
@@ -308,6 +355,7 @@ func (p *peer) updateAddresses(msg *btcwire.MsgVersion) {
 		hasTimestamp := p.ProtocolVersion() >=
 			btcwire.NetAddressTimeVersion
 		if p.server.addrManager.NeedMoreAddresses() && hasTimestamp {
+			p.addrsRequested = getAddrMax
 			p.QueueMessage(btcwire.NewMsgGetAddr(), nil)
 		}
 
@@ -360,6 +408,36 @@ func (p *peer) handleVersionMsg(msg *btcwire.MsgVersion) {
 	// Set the remote peer's user agent.
 	p.userAgent = msg.UserAgent
 
+	// Set whether or not the remote peer wants transactions announced
+	// before it has loaded a bloom filter (BIP0037 relay flag).
+	p.relayMtx.Lock()
+	p.disableRelayTx = msg.DisableRelayTx
+	p.relayMtx.Unlock()
+
+	// Disconnect peers whose clock is too far off from the local clock when
+	// requested rather than letting their time influence the adjusted time.
+	if cfg.RejectTimeOffset > 0 {
+		offset := msg.Timestamp.Sub(time.Now())
+		if offset > cfg.RejectTimeOffset || offset < -cfg.RejectTimeOffset {
+			peerLog.Infof("Disconnecting peer %s with a time offset "+
+				"of %v which exceeds the max of %v", p,
+				offset-offset%time.Second, cfg.RejectTimeOffset)
+			p.StatsMtx.Unlock()
+			p.Disconnect()
+			return
+		}
+	}
+
+	// Add the remote peer time as a sample for creating an offset against
+	// the local clock to keep the network time in sync and record the
+	// offset observed for this peer.  The samples are keyed by host so a
+	// single host can't influence the median with multiple connections.
+	host, _, err := net.SplitHostPort(p.addr)
+	if err != nil {
+		host = p.addr
+	}
+	p.timeOffset = p.server.timeSource.AddTimeSample(host, msg.Timestamp)
+
 	p.StatsMtx.Unlock()
 
 	// Inbound connections.
@@ -426,6 +504,28 @@ func (p *peer) pushTxMsg(sha *btcwire.ShaHash, doneChan, waitChan chan bool) err
 	return nil
 }
 
+// checkHistoricalBlock returns an error and disconnects the peer when the passed
+// block is historical and may not be served to it because the upload target has
+// been reached.  Peers are disconnected rather than being sent a notfound since
+// they would otherwise stall waiting on the block.  Whitelisted peers are always
+// served.
+func (p *peer) checkHistoricalBlock(blk *btcutil.Block) error {
+	if p.whitelisted || cfg.MaxUploadTarget == 0 {
+		return nil
+	}
+	timestamp := blk.MsgBlock().Header.Timestamp
+	if time.Since(timestamp) < historicalBlockAge ||
+		p.server.ServeHistoricalBlocks() {
+		return nil
+	}
+
+	sha, _ := blk.Sha()
+	peerLog.Infof("Upload target reached -- disconnecting peer %s which "+
+		"requested historical block %v", p, sha)
+	p.Disconnect()
+	return errors.New("upload target reached")
+}
+
 // pushBlockMsg sends a block message for the provided block hash to the
 // connected peer.  An error is returned if the block hash is not known.
 func (p *peer) pushBlockMsg(sha *btcwire.ShaHash, doneChan, waitChan chan bool) error {
@@ -435,6 +535,9 @@ func (p *peer) pushBlockMsg(sha *btcwire.ShaHash, doneChan, waitChan chan bool)
 			sha, err)
 		return err
 	}
+	if err := p.checkHistoricalBlock(blk); err != nil {
+		return err
+	}
 
 	// Once we have fetched data wait for any previous operation to finish.
 	if waitChan != nil {
@@ -473,6 +576,64 @@ func (p *peer) pushBlockMsg(sha *btcwire.ShaHash, doneChan, waitChan chan bool)
 	return nil
 }
 
+// pushMerkleBlockMsg sends a merkleblock message for the provided block hash to
+// the connected peer followed by tx messages for the transactions in the block
+// which match the bloom filter loaded by the peer.  Nothing is sent when the
+// peer has not loaded a filter.  An error is returned if the block hash is not
+// known.
+func (p *peer) pushMerkleBlockMsg(sha *btcwire.ShaHash, doneChan, waitChan chan bool) error {
+	// Do not send a response if the peer doesn't have a filter loaded.
+	if !p.filter.IsLoaded() {
+		if doneChan != nil {
+			// Avoid deadlock when caller waits on channel.
+			go func() {
+				doneChan <- false
+			}()
+		}
+		return nil
+	}
+
+	blk, err := p.server.db.FetchBlockBySha(sha)
+	if err != nil {
+		peerLog.Tracef("Unable to fetch requested block sha %v: %v",
+			sha, err)
+		return err
+	}
+	if err := p.checkHistoricalBlock(blk); err != nil {
+		return err
+	}
+
+	// Generate a merkle block by filtering the requested block according
+	// to the filter for the peer.
+	merkle, matchedIndices := newMerkleBlock(blk, p.filter)
+
+	// Once we have fetched data wait for any previous operation to finish.
+	if waitChan != nil {
+		<-waitChan
+	}
+
+	// Send the merkleblock.  Only send the done channel with this message
+	// if no transactions will be sent afterwards.
+	var dc chan bool
+	if len(matchedIndices) == 0 {
+		dc = doneChan
+	}
+	p.QueueMessage(merkle, dc)
+
+	// Finally, send any matched transactions.  The done channel is only
+	// sent with the final one.
+	blkTransactions := blk.MsgBlock().Transactions
+	for i, txIndex := range matchedIndices {
+		dc = nil
+		if i == len(matchedIndices)-1 {
+			dc = doneChan
+		}
+		p.QueueMessage(blkTransactions[txIndex], dc)
+	}
+
+	return nil
+}
+
 // PushGetBlocksMsg sends a getblocks message for the provided block locator
 // and stop hash.  It will ignore back-to-back duplicate requests.
 func (p *peer) PushGetBlocksMsg(locator btcchain.BlockLocator, stopHash *btcwire.ShaHash) error {
@@ -563,7 +724,14 @@ func (p *peer) handleMemPoolMsg(msg *btcwire.MsgMemPool) {
 	for i, hash := range hashes {
 		// Another thread might have removed the transaction from the
 		// pool since the initial query.
-		if !p.server.txMemPool.IsTransactionInPool(hash) {
+		tx, err := p.server.txMemPool.FetchTransaction(hash)
+		if err != nil {
+			continue
+		}
+
+		// Only include the transactions which match the bloom filter
+		// loaded by the peer, if any.
+		if p.filter.IsLoaded() && !p.filter.MatchTxAndUpdate(tx) {
 			continue
 		}
 
@@ -580,6 +748,98 @@ func (p *peer) handleMemPoolMsg(msg *btcwire.MsgMemPool) {
 	}
 }
 
+// bloomFiltersAllowed returns whether the server supports bloom filtering and
+// the peer negotiated a protocol version which includes it (BIP0037).  The
+// peer is disconnected when either isn't the case since the passed filter
+// message is a protocol violation then.
+func (p *peer) bloomFiltersAllowed(msg btcwire.Message) bool {
+	if cfg.NoPeerBloomFilters {
+		peerLog.Debugf("%s sent a %s request with bloom filtering "+
+			"disabled -- disconnecting", p, msg.Command())
+		p.Disconnect()
+		return false
+	}
+
+	if pver := p.ProtocolVersion(); pver < btcwire.BIP0037Version {
+		peerLog.Debugf("%s sent a %s request with protocol version %d "+
+			"which does not support bloom filters -- disconnecting",
+			p, msg.Command(), pver)
+		p.Disconnect()
+		return false
+	}
+
+	return true
+}
+
+// handleFilterAddMsg is invoked when a peer receives a filteradd bitcoin
+// message.  It adds the data to the bloom filter previously loaded by the peer.
+// Peers which send a filteradd message without a loaded filter are
+// disconnected.
+func (p *peer) handleFilterAddMsg(msg *btcwire.MsgFilterAdd) {
+	if !p.bloomFiltersAllowed(msg) {
+		return
+	}
+
+	if !p.filter.IsLoaded() {
+		peerLog.Debugf("%s sent a filteradd request with no filter "+
+			"loaded -- disconnecting", p)
+		p.Disconnect()
+		return
+	}
+
+	p.filter.Add(msg.Data)
+}
+
+// handleFilterClearMsg is invoked when a peer receives a filterclear bitcoin
+// message.  It removes the bloom filter previously loaded by the peer, which
+// results in all transactions being relayed to it once again.  Peers which send
+// a filterclear message without a loaded filter are disconnected.
+func (p *peer) handleFilterClearMsg(msg *btcwire.MsgFilterClear) {
+	if !p.bloomFiltersAllowed(msg) {
+		return
+	}
+
+	if !p.filter.IsLoaded() {
+		peerLog.Debugf("%s sent a filterclear request with no "+
+			"filter loaded -- disconnecting", p)
+		p.Disconnect()
+		return
+	}
+
+	p.filter.Unload()
+
+	p.relayMtx.Lock()
+	p.disableRelayTx = false
+	p.relayMtx.Unlock()
+}
+
+// handleFilterLoadMsg is invoked when a peer receives a filterload bitcoin
+// message.  It replaces the bloom filter for the peer with the one provided
+// and enables relaying of the transactions which match it.
+func (p *peer) handleFilterLoadMsg(msg *btcwire.MsgFilterLoad) {
+	if !p.bloomFiltersAllowed(msg) {
+		return
+	}
+
+	p.filter.Reload(msg)
+
+	p.relayMtx.Lock()
+	p.disableRelayTx = false
+	p.relayMtx.Unlock()
+}
+
+// RelayTxDisabled returns whether or not relaying of transactions to the peer
+// is disabled because it set the BIP0037 relay flag to false in its version
+// message and has not loaded a bloom filter since.
+//
+// This function is safe for concurrent access.
+func (p *peer) RelayTxDisabled() bool {
+	p.relayMtx.Lock()
+	defer p.relayMtx.Unlock()
+
+	return p.disableRelayTx
+}
+
 // handleTxMsg is invoked when a peer receives a tx bitcoin message.  It blocks
 // until the bitcoin transaction has been fully processed.  Unlock the block
 // handler this does not serialize all transactions through a single thread
@@ -592,6 +852,14 @@ func (p *peer) handleTxMsg(msg *btcwire.MsgTx) {
 	iv := btcwire.NewInvVect(btcwire.InvTypeTx, tx.Sha())
 	p.AddKnownInventory(iv)
 
+	// Ignore transactions from peers that are not whitelisted when running
+	// in blocks only mode.
+	if cfg.BlocksOnly && !p.whitelisted {
+		peerLog.Tracef("Ignoring tx %v from %v - blocksonly enabled",
+			tx.Sha(), p)
+		return
+	}
+
 	// Queue the transaction up to be handled by the block manager and
 	// intentionally block further receives until the transaction is fully
 	// processed and known good or bad.  This helps prevent a malicious peer
@@ -674,6 +942,8 @@ func (p *peer) handleGetDataMsg(msg *btcwire.MsgGetData) {
 			err = p.pushTxMsg(&iv.Hash, c, waitChan)
 		case btcwire.InvTypeBlock:
 			err = p.pushBlockMsg(&iv.Hash, c, waitChan)
+		case btcwire.InvTypeFilteredBlock:
+			err = p.pushMerkleBlockMsg(&iv.Hash, c, waitChan)
 		default:
 			peerLog.Warnf("Unknown type in inventory request %d",
 				iv.Type)
@@ -937,6 +1207,40 @@ func (p *peer) pushAddrMsg(addresses []*btcwire.NetAddress) error {
 	return nil
 }
 
+// allowAddr returns whether the address rate limit (--maxaddrspersecond)
+// allows another address to be accepted from the peer and charges it against
+// the token bucket of the peer when it does.  The bucket holds a full addr
+// message worth of tokens so the normal periodic address broadcasts never
+// exceed the limit.  The reply to our getaddr request is not charged at all
+// since it may hold up to getAddrMax addresses spread across several messages.
+//
+// This function MUST be called from the inHandler goroutine.
+func (p *peer) allowAddr() bool {
+	if p.addrsRequested > 0 {
+		p.addrsRequested--
+		return true
+	}
+	if cfg.MaxAddrsPerSec == 0 {
+		return true
+	}
+
+	const burst = btcwire.MaxAddrPerMsg
+	now := time.Now()
+	if p.addrTokensTime.IsZero() {
+		p.addrTokens = burst
+	} else if elapsed := now.Sub(p.addrTokensTime).Seconds(); elapsed > 0 {
+		p.addrTokens = math.Min(p.addrTokens+elapsed*cfg.MaxAddrsPerSec,
+			burst)
+	}
+	p.addrTokensTime = now
+
+	if p.addrTokens < 1 {
+		return false
+	}
+	p.addrTokens--
+	return true
+}
+
 // handleAddrMsg is invoked when a peer receives an addr bitcoin message and
 // is used to notify the server about advertised addresses.
 func (p *peer) handleAddrMsg(msg *btcwire.MsgAddr) {
@@ -961,6 +1265,8 @@ func (p *peer) handleAddrMsg(msg *btcwire.MsgAddr) {
 		return
 	}
 
+	var discarded uint64
+	addrList := make([]*btcwire.NetAddress, 0, len(msg.AddrList))
 	for _, na := range msg.AddrList {
 		// Don't add more address if we're disconnecting.
 		if atomic.LoadInt32(&p.disconnect) != 0 {
@@ -977,6 +1283,36 @@ func (p *peer) handleAddrMsg(msg *btcwire.MsgAddr) {
 
 		// Add address to known addresses for this peer.
 		p.knownAddresses[NetAddressKey(na)] = true
+
+		// Discard addresses on networks the onlynet option doesn't
+		// permit connecting to since they would never be used.
+		if !isNetAllowed(netAddressNetwork(na)) {
+			continue
+		}
+
+		// Discard addresses beyond the rate limit.
+		if !p.allowAddr() {
+			discarded++
+			continue
+		}
+		addrList = append(addrList, na)
+	}
+
+	// Increase the ban score of the peer by a point for every so many
+	// addresses it sent beyond the rate limit in total so that peers which
+	// only occasionally exceed it slightly are not penalized.
+	if discarded > 0 {
+		peerLog.Debugf("Discarded %d addresses from %s exceeding the "+
+			"rate limit", discarded, p)
+		prevDiscarded := p.addrsDiscarded
+		p.addrsDiscarded += discarded
+		points := p.addrsDiscarded/addrsPerBanScore -
+			prevDiscarded/addrsPerBanScore
+		if points > 0 && p.addBanScore(uint32(points),
+			"exceeded the address rate limit") {
+
+			return
+		}
 	}
 
 	// Add addresses to server address manager.  The address manager handles
@@ -984,7 +1320,7 @@ func (p *peer) handleAddrMsg(msg *btcwire.MsgAddr) {
 	// addresses, and last seen updates.
 	// XXX bitcoind gives a 2 hour time penalty here, do we want to do the
 	// same?
-	p.server.addrManager.AddAddresses(msg.AddrList, p.na)
+	p.server.addrManager.AddAddresses(addrList, p.na)
 }
 
 // handlePingMsg is invoked when a peer receives a ping bitcoin message.  For
@@ -1023,6 +1359,19 @@ func (p *peer) handlePongMsg(msg *btcwire.MsgPong) {
 	}
 }
 
+// isPingStalled returns whether or not the last ping sent to the peer has been
+// unanswered for longer than the ping stall timeout.  Peers which are too old
+// to reply to pings with a pong are never considered stalled.
+//
+// This function is safe for concurrent access.
+func (p *peer) isPingStalled() bool {
+	p.StatsMtx.Lock()
+	defer p.StatsMtx.Unlock()
+
+	return p.lastPingNonce != 0 && time.Now().Sub(p.lastPingTime) >
+		pingStallTimeoutMinutes*time.Minute
+}
+
 // readMessage reads the next bitcoin message from the peer with logging.
 func (p *peer) readMessage() (btcwire.Message, []byte, error) {
 	n, msg, buf, err := btcwire.ReadMessageN(p.conn, p.ProtocolVersion(),
@@ -1030,10 +1379,11 @@ func (p *peer) readMessage() (btcwire.Message, []byte, error) {
 	p.StatsMtx.Lock()
 	p.bytesReceived += uint64(n)
 	p.StatsMtx.Unlock()
-	p.server.AddBytesReceived(uint64(n))
 	if err != nil {
+		p.server.AddBytesReceived(otherCommand, uint64(n))
 		return nil, nil, err
 	}
+	p.server.AddBytesReceived(msg.Command(), uint64(n))
 
 	// Use closures to log expensive operations so they are only run when
 	// the logging level requires it.
@@ -1103,7 +1453,7 @@ func (p *peer) writeMessage(msg btcwire.Message) {
 	p.StatsMtx.Lock()
 	p.bytesSent += uint64(n)
 	p.StatsMtx.Unlock()
-	p.server.AddBytesSent(uint64(n))
+	p.server.AddBytesSent(msg.Command(), uint64(n))
 	if err != nil {
 		p.Disconnect()
 		p.logError("Can't send message to %s: %v", p, err)
@@ -1140,10 +1490,16 @@ func (p *peer) isAllowedByRegression(err error) bool {
 // inHandler handles all incoming messages for the peer.  It must be run as a
 // goroutine.
 func (p *peer) inHandler() {
-	// Peers must complete the initial version negotiation within a shorter
-	// timeframe than a general idle timeout.  The timer is then reset below
-	// to idleTimeoutMinutes for all future messages.
-	idleTimer := time.AfterFunc(negotiateTimeoutSeconds*time.Second, func() {
+	// Peers must complete the version handshake, which is finished once
+	// both their version and verack messages have been received, within
+	// the peer timeout regardless of any other traffic.
+	handshakeTimer := time.AfterFunc(cfg.PeerTimeout, func() {
+		peerLog.Debugf("Peer %s did not complete the version handshake "+
+			"within %v, disconnecting", p, cfg.PeerTimeout)
+		p.Disconnect()
+	})
+
+	idleTimer := time.AfterFunc(idleTimeoutMinutes*time.Minute, func() {
 		if p.VersionKnown() {
 			peerLog.Warnf("Peer %s no answer for %d minutes, "+
 				"disconnecting", p, idleTimeoutMinutes)
@@ -1192,7 +1548,9 @@ out:
 			markConnected = true
 
 		case *btcwire.MsgVerAck:
-			// Do nothing.
+			// The version handshake is complete since the version
+			// message is ensured to come first above.
+			handshakeTimer.Stop()
 
 		case *btcwire.MsgGetAddr:
 			p.handleGetAddrMsg(msg)
@@ -1243,6 +1601,15 @@ out:
 		case *btcwire.MsgGetHeaders:
 			p.handleGetHeadersMsg(msg)
 
+		case *btcwire.MsgFilterAdd:
+			p.handleFilterAddMsg(msg)
+
+		case *btcwire.MsgFilterClear:
+			p.handleFilterClearMsg(msg)
+
+		case *btcwire.MsgFilterLoad:
+			p.handleFilterLoadMsg(msg)
+
 		default:
 			peerLog.Debugf("Received unhandled message of type %v: Fix Me",
 				rmsg.Command())
@@ -1263,6 +1630,7 @@ out:
 		idleTimer.Reset(idleTimeoutMinutes * time.Minute)
 	}
 
+	handshakeTimer.Stop()
 	idleTimer.Stop()
 
 	// Ensure connection is closed and notify the server that the peer is
@@ -1381,6 +1749,19 @@ out:
 		case <-p.quit:
 			break out
 		}
+
+		// Disconnect peers which aren't reading the messages queued
+		// for them fast enough rather than letting the queue grow
+		// without bound.  The queued messages are drained below once
+		// the quit channel is seen.
+		if cfg.MaxSendBuffer > 0 &&
+			pendingMsgs.Len() > cfg.MaxSendBuffer &&
+			atomic.LoadInt32(&p.disconnect) == 0 {
+			peerLog.Infof("Peer %s has more than %d messages queued "+
+				"to be sent -- disconnecting", p,
+				cfg.MaxSendBuffer)
+			p.Disconnect()
+		}
 	}
 
 	// Drain any wait channels before we go away so we don't leave something
@@ -1423,9 +1804,20 @@ func (p *peer) outHandler() {
 		}
 		p.QueueMessage(btcwire.NewMsgPing(nonce), nil)
 	})
+	stallTicker := time.NewTicker(pingStallCheckSeconds * time.Second)
 out:
 	for {
 		select {
+		case <-stallTicker.C:
+			// Disconnect the peer when it hasn't answered the last
+			// ping in a timely manner.
+			if p.isPingStalled() {
+				peerLog.Infof("Peer %s has not responded to a "+
+					"ping for %d minutes -- disconnecting",
+					p, pingStallTimeoutMinutes)
+				p.Disconnect()
+			}
+
 		case msg := <-p.sendQueue:
 			// If the message is one we should get a reply for
 			// then reset the timer, we only want to send pings
@@ -1438,15 +1830,23 @@ out:
 			peerLog.Tracef("%s: received from queuehandler", p)
 			reset := true
 			switch m := msg.msg.(type) {
+			case nil:
+				// Flush marker queued by DrainAndShutdown.
+				// Everything queued before it has been
+				// written, so there is nothing to send.
+				reset = false
 			case *btcwire.MsgVersion:
 				// should get an ack
 			case *btcwire.MsgGetAddr:
 				// should get addresses
 			case *btcwire.MsgPing:
 				// expects pong
-				// Also set up statistics.
+				// Also set up statistics.  An outstanding ping
+				// is not replaced so a peer which never answers
+				// is detected as stalled.
 				p.StatsMtx.Lock()
-				if p.protocolVersion > btcwire.BIP0031Version {
+				if p.protocolVersion > btcwire.BIP0031Version &&
+					p.lastPingNonce == 0 {
 					p.lastPingNonce = m.Nonce
 					p.lastPingTime = time.Now()
 				}
@@ -1467,10 +1867,12 @@ out:
 			if reset {
 				pingTimer.Reset(pingTimeoutMinutes * time.Minute)
 			}
-			p.writeMessage(msg.msg)
-			p.StatsMtx.Lock()
-			p.lastSend = time.Now()
-			p.StatsMtx.Unlock()
+			if msg.msg != nil {
+				p.writeMessage(msg.msg)
+				p.StatsMtx.Lock()
+				p.lastSend = time.Now()
+				p.StatsMtx.Unlock()
+			}
 			if msg.doneChan != nil {
 				msg.doneChan <- true
 			}
@@ -1484,6 +1886,7 @@ out:
 	}
 
 	pingTimer.Stop()
+	stallTicker.Stop()
 
 	p.queueWg.Wait()
 
@@ -1522,7 +1925,20 @@ func (p *peer) QueueMessage(msg btcwire.Message, doneChan chan bool) {
 		}
 		return
 	}
-	p.outputQueue <- outMsg{msg: msg, doneChan: doneChan}
+
+	// The peer might be disconnected after the above check, such as when
+	// its send queue is full, in which case the queue handler no longer
+	// reads from the channel.  Don't block the caller, which could be the
+	// server relaying to all peers, when that happens.
+	select {
+	case p.outputQueue <- outMsg{msg: msg, doneChan: doneChan}:
+	case <-p.quit:
+		if doneChan != nil {
+			go func() {
+				doneChan <- false
+			}()
+		}
+	}
 }
 
 // QueueInventory adds the passed inventory to the inventory send queue which
@@ -1543,7 +1959,12 @@ func (p *peer) QueueInventory(invVect *btcwire.InvVect) {
 		return
 	}
 
-	p.outputInvChan <- invVect
+	// As with QueueMessage, don't block if the peer is disconnected after
+	// the above check.
+	select {
+	case p.outputInvChan <- invVect:
+	case <-p.quit:
+	}
 }
 
 // Connected returns whether or not the peer is currently connected.
@@ -1552,6 +1973,69 @@ func (p *peer) Connected() bool {
 		atomic.LoadInt32(&p.disconnect) == 0
 }
 
+// decayedBanScore returns the ban score of the peer decayed by the configured
+// number of points per hour (--banscoredecay) for the time elapsed since it was
+// last updated.
+//
+// This function MUST be called with the ban score lock held (for reads).
+func (p *peer) decayedBanScore(now time.Time) float64 {
+	elapsed := now.Sub(p.banScoreTime).Hours()
+	if elapsed <= 0 {
+		return p.banScore
+	}
+	return math.Max(p.banScore-elapsed*cfg.BanScoreDecay, 0)
+}
+
+// BanScore returns the current ban score of the peer.
+//
+// This function is safe for concurrent access.
+func (p *peer) BanScore() float64 {
+	p.banScoreMtx.Lock()
+	defer p.banScoreMtx.Unlock()
+
+	return p.decayedBanScore(time.Now())
+}
+
+// addBanScore increases the ban score of the peer by the passed number of
+// points for the misbehavior described by reason.  The peer is banned and
+// disconnected once its score reaches the ban threshold (--banthreshold).
+// Whitelisted peers, and all peers when banning is disabled (--nobanning),
+// still accumulate a score so the misbehavior is logged, but they are never
+// banned or disconnected.  It returns whether the peer was
+// disconnected.
+//
+// This function is safe for concurrent access.
+func (p *peer) addBanScore(points uint32, reason string) bool {
+	p.banScoreMtx.Lock()
+	now := time.Now()
+	score := p.decayedBanScore(now) + float64(points)
+	p.banScore = score
+	p.banScoreTime = now
+	p.banScoreMtx.Unlock()
+
+	peerLog.Warnf("Misbehaving peer %s: %s -- ban score increased by "+
+		"%d to %.2f", p, reason, points, score)
+	if score < float64(cfg.BanThreshold) {
+		return false
+	}
+	if p.whitelisted {
+		peerLog.Warnf("Not disconnecting whitelisted peer %s with ban "+
+			"score %.2f", p, score)
+		return false
+	}
+	if cfg.DisableBanning {
+		peerLog.Warnf("Not disconnecting peer %s with ban score %.2f "+
+			"since banning is disabled", p, score)
+		return false
+	}
+
+	peerLog.Warnf("Peer %s reached the ban threshold of %d -- "+
+		"disconnecting", p, cfg.BanThreshold)
+	p.server.BanPeer(p)
+	p.Disconnect()
+	return true
+}
+
 // Disconnect disconnects the peer by closing the connection.  It also sets
 // a flag so the impending shutdown can be detected.
 func (p *peer) Disconnect() {
@@ -1603,12 +2087,41 @@ func (p *peer) Shutdown() {
 	p.Disconnect()
 }
 
+// DrainAndShutdown gracefully shuts down the peer after giving the messages
+// which are already queued up to the passed timeout to be written to the
+// connection.  The connection is closed once the queue has been flushed or the
+// timeout expires, whichever happens first, so a peer with a wedged socket
+// can't block the caller indefinitely.
+func (p *peer) DrainAndShutdown(timeout time.Duration) {
+	if timeout > 0 && p.Connected() {
+		peerLog.Tracef("Draining queued messages for peer %s", p)
+
+		// Bound any blocked write so the output handler doesn't hang
+		// on a socket which isn't being read by the remote peer.
+		p.conn.SetWriteDeadline(time.Now().Add(timeout))
+
+		// Queue a marker with no message behind everything which is
+		// already queued and wait for the output handler to reach it.
+		flushed := make(chan bool, 1)
+		p.QueueMessage(nil, flushed)
+		select {
+		case <-flushed:
+		case <-time.After(timeout):
+			peerLog.Debugf("Timeout waiting for queued messages "+
+				"to be sent to %s", p)
+		}
+	}
+
+	p.Shutdown()
+}
+
 // newPeerBase returns a new base bitcoin peer for the provided server and
 // inbound flag.  This is used by the newInboundPeer and newOutboundPeer
 // functions to perform base setup needed by both types of peers.
 func newPeerBase(s *server, inbound bool) *peer {
 	p := peer{
 		server:          s,
+		id:              atomic.AddInt32(&nodeCount, 1),
 		protocolVersion: maxProtocolVersion,
 		btcnet:          s.netParams.Net,
 		services:        btcwire.SFNodeNetwork,
@@ -1625,16 +2138,51 @@ func newPeerBase(s *server, inbound bool) *peer {
 		txProcessed:     make(chan bool, 1),
 		blockProcessed:  make(chan bool, 1),
 		quit:            make(chan bool),
+		filter:          newBloomFilter(),
 	}
 	return &p
 }
 
+// isWhitelisted returns whether the passed host is an IP address which is
+// included in the whitelisted networks and IPs.
+func isWhitelisted(host string) bool {
+	if len(cfg.whitelists) == 0 {
+		return false
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, ipnet := range cfg.whitelists {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBlockRelayPeer returns whether the passed address is one of the peers
+// which relayed inventory is restricted to (--blockrelaypeer).
+func isBlockRelayPeer(addr string) bool {
+	for _, relayAddr := range cfg.BlockRelayPeers {
+		if addr == relayAddr {
+			return true
+		}
+	}
+	return false
+}
+
 // newPeer returns a new inbound bitcoin peer for the provided server and
 // connection.  Use Start to begin processing incoming and outgoing messages.
 func newInboundPeer(s *server, conn net.Conn) *peer {
 	p := newPeerBase(s, true)
 	p.conn = conn
 	p.addr = conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(p.addr); err == nil {
+		p.whitelisted = isWhitelisted(host)
+	}
 	p.timeConnected = time.Now()
 	atomic.AddInt32(&p.connected, 1)
 	return p
@@ -1643,10 +2191,12 @@ func newInboundPeer(s *server, conn net.Conn) *peer {
 // newOutbountPeer returns a new outbound bitcoin peer for the provided server and
 // address and connects to it asynchronously. If the connection is successful
 // then the peer will also be started.
-func newOutboundPeer(s *server, addr string, persistent bool) *peer {
+func newOutboundPeer(s *server, addr string, persistent bool, retryCount int64) *peer {
 	p := newPeerBase(s, false)
 	p.addr = addr
 	p.persistent = persistent
+	p.retryCount = retryCount
+	p.blockRelayPeer = persistent && isBlockRelayPeer(addr)
 
 	// Setup p.na with a temporary address that we are connecting to with
 	// faked up service flags.  We will replace this with the real one after
@@ -1676,12 +2226,22 @@ func newOutboundPeer(s *server, addr string, persistent bool) *peer {
 			host, err)
 		return nil
 	}
+	p.whitelisted = isWhitelisted(p.na.IP.String())
 
 	go func() {
 		// Attempt to connect to the peer.  If the connection fails and
-		// this is a persistent connection, retry after the retry
-		// interval.
+		// this is a persistent connection, retry with an exponentially
+		// increasing delay so unreachable peers aren't hammered.  The
+		// delay also applies before the first attempt when reconnecting
+		// to a persistent peer which didn't stay connected for long.
 		for atomic.LoadInt32(&p.disconnect) == 0 {
+			if p.retryCount > 0 {
+				delay := connRetryDelay(p.retryCount)
+				srvrLog.Debugf("Retrying connection to %s in "+
+					"%s", addr, delay)
+				time.Sleep(delay)
+			}
+
 			srvrLog.Debugf("Attempting to connect to %s", addr)
 			conn, err := btcdDial("tcp", addr)
 			if err != nil {
@@ -1692,11 +2252,6 @@ func newOutboundPeer(s *server, addr string, persistent bool) *peer {
 					p.server.donePeers <- p
 					return
 				}
-				scaledInterval := connectionRetryInterval.Nanoseconds() * p.retryCount / 2
-				scaledDuration := time.Duration(scaledInterval)
-				srvrLog.Debugf("Retrying connection to %s in "+
-					"%s", addr, scaledDuration)
-				time.Sleep(scaledDuration)
 				continue
 			}
 
@@ -1712,7 +2267,6 @@ func newOutboundPeer(s *server, addr string, persistent bool) *peer {
 					conn.RemoteAddr())
 				p.conn = conn
 				atomic.AddInt32(&p.connected, 1)
-				p.retryCount = 0
 				p.Start()
 			}
 
@@ -1722,6 +2276,24 @@ func newOutboundPeer(s *server, addr string, persistent bool) *peer {
 	return p
 }
 
+// connRetryDelay returns how long to wait before the passed retry of a
+// connection to a persistent peer.  The delay starts at the configured
+// connection retry interval and doubles with each retry up to a maximum of
+// maxConnectionRetryInterval.
+func connRetryDelay(retryCount int64) time.Duration {
+	delay := cfg.ConnRetryInterval
+	for i := int64(1); i < retryCount; i++ {
+		delay *= 2
+		if delay >= maxConnectionRetryInterval {
+			return maxConnectionRetryInterval
+		}
+	}
+	if delay > maxConnectionRetryInterval {
+		return maxConnectionRetryInterval
+	}
+	return delay
+}
+
 // logError makes sure that we only log errors loudly on user peers.
 func (p *peer) logError(fmt string, args ...interface{}) {
 	if p.persistent {
@@ -0,0 +1,153 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"github.com/conformal/btcwire"
+	"testing"
+	"time"
+)
+
+// TestDecayedBanScore ensures ban scores decay by the configured number of
+// points per hour and never drop below zero.
+func TestDecayedBanScore(t *testing.T) {
+	savedCfg := cfg
+	defer func() {
+		cfg = savedCfg
+	}()
+
+	then := time.Unix(1400000000, 0)
+	tests := []struct {
+		name    string
+		score   float64
+		decay   float64
+		elapsed time.Duration
+		want    float64
+	}{
+		{"no decay", 50, 0, 10 * time.Hour, 50},
+		{"no time elapsed", 50, 10, 0, 50},
+		{"clock moved back", 50, 10, -time.Hour, 50},
+		{"one hour", 50, 10, time.Hour, 40},
+		{"half an hour", 50, 10, 30 * time.Minute, 45},
+		{"decayed to zero", 50, 10, 6 * time.Hour, 0},
+	}
+
+	for _, test := range tests {
+		cfg = &config{BanScoreDecay: test.decay}
+		p := &peer{banScore: test.score, banScoreTime: then}
+		got := p.decayedBanScore(then.Add(test.elapsed))
+		if got != test.want {
+			t.Errorf("%s: unexpected score - got %v, want %v",
+				test.name, got, test.want)
+		}
+	}
+}
+
+// TestAddBanScore ensures peers are banned and disconnected once their ban
+// score reaches the threshold unless they are whitelisted or banning is
+// disabled.
+func TestAddBanScore(t *testing.T) {
+	savedCfg := cfg
+	defer func() {
+		cfg = savedCfg
+	}()
+
+	tests := []struct {
+		name        string
+		whitelisted bool
+		noBanning   bool
+		banned      bool
+	}{
+		{"normal peer", false, false, true},
+		{"whitelisted peer", true, false, false},
+		{"banning disabled", false, true, false},
+	}
+
+	for _, test := range tests {
+		cfg = &config{BanThreshold: 100, DisableBanning: test.noBanning}
+		s := &server{banPeers: make(chan *peer, 1)}
+		p := &peer{server: s, quit: make(chan bool),
+			whitelisted: test.whitelisted}
+
+		// The score accumulates below the threshold without any
+		// consequences.
+		for i := 0; i < 4; i++ {
+			if p.addBanScore(20, "test") {
+				t.Fatalf("%s: disconnected below the threshold",
+					test.name)
+			}
+		}
+		if score := p.BanScore(); score != 80 {
+			t.Errorf("%s: unexpected score - got %v, want %v",
+				test.name, score, 80)
+		}
+
+		got := p.addBanScore(20, "test")
+		if got != test.banned {
+			t.Errorf("%s: unexpected disconnect - got %v, want %v",
+				test.name, got, test.banned)
+		}
+		if banned := len(s.banPeers) == 1; banned != test.banned {
+			t.Errorf("%s: unexpected ban - got %v, want %v",
+				test.name, banned, test.banned)
+		}
+		disconnected := p.disconnect != 0
+		if disconnected != test.banned {
+			t.Errorf("%s: unexpected disconnect flag - got %v, "+
+				"want %v", test.name, disconnected, test.banned)
+		}
+	}
+}
+
+// TestAllowAddr ensures the address rate limit allows a full addr message worth
+// of addresses in a burst, never charges the reply to a getaddr request, and is
+// not applied when it is disabled.
+func TestAllowAddr(t *testing.T) {
+	savedCfg := cfg
+	defer func() {
+		cfg = savedCfg
+	}()
+
+	// countAllowed returns how many of the passed number of addresses the
+	// rate limit allows from the peer.
+	countAllowed := func(p *peer, n int) int {
+		allowed := 0
+		for i := 0; i < n; i++ {
+			if p.allowAddr() {
+				allowed++
+			}
+		}
+		return allowed
+	}
+
+	tests := []struct {
+		name      string
+		rate      float64
+		requested int
+		sent      int
+		want      int
+	}{
+		{"within burst", 0.1, 0, btcwire.MaxAddrPerMsg,
+			btcwire.MaxAddrPerMsg},
+		{"beyond burst", 0.1, 0, btcwire.MaxAddrPerMsg + 100,
+			btcwire.MaxAddrPerMsg},
+		{"getaddr reply", 0.1, getAddrMax, getAddrMax,
+			getAddrMax},
+		{"beyond getaddr reply", 0.1, getAddrMax,
+			getAddrMax + btcwire.MaxAddrPerMsg + 100,
+			getAddrMax + btcwire.MaxAddrPerMsg},
+		{"disabled", 0, 0, 5000, 5000},
+	}
+
+	for _, test := range tests {
+		cfg = &config{MaxAddrsPerSec: test.rate}
+		p := &peer{addrsRequested: test.requested}
+		got := countAllowed(p, test.sent)
+		if got != test.want {
+			t.Errorf("%s: unexpected allowed addresses - got %d, "+
+				"want %d", test.name, got, test.want)
+		}
+	}
+}
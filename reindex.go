@@ -0,0 +1,209 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/conformal/btcchain"
+	"github.com/conformal/btcdb"
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// reindexDbSuffix is the suffix appended to the path of the block
+	// database to form the path the existing database is moved to while it
+	// is being reindexed.
+	reindexDbSuffix = ".reindex"
+
+	// reindexLogInterval is the minimum amount of time between reindex
+	// progress messages.
+	reindexLogInterval = time.Second * 10
+)
+
+// errReindexInterrupted is returned by reindexBlockDB when the reindex was
+// interrupted before it completed.
+var errReindexInterrupted = errors.New("reindex interrupted -- restart " +
+	"with --reindex to resume")
+
+// reindexDbPath returns the path the block database is moved to while it is
+// being reindexed.
+func reindexDbPath() string {
+	return blockDbPath(cfg.DbType) + reindexDbSuffix
+}
+
+// checkIncompleteReindex returns an error when a reindex was started but did
+// not complete and the --reindex flag was not specified to resume it.  Starting
+// normally in that case would sync the blocks which were not reindexed yet from
+// the network instead.
+func checkIncompleteReindex() error {
+	if cfg.Reindex || cfg.DbType == "memdb" {
+		return nil
+	}
+	if _, err := os.Stat(reindexDbPath()); err == nil {
+		return fmt.Errorf("a previous reindex of the block database did "+
+			"not complete -- restart with --reindex to resume it or "+
+			"remove '%s' to discard the blocks which were not "+
+			"reindexed yet", reindexDbPath())
+	}
+	return nil
+}
+
+// reindexSource is the interface to the database being reindexed which is
+// required to read its blocks.  It is satisfied by btcdb.Db.
+type reindexSource interface {
+	FetchBlockShaByHeight(height int64) (*btcwire.ShaHash, error)
+	FetchBlockBySha(sha *btcwire.ShaHash) (*btcutil.Block, error)
+}
+
+// reindexBlocks reads the blocks after the passed height through srcHeight
+// from the passed source in order and connects each of them with the passed
+// function.  It returns the height of the last block which was connected.  An
+// error is returned as soon as a block can't be read or connected, or when the
+// reindex is interrupted, so none of the remaining blocks are lost.
+func reindexBlocks(src reindexSource, height, srcHeight int64,
+	connectBlock func(*btcutil.Block) error, interrupted *int32) (int64, error) {
+
+	startHeight := height
+	startTime := time.Now()
+	lastLogTime := startTime
+	for height < srcHeight {
+		if atomic.LoadInt32(interrupted) != 0 {
+			btcdLog.Infof("Reindex interrupted at height %d", height)
+			return height, errReindexInterrupted
+		}
+
+		sha, err := src.FetchBlockShaByHeight(height + 1)
+		if err != nil {
+			return height, fmt.Errorf("unable to read block at "+
+				"height %d: %v", height+1, err)
+		}
+		block, err := src.FetchBlockBySha(sha)
+		if err != nil {
+			return height, fmt.Errorf("unable to read block %v at "+
+				"height %d: %v", sha, height+1, err)
+		}
+		if err := connectBlock(block); err != nil {
+			return height, fmt.Errorf("block %v at height %d failed "+
+				"validation: %v", sha, height+1, err)
+		}
+		height++
+
+		// Log progress along with an estimate of the time remaining
+		// based on the rate blocks have been reindexed at so far.
+		now := time.Now()
+		if now.Sub(lastLogTime) >= reindexLogInterval {
+			elapsed := now.Sub(startTime)
+			perBlock := elapsed / time.Duration(height-startHeight)
+			eta := perBlock * time.Duration(srcHeight-height)
+			btcdLog.Infof("Reindexed block at height %d of %d "+
+				"(%.2f%%, ETA %s)", height, srcHeight,
+				float64(height)*100/float64(srcHeight),
+				eta-eta%time.Second)
+			lastLogTime = now
+		}
+	}
+	return height, nil
+}
+
+// reindexBlockDB rebuilds the block database from the blocks stored in it.  The
+// existing database is moved aside and every block in its main chain is read
+// in order and connected to a new database through the normal chain validation
+// rules.  No network activity takes place since this is done before the server
+// is started.
+//
+// The reindex is resumable.  The moved database is only removed once all of its
+// blocks have been reindexed, so a reindex which is interrupted or fails, such
+// as due to a block which can't be read, keeps it and continues with the block
+// after the best block of the new database when it is run again.
+func reindexBlockDB() error {
+	srcPath := reindexDbPath()
+	if _, err := os.Stat(srcPath); err == nil {
+		btcdLog.Infof("Resuming reindex of the block database from '%s'",
+			srcPath)
+	} else {
+		dbPath := blockDbPath(cfg.DbType)
+		if _, err := os.Stat(dbPath); err != nil {
+			return fmt.Errorf("no block database to reindex at '%s'",
+				dbPath)
+		}
+		btcdLog.Infof("Moving block database to '%s' to reindex it",
+			srcPath)
+		if err := os.Rename(dbPath, srcPath); err != nil {
+			return err
+		}
+	}
+
+	srcDb, err := btcdb.OpenDB(cfg.DbType, srcPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if srcDb != nil {
+			srcDb.Close()
+		}
+	}()
+
+	db, err := loadBlockDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Stop reindexing on Ctrl+C while leaving both databases consistent so
+	// the reindex can be resumed.
+	var interrupted int32
+	addInterruptHandler(func() {
+		atomic.StoreInt32(&interrupted, 1)
+	})
+
+	_, srcHeight, err := srcDb.NewestSha()
+	if err != nil {
+		return err
+	}
+	_, height, err := db.NewestSha()
+	if err != nil {
+		return err
+	}
+
+	btcdLog.Infof("Reindexing blocks %d through %d", height+1, srcHeight)
+	chain := btcchain.New(db, activeNetParams.Params, nil)
+	connectBlock := func(block *btcutil.Block) error {
+		// Connect the block through the normal validation rules and
+		// ensure it extended the main chain.
+		if err := chain.ProcessBlock(block, false); err != nil {
+			return err
+		}
+		sha, _ := block.Sha()
+		if !db.ExistsSha(sha) {
+			return errors.New("not connected to the main chain")
+		}
+		return nil
+	}
+	height, err = reindexBlocks(srcDb, height, srcHeight, connectBlock,
+		&interrupted)
+	if err == errReindexInterrupted {
+		return err
+	}
+	if err != nil {
+		// Keep the existing database so the blocks which have not been
+		// reindexed yet are not lost.
+		return fmt.Errorf("%v -- the existing block database has been "+
+			"kept at %s; restart with --reindex to resume or remove "+
+			"it to discard the remaining blocks", err, srcPath)
+	}
+
+	btcdLog.Infof("Reindex complete at height %d", height)
+	srcDb.Close()
+	srcDb = nil
+	if err := os.RemoveAll(srcPath); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+	"testing"
+)
+
+// fakeReindexSource is a reindexSource which serves blocks from memory and
+// fails to read the block at a configurable height.
+type fakeReindexSource struct {
+	blocks     []*btcutil.Block
+	failHeight int64
+}
+
+// FetchBlockShaByHeight returns the hash of the block at the passed height.
+func (s *fakeReindexSource) FetchBlockShaByHeight(height int64) (*btcwire.ShaHash, error) {
+	if height == s.failHeight {
+		return nil, errors.New("corrupt block")
+	}
+	if height < 0 || height >= int64(len(s.blocks)) {
+		return nil, errors.New("no such block")
+	}
+	return s.blocks[height].Sha()
+}
+
+// FetchBlockBySha returns the block with the passed hash.
+func (s *fakeReindexSource) FetchBlockBySha(sha *btcwire.ShaHash) (*btcutil.Block, error) {
+	for _, block := range s.blocks {
+		blockSha, _ := block.Sha()
+		if blockSha.IsEqual(sha) {
+			return block, nil
+		}
+	}
+	return nil, errors.New("no such block")
+}
+
+// newFakeReindexSource returns a fakeReindexSource with blocks at heights 0
+// through the passed height.
+func newFakeReindexSource(height int64) *fakeReindexSource {
+	src := &fakeReindexSource{failHeight: -1}
+	for i := int64(0); i <= height; i++ {
+		msgBlock := btcwire.NewMsgBlock(&btcwire.BlockHeader{
+			Nonce: uint32(i),
+		})
+		src.blocks = append(src.blocks, btcutil.NewBlock(msgBlock))
+	}
+	return src
+}
+
+// TestReindexBlocks ensures reindexBlocks connects every block in order and
+// stops with an error at the first block which can't be read.
+func TestReindexBlocks(t *testing.T) {
+	tests := []struct {
+		name       string
+		height     int64
+		srcHeight  int64
+		failHeight int64
+		connected  []int64
+		wantHeight int64
+		wantErr    bool
+	}{
+		{
+			name:       "all blocks",
+			height:     0,
+			srcHeight:  5,
+			failHeight: -1,
+			connected:  []int64{1, 2, 3, 4, 5},
+			wantHeight: 5,
+		},
+		{
+			name:       "resume",
+			height:     3,
+			srcHeight:  5,
+			failHeight: -1,
+			connected:  []int64{4, 5},
+			wantHeight: 5,
+		},
+		{
+			name:       "unreadable block",
+			height:     0,
+			srcHeight:  5,
+			failHeight: 3,
+			connected:  []int64{1, 2},
+			wantHeight: 2,
+			wantErr:    true,
+		},
+	}
+
+	for _, test := range tests {
+		src := newFakeReindexSource(test.srcHeight)
+		src.failHeight = test.failHeight
+
+		var connected []int64
+		connectBlock := func(block *btcutil.Block) error {
+			connected = append(connected, int64(block.MsgBlock().Header.Nonce))
+			return nil
+		}
+		var interrupted int32
+		height, err := reindexBlocks(src, test.height, test.srcHeight,
+			connectBlock, &interrupted)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: unexpected error: got %v, want error %v",
+				test.name, err, test.wantErr)
+			continue
+		}
+		if height != test.wantHeight {
+			t.Errorf("%s: unexpected height: got %d, want %d",
+				test.name, height, test.wantHeight)
+			continue
+		}
+		if len(connected) != len(test.connected) {
+			t.Errorf("%s: unexpected connected blocks: got %v, "+
+				"want %v", test.name, connected, test.connected)
+			continue
+		}
+		for i := range connected {
+			if connected[i] != test.connected[i] {
+				t.Errorf("%s: unexpected connected blocks: got "+
+					"%v, want %v", test.name, connected,
+					test.connected)
+				break
+			}
+		}
+	}
+}
+
+// TestReindexBlocksInterrupted ensures reindexBlocks stops without connecting
+// any more blocks once it has been interrupted.
+func TestReindexBlocksInterrupted(t *testing.T) {
+	src := newFakeReindexSource(5)
+	interrupted := int32(1)
+	connectBlock := func(block *btcutil.Block) error {
+		t.Errorf("unexpected connected block at height %d",
+			block.MsgBlock().Header.Nonce)
+		return nil
+	}
+	height, err := reindexBlocks(src, 0, 5, connectBlock, &interrupted)
+	if err != errReindexInterrupted {
+		t.Errorf("unexpected error: got %v, want %v", err,
+			errReindexInterrupted)
+	}
+	if height != 0 {
+		t.Errorf("unexpected height: got %d, want 0", height)
+	}
+}
@@ -0,0 +1,2860 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/conformal/btcjson"
+	"github.com/conformal/btcws"
+)
+
+// This file contains the definitions for chain server RPC commands which are
+// supported by btcd, but are not (yet) provided by btcjson.  They are
+// registered with btcjson as custom commands so the standard parsing and help
+// machinery works for them exactly as it does for the built-in commands.
+
+func init() {
+	btcjson.RegisterCustomCmd("estimatefee", parseEstimateFeeCmd, nil,
+		`estimatefee numblocks
+Estimates the approximate fee per kilobyte needed for a transaction to begin
+confirmation within numblocks blocks.  The estimate is based on how long
+transactions seen in the memory pool waited to be mined.
+Arguments:
+1. numblocks    (numeric, required) the target number of blocks (1-25)
+Result:
+n.nnn           (numeric) estimated fee per kilobyte in BTC, or -1 if not
+                enough transactions have been observed to make an estimate`)
+	btcjson.RegisterCustomCmd("generate", parseGenerateCmd, nil,
+		`generate numblocks ( maxtries )
+Mines numblocks blocks on top of the current best chain using the CPU and
+returns their hashes.  The blocks include transactions from the memory pool
+and pay to the configured mining addresses and scripts.  This command is only
+available on the regression test and simulation test networks.
+Arguments:
+1. numblocks    (numeric, required) the number of blocks to generate
+2. maxtries     (numeric, optional) the maximum number of hashes to try for
+                each block (0 for unlimited, the default)
+Result:
+[
+  "hash",       (string) the hash of a generated block
+  ...
+]`)
+	btcjson.RegisterCustomCmd("getblockchaininfo", parseGetBlockChainInfoCmd,
+		nil, `getblockchaininfo
+Returns information about the current state of the block chain.
+Result:
+{
+  "chain": "xxxx",              (string) the network name (main, test,
+                                regtest, or simnet)
+  "blocks": n,                  (numeric) the number of blocks in the best
+                                chain
+  "headers": n,                 (numeric) the height of the highest known
+                                header, which is ahead of blocks while
+                                headers are downloaded ahead of the blocks
+                                during the initial sync
+  "bestblockhash": "hash",      (string) the hash of the best block
+  "difficulty": x.xxx,          (numeric) the current difficulty
+  "mediantime": ttt,            (numeric) the median time of the past blocks
+                                in seconds since epoch
+  "verificationprogress": x.xx, (numeric) an estimate of the verification
+                                progress between 0 and 1
+  "pruned": true|false          (boolean) whether or not blocks are pruned
+}`)
+	btcjson.RegisterCustomCmd("getblockheader", parseGetBlockHeaderCmd, nil,
+		`getblockheader "hash" ( verbose )
+If verbose is false, returns a string that is serialized, hex-encoded data
+for the 80-byte block header of the block with the passed hash.
+If verbose is true, returns an Object with information about the block header.
+Arguments:
+1. "hash"       (string, required) the block hash
+2. verbose      (boolean, optional, default=true) true for a json object,
+                false for the hex encoded data
+Result (for verbose = true):
+{
+  "hash": "hash",             (string) the block hash (same as provided)
+  "confirmations": n,         (numeric) the number of confirmations
+  "height": n,                (numeric) the block height or index
+  "version": n,               (numeric) the block version
+  "merkleroot": "xxxx",       (string) the merkle root
+  "time": ttt,                (numeric) the block time in seconds since epoch
+  "nonce": n,                 (numeric) the nonce
+  "bits": "1d00ffff",         (string) the bits
+  "difficulty": x.xxx,        (numeric) the difficulty
+  "previousblockhash": "xxx", (string) the hash of the previous block
+  "nextblockhash": "xxx"      (string) the hash of the next block
+}
+Result (for verbose = false):
+"data"                        (string) serialized, hex-encoded block header`)
+	btcjson.RegisterCustomCmd("getblockstats", parseGetBlockStatsCmd, nil,
+		`getblockstats hash_or_height ( ["stat",...] )
+Computes per block statistics for the main chain block with the passed hash or
+height.  All amounts are in satoshis and all fee rates are in satoshis per
+kilobyte.
+Arguments:
+1. hash_or_height   (string or numeric, required) the block hash or height
+2. ["stat",...]     (array, optional) the names of the statistics to return,
+                    all of them when not specified
+Result:
+{
+  "avgfee": n,          (numeric) average fee in the block
+  "avgfeerate": n,      (numeric) average fee rate in the block
+  "avgtxsize": n,       (numeric) average transaction size
+  "blockhash": "hash",  (string) the block hash
+  "height": n,          (numeric) the block height
+  "ins": n,             (numeric) number of inputs (excluding coinbase)
+  "maxfee": n,          (numeric) maximum fee in the block
+  "maxfeerate": n,      (numeric) maximum fee rate in the block
+  "maxtxsize": n,       (numeric) maximum transaction size
+  "medianfee": n,       (numeric) median fee in the block
+  "mediantxsize": n,    (numeric) median transaction size
+  "minfee": n,          (numeric) minimum fee in the block
+  "minfeerate": n,      (numeric) minimum fee rate in the block
+  "mintxsize": n,       (numeric) minimum transaction size
+  "outs": n,            (numeric) number of outputs
+  "subsidy": n,         (numeric) the block subsidy
+  "time": n,            (numeric) the block time in seconds since epoch
+  "total_out": n,       (numeric) total amount in all outputs (excluding
+                        coinbase)
+  "total_size": n,      (numeric) total size of all transactions (excluding
+                        coinbase)
+  "totalfee": n,        (numeric) sum of all fees (excluding coinbase)
+  "txs": n,             (numeric) number of transactions (including coinbase)
+  "utxo_increase": n    (numeric) increase or decrease in the number of
+                        unspent outputs
+}`)
+	btcjson.RegisterCustomCmd("getcfilter", parseGetCFilterCmd, nil,
+		`getcfilter "hash"
+Returns the BIP0158 basic compact filter of the main chain block with the
+passed hash along with its filter header.  This command is only available when
+the compact filter index is enabled (--cfilters).
+Arguments:
+1. "hash"       (string, required) the block hash
+Result:
+{
+  "filter": "xxxx",           (string) the hex-encoded serialized filter
+  "header": "hash"            (string) the filter header
+}`)
+	btcjson.RegisterCustomCmd("getchaintips", parseGetChainTipsCmd, nil,
+		`getchaintips
+Return information about all known tips in the block tree, including the
+main chain as well as orphaned branches.  Only the branches seen since btcd
+was started whose tips are no more than 2016 blocks behind the main chain are
+known.
+Result:
+[
+  {
+    "height": n,       (numeric) height of the chain tip
+    "hash": "xxxx",    (string) block hash of the tip
+    "branchlen": n,    (numeric) length of the branch connecting the tip
+                       to the main chain (0 for the main chain)
+    "status": "xxxx"   (string) status of the chain (active, valid-fork,
+                       valid-headers, invalid)
+  },
+  ...
+]`)
+	btcjson.RegisterCustomCmd("getmempoolentry", parseGetMempoolEntryCmd,
+		nil, `getmempoolentry "txid"
+Returns details about a single transaction in the memory pool.
+Arguments:
+1. "txid"       (string, required) the hash of the transaction
+Result:
+{
+  "size": n,              (numeric) transaction size in bytes
+  "fee": n,               (numeric) transaction fee in BTC
+  "time": n,              (numeric) local time the transaction entered the
+                          pool in seconds since epoch
+  "height": n,            (numeric) block height when the transaction entered
+                          the pool
+  "startingpriority": n,  (numeric) priority when the transaction entered the
+                          pool
+  "currentpriority": n,   (numeric) priority if the transaction were included
+                          in the next block
+  "depends": ["txid",...] (array) unconfirmed transactions in the pool it
+                          spends
+  "spentby": ["txid",...] (array) unconfirmed transactions in the pool
+                          spending it
+  "ancestorcount": n,     (numeric) number of in-pool ancestors (including
+                          this one)
+  "ancestorsize": n,      (numeric) size of in-pool ancestors (including this
+                          one)
+  "ancestorfees": n,      (numeric) fees of in-pool ancestors (including this
+                          one) in BTC
+  "descendantcount": n,   (numeric) number of in-pool descendants (including
+                          this one)
+  "descendantsize": n,    (numeric) size of in-pool descendants (including
+                          this one)
+  "descendantfees": n     (numeric) fees of in-pool descendants (including
+                          this one) in BTC
+}`)
+	btcjson.RegisterCustomCmd("invalidateblock", parseInvalidateBlockCmd,
+		nil, `invalidateblock "hash"
+Marks a block as invalid, as if it violated a consensus rule.  The block and
+all of its descendants are disconnected from the main chain and the best
+remaining chain becomes the main chain.  The mark is not persisted, so it is
+lost when btcd restarts, and known orphan blocks are dropped.
+Arguments:
+1. "hash"       (string, required) the hash of the block to mark as invalid
+Result:
+null`)
+	btcjson.RegisterCustomCmd("node", parseNodeCmd, nil,
+		`node "subcmd" "target"
+Manages the connection to a peer at runtime.  The add, remove, and onetry
+subcommands behave the same as the addnode command.  The disconnect subcommand
+drops the connection to a peer, which is reconnected when it was added.  The
+remove and disconnect subcommands also accept the id of a peer as reported by
+getpeerinfo.
+Arguments:
+1. "subcmd"     (string, required) add, remove, onetry, or disconnect
+2. "target"     (string, required) the address or id of the peer
+Result:
+null`)
+	btcjson.RegisterCustomCmd("notifytxremoved", parseNotifyTxRemovedCmd,
+		nil, `notifytxremoved
+Requests txremoved notifications for transactions which are removed from the
+memory pool for a reason other than being mined.  Only available on websocket
+connections.
+Result:
+null`)
+	btcjson.RegisterCustomCmd("txremoved", parseTxRemovedNtfn, nil,
+		`txremoved "txid" "reason"
+Notification sent to websocket clients registered via notifytxremoved when a
+transaction is removed from the memory pool for a reason other than being
+mined.  Transactions which depend on it are removed with the same reason.
+Parameters:
+1. "txid"       (string) the hash of the removed transaction
+2. "reason"     (string) the reason the transaction was removed (conflict,
+                evicted, reorg)`)
+	btcjson.RegisterCustomCmd("reconsiderblock", parseReconsiderBlockCmd,
+		nil, `reconsiderblock "hash"
+Removes invalidity status of a block and its descendants, reconsidering them
+for activation.  This can be used to undo the effects of invalidateblock.
+Arguments:
+1. "hash"       (string, required) the hash of the block to reconsider
+Result:
+null`)
+	btcjson.RegisterCustomCmd("searchrawtransactions",
+		parseSearchRawTransactionsCmd, nil,
+		`searchrawtransactions "address" ( verbose skip count )
+Returns the main chain transactions which fund or spend the passed address in
+the order they appear in the block chain.  Requires the address index to be
+enabled with --addrindex.
+Arguments:
+1. "address"    (string, required) the address to search for
+2. verbose      (numeric, optional, default=1) 0 for hex encoded data,
+                otherwise a json object for each transaction
+3. skip         (numeric, optional, default=0) the number of leading
+                transactions to skip
+4. count        (numeric, optional, default=100) the maximum number of
+                transactions to return, at most 10000
+Result (for verbose = 0):
+[
+  "data",       (string) serialized, hex-encoded transaction
+  ...
+]
+Result (for verbose = 1):
+[
+  {...},        (object) the same object getrawtransaction returns with
+                verbose = 1
+  ...
+]`)
+	btcjson.RegisterCustomCmd("testmempoolaccept", parseTestMempoolAcceptCmd,
+		nil, `testmempoolaccept ["rawtx",...]
+Returns whether or not each of the passed raw transactions would be accepted
+into the memory pool.  The transactions are tested independently of each
+other against the current contents of the pool, and are neither added to the
+pool nor relayed.
+Arguments:
+1. ["rawtx",...]  (array, required) serialized, hex-encoded transactions
+Result:
+[
+  {
+    "txid": "xxxx",          (string) the hash of the transaction
+    "allowed": true|false,   (boolean) whether or not the transaction would
+                             be accepted into the memory pool
+    "reject-reason": "xxxx"  (string) the reason the transaction would be
+                             rejected (only present when not allowed)
+  },
+  ...
+]`)
+	btcjson.RegisterCustomCmd("getmempoolinfo", parseGetMempoolInfoCmd, nil,
+		`getmempoolinfo
+Returns details on the active state of the transaction memory pool.
+Result:
+{
+  "size": n,        (numeric) current transaction count
+  "bytes": n,       (numeric) sum of all serialized transaction sizes
+  "maxmempool": n   (numeric) maximum size of the memory pool in bytes
+                    (0 when unlimited)
+}`)
+	btcjson.RegisterCustomCmd("getnetworkinfo", parseGetNetworkInfoCmd, nil,
+		`getnetworkinfo
+Returns information about the state of the peer-to-peer network.
+Result:
+{
+  "version": n,              (numeric) the server version
+  "subversion": "xxxx",      (string) the user agent advertised to peers
+  "protocolversion": n,      (numeric) the protocol version
+  "localservices": "xxxx",   (string) the services advertised to peers
+  "timeoffset": n,           (numeric) the offset in seconds applied to the
+                             local clock based on the time of the peers
+  "connections": n,          (numeric) the number of connected peers
+  "networks": [              (array) information per network
+    {
+      "name": "xxxx",        (string) network (ipv4, ipv6 or onion)
+      "limited": true|false, (boolean) whether connections to the network
+                             are limited with --onlynet or --noonion
+      "reachable": true|false, (boolean) whether the network is reachable
+      "proxy": "host:port"   (string) the proxy used for the network
+    },
+    ...
+  ],
+  "relayfee": x.xxxxxxxx     (numeric) minimum relay fee in BTC/kB for
+                             transactions to not be considered free
+  "startuptime": n           (numeric) the time the server process started
+                             in seconds since 1 Jan 1970 GMT
+}`)
+	btcjson.RegisterCustomCmd("getnodeaddresses", parseGetNodeAddressesCmd,
+		nil, `getnodeaddresses ( count )
+Returns randomly selected addresses known to the address manager which have
+been successfully connected to in the past.  Addresses which have only been
+heard about from other peers are never returned.
+Arguments:
+1. count        (numeric, optional, default=1) the maximum number of
+                addresses to return (0 for all of them)
+Result:
+[
+  {
+    "time": n,          (numeric) the time the address was last seen in
+                        seconds since 1 Jan 1970 GMT
+    "services": n,      (numeric) the services offered by the node
+    "address": "xxxx",  (string) the IP address or onion address of the node
+    "port": n           (numeric) the port of the node
+  },
+  ...
+]`)
+	btcjson.RegisterCustomCmd("setban", parseSetBanCmd, nil,
+		`setban "subnet" "add|remove" ( bantime absolute )
+Adds or removes a ban of an IP address or an IP network in CIDR notation.
+Connected peers which are covered by a new ban are disconnected unless they
+are whitelisted.  Bans are saved to disk so they survive a restart.
+Arguments:
+1. "subnet"     (string, required) the IP address (eg. 1.2.3.4) or network
+                (eg. 1.2.3.0/24) to ban or unban
+2. "command"    (string, required) add to add a ban or remove to remove one
+3. bantime      (numeric, optional) the number of seconds to ban for, or the
+                time the ban expires in seconds since epoch when absolute is
+                true (0 or omitted for the --banduration default)
+4. absolute     (boolean, optional, default=false) whether bantime is an
+                absolute time
+Result:
+null`)
+	btcjson.RegisterCustomCmd("listbanned", parseListBannedCmd, nil,
+		`listbanned
+Returns the banned IP networks.
+Result:
+[
+  {
+    "address": "xxxx",       (string) the banned IP network
+    "banned_until": ttt,     (numeric) when the ban expires in seconds since
+                             epoch
+    "ban_created": ttt       (numeric) when the ban was created in seconds
+                             since epoch
+  },
+  ...
+]`)
+	btcjson.RegisterCustomCmd("clearbanned", parseClearBannedCmd, nil,
+		`clearbanned
+Removes all bans.
+Result:
+null`)
+	btcjson.RegisterCustomCmd("rescanblocks", parseRescanBlocksCmd, nil,
+		`rescanblocks ["blockhash",...] ( ["address",...] [outpoint,...] )
+Rescans the passed main chain blocks for transactions which pay to the passed
+addresses or spend the passed outpoints.  Matching transactions are sent as
+recvtx and redeemingtx notifications along with the details of the block they
+are in while each block is rescanned.  The addresses and outpoints registered
+with notifyreceived and notifyspent are rescanned for as well.  Outputs paying
+to the addresses are added to the outpoints which are watched in later blocks.
+Only available on websocket connections.
+Arguments:
+1. ["blockhash",...]  (array, required) the hashes of the blocks to rescan in
+                      the order they should be rescanned
+2. ["address",...]    (array, optional) the addresses to rescan for
+3. [outpoint,...]     (array, optional) the outpoints to rescan for as
+                      objects of the form {"hash":"xxxx","index":n}
+Result:
+null`)
+	btcjson.RegisterCustomCmd("uptime", parseUptimeCmd, nil,
+		`uptime
+Returns the number of seconds the server has been running.
+Result:
+n  (numeric) the number of seconds since the server process started`)
+}
+
+// ClearBannedCmd is a type handling custom marshaling and unmarshaling of
+// clearbanned JSON-RPC commands.
+type ClearBannedCmd struct {
+	id interface{}
+}
+
+// Enforce that ClearBannedCmd satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &ClearBannedCmd{}
+
+// NewClearBannedCmd creates a new ClearBannedCmd.
+func NewClearBannedCmd(id interface{}) *ClearBannedCmd {
+	return &ClearBannedCmd{id: id}
+}
+
+// parseClearBannedCmd parses a RawCmd into a concrete type satisifying the
+// btcjson.Cmd interface.  This is used when registering the custom command
+// with the btcjson parser.
+func parseClearBannedCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) != 0 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+
+	return NewClearBannedCmd(r.Id), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *ClearBannedCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *ClearBannedCmd) Method() string {
+	return "clearbanned"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *ClearBannedCmd) MarshalJSON() ([]byte, error) {
+	raw, err := btcjson.NewRawCmd(cmd.id, cmd.Method(), []interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of the
+// Cmd interface.
+func (cmd *ClearBannedCmd) UnmarshalJSON(b []byte) error {
+	// Unmarshal into a RawCmd.
+	var r btcjson.RawCmd
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+
+	newCmd, err := parseClearBannedCmd(&r)
+	if err != nil {
+		return err
+	}
+
+	concreteCmd, ok := newCmd.(*ClearBannedCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// EstimateFeeCmd is a type handling custom marshaling and unmarshaling of
+// estimatefee JSON-RPC commands.
+type EstimateFeeCmd struct {
+	id        interface{}
+	NumBlocks int64
+}
+
+// Enforce that EstimateFeeCmd satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &EstimateFeeCmd{}
+
+// NewEstimateFeeCmd creates a new EstimateFeeCmd.
+func NewEstimateFeeCmd(id interface{}, numBlocks int64) *EstimateFeeCmd {
+	return &EstimateFeeCmd{
+		id:        id,
+		NumBlocks: numBlocks,
+	}
+}
+
+// parseEstimateFeeCmd parses a RawCmd into a concrete type satisifying the
+// btcjson.Cmd interface.  This is used when registering the custom command
+// with the btcjson parser.
+func parseEstimateFeeCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) != 1 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+
+	var numBlocks int64
+	if err := json.Unmarshal(r.Params[0], &numBlocks); err != nil {
+		return nil, fmt.Errorf("first parameter 'numblocks' must be "+
+			"an integer: %v", err)
+	}
+
+	return NewEstimateFeeCmd(r.Id, numBlocks), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *EstimateFeeCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *EstimateFeeCmd) Method() string {
+	return "estimatefee"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *EstimateFeeCmd) MarshalJSON() ([]byte, error) {
+	raw, err := btcjson.NewRawCmd(cmd.id, cmd.Method(),
+		[]interface{}{cmd.NumBlocks})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of the
+// Cmd interface.
+func (cmd *EstimateFeeCmd) UnmarshalJSON(b []byte) error {
+	// Unmarshal into a RawCmd.
+	var r btcjson.RawCmd
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+
+	newCmd, err := parseEstimateFeeCmd(&r)
+	if err != nil {
+		return err
+	}
+
+	concreteCmd, ok := newCmd.(*EstimateFeeCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// GenerateCmd is a type handling custom marshaling and unmarshaling of
+// generate JSON-RPC commands.
+type GenerateCmd struct {
+	id        interface{}
+	NumBlocks uint32
+	MaxTries  uint64
+}
+
+// Enforce that GenerateCmd satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &GenerateCmd{}
+
+// NewGenerateCmd creates a new GenerateCmd.  The maximum number of tries
+// defaults to 0, which means there is no limit, when it is not specified.
+func NewGenerateCmd(id interface{}, numBlocks uint32,
+	optArgs ...uint64) (*GenerateCmd, error) {
+
+	var maxTries uint64
+	if len(optArgs) > 0 {
+		if len(optArgs) > 1 {
+			return nil, btcjson.ErrTooManyOptArgs
+		}
+		maxTries = optArgs[0]
+	}
+
+	return &GenerateCmd{
+		id:        id,
+		NumBlocks: numBlocks,
+		MaxTries:  maxTries,
+	}, nil
+}
+
+// parseGenerateCmd parses a RawCmd into a concrete type satisifying the
+// btcjson.Cmd interface.  This is used when registering the custom command
+// with the btcjson parser.
+func parseGenerateCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) < 1 || len(r.Params) > 2 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+
+	var numBlocks uint32
+	if err := json.Unmarshal(r.Params[0], &numBlocks); err != nil {
+		return nil, fmt.Errorf("first parameter 'numblocks' must be "+
+			"a positive integer: %v", err)
+	}
+
+	optArgs := make([]uint64, 0, 1)
+	if len(r.Params) > 1 {
+		var maxTries uint64
+		if err := json.Unmarshal(r.Params[1], &maxTries); err != nil {
+			return nil, fmt.Errorf("second optional parameter "+
+				"'maxtries' must be a positive integer: %v", err)
+		}
+		optArgs = append(optArgs, maxTries)
+	}
+
+	return NewGenerateCmd(r.Id, numBlocks, optArgs...)
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *GenerateCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *GenerateCmd) Method() string {
+	return "generate"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *GenerateCmd) MarshalJSON() ([]byte, error) {
+	params := make([]interface{}, 1, 2)
+	params[0] = cmd.NumBlocks
+	if cmd.MaxTries != 0 {
+		params = append(params, cmd.MaxTries)
+	}
+
+	raw, err := btcjson.NewRawCmd(cmd.id, cmd.Method(), params)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of the
+// Cmd interface.
+func (cmd *GenerateCmd) UnmarshalJSON(b []byte) error {
+	// Unmarshal into a RawCmd.
+	var r btcjson.RawCmd
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+
+	newCmd, err := parseGenerateCmd(&r)
+	if err != nil {
+		return err
+	}
+
+	concreteCmd, ok := newCmd.(*GenerateCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// GetBlockChainInfoCmd is a type handling custom marshaling and unmarshaling of
+// getblockchaininfo JSON-RPC commands.
+type GetBlockChainInfoCmd struct {
+	id interface{}
+}
+
+// Enforce that GetBlockChainInfoCmd satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &GetBlockChainInfoCmd{}
+
+// NewGetBlockChainInfoCmd creates a new GetBlockChainInfoCmd.
+func NewGetBlockChainInfoCmd(id interface{}) *GetBlockChainInfoCmd {
+	return &GetBlockChainInfoCmd{id: id}
+}
+
+// parseGetBlockChainInfoCmd parses a RawCmd into a concrete type satisifying
+// the btcjson.Cmd interface.  This is used when registering the custom command
+// with the btcjson parser.
+func parseGetBlockChainInfoCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) != 0 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+
+	return NewGetBlockChainInfoCmd(r.Id), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *GetBlockChainInfoCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *GetBlockChainInfoCmd) Method() string {
+	return "getblockchaininfo"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *GetBlockChainInfoCmd) MarshalJSON() ([]byte, error) {
+	raw, err := btcjson.NewRawCmd(cmd.id, cmd.Method(), []interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of the
+// Cmd interface.
+func (cmd *GetBlockChainInfoCmd) UnmarshalJSON(b []byte) error {
+	// Unmarshal into a RawCmd.
+	var r btcjson.RawCmd
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+
+	newCmd, err := parseGetBlockChainInfoCmd(&r)
+	if err != nil {
+		return err
+	}
+
+	concreteCmd, ok := newCmd.(*GetBlockChainInfoCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// GetBlockChainInfoResult models the data returned from the getblockchaininfo
+// command.
+type GetBlockChainInfoResult struct {
+	Chain                string  `json:"chain"`
+	Blocks               int64   `json:"blocks"`
+	Headers              int64   `json:"headers"`
+	BestBlockHash        string  `json:"bestblockhash"`
+	Difficulty           float64 `json:"difficulty"`
+	MedianTime           int64   `json:"mediantime"`
+	VerificationProgress float64 `json:"verificationprogress"`
+	Pruned               bool    `json:"pruned"`
+}
+
+// PrevOut models the output spent by a transaction input of the decoded
+// transactions returned from the getblock command.
+type PrevOut struct {
+	Addresses []string `json:"addresses,omitempty"`
+	Value     float64  `json:"value"`
+}
+
+// VinPrevOut models a transaction input of the decoded transactions returned
+// from the getblock command along with the output it spends.
+type VinPrevOut struct {
+	Coinbase  string             `json:"coinbase"`
+	Txid      string             `json:"txid"`
+	Vout      uint32             `json:"vout"`
+	ScriptSig *btcjson.ScriptSig `json:"scriptSig"`
+	PrevOut   *PrevOut           `json:"prevOut"`
+	Sequence  uint32             `json:"sequence"`
+}
+
+// IsCoinBase returns whether or not the input is the input of a coinbase
+// transaction.
+func (v *VinPrevOut) IsCoinBase() bool {
+	return len(v.Coinbase) > 0
+}
+
+// MarshalJSON returns the JSON encoding of the input.  Only the fields which
+// apply to the type of the input are included.
+func (v *VinPrevOut) MarshalJSON() ([]byte, error) {
+	if v.IsCoinBase() {
+		coinbaseStruct := struct {
+			Coinbase string `json:"coinbase"`
+			Sequence uint32 `json:"sequence"`
+		}{
+			Coinbase: v.Coinbase,
+			Sequence: v.Sequence,
+		}
+		return json.Marshal(coinbaseStruct)
+	}
+
+	txStruct := struct {
+		Txid      string             `json:"txid"`
+		Vout      uint32             `json:"vout"`
+		ScriptSig *btcjson.ScriptSig `json:"scriptSig"`
+		PrevOut   *PrevOut           `json:"prevOut,omitempty"`
+		Sequence  uint32             `json:"sequence"`
+	}{
+		Txid:      v.Txid,
+		Vout:      v.Vout,
+		ScriptSig: v.ScriptSig,
+		PrevOut:   v.PrevOut,
+		Sequence:  v.Sequence,
+	}
+	return json.Marshal(txStruct)
+}
+
+// TxRawPrevOutResult models a decoded transaction returned from the getblock
+// command.  It extends the btcjson result with the outputs spent by its
+// inputs.
+type TxRawPrevOutResult struct {
+	*btcjson.TxRawResult
+	Vin []VinPrevOut `json:"vin"`
+}
+
+// GetBlockVerboseTxResult models the data returned from the getblock command
+// when the transactions of the block are decoded.  It extends the btcjson
+// result with the outputs spent by the inputs of the transactions.  The decoded
+// transactions are returned in the tx field instead of the rawtx field when
+// mirroring the quirks of Bitcoin Core.
+type GetBlockVerboseTxResult struct {
+	*btcjson.BlockResult
+	Tx    []TxRawPrevOutResult `json:"tx,omitempty"`
+	RawTx []TxRawPrevOutResult `json:"rawtx,omitempty"`
+}
+
+// GetBlockHeaderCmd is a type handling custom marshaling and unmarshaling of
+// getblockheader JSON-RPC commands.
+type GetBlockHeaderCmd struct {
+	id      interface{}
+	Hash    string
+	Verbose bool
+}
+
+// Enforce that GetBlockHeaderCmd satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &GetBlockHeaderCmd{}
+
+// NewGetBlockHeaderCmd creates a new GetBlockHeaderCmd.  The verbose flag
+// defaults to true when it is not specified.
+func NewGetBlockHeaderCmd(id interface{}, hash string,
+	optArgs ...bool) (*GetBlockHeaderCmd, error) {
+
+	verbose := true
+	if len(optArgs) > 0 {
+		if len(optArgs) > 1 {
+			return nil, btcjson.ErrTooManyOptArgs
+		}
+		verbose = optArgs[0]
+	}
+
+	return &GetBlockHeaderCmd{
+		id:      id,
+		Hash:    hash,
+		Verbose: verbose,
+	}, nil
+}
+
+// parseGetBlockHeaderCmd parses a RawCmd into a concrete type satisifying the
+// btcjson.Cmd interface.  This is used when registering the custom command
+// with the btcjson parser.
+func parseGetBlockHeaderCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) < 1 || len(r.Params) > 2 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+
+	var hash string
+	if err := json.Unmarshal(r.Params[0], &hash); err != nil {
+		return nil, fmt.Errorf("first parameter 'hash' must be a "+
+			"string: %v", err)
+	}
+
+	optArgs := make([]bool, 0, 1)
+	if len(r.Params) > 1 {
+		var verbose bool
+		if err := json.Unmarshal(r.Params[1], &verbose); err != nil {
+			return nil, fmt.Errorf("second optional parameter "+
+				"'verbose' must be a bool: %v", err)
+		}
+		optArgs = append(optArgs, verbose)
+	}
+
+	return NewGetBlockHeaderCmd(r.Id, hash, optArgs...)
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *GetBlockHeaderCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *GetBlockHeaderCmd) Method() string {
+	return "getblockheader"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *GetBlockHeaderCmd) MarshalJSON() ([]byte, error) {
+	params := make([]interface{}, 1, 2)
+	params[0] = cmd.Hash
+	if !cmd.Verbose {
+		params = append(params, cmd.Verbose)
+	}
+
+	raw, err := btcjson.NewRawCmd(cmd.id, cmd.Method(), params)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of the
+// Cmd interface.
+func (cmd *GetBlockHeaderCmd) UnmarshalJSON(b []byte) error {
+	// Unmarshal into a RawCmd.
+	var r btcjson.RawCmd
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+
+	newCmd, err := parseGetBlockHeaderCmd(&r)
+	if err != nil {
+		return err
+	}
+
+	concreteCmd, ok := newCmd.(*GetBlockHeaderCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// GetBlockVerbosityCmd is a type handling custom marshaling and unmarshaling
+// of getblock JSON-RPC commands with a verbosity level of 2, which decodes the
+// transactions of the block along with the outputs spent by their inputs.  The
+// other forms of the command are handled by btcjson.GetBlockCmd.
+type GetBlockVerbosityCmd struct {
+	*btcjson.GetBlockCmd
+}
+
+// Enforce that GetBlockVerbosityCmd satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &GetBlockVerbosityCmd{}
+
+// NewGetBlockVerbosityCmd creates a new GetBlockVerbosityCmd.
+func NewGetBlockVerbosityCmd(id interface{}, hash string) (*GetBlockVerbosityCmd, error) {
+	cmd, err := btcjson.NewGetBlockCmd(id, hash, true, true)
+	if err != nil {
+		return nil, err
+	}
+	return &GetBlockVerbosityCmd{GetBlockCmd: cmd}, nil
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *GetBlockVerbosityCmd) MarshalJSON() ([]byte, error) {
+	raw, err := btcjson.NewRawCmd(cmd.Id(), cmd.Method(),
+		[]interface{}{cmd.Hash, 2})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of the
+// Cmd interface.
+func (cmd *GetBlockVerbosityCmd) UnmarshalJSON(b []byte) error {
+	// Unmarshal into a RawCmd.
+	var r btcjson.RawCmd
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+
+	newCmd, err := parseGetBlockCmd(&r)
+	if err != nil {
+		return err
+	}
+
+	concreteCmd, ok := newCmd.(*GetBlockVerbosityCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// GetBlockHeaderVerboseResult models the data returned from the
+// getblockheader command when the verbose flag is set.  When the verbose flag
+// is not set, getblockheader returns a hex-encoded string.
+type GetBlockHeaderVerboseResult struct {
+	Hash          string  `json:"hash"`
+	Confirmations uint64  `json:"confirmations"`
+	Height        int64   `json:"height"`
+	Version       uint32  `json:"version"`
+	MerkleRoot    string  `json:"merkleroot"`
+	Time          int64   `json:"time"`
+	Nonce         uint32  `json:"nonce"`
+	Bits          string  `json:"bits"`
+	Difficulty    float64 `json:"difficulty"`
+	PreviousHash  string  `json:"previousblockhash,omitempty"`
+	NextHash      string  `json:"nextblockhash,omitempty"`
+}
+
+// GetBlockStatsCmd is a type handling custom marshaling and unmarshaling of
+// getblockstats JSON-RPC commands.  The block is identified by Hash when it is
+// not empty and by Height otherwise.
+type GetBlockStatsCmd struct {
+	id     interface{}
+	Hash   string
+	Height int64
+	Stats  []string
+}
+
+// Enforce that GetBlockStatsCmd satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &GetBlockStatsCmd{}
+
+// NewGetBlockStatsCmd creates a new GetBlockStatsCmd.  The passed hashOrHeight
+// must be either a string containing the block hash or an int64 block height.
+// All stats are returned when none are specified.
+func NewGetBlockStatsCmd(id interface{}, hashOrHeight interface{},
+	optArgs ...[]string) (*GetBlockStatsCmd, error) {
+
+	cmd := &GetBlockStatsCmd{id: id}
+	switch v := hashOrHeight.(type) {
+	case string:
+		cmd.Hash = v
+	case int64:
+		cmd.Height = v
+	default:
+		return nil, errors.New("hash_or_height must be a string or " +
+			"an integer")
+	}
+
+	if len(optArgs) > 0 {
+		if len(optArgs) > 1 {
+			return nil, btcjson.ErrTooManyOptArgs
+		}
+		cmd.Stats = optArgs[0]
+	}
+
+	return cmd, nil
+}
+
+// parseGetBlockStatsCmd parses a RawCmd into a concrete type satisifying the
+// btcjson.Cmd interface.  This is used when registering the custom command
+// with the btcjson parser.
+func parseGetBlockStatsCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) < 1 || len(r.Params) > 2 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+
+	var hashOrHeight interface{}
+	var hash string
+	if err := json.Unmarshal(r.Params[0], &hash); err == nil {
+		hashOrHeight = hash
+	} else {
+		var height int64
+		if err := json.Unmarshal(r.Params[0], &height); err != nil {
+			return nil, errors.New("first parameter " +
+				"'hash_or_height' must be a string or an integer")
+		}
+		hashOrHeight = height
+	}
+
+	optArgs := make([][]string, 0, 1)
+	if len(r.Params) > 1 {
+		var stats []string
+		if err := json.Unmarshal(r.Params[1], &stats); err != nil {
+			return nil, fmt.Errorf("second optional parameter "+
+				"'stats' must be an array of strings: %v", err)
+		}
+		optArgs = append(optArgs, stats)
+	}
+
+	return NewGetBlockStatsCmd(r.Id, hashOrHeight, optArgs...)
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *GetBlockStatsCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *GetBlockStatsCmd) Method() string {
+	return "getblockstats"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *GetBlockStatsCmd) MarshalJSON() ([]byte, error) {
+	params := make([]interface{}, 1, 2)
+	if cmd.Hash != "" {
+		params[0] = cmd.Hash
+	} else {
+		params[0] = cmd.Height
+	}
+	if len(cmd.Stats) > 0 {
+		params = append(params, cmd.Stats)
+	}
+
+	raw, err := btcjson.NewRawCmd(cmd.id, cmd.Method(), params)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of the
+// Cmd interface.
+func (cmd *GetBlockStatsCmd) UnmarshalJSON(b []byte) error {
+	// Unmarshal into a RawCmd.
+	var r btcjson.RawCmd
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+
+	newCmd, err := parseGetBlockStatsCmd(&r)
+	if err != nil {
+		return err
+	}
+
+	concreteCmd, ok := newCmd.(*GetBlockStatsCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// TemplateRequest models the optional request object of getblocktemplate
+// JSON-RPC commands as defined by BIP0022 and BIP0023.  Unlike the request
+// provided by btcjson, it includes the block data of proposals.
+type TemplateRequest struct {
+	Mode         string   `json:"mode,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+
+	// Data is the hex-encoded block to check in proposal mode.
+	Data string `json:"data,omitempty"`
+
+	// LongPollID identifies the block template the caller has when long
+	// polling for a new one.
+	LongPollID string `json:"longpollid,omitempty"`
+}
+
+// GetBlockTemplateCmd is a type handling custom marshaling and unmarshaling of
+// getblocktemplate JSON-RPC commands.  Since getblocktemplate is a standard
+// command known to btcjson, it is parsed by parseCmd rather than being
+// registered as a custom command.
+type GetBlockTemplateCmd struct {
+	id      interface{}
+	Request *TemplateRequest
+}
+
+// Enforce that GetBlockTemplateCmd satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &GetBlockTemplateCmd{}
+
+// NewGetBlockTemplateCmd creates a new GetBlockTemplateCmd.  The request may be
+// nil to request a block template.
+func NewGetBlockTemplateCmd(id interface{}, request *TemplateRequest) *GetBlockTemplateCmd {
+	return &GetBlockTemplateCmd{
+		id:      id,
+		Request: request,
+	}
+}
+
+// parseGetBlockTemplateCmd parses a RawCmd into a concrete type satisifying
+// the btcjson.Cmd interface.
+func parseGetBlockTemplateCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) > 1 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+
+	var request *TemplateRequest
+	if len(r.Params) > 0 {
+		if err := json.Unmarshal(r.Params[0], &request); err != nil {
+			return nil, fmt.Errorf("first optional parameter "+
+				"'request' must be an object: %v", err)
+		}
+	}
+
+	return NewGetBlockTemplateCmd(r.Id, request), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *GetBlockTemplateCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *GetBlockTemplateCmd) Method() string {
+	return "getblocktemplate"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *GetBlockTemplateCmd) MarshalJSON() ([]byte, error) {
+	params := make([]interface{}, 0, 1)
+	if cmd.Request != nil {
+		params = append(params, cmd.Request)
+	}
+	raw, err := btcjson.NewRawCmd(cmd.id, cmd.Method(), params)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of the
+// Cmd interface.
+func (cmd *GetBlockTemplateCmd) UnmarshalJSON(b []byte) error {
+	// Unmarshal into a RawCmd.
+	var r btcjson.RawCmd
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+
+	newCmd, err := parseGetBlockTemplateCmd(&r)
+	if err != nil {
+		return err
+	}
+
+	concreteCmd, ok := newCmd.(*GetBlockTemplateCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// GetBlockTemplateResultTx models a transaction of the block template returned
+// from the getblocktemplate command.
+type GetBlockTemplateResultTx struct {
+	Data    string  `json:"data"`
+	TxID    string  `json:"txid"`
+	Hash    string  `json:"hash"`
+	Depends []int64 `json:"depends"`
+	Fee     int64   `json:"fee"`
+	SigOps  int64   `json:"sigops"`
+}
+
+// GetBlockTemplateResult models the data returned from the getblocktemplate
+// command.
+type GetBlockTemplateResult struct {
+	Version       int32                      `json:"version"`
+	PreviousHash  string                     `json:"previousblockhash"`
+	Transactions  []GetBlockTemplateResultTx `json:"transactions"`
+	CoinbaseAux   map[string]string          `json:"coinbaseaux"`
+	CoinbaseValue int64                      `json:"coinbasevalue"`
+	Target        string                     `json:"target"`
+	MinTime       int64                      `json:"mintime"`
+	Mutable       []string                   `json:"mutable"`
+	NonceRange    string                     `json:"noncerange"`
+	SigOpLimit    int64                      `json:"sigoplimit"`
+	SizeLimit     int64                      `json:"sizelimit"`
+	CurTime       int64                      `json:"curtime"`
+	Bits          string                     `json:"bits"`
+	Height        int64                      `json:"height"`
+	LongPollID    string                     `json:"longpollid,omitempty"`
+}
+
+// GetCFilterCmd is a type handling custom marshaling and unmarshaling of
+// getcfilter JSON-RPC commands.
+type GetCFilterCmd struct {
+	id   interface{}
+	Hash string
+}
+
+// Enforce that GetCFilterCmd satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &GetCFilterCmd{}
+
+// NewGetCFilterCmd creates a new GetCFilterCmd.
+func NewGetCFilterCmd(id interface{}, hash string) *GetCFilterCmd {
+	return &GetCFilterCmd{
+		id:   id,
+		Hash: hash,
+	}
+}
+
+// parseGetCFilterCmd parses a RawCmd into a concrete type satisifying the
+// btcjson.Cmd interface.  This is used when registering the custom command
+// with the btcjson parser.
+func parseGetCFilterCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) != 1 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+
+	var hash string
+	if err := json.Unmarshal(r.Params[0], &hash); err != nil {
+		return nil, fmt.Errorf("first parameter 'hash' must be a "+
+			"string: %v", err)
+	}
+
+	return NewGetCFilterCmd(r.Id, hash), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *GetCFilterCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *GetCFilterCmd) Method() string {
+	return "getcfilter"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *GetCFilterCmd) MarshalJSON() ([]byte, error) {
+	raw, err := btcjson.NewRawCmd(cmd.id, cmd.Method(),
+		[]interface{}{cmd.Hash})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of the
+// Cmd interface.
+func (cmd *GetCFilterCmd) UnmarshalJSON(b []byte) error {
+	// Unmarshal into a RawCmd.
+	var r btcjson.RawCmd
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+
+	newCmd, err := parseGetCFilterCmd(&r)
+	if err != nil {
+		return err
+	}
+
+	concreteCmd, ok := newCmd.(*GetCFilterCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// GetCFilterResult models the data returned from the getcfilter command.
+type GetCFilterResult struct {
+	Filter string `json:"filter"`
+	Header string `json:"header"`
+}
+
+// GetChainTipsCmd is a type handling custom marshaling and unmarshaling of
+// getchaintips JSON-RPC commands.
+type GetChainTipsCmd struct {
+	id interface{}
+}
+
+// Enforce that GetChainTipsCmd satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &GetChainTipsCmd{}
+
+// NewGetChainTipsCmd creates a new GetChainTipsCmd.
+func NewGetChainTipsCmd(id interface{}) *GetChainTipsCmd {
+	return &GetChainTipsCmd{id: id}
+}
+
+// parseGetChainTipsCmd parses a RawCmd into a concrete type satisifying the
+// btcjson.Cmd interface.  This is used when registering the custom command
+// with the btcjson parser.
+func parseGetChainTipsCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) != 0 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+
+	return NewGetChainTipsCmd(r.Id), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *GetChainTipsCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *GetChainTipsCmd) Method() string {
+	return "getchaintips"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *GetChainTipsCmd) MarshalJSON() ([]byte, error) {
+	raw, err := btcjson.NewRawCmd(cmd.id, cmd.Method(), []interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of the
+// Cmd interface.
+func (cmd *GetChainTipsCmd) UnmarshalJSON(b []byte) error {
+	// Unmarshal into a RawCmd.
+	var r btcjson.RawCmd
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+
+	newCmd, err := parseGetChainTipsCmd(&r)
+	if err != nil {
+		return err
+	}
+
+	concreteCmd, ok := newCmd.(*GetChainTipsCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// GetChainTipsResult models the data of each entry returned from the
+// getchaintips command.
+type GetChainTipsResult struct {
+	Height    int64  `json:"height"`
+	Hash      string `json:"hash"`
+	BranchLen int64  `json:"branchlen"`
+	Status    string `json:"status"`
+}
+
+// GetMempoolEntryCmd is a type handling custom marshaling and unmarshaling of
+// getmempoolentry JSON-RPC commands.
+type GetMempoolEntryCmd struct {
+	id   interface{}
+	TxID string
+}
+
+// Enforce that GetMempoolEntryCmd satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &GetMempoolEntryCmd{}
+
+// NewGetMempoolEntryCmd creates a new GetMempoolEntryCmd.
+func NewGetMempoolEntryCmd(id interface{}, txID string) *GetMempoolEntryCmd {
+	return &GetMempoolEntryCmd{
+		id:   id,
+		TxID: txID,
+	}
+}
+
+// parseGetMempoolEntryCmd parses a RawCmd into a concrete type satisifying
+// the btcjson.Cmd interface.  This is used when registering the custom
+// command with the btcjson parser.
+func parseGetMempoolEntryCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) != 1 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+
+	var txID string
+	if err := json.Unmarshal(r.Params[0], &txID); err != nil {
+		return nil, fmt.Errorf("first parameter 'txid' must be a "+
+			"string: %v", err)
+	}
+
+	return NewGetMempoolEntryCmd(r.Id, txID), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *GetMempoolEntryCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *GetMempoolEntryCmd) Method() string {
+	return "getmempoolentry"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *GetMempoolEntryCmd) MarshalJSON() ([]byte, error) {
+	raw, err := btcjson.NewRawCmd(cmd.id, cmd.Method(),
+		[]interface{}{cmd.TxID})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of the
+// Cmd interface.
+func (cmd *GetMempoolEntryCmd) UnmarshalJSON(b []byte) error {
+	// Unmarshal into a RawCmd.
+	var r btcjson.RawCmd
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+
+	newCmd, err := parseGetMempoolEntryCmd(&r)
+	if err != nil {
+		return err
+	}
+
+	concreteCmd, ok := newCmd.(*GetMempoolEntryCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// GetMempoolEntryResult models the data returned from the getmempoolentry
+// command.
+type GetMempoolEntryResult struct {
+	Size             int      `json:"size"`
+	Fee              float64  `json:"fee"`
+	Time             int64    `json:"time"`
+	Height           int64    `json:"height"`
+	StartingPriority float64  `json:"startingpriority"`
+	CurrentPriority  float64  `json:"currentpriority"`
+	Depends          []string `json:"depends"`
+	SpentBy          []string `json:"spentby"`
+	AncestorCount    int      `json:"ancestorcount"`
+	AncestorSize     int64    `json:"ancestorsize"`
+	AncestorFees     float64  `json:"ancestorfees"`
+	DescendantCount  int      `json:"descendantcount"`
+	DescendantSize   int64    `json:"descendantsize"`
+	DescendantFees   float64  `json:"descendantfees"`
+}
+
+// GetMempoolInfoCmd is a type handling custom marshaling and unmarshaling of
+// getmempoolinfo JSON-RPC commands.
+type GetMempoolInfoCmd struct {
+	id interface{}
+}
+
+// Enforce that GetMempoolInfoCmd satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &GetMempoolInfoCmd{}
+
+// NewGetMempoolInfoCmd creates a new GetMempoolInfoCmd.
+func NewGetMempoolInfoCmd(id interface{}) *GetMempoolInfoCmd {
+	return &GetMempoolInfoCmd{id: id}
+}
+
+// parseGetMempoolInfoCmd parses a RawCmd into a concrete type satisifying the
+// btcjson.Cmd interface.  This is used when registering the custom command
+// with the btcjson parser.
+func parseGetMempoolInfoCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) != 0 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+
+	return NewGetMempoolInfoCmd(r.Id), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *GetMempoolInfoCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *GetMempoolInfoCmd) Method() string {
+	return "getmempoolinfo"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *GetMempoolInfoCmd) MarshalJSON() ([]byte, error) {
+	raw, err := btcjson.NewRawCmd(cmd.id, cmd.Method(), []interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of the
+// Cmd interface.
+func (cmd *GetMempoolInfoCmd) UnmarshalJSON(b []byte) error {
+	// Unmarshal into a RawCmd.
+	var r btcjson.RawCmd
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+
+	newCmd, err := parseGetMempoolInfoCmd(&r)
+	if err != nil {
+		return err
+	}
+
+	concreteCmd, ok := newCmd.(*GetMempoolInfoCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// GetMempoolInfoResult models the data returned from the getmempoolinfo
+// command.
+type GetMempoolInfoResult struct {
+	Size       int   `json:"size"`
+	Bytes      int64 `json:"bytes"`
+	MaxMempool int64 `json:"maxmempool"`
+}
+
+// GetNetworkInfoCmd is a type handling custom marshaling and unmarshaling of
+// getnetworkinfo JSON-RPC commands.
+type GetNetworkInfoCmd struct {
+	id interface{}
+}
+
+// Enforce that GetNetworkInfoCmd satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &GetNetworkInfoCmd{}
+
+// NewGetNetworkInfoCmd creates a new GetNetworkInfoCmd.
+func NewGetNetworkInfoCmd(id interface{}) *GetNetworkInfoCmd {
+	return &GetNetworkInfoCmd{id: id}
+}
+
+// parseGetNetworkInfoCmd parses a RawCmd into a concrete type satisifying the
+// btcjson.Cmd interface.  This is used when registering the custom command
+// with the btcjson parser.
+func parseGetNetworkInfoCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) != 0 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+
+	return NewGetNetworkInfoCmd(r.Id), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *GetNetworkInfoCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *GetNetworkInfoCmd) Method() string {
+	return "getnetworkinfo"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *GetNetworkInfoCmd) MarshalJSON() ([]byte, error) {
+	raw, err := btcjson.NewRawCmd(cmd.id, cmd.Method(), []interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of the
+// Cmd interface.
+func (cmd *GetNetworkInfoCmd) UnmarshalJSON(b []byte) error {
+	// Unmarshal into a RawCmd.
+	var r btcjson.RawCmd
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+
+	newCmd, err := parseGetNetworkInfoCmd(&r)
+	if err != nil {
+		return err
+	}
+
+	concreteCmd, ok := newCmd.(*GetNetworkInfoCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// GetMiningInfoResult models the data returned from the getmininginfo command.
+type GetMiningInfoResult struct {
+	Blocks           int64   `json:"blocks"`
+	CurrentBlockSize uint64  `json:"currentblocksize"`
+	CurrentBlockTx   uint64  `json:"currentblocktx"`
+	Difficulty       float64 `json:"difficulty"`
+	Errors           string  `json:"errors"`
+	Generate         bool    `json:"generate"`
+	GenProcLimit     int32   `json:"genproclimit"`
+	HashesPerSec     int64   `json:"hashespersec"`
+	NetworkHashPS    int64   `json:"networkhashps"`
+	PooledTx         uint64  `json:"pooledtx"`
+	TestNet          bool    `json:"testnet"`
+	Chain            string  `json:"chain"`
+}
+
+// NetworksResult models the networks data from the getnetworkinfo command.
+type NetworksResult struct {
+	Name      string `json:"name"`
+	Limited   bool   `json:"limited"`
+	Reachable bool   `json:"reachable"`
+	Proxy     string `json:"proxy"`
+}
+
+// UploadTargetResult models the upload target data returned from the
+// getnettotals command.
+type UploadTargetResult struct {
+	TimeFrame             int64  `json:"timeframe"`
+	Target                uint64 `json:"target"`
+	TargetReached         bool   `json:"target_reached"`
+	ServeHistoricalBlocks bool   `json:"serve_historical_blocks"`
+	BytesLeftInCycle      uint64 `json:"bytes_left_in_cycle"`
+	TimeLeftInCycle       int64  `json:"time_left_in_cycle"`
+}
+
+// GetNetTotalsResult models the data returned from the getnettotals command.
+// It extends the btcjson result with the bytes received and sent broken down
+// by message command and the state of the upload target.
+type GetNetTotalsResult struct {
+	*btcjson.GetNetTotalsResult
+	BytesRecvPerMsg map[string]uint64  `json:"bytesrecv_per_msg"`
+	BytesSentPerMsg map[string]uint64  `json:"bytessent_per_msg"`
+	UploadTarget    UploadTargetResult `json:"uploadtarget"`
+}
+
+// GetNetworkInfoResult models the data returned from the getnetworkinfo
+// command.
+type GetNetworkInfoResult struct {
+	Version         int32            `json:"version"`
+	SubVersion      string           `json:"subversion"`
+	ProtocolVersion int32            `json:"protocolversion"`
+	LocalServices   string           `json:"localservices"`
+	TimeOffset      int64            `json:"timeoffset"`
+	Connections     int32            `json:"connections"`
+	Networks        []NetworksResult `json:"networks"`
+	RelayFee        float64          `json:"relayfee"`
+	StartupTime     int64            `json:"startuptime"`
+}
+
+// GetNodeAddressesCmd is a type handling custom marshaling and unmarshaling of
+// getnodeaddresses JSON-RPC commands.
+type GetNodeAddressesCmd struct {
+	id    interface{}
+	Count int32
+}
+
+// Enforce that GetNodeAddressesCmd satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &GetNodeAddressesCmd{}
+
+// NewGetNodeAddressesCmd creates a new GetNodeAddressesCmd.  The count
+// defaults to 1 when it is not specified.
+func NewGetNodeAddressesCmd(id interface{},
+	optArgs ...int32) (*GetNodeAddressesCmd, error) {
+
+	count := int32(1)
+	if len(optArgs) > 0 {
+		if len(optArgs) > 1 {
+			return nil, btcjson.ErrTooManyOptArgs
+		}
+		count = optArgs[0]
+	}
+
+	return &GetNodeAddressesCmd{
+		id:    id,
+		Count: count,
+	}, nil
+}
+
+// parseGetNodeAddressesCmd parses a RawCmd into a concrete type satisifying
+// the btcjson.Cmd interface.  This is used when registering the custom command
+// with the btcjson parser.
+func parseGetNodeAddressesCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) > 1 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+
+	optArgs := make([]int32, 0, 1)
+	if len(r.Params) > 0 {
+		var count int32
+		if err := json.Unmarshal(r.Params[0], &count); err != nil {
+			return nil, fmt.Errorf("first optional parameter "+
+				"'count' must be an integer: %v", err)
+		}
+		optArgs = append(optArgs, count)
+	}
+
+	return NewGetNodeAddressesCmd(r.Id, optArgs...)
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *GetNodeAddressesCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *GetNodeAddressesCmd) Method() string {
+	return "getnodeaddresses"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *GetNodeAddressesCmd) MarshalJSON() ([]byte, error) {
+	params := make([]interface{}, 0, 1)
+	if cmd.Count != 1 {
+		params = append(params, cmd.Count)
+	}
+
+	raw, err := btcjson.NewRawCmd(cmd.id, cmd.Method(), params)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of the
+// Cmd interface.
+func (cmd *GetNodeAddressesCmd) UnmarshalJSON(b []byte) error {
+	// Unmarshal into a RawCmd.
+	var r btcjson.RawCmd
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+
+	newCmd, err := parseGetNodeAddressesCmd(&r)
+	if err != nil {
+		return err
+	}
+
+	concreteCmd, ok := newCmd.(*GetNodeAddressesCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// GetNodeAddressesResult models the data returned from the getnodeaddresses
+// command.
+type GetNodeAddressesResult struct {
+	Time     int64  `json:"time"`
+	Services uint64 `json:"services"`
+	Address  string `json:"address"`
+	Port     uint16 `json:"port"`
+}
+
+// GetPeerInfoResult models the data returned from the getpeerinfo command.  It
+// extends the btcjson result with the clock offset observed for the peer.
+type GetPeerInfoResult struct {
+	ID int32 `json:"id"`
+	*btcjson.GetPeerInfoResult
+	TimeOffset int64 `json:"timeoffset"`
+}
+
+// GetRawMempoolVerboseResult models the data returned from the getrawmempool
+// command when the verbose flag is set.  It extends the btcjson result with
+// the number and total size of the in-pool ancestors and descendants of the
+// transaction, including the transaction itself.
+type GetRawMempoolVerboseResult struct {
+	*btcjson.GetRawMempoolResult
+	AncestorCount   int   `json:"ancestorcount"`
+	AncestorSize    int64 `json:"ancestorsize"`
+	DescendantCount int   `json:"descendantcount"`
+	DescendantSize  int64 `json:"descendantsize"`
+}
+
+// GetTxOutResult models the data returned from the gettxout command.
+type GetTxOutResult struct {
+	BestBlock     string              `json:"bestblock"`
+	Confirmations int64               `json:"confirmations"`
+	Value         float64             `json:"value"`
+	ScriptPubKey  *ScriptPubKeyResult `json:"scriptPubKey"`
+	Version       uint32              `json:"version"`
+	Coinbase      bool                `json:"coinbase"`
+}
+
+// InvalidateBlockCmd is a type handling custom marshaling and unmarshaling of
+// invalidateblock JSON-RPC commands.
+type InvalidateBlockCmd struct {
+	id        interface{}
+	BlockHash string
+}
+
+// Enforce that InvalidateBlockCmd satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &InvalidateBlockCmd{}
+
+// NewInvalidateBlockCmd creates a new InvalidateBlockCmd.
+func NewInvalidateBlockCmd(id interface{}, hash string) *InvalidateBlockCmd {
+	return &InvalidateBlockCmd{
+		id:        id,
+		BlockHash: hash,
+	}
+}
+
+// parseInvalidateBlockCmd parses a RawCmd into a concrete type satisifying the
+// btcjson.Cmd interface.  This is used when registering the custom command
+// with the btcjson parser.
+func parseInvalidateBlockCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) != 1 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+
+	var hash string
+	if err := json.Unmarshal(r.Params[0], &hash); err != nil {
+		return nil, fmt.Errorf("first parameter 'hash' must be a "+
+			"string: %v", err)
+	}
+
+	return NewInvalidateBlockCmd(r.Id, hash), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *InvalidateBlockCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *InvalidateBlockCmd) Method() string {
+	return "invalidateblock"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *InvalidateBlockCmd) MarshalJSON() ([]byte, error) {
+	raw, err := btcjson.NewRawCmd(cmd.id, cmd.Method(),
+		[]interface{}{cmd.BlockHash})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of the
+// Cmd interface.
+func (cmd *InvalidateBlockCmd) UnmarshalJSON(b []byte) error {
+	// Unmarshal into a RawCmd.
+	var r btcjson.RawCmd
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+
+	newCmd, err := parseInvalidateBlockCmd(&r)
+	if err != nil {
+		return err
+	}
+
+	concreteCmd, ok := newCmd.(*InvalidateBlockCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// ListBannedCmd is a type handling custom marshaling and unmarshaling of
+// listbanned JSON-RPC commands.
+type ListBannedCmd struct {
+	id interface{}
+}
+
+// Enforce that ListBannedCmd satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &ListBannedCmd{}
+
+// NewListBannedCmd creates a new ListBannedCmd.
+func NewListBannedCmd(id interface{}) *ListBannedCmd {
+	return &ListBannedCmd{id: id}
+}
+
+// parseListBannedCmd parses a RawCmd into a concrete type satisifying the
+// btcjson.Cmd interface.  This is used when registering the custom command
+// with the btcjson parser.
+func parseListBannedCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) != 0 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+
+	return NewListBannedCmd(r.Id), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *ListBannedCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *ListBannedCmd) Method() string {
+	return "listbanned"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *ListBannedCmd) MarshalJSON() ([]byte, error) {
+	raw, err := btcjson.NewRawCmd(cmd.id, cmd.Method(), []interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of the
+// Cmd interface.
+func (cmd *ListBannedCmd) UnmarshalJSON(b []byte) error {
+	// Unmarshal into a RawCmd.
+	var r btcjson.RawCmd
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+
+	newCmd, err := parseListBannedCmd(&r)
+	if err != nil {
+		return err
+	}
+
+	concreteCmd, ok := newCmd.(*ListBannedCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// ListBannedResult models the data of each entry returned from the listbanned
+// command.
+type ListBannedResult struct {
+	Address     string `json:"address"`
+	BannedUntil int64  `json:"banned_until"`
+	BanCreated  int64  `json:"ban_created"`
+}
+
+// NodeCmd is a type handling custom marshaling and unmarshaling of node
+// JSON-RPC commands.
+type NodeCmd struct {
+	id     interface{}
+	SubCmd string
+	Target string
+}
+
+// Enforce that NodeCmd satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &NodeCmd{}
+
+// NewNodeCmd creates a new NodeCmd.
+func NewNodeCmd(id interface{}, subCmd, target string) *NodeCmd {
+	return &NodeCmd{
+		id:     id,
+		SubCmd: subCmd,
+		Target: target,
+	}
+}
+
+// parseNodeCmd parses a RawCmd into a concrete type satisifying the
+// btcjson.Cmd interface.  This is used when registering the custom command
+// with the btcjson parser.
+func parseNodeCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) != 2 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+
+	var subCmd string
+	if err := json.Unmarshal(r.Params[0], &subCmd); err != nil {
+		return nil, fmt.Errorf("first parameter 'subcmd' must be a "+
+			"string: %v", err)
+	}
+
+	var target string
+	if err := json.Unmarshal(r.Params[1], &target); err != nil {
+		return nil, fmt.Errorf("second parameter 'target' must be a "+
+			"string: %v", err)
+	}
+
+	return NewNodeCmd(r.Id, subCmd, target), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *NodeCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *NodeCmd) Method() string {
+	return "node"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *NodeCmd) MarshalJSON() ([]byte, error) {
+	raw, err := btcjson.NewRawCmd(cmd.id, cmd.Method(),
+		[]interface{}{cmd.SubCmd, cmd.Target})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of the
+// Cmd interface.
+func (cmd *NodeCmd) UnmarshalJSON(b []byte) error {
+	// Unmarshal into a RawCmd.
+	var r btcjson.RawCmd
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+
+	newCmd, err := parseNodeCmd(&r)
+	if err != nil {
+		return err
+	}
+
+	concreteCmd, ok := newCmd.(*NodeCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// NotifyBlocksCmd is a type handling custom marshaling and unmarshaling of
+// notifyblocks JSON-RPC commands.  It extends the btcws command with the level
+// of transaction detail to include in the block notifications.
+type NotifyBlocksCmd struct {
+	id       interface{}
+	TxDetail int
+}
+
+// Enforce that NotifyBlocksCmd satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &NotifyBlocksCmd{}
+
+// NewNotifyBlocksCmd creates a new NotifyBlocksCmd.
+func NewNotifyBlocksCmd(id interface{}, txDetail int) *NotifyBlocksCmd {
+	return &NotifyBlocksCmd{
+		id:       id,
+		TxDetail: txDetail,
+	}
+}
+
+// parseNotifyBlocksCmd parses a RawCmd into a concrete type satisifying the
+// btcjson.Cmd interface.  This is used in place of the btcws parser since it
+// does not support the optional txdetail parameter.
+func parseNotifyBlocksCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) > 1 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+
+	var txDetail int
+	if len(r.Params) > 0 {
+		err := json.Unmarshal(r.Params[0], &txDetail)
+		if err != nil || txDetail < blockNtfnNoTxs ||
+			txDetail > blockNtfnRawTxs {
+
+			return nil, errors.New("first optional parameter " +
+				"'txdetail' must be 0, 1, or 2")
+		}
+	}
+
+	return NewNotifyBlocksCmd(r.Id, txDetail), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *NotifyBlocksCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *NotifyBlocksCmd) Method() string {
+	return "notifyblocks"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *NotifyBlocksCmd) MarshalJSON() ([]byte, error) {
+	params := make([]interface{}, 0, 1)
+	if cmd.TxDetail != blockNtfnNoTxs {
+		params = append(params, cmd.TxDetail)
+	}
+	raw, err := btcjson.NewRawCmd(cmd.id, cmd.Method(), params)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of the
+// Cmd interface.
+func (cmd *NotifyBlocksCmd) UnmarshalJSON(b []byte) error {
+	// Unmarshal into a RawCmd.
+	var r btcjson.RawCmd
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+
+	newCmd, err := parseNotifyBlocksCmd(&r)
+	if err != nil {
+		return err
+	}
+
+	concreteCmd, ok := newCmd.(*NotifyBlocksCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// NotifyTxRemovedCmd is a type handling custom marshaling and unmarshaling of
+// notifytxremoved JSON-RPC commands.
+type NotifyTxRemovedCmd struct {
+	id interface{}
+}
+
+// Enforce that NotifyTxRemovedCmd satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &NotifyTxRemovedCmd{}
+
+// NewNotifyTxRemovedCmd creates a new NotifyTxRemovedCmd.
+func NewNotifyTxRemovedCmd(id interface{}) *NotifyTxRemovedCmd {
+	return &NotifyTxRemovedCmd{id: id}
+}
+
+// parseNotifyTxRemovedCmd parses a RawCmd into a concrete type satisifying the
+// btcjson.Cmd interface.  This is used when registering the custom command
+// with the btcjson parser.
+func parseNotifyTxRemovedCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) != 0 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+
+	return NewNotifyTxRemovedCmd(r.Id), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *NotifyTxRemovedCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *NotifyTxRemovedCmd) Method() string {
+	return "notifytxremoved"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *NotifyTxRemovedCmd) MarshalJSON() ([]byte, error) {
+	raw, err := btcjson.NewRawCmd(cmd.id, cmd.Method(), []interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of the
+// Cmd interface.
+func (cmd *NotifyTxRemovedCmd) UnmarshalJSON(b []byte) error {
+	// Unmarshal into a RawCmd.
+	var r btcjson.RawCmd
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+
+	newCmd, err := parseNotifyTxRemovedCmd(&r)
+	if err != nil {
+		return err
+	}
+
+	concreteCmd, ok := newCmd.(*NotifyTxRemovedCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// ReconsiderBlockCmd is a type handling custom marshaling and unmarshaling of
+// reconsiderblock JSON-RPC commands.
+type ReconsiderBlockCmd struct {
+	id        interface{}
+	BlockHash string
+}
+
+// Enforce that ReconsiderBlockCmd satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &ReconsiderBlockCmd{}
+
+// NewReconsiderBlockCmd creates a new ReconsiderBlockCmd.
+func NewReconsiderBlockCmd(id interface{}, hash string) *ReconsiderBlockCmd {
+	return &ReconsiderBlockCmd{
+		id:        id,
+		BlockHash: hash,
+	}
+}
+
+// parseReconsiderBlockCmd parses a RawCmd into a concrete type satisifying the
+// btcjson.Cmd interface.  This is used when registering the custom command
+// with the btcjson parser.
+func parseReconsiderBlockCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) != 1 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+
+	var hash string
+	if err := json.Unmarshal(r.Params[0], &hash); err != nil {
+		return nil, fmt.Errorf("first parameter 'hash' must be a "+
+			"string: %v", err)
+	}
+
+	return NewReconsiderBlockCmd(r.Id, hash), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *ReconsiderBlockCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *ReconsiderBlockCmd) Method() string {
+	return "reconsiderblock"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *ReconsiderBlockCmd) MarshalJSON() ([]byte, error) {
+	raw, err := btcjson.NewRawCmd(cmd.id, cmd.Method(),
+		[]interface{}{cmd.BlockHash})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of the
+// Cmd interface.
+func (cmd *ReconsiderBlockCmd) UnmarshalJSON(b []byte) error {
+	// Unmarshal into a RawCmd.
+	var r btcjson.RawCmd
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+
+	newCmd, err := parseReconsiderBlockCmd(&r)
+	if err != nil {
+		return err
+	}
+
+	concreteCmd, ok := newCmd.(*ReconsiderBlockCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// RescanBlocksCmd is a type handling custom marshaling and unmarshaling of
+// rescanblocks JSON-RPC commands.
+type RescanBlocksCmd struct {
+	id          interface{}
+	BlockHashes []string
+	Addresses   []string
+	OutPoints   []btcws.OutPoint
+}
+
+// Enforce that RescanBlocksCmd satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &RescanBlocksCmd{}
+
+// NewRescanBlocksCmd creates a new RescanBlocksCmd.
+func NewRescanBlocksCmd(id interface{}, blockHashes, addresses []string,
+	outPoints []btcws.OutPoint) *RescanBlocksCmd {
+
+	return &RescanBlocksCmd{
+		id:          id,
+		BlockHashes: blockHashes,
+		Addresses:   addresses,
+		OutPoints:   outPoints,
+	}
+}
+
+// parseRescanBlocksCmd parses a RawCmd into a concrete type satisifying the
+// btcjson.Cmd interface.  This is used when registering the custom command
+// with the btcjson parser.
+func parseRescanBlocksCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) < 1 || len(r.Params) > 3 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+
+	var blockHashes []string
+	if err := json.Unmarshal(r.Params[0], &blockHashes); err != nil {
+		return nil, fmt.Errorf("first parameter 'blockhashes' must be "+
+			"an array of strings: %v", err)
+	}
+
+	var addresses []string
+	if len(r.Params) > 1 {
+		if err := json.Unmarshal(r.Params[1], &addresses); err != nil {
+			return nil, fmt.Errorf("second optional parameter "+
+				"'addresses' must be an array of strings: %v",
+				err)
+		}
+	}
+
+	var outPoints []btcws.OutPoint
+	if len(r.Params) > 2 {
+		if err := json.Unmarshal(r.Params[2], &outPoints); err != nil {
+			return nil, fmt.Errorf("third optional parameter "+
+				"'outpoints' must be an array of outpoint "+
+				"objects: %v", err)
+		}
+	}
+
+	return NewRescanBlocksCmd(r.Id, blockHashes, addresses, outPoints), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *RescanBlocksCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *RescanBlocksCmd) Method() string {
+	return "rescanblocks"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *RescanBlocksCmd) MarshalJSON() ([]byte, error) {
+	params := []interface{}{cmd.BlockHashes}
+	if len(cmd.Addresses) > 0 || len(cmd.OutPoints) > 0 {
+		params = append(params, cmd.Addresses)
+	}
+	if len(cmd.OutPoints) > 0 {
+		params = append(params, cmd.OutPoints)
+	}
+	raw, err := btcjson.NewRawCmd(cmd.id, cmd.Method(), params)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of the
+// Cmd interface.
+func (cmd *RescanBlocksCmd) UnmarshalJSON(b []byte) error {
+	// Unmarshal into a RawCmd.
+	var r btcjson.RawCmd
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+
+	newCmd, err := parseRescanBlocksCmd(&r)
+	if err != nil {
+		return err
+	}
+
+	concreteCmd, ok := newCmd.(*RescanBlocksCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// ScriptPubKeyResult models the public key script of a transaction output in
+// the data returned from the gettxout command.
+type ScriptPubKeyResult struct {
+	Asm       string   `json:"asm"`
+	Hex       string   `json:"hex"`
+	ReqSigs   int      `json:"reqSigs,omitempty"`
+	Type      string   `json:"type"`
+	Addresses []string `json:"addresses,omitempty"`
+}
+
+// SearchRawTransactionsCmd is a type handling custom marshaling and
+// unmarshaling of searchrawtransactions JSON-RPC commands.
+type SearchRawTransactionsCmd struct {
+	id      interface{}
+	Address string
+	Verbose int
+	Skip    int
+	Count   int
+}
+
+// Enforce that SearchRawTransactionsCmd satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &SearchRawTransactionsCmd{}
+
+// NewSearchRawTransactionsCmd creates a new SearchRawTransactionsCmd.  The
+// optional arguments are verbose, skip, and count in that order, and they
+// default to 1, 0, and 100 respectively when they are not specified.
+func NewSearchRawTransactionsCmd(id interface{}, address string,
+	optArgs ...int) (*SearchRawTransactionsCmd, error) {
+
+	if len(optArgs) > 3 {
+		return nil, btcjson.ErrTooManyOptArgs
+	}
+
+	cmd := &SearchRawTransactionsCmd{
+		id:      id,
+		Address: address,
+		Verbose: 1,
+		Skip:    0,
+		Count:   100,
+	}
+	if len(optArgs) > 0 {
+		cmd.Verbose = optArgs[0]
+	}
+	if len(optArgs) > 1 {
+		cmd.Skip = optArgs[1]
+	}
+	if len(optArgs) > 2 {
+		cmd.Count = optArgs[2]
+	}
+	return cmd, nil
+}
+
+// parseSearchRawTransactionsCmd parses a RawCmd into a concrete type
+// satisifying the btcjson.Cmd interface.  This is used when registering the
+// custom command with the btcjson parser.
+func parseSearchRawTransactionsCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) < 1 || len(r.Params) > 4 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+
+	var address string
+	if err := json.Unmarshal(r.Params[0], &address); err != nil {
+		return nil, fmt.Errorf("first parameter 'address' must be a "+
+			"string: %v", err)
+	}
+
+	names := []string{"verbose", "skip", "count"}
+	optArgs := make([]int, 0, len(names))
+	for i, param := range r.Params[1:] {
+		var arg int
+		if err := json.Unmarshal(param, &arg); err != nil {
+			return nil, fmt.Errorf("optional parameter '%s' must "+
+				"be an integer: %v", names[i], err)
+		}
+		optArgs = append(optArgs, arg)
+	}
+
+	return NewSearchRawTransactionsCmd(r.Id, address, optArgs...)
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *SearchRawTransactionsCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *SearchRawTransactionsCmd) Method() string {
+	return "searchrawtransactions"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *SearchRawTransactionsCmd) MarshalJSON() ([]byte, error) {
+	params := []interface{}{cmd.Address, cmd.Verbose, cmd.Skip, cmd.Count}
+	raw, err := btcjson.NewRawCmd(cmd.id, cmd.Method(), params)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of the
+// Cmd interface.
+func (cmd *SearchRawTransactionsCmd) UnmarshalJSON(b []byte) error {
+	// Unmarshal into a RawCmd.
+	var r btcjson.RawCmd
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+
+	newCmd, err := parseSearchRawTransactionsCmd(&r)
+	if err != nil {
+		return err
+	}
+
+	concreteCmd, ok := newCmd.(*SearchRawTransactionsCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// SetBanCmd is a type handling custom marshaling and unmarshaling of setban
+// JSON-RPC commands.
+type SetBanCmd struct {
+	id       interface{}
+	Subnet   string
+	SubCmd   string
+	BanTime  int64
+	Absolute bool
+}
+
+// Enforce that SetBanCmd satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &SetBanCmd{}
+
+// NewSetBanCmd creates a new SetBanCmd.  A ban time of 0 means the default ban
+// duration is used.
+func NewSetBanCmd(id interface{}, subnet, subCmd string, banTime int64,
+	absolute bool) *SetBanCmd {
+
+	return &SetBanCmd{
+		id:       id,
+		Subnet:   subnet,
+		SubCmd:   subCmd,
+		BanTime:  banTime,
+		Absolute: absolute,
+	}
+}
+
+// parseSetBanCmd parses a RawCmd into a concrete type satisifying the
+// btcjson.Cmd interface.  This is used when registering the custom command
+// with the btcjson parser.
+func parseSetBanCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) < 2 || len(r.Params) > 4 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+
+	var subnet string
+	if err := json.Unmarshal(r.Params[0], &subnet); err != nil {
+		return nil, fmt.Errorf("first parameter 'subnet' must be a "+
+			"string: %v", err)
+	}
+
+	var subCmd string
+	if err := json.Unmarshal(r.Params[1], &subCmd); err != nil {
+		return nil, fmt.Errorf("second parameter 'command' must be a "+
+			"string: %v", err)
+	}
+
+	var banTime int64
+	if len(r.Params) > 2 {
+		if err := json.Unmarshal(r.Params[2], &banTime); err != nil {
+			return nil, fmt.Errorf("third optional parameter "+
+				"'bantime' must be an integer: %v", err)
+		}
+	}
+
+	var absolute bool
+	if len(r.Params) > 3 {
+		if err := json.Unmarshal(r.Params[3], &absolute); err != nil {
+			return nil, fmt.Errorf("fourth optional parameter "+
+				"'absolute' must be a bool: %v", err)
+		}
+	}
+
+	return NewSetBanCmd(r.Id, subnet, subCmd, banTime, absolute), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *SetBanCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *SetBanCmd) Method() string {
+	return "setban"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *SetBanCmd) MarshalJSON() ([]byte, error) {
+	params := []interface{}{cmd.Subnet, cmd.SubCmd}
+	if cmd.BanTime != 0 || cmd.Absolute {
+		params = append(params, cmd.BanTime)
+	}
+	if cmd.Absolute {
+		params = append(params, cmd.Absolute)
+	}
+	raw, err := btcjson.NewRawCmd(cmd.id, cmd.Method(), params)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of the
+// Cmd interface.
+func (cmd *SetBanCmd) UnmarshalJSON(b []byte) error {
+	// Unmarshal into a RawCmd.
+	var r btcjson.RawCmd
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+
+	newCmd, err := parseSetBanCmd(&r)
+	if err != nil {
+		return err
+	}
+
+	concreteCmd, ok := newCmd.(*SetBanCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// TestMempoolAcceptCmd is a type handling custom marshaling and unmarshaling
+// of testmempoolaccept JSON-RPC commands.
+type TestMempoolAcceptCmd struct {
+	id     interface{}
+	RawTxs []string
+}
+
+// Enforce that TestMempoolAcceptCmd satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &TestMempoolAcceptCmd{}
+
+// NewTestMempoolAcceptCmd creates a new TestMempoolAcceptCmd.
+func NewTestMempoolAcceptCmd(id interface{}, rawTxs []string) *TestMempoolAcceptCmd {
+	return &TestMempoolAcceptCmd{
+		id:     id,
+		RawTxs: rawTxs,
+	}
+}
+
+// parseTestMempoolAcceptCmd parses a RawCmd into a concrete type satisifying
+// the btcjson.Cmd interface.  This is used when registering the custom command
+// with the btcjson parser.
+func parseTestMempoolAcceptCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) != 1 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+
+	var rawTxs []string
+	if err := json.Unmarshal(r.Params[0], &rawTxs); err != nil {
+		return nil, fmt.Errorf("first parameter 'rawtxs' must be an "+
+			"array of strings: %v", err)
+	}
+
+	return NewTestMempoolAcceptCmd(r.Id, rawTxs), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *TestMempoolAcceptCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *TestMempoolAcceptCmd) Method() string {
+	return "testmempoolaccept"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *TestMempoolAcceptCmd) MarshalJSON() ([]byte, error) {
+	raw, err := btcjson.NewRawCmd(cmd.id, cmd.Method(),
+		[]interface{}{cmd.RawTxs})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of the
+// Cmd interface.
+func (cmd *TestMempoolAcceptCmd) UnmarshalJSON(b []byte) error {
+	// Unmarshal into a RawCmd.
+	var r btcjson.RawCmd
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+
+	newCmd, err := parseTestMempoolAcceptCmd(&r)
+	if err != nil {
+		return err
+	}
+
+	concreteCmd, ok := newCmd.(*TestMempoolAcceptCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// TestMempoolAcceptResult models the data of each entry returned from the
+// testmempoolaccept command.
+type TestMempoolAcceptResult struct {
+	TxID         string `json:"txid"`
+	Allowed      bool   `json:"allowed"`
+	RejectReason string `json:"reject-reason,omitempty"`
+}
+
+// TxRemovedNtfn is a type handling custom marshaling and unmarshaling of
+// txremoved JSON-RPC notifications.  Notifications don't have an id.
+type TxRemovedNtfn struct {
+	TxID   string
+	Reason string
+}
+
+// Enforce that TxRemovedNtfn satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &TxRemovedNtfn{}
+
+// NewTxRemovedNtfn creates a new TxRemovedNtfn.
+func NewTxRemovedNtfn(txID, reason string) *TxRemovedNtfn {
+	return &TxRemovedNtfn{
+		TxID:   txID,
+		Reason: reason,
+	}
+}
+
+// parseTxRemovedNtfn parses a RawCmd into a concrete type satisifying the
+// btcjson.Cmd interface.  This is used when registering the custom command
+// with the btcjson parser.
+func parseTxRemovedNtfn(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if r.Id != nil {
+		return nil, errors.New("notifications must not have an id")
+	}
+	if len(r.Params) != 2 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+
+	var txID string
+	if err := json.Unmarshal(r.Params[0], &txID); err != nil {
+		return nil, fmt.Errorf("first parameter 'txid' must be a "+
+			"string: %v", err)
+	}
+
+	var reason string
+	if err := json.Unmarshal(r.Params[1], &reason); err != nil {
+		return nil, fmt.Errorf("second parameter 'reason' must be a "+
+			"string: %v", err)
+	}
+
+	return NewTxRemovedNtfn(txID, reason), nil
+}
+
+// Id satisifies the Cmd interface by returning nil since notifications don't
+// have an id.
+func (n *TxRemovedNtfn) Id() interface{} {
+	return nil
+}
+
+// Method satisfies the Cmd interface by returning the method of the
+// notification.
+func (n *TxRemovedNtfn) Method() string {
+	return "txremoved"
+}
+
+// MarshalJSON returns the JSON encoding of n.  Part of the Cmd interface.
+func (n *TxRemovedNtfn) MarshalJSON() ([]byte, error) {
+	raw, err := btcjson.NewRawCmd(nil, n.Method(),
+		[]interface{}{n.TxID, n.Reason})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of n into n.  Part of the Cmd
+// interface.
+func (n *TxRemovedNtfn) UnmarshalJSON(b []byte) error {
+	// Unmarshal into a RawCmd.
+	var r btcjson.RawCmd
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+
+	newNtfn, err := parseTxRemovedNtfn(&r)
+	if err != nil {
+		return err
+	}
+
+	concreteNtfn, ok := newNtfn.(*TxRemovedNtfn)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*n = *concreteNtfn
+	return nil
+}
+
+// UptimeCmd is a type handling custom marshaling and unmarshaling of uptime
+// JSON-RPC commands.
+type UptimeCmd struct {
+	id interface{}
+}
+
+// Enforce that UptimeCmd satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &UptimeCmd{}
+
+// NewUptimeCmd creates a new UptimeCmd.
+func NewUptimeCmd(id interface{}) *UptimeCmd {
+	return &UptimeCmd{id: id}
+}
+
+// parseUptimeCmd parses a RawCmd into a concrete type satisifying the
+// btcjson.Cmd interface.  This is used when registering the custom command
+// with the btcjson parser.
+func parseUptimeCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) != 0 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+
+	return NewUptimeCmd(r.Id), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *UptimeCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *UptimeCmd) Method() string {
+	return "uptime"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *UptimeCmd) MarshalJSON() ([]byte, error) {
+	raw, err := btcjson.NewRawCmd(cmd.id, cmd.Method(), []interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of the
+// Cmd interface.
+func (cmd *UptimeCmd) UnmarshalJSON(b []byte) error {
+	// Unmarshal into a RawCmd.
+	var r btcjson.RawCmd
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+
+	newCmd, err := parseUptimeCmd(&r)
+	if err != nil {
+		return err
+	}
+
+	concreteCmd, ok := newCmd.(*UptimeCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
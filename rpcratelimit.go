@@ -0,0 +1,100 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"github.com/conformal/btcjson"
+	"math"
+	"sync"
+	"time"
+)
+
+// errRateLimited is the error returned to clients which exceed the rate limit
+// for a command.  The connection is left open so the client may retry later.
+var errRateLimited = btcjson.Error{
+	Code:    -32000,
+	Message: "rate limited -- too many requests for this method",
+}
+
+// rpcRateLimitKey identifies the token bucket a request is charged against.
+type rpcRateLimitKey struct {
+	isAdmin bool
+	method  string
+}
+
+// tokenBucket tracks the tokens available to a single user and command.
+type tokenBucket struct {
+	tokens     float64
+	lastRefill time.Time
+}
+
+// rpcRateLimiter limits the rate RPC commands may be called at using a token
+// bucket for each authenticated user and command.  Buckets refill at the
+// configured rate (--rpcratelimit) and hold up to a second worth of tokens, or
+// a single token for rates below one request per second, so short bursts are
+// allowed while the long term rate is bounded.
+//
+// Commands which are not known to the server all share a single bucket per
+// user so the number of buckets is bounded regardless of the method names
+// clients send.  The admin user is not exempt since a misbehaving admin
+// client, such as a runaway script, starves the node just the same.
+type rpcRateLimiter struct {
+	sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[rpcRateLimitKey]*tokenBucket
+}
+
+// newRPCRateLimiter returns a new rate limiter which allows rate requests per
+// second for each user and command.  A rate of 0 disables rate limiting.
+func newRPCRateLimiter(rate float64) *rpcRateLimiter {
+	return &rpcRateLimiter{
+		rate:    rate,
+		burst:   math.Max(rate, 1),
+		buckets: make(map[rpcRateLimitKey]*tokenBucket),
+	}
+}
+
+// Allow returns whether a request for the passed command by the admin or the
+// limited user, depending on isAdmin, is within the rate limit and charges it
+// against the bucket for the user and command when it is.
+//
+// This function is safe for concurrent access.
+func (l *rpcRateLimiter) Allow(isAdmin bool, method string) bool {
+	if l.rate == 0 {
+		return true
+	}
+
+	_, isRPCCmd := rpcHandlers[method]
+	_, isWsCmd := wsHandlers[method]
+	if !isRPCCmd && !isWsCmd {
+		method = ""
+	}
+	key := rpcRateLimitKey{isAdmin: isAdmin, method: method}
+
+	l.Lock()
+	defer l.Unlock()
+
+	now := time.Now()
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, lastRefill: now}
+		l.buckets[key] = bucket
+	}
+
+	// Refill the bucket for the time elapsed since it was last refilled.
+	// The bucket is not refilled when the clock went backwards.
+	if elapsed := now.Sub(bucket.lastRefill).Seconds(); elapsed > 0 {
+		bucket.tokens = math.Min(bucket.tokens+elapsed*l.rate,
+			l.burst)
+	}
+	bucket.lastRefill = now
+
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// drainBucket makes requests for the passed command until the rate limiter
+// rejects one and returns the number of requests that were allowed.  It gives
+// up after the passed maximum number of requests.
+func drainBucket(l *rpcRateLimiter, isAdmin bool, method string, max int) int {
+	for i := 0; i < max; i++ {
+		if !l.Allow(isAdmin, method) {
+			return i
+		}
+	}
+	return max
+}
+
+// TestRPCRateLimiterBurst ensures buckets hold a second worth of requests, or a
+// single request for rates below one per second, and that a rate of 0 disables
+// the limit.
+func TestRPCRateLimiterBurst(t *testing.T) {
+	tests := []struct {
+		rate float64
+		want int
+	}{
+		{0, 100},
+		{0.5, 1},
+		{1, 1},
+		{10, 10},
+		{2.5, 2},
+	}
+
+	for _, test := range tests {
+		l := newRPCRateLimiter(test.rate)
+		got := drainBucket(l, true, "getblock", 100)
+		if got != test.want {
+			t.Errorf("rate %v: unexpected burst - got %d, want %d",
+				test.rate, got, test.want)
+		}
+	}
+}
+
+// TestRPCRateLimiterBuckets ensures each user and known command has its own
+// bucket while unknown commands share a single bucket per user.
+func TestRPCRateLimiterBuckets(t *testing.T) {
+	l := newRPCRateLimiter(1)
+	if !l.Allow(true, "getblock") {
+		t.Fatalf("first admin getblock request rejected")
+	}
+	if l.Allow(true, "getblock") {
+		t.Errorf("second admin getblock request allowed")
+	}
+	if !l.Allow(false, "getblock") {
+		t.Errorf("limited user getblock request charged to the admin")
+	}
+	if !l.Allow(true, "getblockcount") {
+		t.Errorf("getblockcount request charged to getblock")
+	}
+
+	if !l.Allow(true, "nosuchmethod") {
+		t.Fatalf("first unknown method request rejected")
+	}
+	if l.Allow(true, "othermethod") {
+		t.Errorf("unknown methods do not share a bucket")
+	}
+	if !l.Allow(false, "othermethod") {
+		t.Errorf("limited user unknown method request charged to the " +
+			"admin")
+	}
+	if len(l.buckets) != 5 {
+		t.Errorf("unexpected number of buckets - got %d, want %d",
+			len(l.buckets), 5)
+	}
+}
+
+// TestRPCRateLimiterRefill ensures buckets refill at the configured rate up to
+// the burst size and are not refilled when the clock goes backwards.
+func TestRPCRateLimiterRefill(t *testing.T) {
+	l := newRPCRateLimiter(10)
+	key := rpcRateLimitKey{isAdmin: true, method: "getblock"}
+	drainBucket(l, true, "getblock", 100)
+
+	// Half a second refills five tokens.
+	l.buckets[key].lastRefill = time.Now().Add(-500 * time.Millisecond)
+	if got := drainBucket(l, true, "getblock", 100); got != 5 {
+		t.Errorf("unexpected requests after half a second - got %d, "+
+			"want %d", got, 5)
+	}
+
+	// A long idle period refills no more than the burst size.
+	l.buckets[key].lastRefill = time.Now().Add(-time.Hour)
+	if got := drainBucket(l, true, "getblock", 100); got != 10 {
+		t.Errorf("unexpected requests after an hour - got %d, want %d",
+			got, 10)
+	}
+
+	// A clock moving backwards does not refill the bucket.
+	l.buckets[key].lastRefill = time.Now().Add(time.Hour)
+	if l.Allow(true, "getblock") {
+		t.Errorf("request allowed after the clock moved backwards")
+	}
+}
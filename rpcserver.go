@@ -11,6 +11,7 @@ import (
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/conformal/btcchain"
@@ -23,13 +24,17 @@ import (
 	"github.com/conformal/btcws"
 	"github.com/conformal/fastsha256"
 	"github.com/conformal/websocket"
+	"io"
 	"io/ioutil"
 	"math/big"
 	"math/rand"
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -41,6 +46,17 @@ const (
 	// is closed.
 	rpcAuthTimeoutSeconds = 10
 
+	// unixSocketPrefix is the prefix of RPC listen addresses which specify
+	// the path of a Unix domain socket rather than a TCP address.
+	unixSocketPrefix = "unix:"
+
+	// rpcCookieFilename is the name of the file in the data directory the
+	// RPC authentication cookie is written to when --rpccookie is set.
+	rpcCookieFilename = ".cookie"
+
+	// rpcCookieUser is the username of the RPC authentication cookie.
+	rpcCookieUser = "__cookie__"
+
 	// uint256Size is the number of bytes needed to represent an unsigned
 	// 256-bit integer.
 	uint256Size = 32
@@ -61,6 +77,42 @@ const (
 	// padding format.
 	hash1Len = (1 + ((btcwire.HashSize + 8) / fastsha256.BlockSize)) *
 		fastsha256.BlockSize
+
+	// gbtRegenerateInterval is the minimum amount of time between
+	// regenerating the block template returned by getblocktemplate when
+	// only the transactions in the memory pool have changed.
+	gbtRegenerateInterval = time.Minute
+
+	// maxPrevOutLookups is the maximum number of transactions which are
+	// loaded from the database to decode the outputs spent by the inputs
+	// of the transactions of a block for getblock.
+	maxPrevOutLookups = 10000
+
+	// maxSearchRawTxCount is the maximum number of transactions which may
+	// be requested from searchrawtransactions at once.
+	maxSearchRawTxCount = 10000
+
+	// gbtLongPollTimeout is the maximum amount of time a getblocktemplate
+	// long poll waits for the best block to change before returning the
+	// current block template.
+	gbtLongPollTimeout = time.Minute * 2
+
+	// gbtNonceRange is the range of the nonce field of the block header
+	// which may be modified by callers of getblocktemplate.
+	gbtNonceRange = "00000000ffffffff"
+)
+
+var (
+	// gbtMutableFields are the fields of the block template returned by
+	// getblocktemplate which callers may modify.
+	gbtMutableFields = []string{"time", "transactions/add", "prevblock"}
+
+	// gbtCoinbaseAux describes the data which must be included in the
+	// signature script of the coinbase of blocks built from a template
+	// returned by getblocktemplate.
+	gbtCoinbaseAux = map[string]string{
+		"flags": hex.EncodeToString([]byte(coinbaseFlags)),
+	}
 )
 
 // Errors
@@ -70,50 +122,135 @@ var (
 	ErrBadParamsField = errors.New("bad params field")
 )
 
-type commandHandler func(*rpcServer, btcjson.Cmd) (interface{}, error)
+// commandHandler describes the handler functions of RPC commands.  The passed
+// channel receives a value or is closed when the client which issued the
+// command disconnects, which allows long running commands to stop early.
+type commandHandler func(*rpcServer, btcjson.Cmd, <-chan bool) (interface{}, error)
 
 // handlers maps RPC command strings to appropriate handler functions.
 // this is copied by init because help references rpcHandlers and thus causes
 // a dependancy loop.
 var rpcHandlers map[string]commandHandler
 var rpcHandlersBeforeInit = map[string]commandHandler{
-	"addnode":              handleAddNode,
-	"createrawtransaction": handleCreateRawTransaction,
-	"debuglevel":           handleDebugLevel,
-	"decoderawtransaction": handleDecodeRawTransaction,
-	"decodescript":         handleDecodeScript,
-	"getaddednodeinfo":     handleGetAddedNodeInfo,
-	"getbestblock":         handleGetBestBlock,
-	"getbestblockhash":     handleGetBestBlockHash,
-	"getblock":             handleGetBlock,
-	"getblockcount":        handleGetBlockCount,
-	"getblockhash":         handleGetBlockHash,
-	"getconnectioncount":   handleGetConnectionCount,
-	"getcurrentnet":        handleGetCurrentNet,
-	"getdifficulty":        handleGetDifficulty,
-	"getgenerate":          handleGetGenerate,
-	"gethashespersec":      handleGetHashesPerSec,
-	"getinfo":              handleGetInfo,
-	"getmininginfo":        handleGetMiningInfo,
-	"getnettotals":         handleGetNetTotals,
-	"getnetworkhashps":     handleGetNetworkHashPS,
-	"getpeerinfo":          handleGetPeerInfo,
-	"getrawmempool":        handleGetRawMempool,
-	"getrawtransaction":    handleGetRawTransaction,
-	"getwork":              handleGetWork,
-	"help":                 handleHelp,
-	"ping":                 handlePing,
-	"sendrawtransaction":   handleSendRawTransaction,
-	"setgenerate":          handleSetGenerate,
-	"stop":                 handleStop,
-	"submitblock":          handleSubmitBlock,
-	"verifychain":          handleVerifyChain,
+	"addnode":               handleAddNode,
+	"clearbanned":           handleClearBanned,
+	"createrawtransaction":  handleCreateRawTransaction,
+	"debuglevel":            handleDebugLevel,
+	"decoderawtransaction":  handleDecodeRawTransaction,
+	"decodescript":          handleDecodeScript,
+	"estimatefee":           handleEstimateFee,
+	"generate":              handleGenerate,
+	"getaddednodeinfo":      handleGetAddedNodeInfo,
+	"getbestblock":          handleGetBestBlock,
+	"getbestblockhash":      handleGetBestBlockHash,
+	"getblock":              handleGetBlock,
+	"getblockchaininfo":     handleGetBlockChainInfo,
+	"getblockcount":         handleGetBlockCount,
+	"getblockhash":          handleGetBlockHash,
+	"getblockheader":        handleGetBlockHeader,
+	"getblocktemplate":      handleGetBlockTemplate,
+	"getblockstats":         handleGetBlockStats,
+	"getcfilter":            handleGetCFilter,
+	"getchaintips":          handleGetChainTips,
+	"getconnectioncount":    handleGetConnectionCount,
+	"getcurrentnet":         handleGetCurrentNet,
+	"getdifficulty":         handleGetDifficulty,
+	"getgenerate":           handleGetGenerate,
+	"gethashespersec":       handleGetHashesPerSec,
+	"getinfo":               handleGetInfo,
+	"getmempoolentry":       handleGetMempoolEntry,
+	"getmempoolinfo":        handleGetMempoolInfo,
+	"getmininginfo":         handleGetMiningInfo,
+	"getnettotals":          handleGetNetTotals,
+	"getnetworkhashps":      handleGetNetworkHashPS,
+	"getnetworkinfo":        handleGetNetworkInfo,
+	"getnodeaddresses":      handleGetNodeAddresses,
+	"getpeerinfo":           handleGetPeerInfo,
+	"getrawmempool":         handleGetRawMempool,
+	"getrawtransaction":     handleGetRawTransaction,
+	"gettxout":              handleGetTxOut,
+	"getwork":               handleGetWork,
+	"help":                  handleHelp,
+	"invalidateblock":       handleInvalidateBlock,
+	"listbanned":            handleListBanned,
+	"node":                  handleNode,
+	"ping":                  handlePing,
+	"reconsiderblock":       handleReconsiderBlock,
+	"searchrawtransactions": handleSearchRawTransactions,
+	"sendrawtransaction":    handleSendRawTransaction,
+	"setban":                handleSetBan,
+	"setgenerate":           handleSetGenerate,
+	"stop":                  handleStop,
+	"submitblock":           handleSubmitBlock,
+	"testmempoolaccept":     handleTestMempoolAccept,
+	"uptime":                handleUptime,
+	"verifychain":           handleVerifyChain,
 }
 
 func init() {
 	rpcHandlers = rpcHandlersBeforeInit
 }
 
+// rpcLimited is the set of commands a limited user is allowed to call.  They
+// only query the state of the server and are therefore safe to expose to
+// untrusted clients such as block explorers.
+var rpcLimited = map[string]struct{}{
+	// Websocket commands
+	"notifyblocks":          struct{}{},
+	"notifynewtransactions": struct{}{},
+	"notifyreceived":        struct{}{},
+	"notifyspent":           struct{}{},
+	"notifytxremoved":       struct{}{},
+	"rescan":                struct{}{},
+	"rescanblocks":          struct{}{},
+
+	// Standard commands
+	"createrawtransaction":  struct{}{},
+	"decoderawtransaction":  struct{}{},
+	"decodescript":          struct{}{},
+	"estimatefee":           struct{}{},
+	"getbestblock":          struct{}{},
+	"getbestblockhash":      struct{}{},
+	"getblock":              struct{}{},
+	"getblockchaininfo":     struct{}{},
+	"getblockcount":         struct{}{},
+	"getblockhash":          struct{}{},
+	"getblockheader":        struct{}{},
+	"getblockstats":         struct{}{},
+	"getcfilter":            struct{}{},
+	"getchaintips":          struct{}{},
+	"getcurrentnet":         struct{}{},
+	"getdifficulty":         struct{}{},
+	"getinfo":               struct{}{},
+	"getmempoolentry":       struct{}{},
+	"getmempoolinfo":        struct{}{},
+	"getmininginfo":         struct{}{},
+	"getnettotals":          struct{}{},
+	"getnetworkhashps":      struct{}{},
+	"getnetworkinfo":        struct{}{},
+	"getrawmempool":         struct{}{},
+	"getrawtransaction":     struct{}{},
+	"gettxout":              struct{}{},
+	"help":                  struct{}{},
+	"searchrawtransactions": struct{}{},
+	"testmempoolaccept":     struct{}{},
+	"uptime":                struct{}{},
+}
+
+// errLimitedUser is the error returned to limited users which attempt to call
+// a command that is not available to them.
+var errLimitedUser = btcjson.Error{
+	Code:    btcjson.ErrMethodNotFound.Code,
+	Message: "limited user not authorized for this method",
+}
+
+// isLimitedCmd returns whether or not the passed command may be called by a
+// limited user.
+func isLimitedCmd(method string) bool {
+	_, ok := rpcLimited[method]
+	return ok
+}
+
 // list of commands that we recognise, but for which btcd has no support because
 // it lacks support for wallet functionality. For these commands the user
 // should ask a connected instance of btcwallet.
@@ -129,13 +266,11 @@ var rpcAskWallet = map[string]bool{
 	"getaccountaddress":      true,
 	"getaddressesbyaccount":  true,
 	"getbalance":             true,
-	"getblocktemplate":       true,
 	"getnewaddress":          true,
 	"getrawchangeaddress":    true,
 	"getreceivedbyaccount":   true,
 	"getreceivedbyaddress":   true,
 	"gettransaction":         true,
-	"gettxout":               true,
 	"gettxoutsetinfo":        true,
 	"importprivkey":          true,
 	"importwallet":           true,
@@ -194,6 +329,67 @@ func newWorkState() *workState {
 	}
 }
 
+// gbtWorkState houses state that is used in between multiple RPC invocations to
+// getblocktemplate.
+type gbtWorkState struct {
+	sync.Mutex
+	lastTxUpdate  time.Time
+	lastGenerated time.Time
+	prevHash      *btcwire.ShaHash
+	template      *BlockTemplate
+
+	// newTipChan is closed and replaced when the best block changes to
+	// wake up all long polls waiting on it.  It is protected by its own
+	// mutex rather than the state lock since the state lock is held while
+	// generating templates, which waits on the block handler goroutine that
+	// notifies about new tips.
+	tipMtx     sync.Mutex
+	newTipChan chan struct{}
+}
+
+// newGbtWorkState returns a new instance of a gbtWorkState ready to use.
+func newGbtWorkState() *gbtWorkState {
+	return &gbtWorkState{
+		newTipChan: make(chan struct{}),
+	}
+}
+
+// NotifyNewTip wakes up all getblocktemplate long polls which are waiting for
+// the best block to change.
+//
+// This function is safe for concurrent access.
+func (state *gbtWorkState) NotifyNewTip() {
+	state.tipMtx.Lock()
+	defer state.tipMtx.Unlock()
+
+	close(state.newTipChan)
+	state.newTipChan = make(chan struct{})
+}
+
+// encodeLongPollID returns the long poll id which identifies the block template
+// generated against the passed previous block at the passed time.
+func encodeLongPollID(prevHash *btcwire.ShaHash, lastGenerated time.Time) string {
+	return fmt.Sprintf("%s-%d", prevHash, lastGenerated.Unix())
+}
+
+// decodeLongPollID decodes the previous block hash and generation time of the
+// block template identified by the passed long poll id.
+func decodeLongPollID(longPollID string) (*btcwire.ShaHash, int64, error) {
+	fields := strings.Split(longPollID, "-")
+	if len(fields) != 2 {
+		return nil, 0, fmt.Errorf("invalid longpollid %q", longPollID)
+	}
+	prevHash, err := btcwire.NewShaHashFromStr(fields[0])
+	if err != nil {
+		return nil, 0, err
+	}
+	lastGenerated, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil, 0, err
+	}
+	return prevHash, lastGenerated, nil
+}
+
 // rpcServer holds the items the rpc server may need to access (config,
 // shutdown, main server, etc.)
 type rpcServer struct {
@@ -201,12 +397,16 @@ type rpcServer struct {
 	shutdown        int32
 	server          *server
 	authsha         [fastsha256.Size]byte
+	limitauthsha    [fastsha256.Size]byte
+	cookiePath      string
 	ntfnMgr         *wsNotificationManager
 	numClients      int
 	numClientsMutex sync.Mutex
 	wg              sync.WaitGroup
 	listeners       []net.Listener
 	workState       *workState
+	gbtWorkState    *gbtWorkState
+	rateLimiter     *rpcRateLimiter
 	quit            chan int
 }
 
@@ -238,16 +438,17 @@ func (s *rpcServer) Start() {
 		// Keep track of the number of connected clients.
 		s.incrementClients()
 		defer s.decrementClients()
-		if _, err := s.checkAuth(r, true); err != nil {
+		_, isAdmin, err := s.checkAuth(r, true)
+		if err != nil {
 			jsonAuthFail(w, r, s)
 			return
 		}
-		jsonRPCRead(w, r, s)
+		jsonRPCRead(w, r, isAdmin, s)
 	})
 
 	// Websocket endpoint.
 	rpcServeMux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		authenticated, err := s.checkAuth(r, false)
+		authenticated, isAdmin, err := s.checkAuth(r, false)
 		if err != nil {
 			http.Error(w, "401 Unauthorized.", http.StatusUnauthorized)
 			return
@@ -263,7 +464,7 @@ func (s *rpcServer) Start() {
 			}
 			return
 		}
-		s.WebsocketHandler(ws, r.RemoteAddr, authenticated)
+		s.WebsocketHandler(ws, r.RemoteAddr, authenticated, isAdmin)
 	})
 
 	for _, listener := range s.listeners {
@@ -328,25 +529,49 @@ func (s *rpcServer) decrementClients() {
 // returned.
 //
 // This check is time-constant.
-func (s *rpcServer) checkAuth(r *http.Request, require bool) (bool, error) {
+//
+// The first bool return value signifies auth success (true if successful) and
+// the second bool return value specifies whether the user can change the state
+// of the server (true) or whether the user is limited (false).  The second is
+// always false if the first is false.
+func (s *rpcServer) checkAuth(r *http.Request, require bool) (bool, bool, error) {
 	authhdr := r.Header["Authorization"]
 	if len(authhdr) <= 0 {
 		if require {
 			rpcsLog.Warnf("RPC authentication failure from %s",
 				r.RemoteAddr)
-			return false, errors.New("auth failure")
+			return false, false, errors.New("auth failure")
 		}
 
-		return false, nil
+		return false, false, nil
 	}
 
 	authsha := fastsha256.Sum256([]byte(authhdr[0]))
-	cmp := subtle.ConstantTimeCompare(authsha[:], s.authsha[:])
-	if cmp != 1 {
+	authenticated, isAdmin := s.authenticate(authsha)
+	if !authenticated {
 		rpcsLog.Warnf("RPC authentication failure from %s", r.RemoteAddr)
-		return false, errors.New("auth failure")
+		return false, false, errors.New("auth failure")
 	}
-	return true, nil
+	return true, isAdmin, nil
+}
+
+// authenticate compares the passed hash of an HTTP Basic authorization header
+// against the expected values for both the admin and limited users.  The first
+// return value specifies whether the hash matches either of them and the
+// second whether it matches the admin user.
+//
+// Both comparisons are always performed and are time-constant.
+func (s *rpcServer) authenticate(authsha [fastsha256.Size]byte) (bool, bool) {
+	limitcmp := subtle.ConstantTimeCompare(authsha[:], s.limitauthsha[:])
+	cmp := subtle.ConstantTimeCompare(authsha[:], s.authsha[:])
+	if cmp == 1 && (s.cookiePath != "" ||
+		(cfg.RPCUser != "" && cfg.RPCPass != "")) {
+		return true, true
+	}
+	if limitcmp == 1 && cfg.RPCLimitUser != "" && cfg.RPCLimitPass != "" {
+		return true, false
+	}
+	return false, false
 }
 
 // Stop is used by server.go to stop the rpc listener.
@@ -367,6 +592,15 @@ func (s *rpcServer) Stop() error {
 	s.ntfnMgr.WaitForShutdown()
 	close(s.quit)
 	s.wg.Wait()
+
+	// Remove the authentication cookie so it can't be used once the server
+	// is no longer running.  A new one is generated on the next start.
+	if s.cookiePath != "" {
+		if err := os.Remove(s.cookiePath); err != nil {
+			rpcsLog.Errorf("Unable to remove RPC cookie file: %v",
+				err)
+		}
+	}
 	rpcsLog.Infof("RPC server shutdown complete")
 	return nil
 }
@@ -395,43 +629,88 @@ func genCertPair(certFile, keyFile string) error {
 	return nil
 }
 
+// genCookie generates a new random RPC authentication cookie and writes it to
+// the passed path in the form __cookie__:<password> so it can be read by other
+// processes running as the same user.  The login is returned.
+func genCookie(cookiePath string) (string, error) {
+	var buf [32]byte
+	for i := 0; i < len(buf); i += 8 {
+		n, err := btcwire.RandomUint64()
+		if err != nil {
+			return "", err
+		}
+		binary.LittleEndian.PutUint64(buf[i:], n)
+	}
+	login := rpcCookieUser + ":" + hex.EncodeToString(buf[:])
+
+	if err := ioutil.WriteFile(cookiePath, []byte(login), 0600); err != nil {
+		return "", err
+	}
+	return login, nil
+}
+
 // newRPCServer returns a new instance of the rpcServer struct.
 func newRPCServer(listenAddrs []string, s *server) (*rpcServer, error) {
 	login := cfg.RPCUser + ":" + cfg.RPCPass
 	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte(login))
+	limitLogin := cfg.RPCLimitUser + ":" + cfg.RPCLimitPass
+	limitAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte(limitLogin))
 	rpc := rpcServer{
-		authsha:   fastsha256.Sum256([]byte(auth)),
-		server:    s,
-		workState: newWorkState(),
-		quit:      make(chan int),
+		authsha:      fastsha256.Sum256([]byte(auth)),
+		limitauthsha: fastsha256.Sum256([]byte(limitAuth)),
+		server:       s,
+		workState:    newWorkState(),
+		gbtWorkState: newGbtWorkState(),
+		rateLimiter:  newRPCRateLimiter(cfg.RPCRateLimit),
+		quit:         make(chan int),
 	}
 	rpc.ntfnMgr = newWsNotificationManager(&rpc)
 
-	// check for existence of cert file and key file
-	if !fileExists(cfg.RPCKey) && !fileExists(cfg.RPCCert) {
-		// if both files do not exist, we generate them.
-		err := genCertPair(cfg.RPCCert, cfg.RPCKey)
+	// Use the certificate and key provided as PEM data when configured.
+	// Otherwise, load them from the configured files.
+	var keypair tls.Certificate
+	if cfg.rpcKeyPair != nil {
+		keypair = *cfg.rpcKeyPair
+	} else {
+		// check for existence of cert file and key file
+		if !fileExists(cfg.RPCKey) && !fileExists(cfg.RPCCert) {
+			// if both files do not exist, we generate them.
+			err := genCertPair(cfg.RPCCert, cfg.RPCKey)
+			if err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		keypair, err = tls.LoadX509KeyPair(cfg.RPCCert, cfg.RPCKey)
 		if err != nil {
 			return nil, err
 		}
 	}
-	keypair, err := tls.LoadX509KeyPair(cfg.RPCCert, cfg.RPCKey)
-	if err != nil {
-		return nil, err
-	}
 
 	tlsConfig := tls.Config{
 		Certificates: []tls.Certificate{keypair},
 	}
 
+	// Separate the Unix domain socket listeners from the TCP listeners.
+	tcpListenAddrs := make([]string, 0, len(listenAddrs))
+	unixListenAddrs := make([]string, 0, len(listenAddrs))
+	for _, addr := range listenAddrs {
+		if strings.HasPrefix(addr, unixSocketPrefix) {
+			path := strings.TrimPrefix(addr, unixSocketPrefix)
+			unixListenAddrs = append(unixListenAddrs, path)
+			continue
+		}
+		tcpListenAddrs = append(tcpListenAddrs, addr)
+	}
+
 	// TODO(oga) this code is similar to that in server, should be
 	// factored into something shared.
-	ipv4ListenAddrs, ipv6ListenAddrs, _, err := parseListeners(listenAddrs)
+	ipv4ListenAddrs, ipv6ListenAddrs, _, err := parseListeners(tcpListenAddrs)
 	if err != nil {
 		return nil, err
 	}
-	listeners := make([]net.Listener, 0,
-		len(ipv6ListenAddrs)+len(ipv4ListenAddrs))
+	listeners := make([]net.Listener, 0, len(ipv6ListenAddrs)+
+		len(ipv4ListenAddrs)+len(unixListenAddrs))
 	for _, addr := range ipv4ListenAddrs {
 		listener, err := tls.Listen("tcp4", addr, &tlsConfig)
 		if err != nil {
@@ -439,7 +718,7 @@ func newRPCServer(listenAddrs []string, s *server) (*rpcServer, error) {
 				err)
 			continue
 		}
-		listeners = append(listeners, listener)
+		listeners = append(listeners, newAllowIPListener(listener))
 	}
 
 	for _, addr := range ipv6ListenAddrs {
@@ -449,6 +728,18 @@ func newRPCServer(listenAddrs []string, s *server) (*rpcServer, error) {
 				err)
 			continue
 		}
+		listeners = append(listeners, newAllowIPListener(listener))
+	}
+
+	// Connections over Unix domain sockets are not wrapped with TLS since
+	// access to them is controlled by the filesystem permissions of the
+	// socket.  The socket file is removed when the listener is closed.
+	for _, path := range unixListenAddrs {
+		listener, err := listenUnix(path)
+		if err != nil {
+			rpcsLog.Warnf("Can't listen on %s: %v", path, err)
+			continue
+		}
 		listeners = append(listeners, listener)
 	}
 	if len(listeners) == 0 {
@@ -457,27 +748,128 @@ func newRPCServer(listenAddrs []string, s *server) (*rpcServer, error) {
 
 	rpc.listeners = listeners
 
+	// Generate a new authentication cookie for the admin user when no
+	// username and password have been configured for it.  This is done
+	// last so the cookie file is not left behind when the server can't be
+	// created.
+	if cfg.RPCCookie {
+		cookiePath := filepath.Join(cfg.DataDir, rpcCookieFilename)
+		login, err := genCookie(cookiePath)
+		if err != nil {
+			for _, listener := range listeners {
+				listener.Close()
+			}
+			return nil, err
+		}
+		auth := "Basic " + base64.StdEncoding.EncodeToString([]byte(login))
+		rpc.authsha = fastsha256.Sum256([]byte(auth))
+		rpc.cookiePath = cookiePath
+		rpcsLog.Infof("Wrote RPC authentication cookie to %s",
+			cookiePath)
+	}
+
 	return &rpc, nil
 }
 
+// allowIPListener wraps a TCP listener to drop connections from remote
+// addresses which are not allowed to connect to the RPC server as configured
+// via --rpcallowip.  Connections are dropped as soon as they are accepted, so
+// before the TLS handshake and any authentication takes place.
+type allowIPListener struct {
+	net.Listener
+}
+
+// newAllowIPListener returns the passed listener wrapped so it only accepts
+// connections from allowed remote addresses.  The listener is returned as is
+// when no restrictions are configured.
+func newAllowIPListener(listener net.Listener) net.Listener {
+	if len(cfg.rpcAllowIPs) == 0 {
+		return listener
+	}
+	return &allowIPListener{Listener: listener}
+}
+
+// isAllowedRPCHost returns whether or not the passed remote host is allowed to
+// connect to the RPC server.  Loopback addresses are always allowed.
+func isAllowedRPCHost(host string) bool {
+	// Strip the zone identifier of IPv6 link-local addresses since it is
+	// not part of the IP itself.
+	if zoneIdx := strings.LastIndex(host, "%"); zoneIdx > 0 {
+		host = host[:zoneIdx]
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	if ip.IsLoopback() {
+		return true
+	}
+	for _, ipnet := range cfg.rpcAllowIPs {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Accept waits for and returns the next connection from an allowed remote
+// address.  Connections from other addresses are closed.  Part of the
+// net.Listener interface.
+func (l *allowIPListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+		if err == nil && isAllowedRPCHost(host) {
+			return conn, nil
+		}
+		rpcsLog.Warnf("Dropping RPC connection from %s which is not "+
+			"an allowed address", conn.RemoteAddr())
+		conn.Close()
+	}
+}
+
+// listenUnix listens on a Unix domain socket at the passed path.  A stale
+// socket file left behind by an unclean shutdown is removed first, however any
+// other type of file at the path results in an error.
+func listenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket",
+				path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	return net.Listen("unix", path)
+}
+
 // jsonAuthFail sends a message back to the client if the http auth is rejected.
 func jsonAuthFail(w http.ResponseWriter, r *http.Request, s *rpcServer) {
 	w.Header().Add("WWW-Authenticate", `Basic realm="btcd RPC"`)
 	http.Error(w, "401 Unauthorized.", http.StatusUnauthorized)
 }
 
-// jsonRPCRead is the RPC wrapper around the jsonRead function to handle reading
-// and responding to RPC messages.
-func jsonRPCRead(w http.ResponseWriter, r *http.Request, s *rpcServer) {
-	if atomic.LoadInt32(&s.shutdown) != 0 {
-		return
-	}
-	body, err := btcjson.GetRaw(r.Body)
-	if err != nil {
-		rpcsLog.Errorf("Error getting json message: %v", err)
-		return
-	}
+// rpcRequestTooLarge responds to an RPC request which exceeds the max request
+// size (--maxrpcrequestsize) with a 413 Request Entity Too Large status.
+func rpcRequestTooLarge(w http.ResponseWriter, r *http.Request, maxSize int64) {
+	rpcsLog.Warnf("RPC request from %s exceeds the max size of %d bytes",
+		r.RemoteAddr, maxSize)
+	http.Error(w, "413 Request Entity Too Large.",
+		http.StatusRequestEntityTooLarge)
+}
 
+// jsonRPCReply returns the reply to the passed marshaled JSON-RPC request.
+// Requests from limited users are only serviced when the command is in the list
+// of commands available to them and requests which exceed the rate limit
+// (--rpcratelimit) are rejected.
+func jsonRPCReply(body []byte, isAdmin bool, s *rpcServer, closeChan <-chan bool) btcjson.Reply {
 	var reply btcjson.Reply
 	cmd, jsonErr := parseCmd(body)
 	if cmd != nil {
@@ -488,11 +880,125 @@ func jsonRPCRead(w http.ResponseWriter, r *http.Request, s *rpcServer) {
 	}
 	if jsonErr != nil {
 		reply.Error = jsonErr
+	} else if !isAdmin && !isLimitedCmd(cmd.Method()) {
+		reply.Error = &errLimitedUser
+	} else if !s.rateLimiter.Allow(isAdmin, cmd.Method()) {
+		reply.Error = &errRateLimited
 	} else {
-		reply = standardCmdReply(cmd, s)
+		reply = standardCmdReply(cmd, s, closeChan)
 	}
 
 	rpcsLog.Tracef("reply: %v", reply)
+	return reply
+}
+
+// isBatchRequest returns whether the passed request body is a batch of
+// JSON-RPC requests, which is a JSON array of requests.
+func isBatchRequest(body []byte) bool {
+	trimmed := bytes.TrimLeft(body, " \t\r\n")
+	return len(trimmed) > 0 && trimmed[0] == '['
+}
+
+// jsonRPCBatchReply returns the replies to the passed batch of marshaled
+// JSON-RPC requests in the same order as the requests.  Each request is
+// handled independently, so a request which fails does not prevent the rest
+// of the batch from being serviced.  A single error reply is returned when the
+// batch itself is malformed or empty as per the JSON-RPC 2.0 batch convention.
+func jsonRPCBatchReply(body []byte, isAdmin bool, s *rpcServer, closeChan <-chan bool) interface{} {
+	var requests []json.RawMessage
+	if err := json.Unmarshal(body, &requests); err != nil {
+		return btcjson.Reply{
+			Error: &btcjson.Error{
+				Code:    btcjson.ErrParse.Code,
+				Message: "Failed to parse batch request: " + err.Error(),
+			},
+		}
+	}
+	if len(requests) == 0 {
+		return btcjson.Reply{
+			Error: &btcjson.Error{
+				Code:    btcjson.ErrInvalidRequest.Code,
+				Message: "Empty batch request",
+			},
+		}
+	}
+
+	replies := make([]btcjson.Reply, 0, len(requests))
+	for _, request := range requests {
+		replies = append(replies, jsonRPCReply(request, isAdmin, s,
+			closeChan))
+	}
+	return replies
+}
+
+// jsonRPCRead is the RPC wrapper around the jsonRead function to handle reading
+// and responding to RPC messages.  The request may be a single JSON-RPC request
+// or a batch of them.  Requests which exceed the max request size
+// (--maxrpcrequestsize), including the whole of a batch, are rejected.
+func jsonRPCRead(w http.ResponseWriter, r *http.Request, isAdmin bool, s *rpcServer) {
+	if atomic.LoadInt32(&s.shutdown) != 0 {
+		return
+	}
+
+	// Reject requests larger than the limit without reading them into
+	// memory.  The declared content length is checked up front and the
+	// body is read through a reader which stops just past the limit since
+	// the content length may be missing or wrong.
+	maxSize := cfg.MaxRPCRequestSize
+	if maxSize != 0 && r.ContentLength > maxSize {
+		rpcRequestTooLarge(w, r, maxSize)
+		return
+	}
+	bodyReader := r.Body
+	if maxSize != 0 {
+		bodyReader = ioutil.NopCloser(io.LimitReader(r.Body, maxSize+1))
+	}
+	body, err := btcjson.GetRaw(bodyReader)
+	if err != nil {
+		rpcsLog.Errorf("Error getting json message: %v", err)
+		return
+	}
+	if maxSize != 0 && int64(len(body)) > maxSize {
+		rpcRequestTooLarge(w, r, maxSize)
+		return
+	}
+
+	// Handlers such as getblocktemplate long polling may wait for some
+	// time before replying, so provide them with a way to detect that the
+	// client went away while waiting.
+	var closeChan <-chan bool
+	if cn, ok := w.(http.CloseNotifier); ok {
+		closeChan = cn.CloseNotify()
+	}
+
+	// Batches are replied to with 200 OK since the replies to the
+	// individual requests carry their own errors.  The exception is a
+	// malformed or empty batch which is replied to with a single error
+	// and is treated like the reply to a single request below.
+	if isBatchRequest(body) {
+		replies := jsonRPCBatchReply(body, isAdmin, s, closeChan)
+		reply, ok := replies.(btcjson.Reply)
+		if ok && cfg.RPCQuirks && reply.Error != nil {
+			w.WriteHeader(quirkErrorStatusCode(reply.Error))
+		}
+		marshalled, err := json.Marshal(replies)
+		if err != nil {
+			rpcsLog.Errorf("Error marshalling batch reply: %v", err)
+			return
+		}
+		if _, err := w.Write(marshalled); err != nil {
+			rpcsLog.Errorf("Error sending batch reply: %v", err)
+		}
+		return
+	}
+
+	reply := jsonRPCReply(body, isAdmin, s, closeChan)
+
+	// Bitcoin Core replies to errors with an HTTP status code other than
+	// 200 OK, which some clients depend on.
+	if cfg.RPCQuirks && reply.Error != nil {
+		w.WriteHeader(quirkErrorStatusCode(reply.Error))
+	}
 
 	msg, err := btcjson.MarshallAndSend(reply, w)
 	if err != nil {
@@ -502,21 +1008,34 @@ func jsonRPCRead(w http.ResponseWriter, r *http.Request, s *rpcServer) {
 	rpcsLog.Tracef(msg)
 }
 
+// quirkErrorStatusCode returns the HTTP status code Bitcoin Core replies with
+// for the passed error.  It is only used when --rpcquirks is specified.
+func quirkErrorStatusCode(jsonErr *btcjson.Error) int {
+	switch jsonErr.Code {
+	case btcjson.ErrMethodNotFound.Code:
+		return http.StatusNotFound
+	case btcjson.ErrInvalidRequest.Code:
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 // handleUnimplemented is a temporary handler for commands that we should
 // support but do not.
-func handleUnimplemented(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
+func handleUnimplemented(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
 	return nil, btcjson.ErrUnimplemented
 }
 
 // handleAskWallet is the handler for commands that we do recognise as valid
 // but that we can not answer correctly since it involves wallet state.
 // These commands will be implemented in btcwallet.
-func handleAskWallet(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
+func handleAskWallet(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
 	return nil, btcjson.ErrNoWallet
 }
 
 // handleAddNode handles addnode commands.
-func handleAddNode(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
+func handleAddNode(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
 	c := cmd.(*btcjson.AddNodeCmd)
 
 	addr := normalizeAddress(c.Addr, activeNetParams.DefaultPort)
@@ -525,7 +1044,7 @@ func handleAddNode(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
 	case "add":
 		err = s.server.AddAddr(addr, true)
 	case "remove":
-		err = s.server.RemoveAddr(addr)
+		err = s.server.RemoveNodeByAddr(addr)
 	case "onetry":
 		err = s.server.AddAddr(addr, false)
 	default:
@@ -543,6 +1062,129 @@ func handleAddNode(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
 	return nil, nil
 }
 
+// handleNode handles node commands.
+func handleNode(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
+	c := cmd.(*NodeCmd)
+
+	// The remove and disconnect subcommands accept either the id of a
+	// peer or its address.
+	id, idErr := strconv.ParseInt(c.Target, 10, 32)
+	addr := normalizeAddress(c.Target, activeNetParams.DefaultPort)
+	var err error
+	switch c.SubCmd {
+	case "add":
+		err = s.server.AddAddr(addr, true)
+	case "remove":
+		if idErr == nil {
+			err = s.server.RemoveNodeByID(int32(id))
+		} else {
+			err = s.server.RemoveNodeByAddr(addr)
+		}
+	case "onetry":
+		err = s.server.AddAddr(addr, false)
+	case "disconnect":
+		if idErr == nil {
+			err = s.server.DisconnectNodeByID(int32(id))
+		} else {
+			err = s.server.DisconnectNodeByAddr(addr)
+		}
+	default:
+		err = errors.New("Invalid subcommand for node")
+	}
+
+	if err != nil {
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrInternal.Code,
+			Message: err.Error(),
+		}
+	}
+
+	// no data returned unless an error.
+	return nil, nil
+}
+
+// handleSetBan handles setban commands.
+func handleSetBan(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
+	c := cmd.(*SetBanCmd)
+
+	subnet, err := parseBanSubnet(c.Subnet)
+	if err != nil {
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrInvalidParameter.Code,
+			Message: err.Error(),
+		}
+	}
+
+	switch c.SubCmd {
+	case "add":
+		// Determine when the ban expires.  The ban time is either an
+		// absolute time or a duration which defaults to the configured
+		// ban duration.
+		until := time.Now().Add(cfg.BanDuration)
+		if c.Absolute {
+			until = time.Unix(c.BanTime, 0)
+		} else if c.BanTime != 0 {
+			until = time.Now().Add(time.Duration(c.BanTime) *
+				time.Second)
+		}
+		if !until.After(time.Now()) {
+			return nil, btcjson.Error{
+				Code:    btcjson.ErrInvalidParameter.Code,
+				Message: "Ban time must be in the future",
+			}
+		}
+		err = s.server.SetBan(subnet, until)
+	case "remove":
+		err = s.server.RemoveBan(subnet)
+	default:
+		err = errors.New("Invalid subcommand for setban")
+	}
+
+	if err != nil {
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrInvalidParameter.Code,
+			Message: err.Error(),
+		}
+	}
+
+	// no data returned unless an error.
+	return nil, nil
+}
+
+// handleListBanned handles listbanned commands.
+func handleListBanned(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
+	banned := s.server.ListBanned()
+	subnets := make([]string, 0, len(banned))
+	for subnet := range banned {
+		subnets = append(subnets, subnet)
+	}
+	sort.Strings(subnets)
+
+	results := make([]*ListBannedResult, 0, len(subnets))
+	for _, subnet := range subnets {
+		ban := banned[subnet]
+		results = append(results, &ListBannedResult{
+			Address:     subnet,
+			BannedUntil: ban.until.Unix(),
+			BanCreated:  ban.created.Unix(),
+		})
+	}
+	return results, nil
+}
+
+// handleClearBanned handles clearbanned commands.
+func handleClearBanned(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
+	if err := s.server.ClearBanned(); err != nil {
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrInternal.Code,
+			Message: err.Error(),
+		}
+	}
+
+	// no data returned unless an error.
+	return nil, nil
+}
+
 // messageToHex serializes a message to the wire protocol encoding using the
 // latest protocol version and returns a hex-encoded string of the result.
 func messageToHex(msg btcwire.Message) (string, error) {
@@ -558,7 +1200,7 @@ func messageToHex(msg btcwire.Message) (string, error) {
 }
 
 // handleCreateRawTransaction handles createrawtransaction commands.
-func handleCreateRawTransaction(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
+func handleCreateRawTransaction(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
 	c := cmd.(*btcjson.CreateRawTransactionCmd)
 
 	// Add all transaction inputs to a new transaction after performing
@@ -567,13 +1209,20 @@ func handleCreateRawTransaction(s *rpcServer, cmd btcjson.Cmd) (interface{}, err
 	for _, input := range c.Inputs {
 		txHash, err := btcwire.NewShaHashFromStr(input.Txid)
 		if err != nil {
-			return nil, btcjson.ErrDecodeHexString
+			return nil, btcjson.Error{
+				Code: btcjson.ErrDecodeHexString.Code,
+				Message: fmt.Sprintf("%s: invalid txid %q: %v",
+					btcjson.ErrDecodeHexString.Message,
+					input.Txid, err),
+			}
 		}
 
 		if input.Vout < 0 {
 			return nil, btcjson.Error{
-				Code:    btcjson.ErrInvalidParameter.Code,
-				Message: "Invalid parameter, vout must be positive",
+				Code: btcjson.ErrInvalidParameter.Code,
+				Message: fmt.Sprintf("Invalid parameter, vout "+
+					"must be positive (txid %s, vout %d)",
+					input.Txid, input.Vout),
 			}
 		}
 
@@ -588,8 +1237,10 @@ func handleCreateRawTransaction(s *rpcServer, cmd btcjson.Cmd) (interface{}, err
 		// Ensure amount is in the valid range for monetary amounts.
 		if amount <= 0 || amount > btcutil.MaxSatoshi {
 			return nil, btcjson.Error{
-				Code:    btcjson.ErrType.Code,
-				Message: "Invalid amount",
+				Code: btcjson.ErrType.Code,
+				Message: fmt.Sprintf("Invalid amount of %d "+
+					"satoshi for address %s", amount,
+					encodedAddr),
 			}
 		}
 
@@ -611,7 +1262,12 @@ func handleCreateRawTransaction(s *rpcServer, cmd btcjson.Cmd) (interface{}, err
 		case *btcutil.AddressPubKeyHash:
 		case *btcutil.AddressScriptHash:
 		default:
-			return nil, btcjson.ErrInvalidAddressOrKey
+			return nil, btcjson.Error{
+				Code: btcjson.ErrInvalidAddressOrKey.Code,
+				Message: fmt.Sprintf("%s: unsupported address "+
+					"type %q", btcjson.ErrInvalidAddressOrKey.Message,
+					encodedAddr),
+			}
 		}
 		if !addr.IsForNet(s.server.netParams) {
 			return nil, btcjson.Error{
@@ -644,7 +1300,7 @@ func handleCreateRawTransaction(s *rpcServer, cmd btcjson.Cmd) (interface{}, err
 }
 
 // handleDebugLevel handles debuglevel commands.
-func handleDebugLevel(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
+func handleDebugLevel(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
 	c := cmd.(*btcjson.DebugLevelCmd)
 
 	// Special show command to list supported subsystems.
@@ -701,31 +1357,15 @@ func createVoutList(mtx *btcwire.MsgTx, net *btcnet.Params) ([]btcjson.Vout, err
 		voutList[i].N = i
 		voutList[i].Value = float64(v.Value) / float64(btcutil.SatoshiPerBitcoin)
 
-		disbuf, err := btcscript.DisasmString(v.PkScript)
+		scriptPubKey, err := createScriptPubKeyResult(v.PkScript, net)
 		if err != nil {
-			return nil, btcjson.Error{
-				Code:    btcjson.ErrInternal.Code,
-				Message: err.Error(),
-			}
-		}
-		voutList[i].ScriptPubKey.Asm = disbuf
-		voutList[i].ScriptPubKey.Hex = hex.EncodeToString(v.PkScript)
-
-		// Ignore the error here since an error means the script
-		// couldn't parse and there is no additional information about
-		// it anyways.
-		scriptClass, addrs, reqSigs, _ := btcscript.ExtractPkScriptAddrs(v.PkScript, net)
-		voutList[i].ScriptPubKey.Type = scriptClass.String()
-		voutList[i].ScriptPubKey.ReqSigs = reqSigs
-
-		if addrs == nil {
-			voutList[i].ScriptPubKey.Addresses = nil
-		} else {
-			voutList[i].ScriptPubKey.Addresses = make([]string, len(addrs))
-			for j, addr := range addrs {
-				voutList[i].ScriptPubKey.Addresses[j] = addr.EncodeAddress()
-			}
+			return nil, err
 		}
+		voutList[i].ScriptPubKey.Asm = scriptPubKey.Asm
+		voutList[i].ScriptPubKey.Hex = scriptPubKey.Hex
+		voutList[i].ScriptPubKey.Type = scriptPubKey.Type
+		voutList[i].ScriptPubKey.ReqSigs = scriptPubKey.ReqSigs
+		voutList[i].ScriptPubKey.Addresses = scriptPubKey.Addresses
 	}
 
 	return voutList, nil
@@ -775,7 +1415,7 @@ func createTxRawResult(net *btcnet.Params, txSha string, mtx *btcwire.MsgTx,
 }
 
 // handleDecodeRawTransaction handles decoderawtransaction commands.
-func handleDecodeRawTransaction(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
+func handleDecodeRawTransaction(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
 	c := cmd.(*btcjson.DecodeRawTransactionCmd)
 
 	// Deserialize the transaction.
@@ -796,7 +1436,7 @@ func handleDecodeRawTransaction(s *rpcServer, cmd btcjson.Cmd) (interface{}, err
 	if err != nil {
 		return nil, btcjson.Error{
 			Code:    btcjson.ErrDeserialization.Code,
-			Message: "TX decode failed",
+			Message: "TX decode failed: " + err.Error(),
 		}
 	}
 	txSha, _ := mtx.TxSha()
@@ -822,7 +1462,7 @@ func handleDecodeRawTransaction(s *rpcServer, cmd btcjson.Cmd) (interface{}, err
 }
 
 // handleDecodeScript handles decodescript commands.
-func handleDecodeScript(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
+func handleDecodeScript(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
 	c := cmd.(*btcjson.DecodeScriptCmd)
 
 	// Convert the hex script to bytes.
@@ -869,13 +1509,70 @@ func handleDecodeScript(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
 	return reply, nil
 }
 
+// handleEstimateFee implements the estimatefee command.
+func handleEstimateFee(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
+	c := cmd.(*EstimateFeeCmd)
+	if c.NumBlocks < 1 || c.NumBlocks > maxConfirmTarget {
+		return nil, btcjson.Error{
+			Code: btcjson.ErrInvalidParameter.Code,
+			Message: fmt.Sprintf("numblocks must be between 1 and %d",
+				maxConfirmTarget),
+		}
+	}
+
+	feeRate := s.server.feeEstimator.EstimateFee(int(c.NumBlocks))
+	if feeRate < 0 {
+		return float64(-1), nil
+	}
+
+	// Convert the fee rate from satoshi to BTC per kilobyte.
+	return float64(feeRate) / float64(btcutil.SatoshiPerBitcoin), nil
+}
+
+// handleGenerate implements the generate command.
+func handleGenerate(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
+	// Generating blocks on demand is only useful for testing, so only
+	// allow it on the test networks where blocks are cheap to solve.
+	if !(cfg.RegressionTest || cfg.SimNet) {
+		return nil, btcjson.Error{
+			Code: btcjson.ErrMisc.Code,
+			Message: fmt.Sprintf("The generate command is not "+
+				"supported on the %s network.  Try using "+
+				"--simnet or --regtest.", activeNetParams.Name),
+		}
+	}
+
+	c := cmd.(*GenerateCmd)
+	if c.NumBlocks == 0 {
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrInvalidParameter.Code,
+			Message: "Please request a nonzero number of blocks to generate.",
+		}
+	}
+
+	blockHashes, err := s.server.cpuMiner.GenerateNBlocks(c.NumBlocks,
+		c.MaxTries)
+	if err != nil {
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrInternal.Code,
+			Message: err.Error(),
+		}
+	}
+
+	reply := make([]string, 0, len(blockHashes))
+	for _, hash := range blockHashes {
+		reply = append(reply, hash.String())
+	}
+	return reply, nil
+}
+
 // handleGetAddedNodeInfo handles getaddednodeinfo commands.
-func handleGetAddedNodeInfo(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
+func handleGetAddedNodeInfo(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
 	c := cmd.(*btcjson.GetAddedNodeInfoCmd)
 
 	// Retrieve a list of persistent (added) peers from the bitcoin server
 	// and filter the list of peer per the specified address (if any).
-	peers := s.server.AddedNodeInfo()
+	peers, connected := s.server.AddedNodeInfo()
 	if c.Node != "" {
 		found := false
 		for i, peer := range peers {
@@ -892,26 +1589,12 @@ func handleGetAddedNodeInfo(s *rpcServer, cmd btcjson.Cmd) (interface{}, error)
 		}
 	}
 
-	// Without the dns flag, the result is just a slice of the addresses as
-	// strings.
-	if !c.Dns {
-		results := make([]string, 0, len(peers))
-		for _, peer := range peers {
-			results = append(results, peer.addr)
-		}
-		return results, nil
-	}
-
-	// With the dns flag, the result is an array of JSON objects which
-	// include the result of DNS lookups for each peer.
 	results := make([]*btcjson.GetAddedNodeInfoResult, 0, len(peers))
 	for _, peer := range peers {
 		// Set the "address" of the peer which could be an ip address
 		// or a domain name.
 		var result btcjson.GetAddedNodeInfoResult
 		result.AddedNode = peer.addr
-		isConnected := peer.Connected()
-		result.Connected = &isConnected
 
 		// Split the address into host and port portions so we can do
 		// a DNS lookup against the host.  When no port is specified in
@@ -921,6 +1604,20 @@ func handleGetAddedNodeInfo(s *rpcServer, cmd btcjson.Cmd) (interface{}, error)
 			host = peer.addr
 		}
 
+		// The node is connected when its persistent peer is connected
+		// or another peer, such as an inbound one, is connected from
+		// the same host.
+		_, isConnected := connected[host]
+		isConnected = isConnected || peer.Connected()
+
+		// Without the dns flag, only the connection status is
+		// reported.
+		if !c.Dns {
+			result.Connected = &isConnected
+			results = append(results, &result)
+			continue
+		}
+
 		// Do a DNS lookup for the address.  If the lookup fails, just
 		// use the host.
 		var ipList []string
@@ -935,17 +1632,21 @@ func handleGetAddedNodeInfo(s *rpcServer, cmd btcjson.Cmd) (interface{}, error)
 			ipList[0] = host
 		}
 
-		// Add the addresses and connection info to the result.
+		// Add the addresses and connection info to the result.  Each
+		// address is reported as connected along with the direction
+		// of the connection when a peer is connected from it.
 		addrs := make([]btcjson.GetAddedNodeInfoResultAddr, 0, len(ipList))
 		for _, ip := range ipList {
 			var addr btcjson.GetAddedNodeInfoResultAddr
 			addr.Address = ip
 			addr.Connected = "false"
-			if ip == host && peer.Connected() {
-				addr.Connected = directionString(peer.inbound)
+			if p, ok := connected[ip]; ok {
+				addr.Connected = directionString(p.inbound)
+				isConnected = true
 			}
 			addrs = append(addrs, addr)
 		}
+		result.Connected = &isConnected
 		result.Addresses = &addrs
 		results = append(results, &result)
 	}
@@ -953,7 +1654,7 @@ func handleGetAddedNodeInfo(s *rpcServer, cmd btcjson.Cmd) (interface{}, error)
 }
 
 // handleGetBestBlock implements the getbestblock command.
-func handleGetBestBlock(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
+func handleGetBestBlock(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
 	// All other "get block" commands give either the height, the
 	// hash, or both but require the block SHA.  This gets both for
 	// the best block.
@@ -970,7 +1671,7 @@ func handleGetBestBlock(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
 }
 
 // handleGetBestBlockHash implements the getbestblockhash command.
-func handleGetBestBlockHash(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
+func handleGetBestBlockHash(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
 	sha, _, err := s.server.db.NewestSha()
 	if err != nil {
 		rpcsLog.Errorf("Error getting newest sha: %v", err)
@@ -981,8 +1682,15 @@ func handleGetBestBlockHash(s *rpcServer, cmd btcjson.Cmd) (interface{}, error)
 }
 
 // handleGetBlock implements the getblock command.
-func handleGetBlock(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
-	c := cmd.(*btcjson.GetBlockCmd)
+func handleGetBlock(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
+	// A verbosity level of 2 decodes the transactions along with the
+	// outputs spent by their inputs.
+	var decodePrevOuts bool
+	c, ok := cmd.(*btcjson.GetBlockCmd)
+	if !ok {
+		c = cmd.(*GetBlockVerbosityCmd).GetBlockCmd
+		decodePrevOuts = true
+	}
 	sha, err := btcwire.NewShaHashFromStr(c.Hash)
 	if err != nil {
 		rpcsLog.Errorf("Error generating sha: %v", err)
@@ -1043,7 +1751,7 @@ func handleGetBlock(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
 		}
 
 		blockReply.Tx = txNames
-	} else {
+	} else if !decodePrevOuts {
 		txns := blk.Transactions()
 		rawTxns := make([]btcjson.TxRawResult, len(txns))
 		for i, tx := range txns {
@@ -1073,225 +1781,1359 @@ func handleGetBlock(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
 		blockReply.NextHash = shaNext.String()
 	}
 
-	return blockReply, nil
-}
+	if !decodePrevOuts {
+		return blockReply, nil
+	}
 
-// handleGetBlockCount implements the getblockcount command.
-func handleGetBlockCount(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
-	_, maxidx, err := s.server.db.NewestSha()
+	// The transactions are decoded along with the outputs spent by their
+	// inputs.
+	originTxns, err := fetchBlockInputTxns(s.server.db, blk)
 	if err != nil {
-		rpcsLog.Errorf("Error getting newest sha: %v", err)
-		return nil, btcjson.ErrBlockCount
+		return nil, err
 	}
+	txns := blk.Transactions()
+	rawTxns := make([]TxRawPrevOutResult, len(txns))
+	for i, tx := range txns {
+		txSha := tx.Sha().String()
+		mtx := tx.MsgTx()
 
-	return maxidx, nil
-}
-
-// handleGetBlockHash implements the getblockhash command.
-func handleGetBlockHash(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
-	c := cmd.(*btcjson.GetBlockHashCmd)
-	sha, err := s.server.db.FetchBlockShaByHeight(c.Index)
-	if err != nil {
-		rpcsLog.Errorf("Error getting block: %v", err)
-		return nil, btcjson.ErrOutOfRange
+		rawTxn, err := createTxRawResult(s.server.netParams, txSha, mtx,
+			blk, maxidx, sha)
+		if err != nil {
+			rpcsLog.Errorf("Cannot create TxRawResult for "+
+				"transaction %s: %v", txSha, err)
+			return nil, err
+		}
+		vin, err := createVinPrevOutList(mtx, s.server.netParams,
+			originTxns)
+		if err != nil {
+			return nil, err
+		}
+		rawTxns[i] = TxRawPrevOutResult{
+			TxRawResult: rawTxn,
+			Vin:         vin,
+		}
 	}
 
-	return sha.String(), nil
+	// Bitcoin Core returns the decoded transactions in the tx field.
+	result := &GetBlockVerboseTxResult{BlockResult: &blockReply}
+	if cfg.RPCQuirks {
+		result.Tx = rawTxns
+	} else {
+		result.RawTx = rawTxns
+	}
+	return result, nil
 }
 
-// handleGetConnectionCount implements the getconnectioncount command.
-func handleGetConnectionCount(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
-	return s.server.ConnectedCount(), nil
-}
+// fetchBlockInputTxns returns the transactions which contain the outputs spent
+// by the inputs of the transactions in the passed block, keyed by their hashes.
+// Transactions of the block itself are included since they may be spent by
+// later transactions in the block.  Transactions which can't be found are left
+// out.  An error is returned when the block spends outputs of more than
+// maxPrevOutLookups transactions which are not part of it in order to bound the
+// amount of work a single request may cause.
+func fetchBlockInputTxns(db btcdb.Db, blk *btcutil.Block) (map[btcwire.ShaHash]*btcwire.MsgTx, error) {
+	transactions := blk.Transactions()
+	originTxns := make(map[btcwire.ShaHash]*btcwire.MsgTx,
+		len(transactions))
+	for _, tx := range transactions {
+		originTxns[*tx.Sha()] = tx.MsgTx()
+	}
+
+	var numLookups int
+	for _, tx := range transactions[1:] {
+		for _, txIn := range tx.MsgTx().TxIn {
+			prevHash := &txIn.PreviousOutpoint.Hash
+			if _, ok := originTxns[*prevHash]; ok {
+				continue
+			}
 
-// handleGetCurrentNet implements the getcurrentnet command.
-func handleGetCurrentNet(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
-	return s.server.netParams.Net, nil
+			numLookups++
+			if numLookups > maxPrevOutLookups {
+				return nil, btcjson.Error{
+					Code: btcjson.ErrInvalidParameter.Code,
+					Message: "Block spends outputs of too " +
+						"many transactions to decode its " +
+						"transactions -- use verbosity 1 " +
+						"and getrawtransaction instead",
+				}
+			}
+
+			txList, err := db.FetchTxBySha(prevHash)
+			if err != nil || len(txList) == 0 {
+				rpcsLog.Debugf("Unable to fetch input "+
+					"transaction %v: %v", prevHash, err)
+				originTxns[*prevHash] = nil
+				continue
+			}
+			originTxns[*prevHash] = txList[len(txList)-1].Tx
+		}
+	}
+	return originTxns, nil
 }
 
-// handleGetDifficulty implements the getdifficulty command.
-func handleGetDifficulty(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
-	sha, _, err := s.server.db.NewestSha()
-	if err != nil {
-		rpcsLog.Errorf("Error getting sha: %v", err)
-		return nil, btcjson.ErrDifficulty
+// createVinPrevOutList returns a slice of JSON objects for the inputs of the
+// passed transaction which include the value and addresses of the outputs they
+// spend.  The spent outputs are looked up in the passed transactions, and the
+// inputs which spend outputs of missing transactions are left without them.
+func createVinPrevOutList(mtx *btcwire.MsgTx, net *btcnet.Params, originTxns map[btcwire.ShaHash]*btcwire.MsgTx) ([]VinPrevOut, error) {
+	tx := btcutil.NewTx(mtx)
+	if btcchain.IsCoinBase(tx) {
+		vinList := make([]VinPrevOut, len(mtx.TxIn))
+		for i, txIn := range mtx.TxIn {
+			vinList[i].Coinbase = hex.EncodeToString(txIn.SignatureScript)
+			vinList[i].Sequence = txIn.Sequence
+		}
+		return vinList, nil
 	}
-	blockHeader, err := s.server.db.FetchBlockHeaderBySha(sha)
-	if err != nil {
-		rpcsLog.Errorf("Error getting block: %v", err)
-		return nil, btcjson.ErrDifficulty
+
+	vinList := make([]VinPrevOut, len(mtx.TxIn))
+	for i, txIn := range mtx.TxIn {
+		disbuf, err := btcscript.DisasmString(txIn.SignatureScript)
+		if err != nil {
+			return nil, btcjson.Error{
+				Code:    btcjson.ErrInternal.Code,
+				Message: err.Error(),
+			}
+		}
+
+		prevOut := &txIn.PreviousOutpoint
+		vinList[i] = VinPrevOut{
+			Txid: prevOut.Hash.String(),
+			Vout: prevOut.Index,
+			ScriptSig: &btcjson.ScriptSig{
+				Asm: disbuf,
+				Hex: hex.EncodeToString(txIn.SignatureScript),
+			},
+			Sequence: txIn.Sequence,
+		}
+
+		originTx := originTxns[prevOut.Hash]
+		if originTx == nil || prevOut.Index >= uint32(len(originTx.TxOut)) {
+			continue
+		}
+		txOut := originTx.TxOut[prevOut.Index]
+
+		// Ignore the error here since an error means the script
+		// couldn't parse and there is no additional information about
+		// it anyways.
+		_, addrs, _, _ := btcscript.ExtractPkScriptAddrs(txOut.PkScript,
+			net)
+		encodedAddrs := make([]string, len(addrs))
+		for j, addr := range addrs {
+			encodedAddrs[j] = addr.EncodeAddress()
+		}
+		vinList[i].PrevOut = &PrevOut{
+			Addresses: encodedAddrs,
+			Value: float64(txOut.Value) /
+				float64(btcutil.SatoshiPerBitcoin),
+		}
 	}
-	return getDifficultyRatio(blockHeader.Bits), nil
-}
 
-// handleGetGenerate implements the getgenerate command.
-func handleGetGenerate(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
-	// btcd does not do mining so we can hardcode replies here.
-	return false, nil
+	return vinList, nil
 }
 
-// handleGetHashesPerSec implements the gethashespersec command.
-func handleGetHashesPerSec(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
-	// btcd does not do mining so we can hardcode replies here.
-	return 0, nil
+// chainName returns the name of the passed network as it is reported by the
+// getblockchaininfo command, which matches the names used by the reference
+// implementation.
+func chainName(netParams *params) string {
+	switch netParams.Net {
+	case btcwire.MainNet:
+		return "main"
+	case btcwire.TestNet3:
+		return "test"
+	case btcwire.TestNet:
+		return "regtest"
+	default:
+		return netParams.Name
+	}
 }
 
-// handleGetInfo implements the getinfo command. We only return the fields
-// that are not related to wallet functionality.
-func handleGetInfo(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
-	// We require the current block height and sha.
-	sha, height, err := s.server.db.NewestSha()
-	if err != nil {
-		rpcsLog.Errorf("Error getting sha: %v", err)
-		return nil, btcjson.ErrBlockCount
-	}
-	blkHeader, err := s.server.db.FetchBlockHeaderBySha(sha)
-	if err != nil {
-		rpcsLog.Errorf("Error getting block: %v", err)
-		return nil, btcjson.ErrDifficulty
+// estimateVerificationProgress returns an estimate between 0 and 1 of how much
+// of the block chain has been verified based on the timestamp of the best block
+// relative to the timestamp of the genesis block and the current time.
+func estimateVerificationProgress(bestTime time.Time) float64 {
+	genesisTime := activeNetParams.GenesisBlock.Header.Timestamp
+	if !bestTime.After(genesisTime) {
+		return 0
 	}
 
-	ret := &btcjson.InfoResult{
-		Version:         int(1000000*appMajor + 10000*appMinor + 100*appPatch),
-		ProtocolVersion: int(maxProtocolVersion),
-		Blocks:          int(height),
-		TimeOffset:      0,
-		Connections:     s.server.ConnectedCount(),
-		Proxy:           cfg.Proxy,
-		Difficulty:      getDifficultyRatio(blkHeader.Bits),
-		TestNet:         cfg.TestNet3,
-		RelayFee:        float64(minTxRelayFee) / float64(btcutil.SatoshiPerBitcoin),
+	totalSecs := time.Now().Sub(genesisTime).Seconds()
+	progress := bestTime.Sub(genesisTime).Seconds() / totalSecs
+	if progress > 1 {
+		progress = 1
 	}
-
-	return ret, nil
+	return progress
 }
 
-// handleGetMiningInfo implements the getmininginfo command. We only return the
-// fields that are not related to wallet functionality.
-func handleGetMiningInfo(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
+// handleGetBlockChainInfo implements the getblockchaininfo command.
+func handleGetBlockChainInfo(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
 	sha, height, err := s.server.db.NewestSha()
 	if err != nil {
-		rpcsLog.Errorf("Error getting sha: %v", err)
-		return nil, btcjson.ErrBlockCount
+		rpcsLog.Errorf("Error getting newest sha: %v", err)
+		return nil, btcjson.ErrBestBlockHash
 	}
-	block, err := s.server.db.FetchBlockBySha(sha)
+	blockHeader, err := s.server.db.FetchBlockHeaderBySha(sha)
 	if err != nil {
-		rpcsLog.Errorf("Error getting block: %v", err)
+		rpcsLog.Errorf("Error fetching header: %v", err)
 		return nil, btcjson.ErrBlockNotFound
 	}
-	blockBytes, err := block.Bytes()
+
+	// The headers downloaded during the headers-first initial sync are
+	// ahead of the best block until their blocks have been processed.
+	chainState := &s.server.blockManager.chainState
+	chainState.Lock()
+	medianTime := chainState.pastMedianTime
+	headers := chainState.newestHeaderHeight
+	chainState.Unlock()
+	if headers < height {
+		headers = height
+	}
+
+	// The chain is considered fully verified once the block manager
+	// believes it is synced to the latest known block.  Otherwise, estimate
+	// the progress from the timestamp of the best block.
+	progress := 1.0
+	if !s.server.blockManager.IsCurrent() {
+		progress = estimateVerificationProgress(blockHeader.Timestamp)
+	}
+
+	// Blocks are never pruned.
+	result := &GetBlockChainInfoResult{
+		Chain:                chainName(activeNetParams),
+		Blocks:               height,
+		Headers:              headers,
+		BestBlockHash:        sha.String(),
+		Difficulty:           getDifficultyRatio(blockHeader.Bits),
+		MedianTime:           medianTime.Unix(),
+		VerificationProgress: progress,
+		Pruned:               false,
+	}
+	return result, nil
+}
+
+// handleGetBlockCount implements the getblockcount command.
+func handleGetBlockCount(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
+	_, maxidx, err := s.server.db.NewestSha()
 	if err != nil {
-		rpcsLog.Errorf("Error getting block: %v", err)
-		return nil, btcjson.Error{
-			Code:    btcjson.ErrInternal.Code,
-			Message: err.Error(),
-		}
+		rpcsLog.Errorf("Error getting newest sha: %v", err)
+		return nil, btcjson.ErrBlockCount
 	}
 
-	// Create a default getnetworkhashps command to use defaults and make
-	// use of the existing getnetworkhashps handler.
-	gnhpsCmd, err := btcjson.NewGetNetworkHashPSCmd(0)
+	return maxidx, nil
+}
+
+// handleGetBlockHash implements the getblockhash command.
+func handleGetBlockHash(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
+	c := cmd.(*btcjson.GetBlockHashCmd)
+
+	// Reject heights which are not in the main chain up front rather than
+	// relying on the database lookup failing.
+	_, bestHeight, err := s.server.db.NewestSha()
 	if err != nil {
 		return nil, btcjson.Error{
 			Code:    btcjson.ErrInternal.Code,
 			Message: err.Error(),
 		}
 	}
-	rpcsLog.Info(gnhpsCmd.Blocks, gnhpsCmd.Height)
-	networkHashesPerSecIface, err := handleGetNetworkHashPS(s, gnhpsCmd)
-	if err != nil {
-		// This is already a btcjson.Error from the handler.
-		return nil, err
-	}
-	networkHashesPerSec, ok := networkHashesPerSecIface.(int64)
-	if !ok {
+	if c.Index < 0 || c.Index > bestHeight {
 		return nil, btcjson.Error{
-			Code:    btcjson.ErrInternal.Code,
-			Message: "networkHashesPerSec is not an int64",
+			Code: btcjson.ErrOutOfRange.Code,
+			Message: fmt.Sprintf("Block height %d out of range -- "+
+				"must be between 0 and %d", c.Index, bestHeight),
 		}
 	}
 
-	result := &btcjson.GetMiningInfoResult{
-		Blocks:           height,
-		CurrentBlockSize: uint64(len(blockBytes)),
-		CurrentBlockTx:   uint64(len(block.MsgBlock().Transactions)),
-		Difficulty:       getDifficultyRatio(block.MsgBlock().Header.Bits),
-		Generate:         false, // no built-in miner
-		GenProcLimit:     -1,    // no built-in miner
-		HashesPerSec:     0,     // no built-in miner
-		NetworkHashPS:    networkHashesPerSec,
-		PooledTx:         uint64(s.server.txMemPool.Count()),
-		TestNet:          cfg.TestNet3,
+	sha, err := s.server.db.FetchBlockShaByHeight(c.Index)
+	if err != nil {
+		rpcsLog.Errorf("Error getting block: %v", err)
+		return nil, btcjson.ErrOutOfRange
 	}
-	return &result, nil
-}
 
-// handleGetNetTotals implements the getnettotals command.
-func handleGetNetTotals(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
-	totalBytesRecv, totalBytesSent := s.server.NetTotals()
-	reply := &btcjson.GetNetTotalsResult{
-		TotalBytesRecv: totalBytesRecv,
-		TotalBytesSent: totalBytesSent,
-		TimeMillis:     time.Now().UTC().UnixNano() / int64(time.Millisecond),
-	}
-	return reply, nil
+	return sha.String(), nil
 }
 
-// handleGetNetworkHashPS implements the getnetworkhashps command.
-func handleGetNetworkHashPS(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
-	c := cmd.(*btcjson.GetNetworkHashPSCmd)
-
-	_, newestHeight, err := s.server.db.NewestSha()
+// handleGetBlockHeader implements the getblockheader command.
+func handleGetBlockHeader(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
+	c := cmd.(*GetBlockHeaderCmd)
+	sha, err := btcwire.NewShaHashFromStr(c.Hash)
 	if err != nil {
-		return nil, btcjson.Error{
-			Code:    btcjson.ErrInternal.Code,
-			Message: err.Error(),
-		}
-	}
-
-	// When the passed height is too high or zero, just return 0 now
-	// since we can't reasonably calculate the number of network hashes
-	// per second from invalid values.  When it's negative, use the current
-	// best block height.
-	endHeight := int64(c.Height)
-	if endHeight > newestHeight || endHeight == 0 {
-		return 0, nil
-	}
-	if endHeight < 0 {
-		endHeight = newestHeight
+		rpcsLog.Errorf("Error generating sha: %v", err)
+		return nil, btcjson.ErrBlockNotFound
 	}
 
-	// Calculate the starting block height based on the passed number of
-	// blocks.  When the passed value is negative, use the last block the
-	// difficulty changed as the starting height.  Also make sure the
-	// starting height is not before the beginning of the chain.
-	var startHeight int64
-	if c.Blocks <= 0 {
-		startHeight = endHeight - ((endHeight % btcchain.BlocksPerRetarget) + 1)
-	} else {
-		startHeight = endHeight - int64(c.Blocks)
-	}
-	if startHeight < 0 {
-		startHeight = 0
+	// Only the header is loaded from the database rather than the entire
+	// block.
+	blockHeader, err := s.server.db.FetchBlockHeaderBySha(sha)
+	if err != nil {
+		rpcsLog.Errorf("Error fetching header: %v", err)
+		return nil, btcjson.ErrBlockNotFound
 	}
-	rpcsLog.Debugf("Calculating network hashes per second from %d to %d",
-		startHeight, endHeight)
 
-	// Find the min and max block timestamps as well as calculate the total
-	// amount of work that happened between the start and end blocks.
-	var minTimestamp, maxTimestamp time.Time
-	totalWork := big.NewInt(0)
-	for curHeight := startHeight; curHeight <= endHeight; curHeight++ {
-		hash, err := s.server.db.FetchBlockShaByHeight(curHeight)
-		if err != nil {
-			return nil, btcjson.Error{
-				Code:    btcjson.ErrInternal.Code,
-				Message: err.Error(),
-			}
-		}
-
-		header, err := s.server.db.FetchBlockHeaderBySha(hash)
-		if err != nil {
+	// When the verbose flag isn't set, simply return the serialized block
+	// header as a hex-encoded string.
+	if !c.Verbose {
+		var headerBuf bytes.Buffer
+		if err := blockHeader.Serialize(&headerBuf); err != nil {
+			return nil, btcjson.Error{
+				Code:    btcjson.ErrInternal.Code,
+				Message: err.Error(),
+			}
+		}
+		return hex.EncodeToString(headerBuf.Bytes()), nil
+	}
+
+	// The verbose flag is set, so generate the JSON object and return it.
+	idx, err := s.server.db.FetchBlockHeightBySha(sha)
+	if err != nil {
+		rpcsLog.Errorf("Error fetching block height: %v", err)
+		return nil, btcjson.ErrBlockNotFound
+	}
+	_, maxidx, err := s.server.db.NewestSha()
+	if err != nil {
+		rpcsLog.Errorf("Cannot get newest sha: %v", err)
+		return nil, btcjson.ErrBlockNotFound
+	}
+
+	headerReply := GetBlockHeaderVerboseResult{
+		Hash:          c.Hash,
+		Confirmations: uint64(1 + maxidx - idx),
+		Height:        idx,
+		Version:       blockHeader.Version,
+		MerkleRoot:    blockHeader.MerkleRoot.String(),
+		Time:          blockHeader.Timestamp.Unix(),
+		Nonce:         blockHeader.Nonce,
+		Bits:          strconv.FormatInt(int64(blockHeader.Bits), 16),
+		Difficulty:    getDifficultyRatio(blockHeader.Bits),
+	}
+
+	// The genesis block does not have a previous block.
+	if idx > 0 {
+		headerReply.PreviousHash = blockHeader.PrevBlock.String()
+	}
+
+	// Get next block unless we are already at the top.
+	if idx < maxidx {
+		shaNext, err := s.server.db.FetchBlockShaByHeight(idx + 1)
+		if err != nil {
+			rpcsLog.Errorf("No next block: %v", err)
+			return nil, btcjson.ErrBlockNotFound
+		}
+		headerReply.NextHash = shaNext.String()
+	}
+
+	return headerReply, nil
+}
+
+// blockStatNames houses the names of all of the statistics returned by the
+// getblockstats command.
+var blockStatNames = map[string]struct{}{
+	"avgfee":        struct{}{},
+	"avgfeerate":    struct{}{},
+	"avgtxsize":     struct{}{},
+	"blockhash":     struct{}{},
+	"height":        struct{}{},
+	"ins":           struct{}{},
+	"maxfee":        struct{}{},
+	"maxfeerate":    struct{}{},
+	"maxtxsize":     struct{}{},
+	"medianfee":     struct{}{},
+	"mediantxsize":  struct{}{},
+	"minfee":        struct{}{},
+	"minfeerate":    struct{}{},
+	"mintxsize":     struct{}{},
+	"outs":          struct{}{},
+	"subsidy":       struct{}{},
+	"time":          struct{}{},
+	"total_out":     struct{}{},
+	"total_size":    struct{}{},
+	"totalfee":      struct{}{},
+	"txs":           struct{}{},
+	"utxo_increase": struct{}{},
+}
+
+// updateBlockTemplate regenerates the block template returned by
+// getblocktemplate when the best block has changed or when the transactions in
+// the memory pool have changed and the template is at least
+// gbtRegenerateInterval old.  Otherwise, only the time of the existing template
+// is updated.
+//
+// This function MUST be called with the gbt work state locked.
+func (s *rpcServer) updateBlockTemplate() error {
+	state := s.gbtWorkState
+
+	lastTxUpdate := s.server.txMemPool.LastUpdated()
+	latestHash, _ := s.server.blockManager.chainState.Best()
+	if state.template == nil || state.prevHash == nil ||
+		!state.prevHash.IsEqual(latestHash) ||
+		(state.lastTxUpdate != lastTxUpdate &&
+			time.Now().After(state.lastGenerated.Add(gbtRegenerateInterval))) {
+
+		// Reset the previous best hash the block template was generated
+		// against so any errors below cause the next invocation to try
+		// again.
+		state.prevHash = nil
+
+		// The coinbase of the template is not returned to callers since
+		// they create their own, so it does not need to pay to any
+		// particular script.
+		template, err := NewBlockTemplate(nil, s.server.txMemPool)
+		if err != nil {
+			return err
+		}
+
+		state.template = template
+		state.lastGenerated = time.Now()
+		state.lastTxUpdate = lastTxUpdate
+		state.prevHash = latestHash
+
+		rpcsLog.Debugf("Generated block template for getblocktemplate "+
+			"(timestamp %v, target %064x, %d transactions)",
+			template.block.Header.Timestamp,
+			btcchain.CompactToBig(template.block.Header.Bits),
+			len(template.block.Transactions))
+		return nil
+	}
+
+	// Update the time of the block template to the current time while
+	// accounting for the median time of the past several blocks per the
+	// chain consensus rules.
+	return UpdateBlockTime(state.template.block, s.server.blockManager)
+}
+
+// blockTemplateResult returns the getblocktemplate result for the current block
+// template.
+//
+// This function MUST be called with the gbt work state locked.
+func (s *rpcServer) blockTemplateResult() (*GetBlockTemplateResult, error) {
+	template := s.gbtWorkState.template
+	msgBlock := template.block
+	header := &msgBlock.Header
+
+	// Convert each transaction other than the coinbase to its result along
+	// with the one-based indices of the transactions in the template it
+	// depends on.
+	txIndex := make(map[btcwire.ShaHash]int64, len(msgBlock.Transactions))
+	transactions := make([]GetBlockTemplateResultTx, 0,
+		len(msgBlock.Transactions)-1)
+	for i, tx := range msgBlock.Transactions {
+		txHash, err := tx.TxSha()
+		if err != nil {
+			return nil, err
+		}
+		txIndex[txHash] = int64(i)
+		if i == 0 {
+			continue
+		}
+
+		depends := make([]int64, 0)
+		for _, txIn := range tx.TxIn {
+			index, ok := txIndex[txIn.PreviousOutpoint.Hash]
+			if !ok || containsInt64(depends, index) {
+				continue
+			}
+			depends = append(depends, index)
+		}
+
+		txHex, err := messageToHex(tx)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, GetBlockTemplateResultTx{
+			Data:    txHex,
+			TxID:    txHash.String(),
+			Hash:    txHash.String(),
+			Depends: depends,
+			Fee:     template.fees[i],
+			SigOps:  template.sigOpCounts[i],
+		})
+	}
+
+	// The earliest time a block built from the template may have is one
+	// second after the median time of the past several blocks.
+	chainState := &s.server.blockManager.chainState
+	chainState.Lock()
+	minTime := chainState.pastMedianTime.Add(time.Second)
+	chainState.Unlock()
+
+	target := btcchain.CompactToBig(header.Bits)
+	return &GetBlockTemplateResult{
+		Version:       header.Version,
+		PreviousHash:  header.PrevBlock.String(),
+		Transactions:  transactions,
+		CoinbaseAux:   gbtCoinbaseAux,
+		CoinbaseValue: msgBlock.Transactions[0].TxOut[0].Value,
+		Target:        fmt.Sprintf("%064x", target),
+		MinTime:       minTime.Unix(),
+		Mutable:       gbtMutableFields,
+		NonceRange:    gbtNonceRange,
+		SigOpLimit:    btcchain.MaxSigOpsPerBlock,
+		SizeLimit:     btcwire.MaxBlockPayload,
+		CurTime:       header.Timestamp.Unix(),
+		Bits:          fmt.Sprintf("%08x", header.Bits),
+		Height:        template.height,
+		LongPollID: encodeLongPollID(s.gbtWorkState.prevHash,
+			s.gbtWorkState.lastGenerated),
+	}, nil
+}
+
+// containsInt64 returns whether or not the passed value is in the passed slice.
+func containsInt64(values []int64, value int64) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// checkProposalSanity performs the checks on a block proposal which don't need
+// the previous outputs the transactions spend and returns the BIP0023 reject
+// reason for the first one which fails, or an empty string when all of them
+// pass.  These mirror the context-free block sanity checks except the proof of
+// work, which proposals are not expected to satisfy, along with the checks of
+// the difficulty bits and timestamp against the current best chain.
+func checkProposalSanity(s *rpcServer, block *btcutil.Block) (string, error) {
+	msgBlock := block.MsgBlock()
+	header := &msgBlock.Header
+	if len(msgBlock.Transactions) == 0 ||
+		msgBlock.SerializeSize() > btcwire.MaxBlockPayload {
+
+		return "bad-blk-length", nil
+	}
+
+	// The first transaction, and only the first, must be a coinbase.
+	transactions := block.Transactions()
+	if !btcchain.IsCoinBase(transactions[0]) {
+		return "bad-cb-missing", nil
+	}
+	existingTxHashes := make(map[btcwire.ShaHash]struct{})
+	for i, tx := range transactions {
+		if i != 0 && btcchain.IsCoinBase(tx) {
+			return "bad-cb-multiple", nil
+		}
+		if err := btcchain.CheckTransactionSanity(tx); err != nil {
+			return "bad-txns", nil
+		}
+		if _, exists := existingTxHashes[*tx.Sha()]; exists {
+			return "bad-txns-duplicate", nil
+		}
+		existingTxHashes[*tx.Sha()] = struct{}{}
+	}
+	merkles := btcchain.BuildMerkleTreeStore(transactions)
+	if !header.MerkleRoot.IsEqual(merkles[len(merkles)-1]) {
+		return "bad-txnmrklroot", nil
+	}
+
+	// The difficulty bits must be the ones required for the next block
+	// and the timestamp must be after the median time of the last several
+	// blocks and not too far in the future.
+	bits, err := s.server.blockManager.CalcNextRequiredDifficulty(
+		header.Timestamp)
+	if err != nil {
+		return "", err
+	}
+	if header.Bits != bits {
+		return "bad-diffbits", nil
+	}
+	chainState := &s.server.blockManager.chainState
+	chainState.Lock()
+	medianTime := chainState.pastMedianTime
+	chainState.Unlock()
+	if !header.Timestamp.After(medianTime) {
+		return "time-too-old", nil
+	}
+	maxTimestamp := s.server.timeSource.AdjustedTime().Add(time.Hour * 2)
+	if header.Timestamp.After(maxTimestamp) {
+		return "time-too-new", nil
+	}
+	return "", nil
+}
+
+// handleGetBlockTemplateProposal is a helper for handleGetBlockTemplate which
+// checks the block proposed by the caller as defined by BIP0023.  The result is
+// null when the block would be accepted as the next block of the main chain
+// apart from its proof of work, or a string describing why it would not.
+func handleGetBlockTemplateProposal(s *rpcServer, request *TemplateRequest) (interface{}, error) {
+	if request.Data == "" {
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrInvalidParameter.Code,
+			Message: "Data must be provided in proposal mode",
+		}
+	}
+	serializedBlock, err := hex.DecodeString(request.Data)
+	if err != nil {
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrDeserialization.Code,
+			Message: "Block decode failed",
+		}
+	}
+	block, err := btcutil.NewBlockFromBytes(serializedBlock)
+	if err != nil {
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrDeserialization.Code,
+			Message: "Block decode failed",
+		}
+	}
+
+	// Blocks which are already known are reported distinctly.
+	blockHash, err := block.Sha()
+	if err != nil {
+		return nil, err
+	}
+	if s.server.db.ExistsSha(blockHash) {
+		return "duplicate", nil
+	}
+
+	// Only blocks which extend the current best block can be checked.
+	latestHash, latestHeight := s.server.blockManager.chainState.Best()
+	if !block.MsgBlock().Header.PrevBlock.IsEqual(latestHash) {
+		return "inconclusive-not-best-prevblk", nil
+	}
+
+	reason, err := checkProposalSanity(s, block)
+	if err != nil {
+		return nil, btcjson.Error{
+			Code: btcjson.ErrInternal.Code,
+			Message: fmt.Sprintf("Unexpected error while checking "+
+				"block: %v", err),
+		}
+	}
+	if reason != "" {
+		rpcsLog.Infof("Block proposal %v rejected: %s", blockHash,
+			reason)
+		return reason, nil
+	}
+
+	block.SetHeight(latestHeight + 1)
+	if err := s.server.blockManager.CheckConnectBlock(block); err != nil {
+		if _, ok := err.(btcchain.RuleError); !ok {
+			return nil, btcjson.Error{
+				Code: btcjson.ErrInternal.Code,
+				Message: fmt.Sprintf("Unexpected error while "+
+					"checking block: %v", err),
+			}
+		}
+		rpcsLog.Infof("Block proposal %v rejected: %v", blockHash, err)
+		return fmt.Sprintf("rejected: %v", err), nil
+	}
+	return nil, nil
+}
+
+// handleGetBlockTemplateLongPoll is a helper for handleGetBlockTemplate which
+// handles requests with a long poll id.  The current block template is returned
+// immediately when it is not the template identified by the long poll id.
+// Otherwise, the reply is delayed until the best block changes or
+// gbtLongPollTimeout elapses.  Waiting stops early when the client disconnects
+// or the server shuts down.
+func handleGetBlockTemplateLongPoll(s *rpcServer, longPollID string, closeChan <-chan bool) (interface{}, error) {
+	prevHash, lastGenerated, err := decodeLongPollID(longPollID)
+	if err != nil {
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrInvalidParameter.Code,
+			Message: "Invalid longpollid",
+		}
+	}
+
+	// Grab the channel which is closed when the best block changes before
+	// the template is updated so a change which happens before waiting on
+	// it is not missed.
+	state := s.gbtWorkState
+	state.tipMtx.Lock()
+	newTipChan := state.newTipChan
+	state.tipMtx.Unlock()
+
+	state.Lock()
+	if err := s.updateBlockTemplate(); err != nil {
+		state.Unlock()
+		return nil, gbtTemplateError(err)
+	}
+	if !prevHash.IsEqual(state.prevHash) ||
+		lastGenerated != state.lastGenerated.Unix() {
+
+		defer state.Unlock()
+		return s.blockTemplateResult()
+	}
+	state.Unlock()
+
+	timer := time.NewTimer(gbtLongPollTimeout)
+	defer timer.Stop()
+	select {
+	case <-newTipChan:
+	case <-timer.C:
+	case <-closeChan:
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrInternal.Code,
+			Message: "Client disconnected",
+		}
+	case <-s.quit:
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrInternal.Code,
+			Message: "Server shutting down",
+		}
+	}
+
+	state.Lock()
+	defer state.Unlock()
+	if err := s.updateBlockTemplate(); err != nil {
+		return nil, gbtTemplateError(err)
+	}
+	return s.blockTemplateResult()
+}
+
+// gbtTemplateError logs and returns the error to reply with when the block
+// template for getblocktemplate could not be created.
+func gbtTemplateError(err error) error {
+	errStr := fmt.Sprintf("Failed to create new block template: %v", err)
+	rpcsLog.Errorf(errStr)
+	return btcjson.Error{
+		Code:    btcjson.ErrInternal.Code,
+		Message: errStr,
+	}
+}
+
+// handleGetBlockTemplate implements the getblocktemplate command as defined by
+// BIP0022 and BIP0023.  The block template is assembled the same way as the
+// blocks generated for getwork and is regenerated when the best block changes
+// or periodically when the transactions in the memory pool change.
+func handleGetBlockTemplate(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
+	c := cmd.(*GetBlockTemplateCmd)
+
+	mode := "template"
+	if c.Request != nil && c.Request.Mode != "" {
+		mode = c.Request.Mode
+	}
+	switch mode {
+	case "template":
+	case "proposal":
+		return handleGetBlockTemplateProposal(s, c.Request)
+	default:
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrInvalidParameter.Code,
+			Message: "Invalid mode",
+		}
+	}
+
+	// Return an error if there are no peers connected since there is no
+	// way to relay a found block or receive transactions to work on.
+	// However, allow this state when running in the regression test or
+	// simulation test mode.
+	if !(cfg.RegressionTest || cfg.SimNet) && s.server.ConnectedCount() == 0 {
+		return nil, btcjson.ErrClientNotConnected
+	}
+
+	// No point in generating templates before the chain is synced.
+	_, currentHeight := s.server.blockManager.chainState.Best()
+	if currentHeight != 0 && !s.server.blockManager.IsCurrent() {
+		return nil, btcjson.ErrClientInInitialDownload
+	}
+
+	if c.Request != nil && c.Request.LongPollID != "" {
+		return handleGetBlockTemplateLongPoll(s, c.Request.LongPollID,
+			closeChan)
+	}
+
+	// Protect concurrent access from multiple RPC invocations.
+	s.gbtWorkState.Lock()
+	defer s.gbtWorkState.Unlock()
+
+	if err := s.updateBlockTemplate(); err != nil {
+		return nil, gbtTemplateError(err)
+	}
+	return s.blockTemplateResult()
+}
+
+// handleGetBlockStats implements the getblockstats command.
+func handleGetBlockStats(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
+	c := cmd.(*GetBlockStatsCmd)
+
+	// Reject unknown statistics before doing any work.
+	for _, stat := range c.Stats {
+		if _, ok := blockStatNames[stat]; !ok {
+			return nil, btcjson.Error{
+				Code: btcjson.ErrInvalidParameter.Code,
+				Message: fmt.Sprintf("Invalid selected statistic %s",
+					stat),
+			}
+		}
+	}
+
+	// Look up the hash of the block when it is identified by height.
+	var sha *btcwire.ShaHash
+	if c.Hash != "" {
+		var err error
+		sha, err = btcwire.NewShaHashFromStr(c.Hash)
+		if err != nil {
+			rpcsLog.Errorf("Error generating sha: %v", err)
+			return nil, btcjson.ErrBlockNotFound
+		}
+	} else {
+		_, maxidx, err := s.server.db.NewestSha()
+		if err != nil {
+			rpcsLog.Errorf("Cannot get newest sha: %v", err)
+			return nil, btcjson.ErrBlockNotFound
+		}
+		if c.Height < 0 || c.Height > maxidx {
+			return nil, btcjson.Error{
+				Code:    btcjson.ErrOutOfRange.Code,
+				Message: "Block number out of range.",
+			}
+		}
+		sha, err = s.server.db.FetchBlockShaByHeight(c.Height)
+		if err != nil {
+			rpcsLog.Errorf("Error getting block: %v", err)
+			return nil, btcjson.ErrBlockNotFound
+		}
+	}
+
+	blk, err := s.server.db.FetchBlockBySha(sha)
+	if err != nil {
+		rpcsLog.Errorf("Error fetching sha: %v", err)
+		return nil, btcjson.ErrBlockNotFound
+	}
+
+	stats, err := calcBlockStats(s.server.db, blk)
+	if err != nil {
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrInternal.Code,
+			Message: err.Error(),
+		}
+	}
+
+	// Only return the requested statistics when any were specified.
+	if len(c.Stats) == 0 {
+		return stats, nil
+	}
+	reply := make(map[string]interface{}, len(c.Stats))
+	for _, stat := range c.Stats {
+		reply[stat] = stats[stat]
+	}
+	return reply, nil
+}
+
+// calcBlockStats returns all of the statistics reported by the getblockstats
+// command for the passed block keyed by their names.  The transactions
+// referenced by the inputs of the block are loaded from the passed database to
+// calculate the fees.
+func calcBlockStats(db btcdb.Db, blk *btcutil.Block) (map[string]interface{}, error) {
+	// Keep track of the transactions in the block so inputs which spend
+	// the outputs of earlier transactions in the same block don't need to
+	// be loaded from the database.
+	blockTxns := make(map[btcwire.ShaHash]*btcwire.MsgTx)
+
+	transactions := blk.Transactions()
+	fees := make([]int64, 0, len(transactions))
+	feeRates := make([]int64, 0, len(transactions))
+	txSizes := make([]int64, 0, len(transactions))
+	var totalFee, totalOut, totalSize, ins, outs, utxoIncrease int64
+	for i, tx := range transactions {
+		msgTx := tx.MsgTx()
+		blockTxns[*tx.Sha()] = msgTx
+		outs += int64(len(msgTx.TxOut))
+		utxoIncrease += int64(len(msgTx.TxOut))
+
+		// The coinbase only counts towards the outputs.
+		if i == 0 {
+			continue
+		}
+		ins += int64(len(msgTx.TxIn))
+		utxoIncrease -= int64(len(msgTx.TxIn))
+
+		var inValue int64
+		for _, txIn := range msgTx.TxIn {
+			prevOut := &txIn.PreviousOutpoint
+			originTx, ok := blockTxns[prevOut.Hash]
+			if !ok {
+				txList, err := db.FetchTxBySha(&prevOut.Hash)
+				if err != nil || len(txList) == 0 {
+					return nil, fmt.Errorf("unable to fetch "+
+						"input transaction %v: %v",
+						prevOut.Hash, err)
+				}
+				originTx = txList[len(txList)-1].Tx
+			}
+			if prevOut.Index >= uint32(len(originTx.TxOut)) {
+				return nil, fmt.Errorf("input %v references a "+
+					"non-existent output", prevOut)
+			}
+			inValue += originTx.TxOut[prevOut.Index].Value
+		}
+
+		var outValue int64
+		for _, txOut := range msgTx.TxOut {
+			outValue += txOut.Value
+		}
+
+		fee := inValue - outValue
+		size := int64(msgTx.SerializeSize())
+		fees = append(fees, fee)
+		feeRates = append(feeRates, fee*1000/size)
+		txSizes = append(txSizes, size)
+		totalFee += fee
+		totalOut += outValue
+		totalSize += size
+	}
+
+	var avgFee, avgFeeRate, avgTxSize int64
+	if numTxns := int64(len(fees)); numTxns > 0 {
+		avgFee = totalFee / numTxns
+		avgFeeRate = totalFee * 1000 / totalSize
+		avgTxSize = totalSize / numTxns
+	}
+	minFee, maxFee, medianFee := int64Stats(fees)
+	minFeeRate, maxFeeRate, _ := int64Stats(feeRates)
+	minTxSize, maxTxSize, medianTxSize := int64Stats(txSizes)
+
+	header := &blk.MsgBlock().Header
+	blockSha, err := blk.Sha()
+	if err != nil {
+		return nil, err
+	}
+	subsidy := btcchain.CalcBlockSubsidy(blk.Height(), activeNetParams.Params)
+	return map[string]interface{}{
+		"avgfee":        avgFee,
+		"avgfeerate":    avgFeeRate,
+		"avgtxsize":     avgTxSize,
+		"blockhash":     blockSha.String(),
+		"height":        blk.Height(),
+		"ins":           ins,
+		"maxfee":        maxFee,
+		"maxfeerate":    maxFeeRate,
+		"maxtxsize":     maxTxSize,
+		"medianfee":     medianFee,
+		"mediantxsize":  medianTxSize,
+		"minfee":        minFee,
+		"minfeerate":    minFeeRate,
+		"mintxsize":     minTxSize,
+		"outs":          outs,
+		"subsidy":       subsidy,
+		"time":          header.Timestamp.Unix(),
+		"total_out":     totalOut,
+		"total_size":    totalSize,
+		"totalfee":      totalFee,
+		"txs":           len(transactions),
+		"utxo_increase": utxoIncrease,
+	}, nil
+}
+
+// int64Stats returns the minimum, maximum, and median of the passed values.
+// The median of an even number of values is the average of the two middle
+// values.  All of them are 0 when no values are passed.
+func int64Stats(values []int64) (int64, int64, int64) {
+	if len(values) == 0 {
+		return 0, 0, 0
+	}
+
+	sorted := make([]int64, len(values))
+	copy(sorted, values)
+	sort.Sort(int64Sorter(sorted))
+
+	n := len(sorted)
+	median := sorted[n/2]
+	if n%2 == 0 {
+		median = (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+	return sorted[0], sorted[n-1], median
+}
+
+// int64Sorter implements sort.Interface to allow a slice of 64-bit integers to
+// be sorted in ascending order.
+type int64Sorter []int64
+
+// Len returns the number of 64-bit integers in the slice.  It is part of the
+// sort.Interface implementation.
+func (s int64Sorter) Len() int {
+	return len(s)
+}
+
+// Swap swaps the 64-bit integers at the passed indices.  It is part of the
+// sort.Interface implementation.
+func (s int64Sorter) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+// Less returns whether the 64-bit integer with index i should sort before the
+// 64-bit integer with index j.  It is part of the sort.Interface
+// implementation.
+func (s int64Sorter) Less(i, j int) bool {
+	return s[i] < s[j]
+}
+
+// handleGetCFilter implements the getcfilter command.
+func handleGetCFilter(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
+	idx := s.server.cfIndex
+	if idx == nil {
+		return nil, btcjson.Error{
+			Code: btcjson.ErrMisc.Code,
+			Message: "Compact filter index must be enabled " +
+				"(--cfilters) to retrieve compact filters",
+		}
+	}
+
+	c := cmd.(*GetCFilterCmd)
+	sha, err := btcwire.NewShaHashFromStr(c.Hash)
+	if err != nil {
+		rpcsLog.Errorf("Error generating sha: %v", err)
+		return nil, btcjson.ErrBlockNotFound
+	}
+	filter, header, err := idx.FilterByBlockHash(sha)
+	if err == errCFIndexUnavailable {
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrMisc.Code,
+			Message: err.Error(),
+		}
+	}
+	if err != nil {
+		rpcsLog.Debugf("No compact filter for block %v: %v", sha, err)
+		return nil, btcjson.ErrBlockNotFound
+	}
+
+	return &GetCFilterResult{
+		Filter: hex.EncodeToString(filter),
+		Header: header.String(),
+	}, nil
+}
+
+// handleGetChainTips implements the getchaintips command.
+func handleGetChainTips(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
+	tips := s.server.blockManager.ChainTips()
+
+	// Order the tips by descending height.  A stable sort is used so the
+	// active chain tip is listed before any side chain tips at the same
+	// height.
+	sort.Stable(chainTipsByHeight(tips))
+
+	result := make([]GetChainTipsResult, 0, len(tips))
+	for _, tip := range tips {
+		result = append(result, GetChainTipsResult{
+			Height:    tip.height,
+			Hash:      tip.hash.String(),
+			BranchLen: tip.branchLen,
+			Status:    tip.status,
+		})
+	}
+	return result, nil
+}
+
+// chainTipsByHeight provides sorting of chain tips by descending height.
+type chainTipsByHeight []chainTip
+
+// Len returns the number of chain tips.  It is part of the sort.Interface
+// implementation.
+func (s chainTipsByHeight) Len() int {
+	return len(s)
+}
+
+// Swap swaps the chain tips at the passed indices.  It is part of the
+// sort.Interface implementation.
+func (s chainTipsByHeight) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+// Less returns whether the chain tip with index i should sort before the
+// chain tip with index j.  It is part of the sort.Interface implementation.
+func (s chainTipsByHeight) Less(i, j int) bool {
+	return s[i].height > s[j].height
+}
+
+// handleGetConnectionCount implements the getconnectioncount command.
+func handleGetConnectionCount(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
+	return s.server.ConnectedCount(), nil
+}
+
+// handleGetCurrentNet implements the getcurrentnet command.
+func handleGetCurrentNet(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
+	return s.server.netParams.Net, nil
+}
+
+// handleGetDifficulty implements the getdifficulty command.
+func handleGetDifficulty(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
+	sha, _, err := s.server.db.NewestSha()
+	if err != nil {
+		rpcsLog.Errorf("Error getting sha: %v", err)
+		return nil, btcjson.ErrDifficulty
+	}
+	blockHeader, err := s.server.db.FetchBlockHeaderBySha(sha)
+	if err != nil {
+		rpcsLog.Errorf("Error getting block: %v", err)
+		return nil, btcjson.ErrDifficulty
+	}
+	return getDifficultyRatio(blockHeader.Bits), nil
+}
+
+// handleGetGenerate implements the getgenerate command.
+func handleGetGenerate(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
+	return s.server.cpuMiner.IsMining(), nil
+}
+
+// handleGetHashesPerSec implements the gethashespersec command.
+func handleGetHashesPerSec(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
+	return s.server.cpuMiner.HashesPerSecond(), nil
+}
+
+// rpcVersion returns the version of btcd in the numeric form reported by the
+// getinfo and getnetworkinfo commands.
+func rpcVersion() int32 {
+	return int32(1000000*appMajor + 10000*appMinor + 100*appPatch)
+}
+
+// rpcRelayFee returns the minimum relay fee in BTC per kilobyte as reported by
+// the getinfo and getnetworkinfo commands.
+func rpcRelayFee() float64 {
+	return float64(cfg.minRelayTxFee) / float64(btcutil.SatoshiPerBitcoin)
+}
+
+// handleGetInfo implements the deprecated getinfo command. We only return the
+// fields that are not related to wallet functionality.  The fields are sourced
+// from the same state as the more specific commands which replace it, such as
+// getblockcount, getdifficulty, and getnetworkinfo, so they always agree.
+func handleGetInfo(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
+	// The height and difficulty are both taken from the same block so they
+	// are consistent even when a new block is connected meanwhile.
+	sha, height, err := s.server.db.NewestSha()
+	if err != nil {
+		rpcsLog.Errorf("Error getting sha: %v", err)
+		return nil, btcjson.ErrBlockCount
+	}
+	blockHeader, err := s.server.db.FetchBlockHeaderBySha(sha)
+	if err != nil {
+		rpcsLog.Errorf("Error getting block: %v", err)
+		return nil, btcjson.ErrDifficulty
+	}
+
+	ret := &btcjson.InfoResult{
+		Version:         int(rpcVersion()),
+		ProtocolVersion: int(maxProtocolVersion),
+		Blocks:          int(height),
+		TimeOffset:      int64(s.server.timeSource.Offset().Seconds()),
+		Connections:     s.server.ConnectedCount(),
+		Proxy:           cfg.Proxy,
+		Difficulty:      getDifficultyRatio(blockHeader.Bits),
+		TestNet:         cfg.TestNet3,
+		RelayFee:        rpcRelayFee(),
+	}
+
+	return ret, nil
+}
+
+// handleGetMempoolEntry implements the getmempoolentry command.
+func handleGetMempoolEntry(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
+	c := cmd.(*GetMempoolEntryCmd)
+
+	txSha, err := btcwire.NewShaHashFromStr(c.TxID)
+	if err != nil {
+		str := fmt.Sprintf("argument must be hexadecimal string "+
+			"(not %q)", c.TxID)
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrDecodeHexString.Code,
+			Message: str,
+		}
+	}
+
+	// Only transactions in the main pool are considered.  The entry is
+	// calculated with the mempool lock held, so it reflects a consistent
+	// view of the pool.
+	if !s.server.txMemPool.IsTransactionInPool(txSha) {
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrNoTxInfo.Code,
+			Message: "Transaction not in mempool",
+		}
+	}
+	entry, err := s.server.txMemPool.FetchTxPoolEntry(txSha)
+	if err != nil {
+		rpcsLog.Errorf("Error fetching mempool entry for %v: %v",
+			txSha, err)
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrNoTxInfo.Code,
+			Message: err.Error(),
+		}
+	}
+
+	hashStrings := func(hashes []*btcwire.ShaHash) []string {
+		strs := make([]string, 0, len(hashes))
+		for _, hash := range hashes {
+			strs = append(strs, hash.String())
+		}
+		return strs
+	}
+	toBTC := func(amount int64) float64 {
+		return float64(amount) / float64(btcutil.SatoshiPerBitcoin)
+	}
+
+	result := &GetMempoolEntryResult{
+		Size:             entry.Tx.MsgTx().SerializeSize(),
+		Fee:              toBTC(entry.Fee),
+		Time:             entry.Added.Unix(),
+		Height:           entry.Height,
+		StartingPriority: entry.StartingPriority,
+		CurrentPriority:  entry.CurrentPriority,
+		Depends:          hashStrings(entry.Depends),
+		SpentBy:          hashStrings(entry.SpentBy),
+		AncestorCount:    entry.AncestorCount,
+		AncestorSize:     entry.AncestorSize,
+		AncestorFees:     toBTC(entry.AncestorFees),
+		DescendantCount:  entry.DescendantCount,
+		DescendantSize:   entry.DescendantSize,
+		DescendantFees:   toBTC(entry.DescendantFees),
+	}
+	return result, nil
+}
+
+// handleGetMempoolInfo implements the getmempoolinfo command.
+func handleGetMempoolInfo(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
+	numTxns, numBytes := s.server.txMemPool.Info()
+	result := &GetMempoolInfoResult{
+		Size:       numTxns,
+		Bytes:      numBytes,
+		MaxMempool: maxPoolBytes(),
+	}
+	return result, nil
+}
+
+// handleGetMiningInfo implements the getmininginfo command. We only return the
+// fields that are not related to wallet functionality.
+func handleGetMiningInfo(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
+	sha, height, err := s.server.db.NewestSha()
+	if err != nil {
+		rpcsLog.Errorf("Error getting sha: %v", err)
+		return nil, btcjson.ErrBlockCount
+	}
+	block, err := s.server.db.FetchBlockBySha(sha)
+	if err != nil {
+		rpcsLog.Errorf("Error getting block: %v", err)
+		return nil, btcjson.ErrBlockNotFound
+	}
+	blockBytes, err := block.Bytes()
+	if err != nil {
+		rpcsLog.Errorf("Error getting block: %v", err)
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrInternal.Code,
+			Message: err.Error(),
+		}
+	}
+
+	// Create a default getnetworkhashps command to use defaults and make
+	// use of the existing getnetworkhashps handler.
+	gnhpsCmd, err := btcjson.NewGetNetworkHashPSCmd(0)
+	if err != nil {
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrInternal.Code,
+			Message: err.Error(),
+		}
+	}
+	networkHashesPerSecIface, err := handleGetNetworkHashPS(s, gnhpsCmd, closeChan)
+	if err != nil {
+		// This is already a btcjson.Error from the handler.
+		return nil, err
+	}
+	networkHashesPerSec, ok := networkHashesPerSecIface.(int64)
+	if !ok {
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrInternal.Code,
+			Message: "networkHashesPerSec is not an int64",
+		}
+	}
+
+	result := &GetMiningInfoResult{
+		Blocks:           height,
+		CurrentBlockSize: uint64(len(blockBytes)),
+		CurrentBlockTx:   uint64(len(block.MsgBlock().Transactions)),
+		Difficulty:       getDifficultyRatio(block.MsgBlock().Header.Bits),
+		Errors:           "",
+		Generate:         s.server.cpuMiner.IsMining(),
+		GenProcLimit:     s.server.cpuMiner.NumWorkers(),
+		HashesPerSec:     s.server.cpuMiner.HashesPerSecond(),
+		NetworkHashPS:    networkHashesPerSec,
+		PooledTx:         uint64(s.server.txMemPool.Count()),
+		TestNet:          cfg.TestNet3,
+		Chain:            chainName(activeNetParams),
+	}
+	return result, nil
+}
+
+// handleGetNetTotals implements the getnettotals command.
+func handleGetNetTotals(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
+	totalBytesRecv, totalBytesSent := s.server.NetTotals()
+	bytesRecvPerMsg, bytesSentPerMsg := s.server.NetTotalsPerCommand()
+	uploadTarget := s.server.UploadTargetInfo()
+	reply := &GetNetTotalsResult{
+		GetNetTotalsResult: &btcjson.GetNetTotalsResult{
+			TotalBytesRecv: totalBytesRecv,
+			TotalBytesSent: totalBytesSent,
+			TimeMillis:     time.Now().UTC().UnixNano() / int64(time.Millisecond),
+		},
+		BytesRecvPerMsg: bytesRecvPerMsg,
+		BytesSentPerMsg: bytesSentPerMsg,
+		UploadTarget: UploadTargetResult{
+			TimeFrame:             int64(uploadTargetTimeframe / time.Second),
+			Target:                uploadTarget.target,
+			TargetReached:         uploadTarget.targetReached,
+			ServeHistoricalBlocks: uploadTarget.serveHistorical,
+			BytesLeftInCycle:      uploadTarget.bytesLeftInCycle,
+			TimeLeftInCycle:       int64(uploadTarget.timeLeftInCycle / time.Second),
+		},
+	}
+	return reply, nil
+}
+
+// handleGetNetworkHashPS implements the getnetworkhashps command.
+func handleGetNetworkHashPS(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
+	c := cmd.(*btcjson.GetNetworkHashPSCmd)
+
+	_, newestHeight, err := s.server.db.NewestSha()
+	if err != nil {
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrInternal.Code,
+			Message: err.Error(),
+		}
+	}
+
+	// When the passed height is too high or zero, just return 0 now
+	// since we can't reasonably calculate the number of network hashes
+	// per second from invalid values.  When it's negative, use the current
+	// best block height.
+	endHeight := int64(c.Height)
+	if endHeight > newestHeight || endHeight == 0 {
+		return 0, nil
+	}
+	if endHeight < 0 {
+		endHeight = newestHeight
+	}
+
+	// Calculate the starting block height based on the passed number of
+	// blocks.  When the passed value is negative, use the last block the
+	// difficulty changed as the starting height.  Also make sure the
+	// starting height is not before the beginning of the chain.
+	var startHeight int64
+	if c.Blocks <= 0 {
+		startHeight = endHeight - ((endHeight % btcchain.BlocksPerRetarget) + 1)
+	} else {
+		startHeight = endHeight - int64(c.Blocks)
+	}
+	if startHeight < 0 {
+		startHeight = 0
+	}
+	rpcsLog.Debugf("Calculating network hashes per second from %d to %d",
+		startHeight, endHeight)
+
+	// Find the min and max block timestamps as well as calculate the total
+	// amount of work that happened between the start and end blocks.  The
+	// work is calculated from the difficulty bits of each individual block
+	// rather than from the bits of the end block, so the estimate is not
+	// skewed when the window spans a difficulty adjustment.
+	var minTimestamp, maxTimestamp time.Time
+	totalWork := big.NewInt(0)
+	for curHeight := startHeight; curHeight <= endHeight; curHeight++ {
+		hash, err := s.server.db.FetchBlockShaByHeight(curHeight)
+		if err != nil {
+			return nil, btcjson.Error{
+				Code:    btcjson.ErrInternal.Code,
+				Message: err.Error(),
+			}
+		}
+
+		header, err := s.server.db.FetchBlockHeaderBySha(hash)
+		if err != nil {
 			return nil, btcjson.Error{
 				Code:    btcjson.ErrInternal.Code,
 				Message: err.Error(),
@@ -1325,26 +3167,95 @@ func handleGetNetworkHashPS(s *rpcServer, cmd btcjson.Cmd) (interface{}, error)
 	return hashesPerSec.Int64(), nil
 }
 
+// handleGetNetworkInfo implements the getnetworkinfo command.
+func handleGetNetworkInfo(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
+	// Build the user agent the same way it is advertised to peers.
+	msgVersion := btcwire.MsgVersion{UserAgent: btcwire.DefaultUserAgent}
+	msgVersion.AddUserAgent(userAgentName, userAgentVersion,
+		cfg.UserAgentComments...)
+
+	// Report each network along with whether or not connections to it are
+	// limited and the proxy used to reach it.
+	networks := make([]NetworksResult, 0, 3)
+	for _, network := range []string{"ipv4", "ipv6", "onion"} {
+		limited := !isNetAllowed(network)
+		proxy := cfg.Proxy
+		reachable := !limited
+		if network == "onion" {
+			limited = limited || cfg.NoOnion
+			if len(cfg.OnionProxies) > 0 {
+				proxy = strings.Join(cfg.OnionProxies, ",")
+			}
+			reachable = !limited && proxy != ""
+		}
+		networks = append(networks, NetworksResult{
+			Name:      network,
+			Limited:   limited,
+			Reachable: reachable,
+			Proxy:     proxy,
+		})
+	}
+
+	offset := s.server.timeSource.Offset()
+	result := &GetNetworkInfoResult{
+		Version:         rpcVersion(),
+		SubVersion:      msgVersion.UserAgent,
+		ProtocolVersion: int32(maxProtocolVersion),
+		LocalServices:   fmt.Sprintf("%016x", uint64(s.server.services)),
+		TimeOffset:      int64(offset.Seconds()),
+		Connections:     int32(s.server.ConnectedCount()),
+		Networks:        networks,
+		RelayFee:        rpcRelayFee(),
+		StartupTime:     s.server.startupTime.Unix(),
+	}
+	return result, nil
+}
+
+// handleGetNodeAddresses implements the getnodeaddresses command.
+func handleGetNodeAddresses(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
+	c := cmd.(*GetNodeAddressesCmd)
+	if c.Count < 0 {
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrInvalidParameter.Code,
+			Message: "Address count out of range",
+		}
+	}
+
+	addrs := s.server.addrManager.NodeAddresses(int(c.Count))
+	result := make([]GetNodeAddressesResult, 0, len(addrs))
+	for _, na := range addrs {
+		result = append(result, GetNodeAddressesResult{
+			Time:     na.Timestamp.Unix(),
+			Services: uint64(na.Services),
+			Address:  ipString(na),
+			Port:     na.Port,
+		})
+	}
+	return result, nil
+}
+
 // handleGetPeerInfo implements the getpeerinfo command.
-func handleGetPeerInfo(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
+func handleGetPeerInfo(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
 	return s.server.PeerInfo(), nil
 }
 
 // handleGetRawMempool implements the getrawmempool command.
-func handleGetRawMempool(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
+func handleGetRawMempool(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
 	c := cmd.(*btcjson.GetRawMempoolCmd)
 	descs := s.server.txMemPool.TxDescs()
 
 	if c.Verbose {
-		result := make(map[string]*btcjson.GetRawMempoolResult, len(descs))
+		relatives := s.server.txMemPool.TxRelatives()
+		result := make(map[string]*GetRawMempoolVerboseResult, len(descs))
 		for _, desc := range descs {
+			size := desc.Tx.MsgTx().SerializeSize()
 			mpd := &btcjson.GetRawMempoolResult{
-				Size: desc.Tx.MsgTx().SerializeSize(),
+				Size: size,
 				Fee: float64(desc.Fee) /
 					float64(btcutil.SatoshiPerBitcoin),
 				Time:             desc.Added.Unix(),
 				Height:           desc.Height,
-				StartingPriority: 0, // We don't mine.
+				StartingPriority: desc.StartingPriority,
 				CurrentPriority:  0, // We don't mine.
 				Depends:          make([]string, 0),
 			}
@@ -1356,7 +3267,24 @@ func handleGetRawMempool(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
 				}
 			}
 
-			result[desc.Tx.Sha().String()] = mpd
+			// Transactions added after the summaries of the
+			// relatives were calculated have no known relatives.
+			r, ok := relatives[*desc.Tx.Sha()]
+			if !ok {
+				r = TxRelatives{
+					AncestorCount:   1,
+					AncestorSize:    int64(size),
+					DescendantCount: 1,
+					DescendantSize:  int64(size),
+				}
+			}
+			result[desc.Tx.Sha().String()] = &GetRawMempoolVerboseResult{
+				GetRawMempoolResult: mpd,
+				AncestorCount:       r.AncestorCount,
+				AncestorSize:        r.AncestorSize,
+				DescendantCount:     r.DescendantCount,
+				DescendantSize:      r.DescendantSize,
+			}
 		}
 
 		return result, nil
@@ -1373,7 +3301,7 @@ func handleGetRawMempool(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
 }
 
 // handleGetRawTransaction implements the getrawtransaction command.
-func handleGetRawTransaction(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
+func handleGetRawTransaction(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
 	c := cmd.(*btcjson.GetRawTransactionCmd)
 
 	// Convert the provided transaction hash hex to a ShaHash.
@@ -1387,7 +3315,10 @@ func handleGetRawTransaction(s *rpcServer, cmd btcjson.Cmd) (interface{}, error)
 	}
 
 	// Try to fetch the transaction from the memory pool and if that fails,
-	// try the block database.
+	// try the block database.  The block database indexes every main chain
+	// transaction by its hash, including those whose outputs are all
+	// spent, so any confirmed transaction can be found without knowing its
+	// block and there is no need for an optional transaction index.
 	var mtx *btcwire.MsgTx
 	var blksha *btcwire.ShaHash
 	tx, err := s.server.txMemPool.FetchTransaction(txSha)
@@ -1401,44 +3332,158 @@ func handleGetRawTransaction(s *rpcServer, cmd btcjson.Cmd) (interface{}, error)
 		lastTx := len(txList) - 1
 		mtx = txList[lastTx].Tx
 
-		blksha = txList[lastTx].BlkSha
-	} else {
-		mtx = tx.MsgTx()
+		blksha = txList[lastTx].BlkSha
+	} else {
+		mtx = tx.MsgTx()
+	}
+
+	// When the verbose flag isn't set, simply return the network-serialized
+	// transaction as a hex-encoded string.
+	if c.Verbose == 0 {
+		mtxHex, err := messageToHex(mtx)
+		if err != nil {
+			return nil, err
+		}
+		return mtxHex, nil
+	}
+
+	var blk *btcutil.Block
+	var maxidx int64
+	if blksha != nil {
+		blk, err = s.server.db.FetchBlockBySha(blksha)
+		if err != nil {
+			rpcsLog.Errorf("Error fetching sha: %v", err)
+			return nil, btcjson.ErrBlockNotFound
+		}
+
+		_, maxidx, err = s.server.db.NewestSha()
+		if err != nil {
+			rpcsLog.Errorf("Cannot get newest sha: %v", err)
+			return nil, btcjson.ErrNoNewestBlockInfo
+		}
+	}
+
+	rawTxn, jsonErr := createTxRawResult(s.server.netParams, c.Txid, mtx,
+		blk, maxidx, blksha)
+	if err != nil {
+		rpcsLog.Errorf("Cannot create TxRawResult for txSha=%s: %v", txSha, err)
+		return nil, jsonErr
+	}
+	return *rawTxn, nil
+}
+
+// createScriptPubKeyResult returns a JSON object describing the passed public
+// key script.  It is used for the outputs returned by gettxout as well as the
+// outputs of decoded transactions.
+func createScriptPubKeyResult(pkScript []byte, net *btcnet.Params) (*ScriptPubKeyResult, error) {
+	disbuf, err := btcscript.DisasmString(pkScript)
+	if err != nil {
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrInternal.Code,
+			Message: err.Error(),
+		}
+	}
+
+	// Ignore the error here since an error means the script couldn't parse
+	// and there is no additional information about it anyways.
+	scriptClass, addrs, reqSigs, _ := btcscript.ExtractPkScriptAddrs(pkScript, net)
+	result := &ScriptPubKeyResult{
+		Asm:     disbuf,
+		Hex:     hex.EncodeToString(pkScript),
+		ReqSigs: reqSigs,
+		Type:    scriptClass.String(),
+	}
+	if addrs != nil {
+		result.Addresses = make([]string, len(addrs))
+		for i, addr := range addrs {
+			result.Addresses[i] = addr.EncodeAddress()
+		}
+	}
+	return result, nil
+}
+
+// handleGetTxOut implements the gettxout command.  The output is looked up in the
+// memory pool when requested and otherwise in the set of unspent transaction
+// outputs of the main chain.  Outputs which are spent or which do not exist
+// result in a null reply.
+func handleGetTxOut(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
+	c := cmd.(*btcjson.GetTxOutCmd)
+
+	txSha, err := btcwire.NewShaHashFromStr(c.Txid)
+	if err != nil {
+		str := fmt.Sprintf("argument must be hexadecimal string "+
+			"(not %q)", c.Txid)
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrDecodeHexString.Code,
+			Message: str,
+		}
+	}
+	if c.Output < 0 {
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrInvalidParameter.Code,
+			Message: "output index may not be negative",
+		}
+	}
+	outpoint := btcwire.OutPoint{Hash: *txSha, Index: uint32(c.Output)}
+
+	bestSha, bestHeight, err := s.server.db.NewestSha()
+	if err != nil {
+		rpcsLog.Errorf("Cannot get newest sha: %v", err)
+		return nil, btcjson.ErrNoNewestBlockInfo
 	}
 
-	// When the verbose flag isn't set, simply return the network-serialized
-	// transaction as a hex-encoded string.
-	if c.Verbose == 0 {
-		mtxHex, err := messageToHex(mtx)
-		if err != nil {
-			return nil, err
-		}
-		return mtxHex, nil
+	// Outputs which are spent by a transaction in the memory pool are
+	// treated as spent when the memory pool is included.
+	if c.IncludeMempool && s.server.txMemPool.CheckSpend(outpoint) != nil {
+		return nil, nil
 	}
 
-	var blk *btcutil.Block
-	var maxidx int64
-	if blksha != nil {
-		blk, err = s.server.db.FetchBlockBySha(blksha)
-		if err != nil {
-			rpcsLog.Errorf("Error fetching sha: %v", err)
-			return nil, btcjson.ErrBlockNotFound
+	// Look for the transaction in the memory pool first when requested and
+	// then fall back to the most recent main chain transaction with the
+	// hash.  Transactions in the memory pool have no confirmations.
+	var mtx *btcwire.MsgTx
+	var confirmations int64
+	if c.IncludeMempool {
+		if tx, err := s.server.txMemPool.FetchTransaction(txSha); err == nil {
+			mtx = tx.MsgTx()
+		}
+	}
+	if mtx == nil {
+		txList, err := s.server.db.FetchTxBySha(txSha)
+		if err != nil || len(txList) == 0 {
+			return nil, nil
 		}
+		txReply := txList[len(txList)-1]
+		if c.Output >= len(txReply.TxSpent) ||
+			txReply.TxSpent[c.Output] {
 
-		_, maxidx, err = s.server.db.NewestSha()
-		if err != nil {
-			rpcsLog.Errorf("Cannot get newest sha: %v", err)
-			return nil, btcjson.ErrNoNewestBlockInfo
+			return nil, nil
 		}
+		mtx = txReply.Tx
+		confirmations = bestHeight - txReply.Height + 1
+	}
+	if c.Output >= len(mtx.TxOut) {
+		return nil, nil
 	}
+	txOut := mtx.TxOut[c.Output]
 
-	rawTxn, jsonErr := createTxRawResult(s.server.netParams, c.Txid, mtx,
-		blk, maxidx, blksha)
+	scriptPubKey, err := createScriptPubKeyResult(txOut.PkScript,
+		s.server.netParams)
 	if err != nil {
-		rpcsLog.Errorf("Cannot create TxRawResult for txSha=%s: %v", txSha, err)
-		return nil, jsonErr
+		return nil, err
 	}
-	return *rawTxn, nil
+
+	// Report whether or not the output is from a coinbase along with its
+	// confirmations so callers can determine whether it has matured enough
+	// to be spent.
+	return &GetTxOutResult{
+		BestBlock:     bestSha.String(),
+		Confirmations: confirmations,
+		Value:         float64(txOut.Value) / float64(btcutil.SatoshiPerBitcoin),
+		ScriptPubKey:  scriptPubKey,
+		Version:       mtx.Version,
+		Coinbase:      btcchain.IsCoinBase(btcutil.NewTx(mtx)),
+	}, nil
 }
 
 // bigToLEUint256 returns the passed big integer as an unsigned 256-bit integer
@@ -1507,11 +3552,11 @@ func handleGetWorkRequest(s *rpcServer) (interface{}, error) {
 		// again.
 		state.prevHash = nil
 
-		// Choose a payment address at random.
+		// Choose a payment script at random.
 		rand.Seed(time.Now().UnixNano())
-		payToAddr := cfg.miningKeys[rand.Intn(len(cfg.miningKeys))]
+		payToScript := cfg.miningScripts[rand.Intn(len(cfg.miningScripts))]
 
-		template, err := NewBlockTemplate(payToAddr, s.server.txMemPool)
+		template, err := NewBlockTemplate(payToScript, s.server.txMemPool)
 		if err != nil {
 			errStr := fmt.Sprintf("Failed to create new block "+
 				"template: %v", err)
@@ -1748,7 +3793,11 @@ func handleGetWorkSubmission(s *rpcServer, hexData string) (interface{}, error)
 	// Process this block using the same rules as blocks coming from other
 	// nodes.  This will in turn relay it to the network like normal.
 	isOrphan, err := s.server.blockManager.ProcessBlock(block)
-	if err != nil || isOrphan {
+	if err == errDuplicateBlock {
+		rpcsLog.Infof("Block submitted via getwork rejected: duplicate")
+		return false, nil
+	}
+	if err != nil {
 		// Anything other than a rule violation is an unexpected error,
 		// so return that error as an internal error.
 		if _, ok := err.(btcchain.RuleError); !ok {
@@ -1762,6 +3811,10 @@ func handleGetWorkSubmission(s *rpcServer, hexData string) (interface{}, error)
 		rpcsLog.Infof("Block submitted via getwork rejected: %v", err)
 		return false, nil
 	}
+	if isOrphan {
+		rpcsLog.Infof("Block submitted via getwork rejected: orphan")
+		return false, nil
+	}
 
 	// The block was accepted.
 	blockSha, _ := block.Sha()
@@ -1770,15 +3823,16 @@ func handleGetWorkSubmission(s *rpcServer, hexData string) (interface{}, error)
 }
 
 // handleGetWork implements the getwork command.
-func handleGetWork(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
+func handleGetWork(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
 	c := cmd.(*btcjson.GetWorkCmd)
 
-	// Respond with an error if there are no public keys to pay the created
-	// blocks to.
-	if len(cfg.miningKeys) == 0 {
+	// Respond with an error if there are no public keys or scripts to pay
+	// the created blocks to.
+	if len(cfg.miningScripts) == 0 {
 		return nil, btcjson.Error{
-			Code:    btcjson.ErrInternal.Code,
-			Message: "No payment addresses specified via --getworkkey",
+			Code: btcjson.ErrInternal.Code,
+			Message: "No payment addresses or scripts specified " +
+				"via --getworkkey or --miningscript",
 		}
 	}
 
@@ -1813,17 +3867,36 @@ func handleGetWork(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
 }
 
 var helpAddenda = map[string]string{
-	"getgenerate": `
-NOTE: btcd does not mine so this will always return false. The call is provided
-for compatibility only.`,
-	"gethashespersec": `
-NOTE: btcd does not mine so this will always return false. The call is provided
-for compatibility only.`,
+	"getblock": `
+NOTE: The verbose parameter may also be a verbosity level of 0, 1, or 2 where 2
+decodes the transactions along with the value and addresses of the outputs
+spent by their inputs.  Unlike verbosetx, which decodes the transactions
+without them, it can't be combined with the verbosetx parameter.  When btcd is
+started with --rpcquirks, the transactions decoded with a verbosity level of 2
+are returned in the tx field instead of the rawtx field.`,
+	"getinfo": `
+NOTE: getinfo is deprecated and only kept for older clients.  New clients should
+use getblockcount, getdifficulty, getnetworkinfo, and getpeerinfo instead.  The
+wallet related fields are not returned by btcd.`,
+	"getnettotals": `
+NOTE: btcd also returns the bytes received and sent broken down by message
+command in the bytesrecv_per_msg and bytessent_per_msg objects.  Bytes of
+messages which could not be decoded are counted under "*other*".`,
+	"notifyblocks": `
+NOTE: btcd accepts an optional "txdetail" parameter of 0, 1, or 2.  When it is
+1, the blockconnected and blockdisconnected notifications include the hash of
+the previous block and an array of the hashes of the transactions in the block
+as the third and fourth parameters.  When it is 2, an array of the
+hex-encoded serialized transactions is included as the fifth parameter as well.
+The transactions are in block order, so clients should undo the transactions of
+a disconnected block in reverse order.`,
 	"sendrawtransaction": `
 NOTE: btcd does not currently support the "allowhighfees" parameter.`,
 	"setgenerate": `
-NOTE: btcd does not mine so command has no effect. The call is provided
-for compatibility only.`,
+NOTE: btcd only allows CPU mining on the test networks.  Unlike Bitcoin Core,
+where a genproclimit of 0 stops generating, btcd treats a genproclimit of 0 the
+same as omitting it and keeps the current number of workers since the two can't
+be told apart.  Use setgenerate false to stop generating.`,
 }
 
 // getHelp text retreives help text from btcjson for the command in question.
@@ -1842,7 +3915,7 @@ func getHelpText(cmdName string) (string, error) {
 }
 
 // handleHelp implements the help command.
-func handleHelp(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
+func handleHelp(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
 	help := cmd.(*btcjson.HelpCmd)
 
 	// if no args we give a list of all known commands
@@ -1872,8 +3945,28 @@ func handleHelp(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
 	return getHelpText(help.Command)
 }
 
+// handleInvalidateBlock implements the invalidateblock command.
+func handleInvalidateBlock(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
+	c := cmd.(*InvalidateBlockCmd)
+	sha, err := btcwire.NewShaHashFromStr(c.BlockHash)
+	if err != nil {
+		rpcsLog.Errorf("Error generating sha: %v", err)
+		return nil, btcjson.ErrBlockNotFound
+	}
+
+	err = s.server.blockManager.InvalidateBlock(sha)
+	if err != nil {
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrMisc.Code,
+			Message: err.Error(),
+		}
+	}
+
+	return nil, nil
+}
+
 // handlePing implements the ping command.
-func handlePing(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
+func handlePing(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
 	// Ask server to ping \o_
 	nonce, err := btcwire.RandomUint64()
 	if err != nil {
@@ -1885,8 +3978,127 @@ func handlePing(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
 	return nil, nil
 }
 
+// handleReconsiderBlock implements the reconsiderblock command.
+func handleReconsiderBlock(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
+	c := cmd.(*ReconsiderBlockCmd)
+	sha, err := btcwire.NewShaHashFromStr(c.BlockHash)
+	if err != nil {
+		rpcsLog.Errorf("Error generating sha: %v", err)
+		return nil, btcjson.ErrBlockNotFound
+	}
+
+	err = s.server.blockManager.ReconsiderBlock(sha)
+	if err != nil {
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrMisc.Code,
+			Message: err.Error(),
+		}
+	}
+
+	return nil, nil
+}
+
+// handleSearchRawTransactions implements the searchrawtransactions command.
+func handleSearchRawTransactions(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
+	idx := s.server.addrIndex
+	if idx == nil {
+		return nil, btcjson.Error{
+			Code: btcjson.ErrMisc.Code,
+			Message: "Address index must be enabled (--addrindex) " +
+				"to search transactions by address",
+		}
+	}
+
+	c := cmd.(*SearchRawTransactionsCmd)
+	addr, err := btcutil.DecodeAddress(c.Address, activeNetParams.Params)
+	if err != nil || !addr.IsForNet(activeNetParams.Params) {
+		return nil, btcjson.Error{
+			Code: btcjson.ErrInvalidAddressOrKey.Code,
+			Message: btcjson.ErrInvalidAddressOrKey.Message +
+				": " + c.Address,
+		}
+	}
+	if c.Skip < 0 || c.Count <= 0 || c.Count > maxSearchRawTxCount {
+		return nil, btcjson.Error{
+			Code: btcjson.ErrInvalidParameter.Code,
+			Message: fmt.Sprintf("Skip must not be negative and "+
+				"count must be between 1 and %d",
+				maxSearchRawTxCount),
+		}
+	}
+
+	entries, err := idx.EntriesForAddress(addr, c.Skip, c.Count)
+	if err == errAddrIndexUnavailable {
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrMisc.Code,
+			Message: err.Error(),
+		}
+	}
+	if err != nil {
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrInvalidAddressOrKey.Code,
+			Message: err.Error(),
+		}
+	}
+
+	_, maxidx, err := s.server.db.NewestSha()
+	if err != nil {
+		rpcsLog.Errorf("Cannot get newest sha: %v", err)
+		return nil, btcjson.ErrNoNewestBlockInfo
+	}
+
+	reply := make([]interface{}, 0, len(entries))
+	for _, entry := range entries {
+		// Find the instance of the transaction in the block the index
+		// entry refers to.
+		txList, err := s.server.db.FetchTxBySha(&entry.txSha)
+		if err != nil {
+			rpcsLog.Errorf("Error fetching tx: %v", err)
+			return nil, btcjson.ErrNoTxInfo
+		}
+		var txReply *btcdb.TxListReply
+		for _, tx := range txList {
+			if tx.Height == entry.height {
+				txReply = tx
+				break
+			}
+		}
+		if txReply == nil {
+			rpcsLog.Errorf("Transaction %v is not in the block at "+
+				"height %d", entry.txSha, entry.height)
+			return nil, btcjson.ErrNoTxInfo
+		}
+
+		if c.Verbose == 0 {
+			mtxHex, err := messageToHex(txReply.Tx)
+			if err != nil {
+				return nil, err
+			}
+			reply = append(reply, mtxHex)
+			continue
+		}
+
+		blk, err := s.server.db.FetchBlockBySha(txReply.BlkSha)
+		if err != nil {
+			rpcsLog.Errorf("Error fetching sha: %v", err)
+			return nil, btcjson.ErrBlockNotFound
+		}
+		rawTxn, err := createTxRawResult(s.server.netParams,
+			entry.txSha.String(), txReply.Tx, blk, maxidx,
+			txReply.BlkSha)
+		if err != nil {
+			rpcsLog.Errorf("Cannot create TxRawResult for txSha=%s: "+
+				"%v", entry.txSha, err)
+			return nil, err
+		}
+		reply = append(reply, *rawTxn)
+	}
+
+	return reply, nil
+}
+
 // handleSendRawTransaction implements the sendrawtransaction command.
-func handleSendRawTransaction(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
+func handleSendRawTransaction(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
 	c := cmd.(*btcjson.SendRawTransactionCmd)
 	// Deserialize and send off to tx relay
 	serializedTx, err := hex.DecodeString(c.HexTx)
@@ -1935,19 +4147,52 @@ func handleSendRawTransaction(s *rpcServer, cmd btcjson.Cmd) (interface{}, error
 }
 
 // handleSetGenerate implements the setgenerate command.
-func handleSetGenerate(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
-	// btcd does not do mining so we can hardcode replies here.
+//
+// The number of workers used by the CPU miner is set from the optional
+// genproclimit parameter, where -1 uses one worker per CPU and 0 (the default
+// when it is not specified) keeps the current number.  This differs from
+// Bitcoin Core, where 0 stops generating, since an explicit 0 can't be told
+// apart from the parameter being omitted.  CPU mining is only allowed on the
+// test networks.
+func handleSetGenerate(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
+	c := cmd.(*btcjson.SetGenerateCmd)
+
+	// Mining with the CPU on the main network is pointless.
+	if activeNetParams == &mainNetParams {
+		return nil, btcjson.Error{
+			Code: btcjson.ErrMisc.Code,
+			Message: fmt.Sprintf("The setgenerate command is not "+
+				"supported on the %s network.  Try using "+
+				"--testnet, --simnet, or --regtest.",
+				activeNetParams.Name),
+		}
+	}
+
+	if !c.Generate {
+		s.server.cpuMiner.Stop()
+		return nil, nil
+	}
+
+	if c.GenProcLimit != 0 {
+		s.server.cpuMiner.SetNumWorkers(int32(c.GenProcLimit))
+	}
+	if err := s.server.cpuMiner.Start(); err != nil {
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrMisc.Code,
+			Message: err.Error(),
+		}
+	}
 	return nil, nil
 }
 
 // handleStop implements the stop command.
-func handleStop(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
+func handleStop(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
 	s.server.Stop()
 	return "btcd stopping.", nil
 }
 
 // handleSubmitBlock implements the submitblock command.
-func handleSubmitBlock(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
+func handleSubmitBlock(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
 	c := cmd.(*btcjson.SubmitBlockCmd)
 	// Deserialize and send off to block processor.
 	serializedBlock, err := hex.DecodeString(c.HexBlock)
@@ -1968,7 +4213,13 @@ func handleSubmitBlock(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
 		return nil, err
 	}
 
+	// Process the block the same way as a block relayed by a peer.  It is
+	// relayed to peers once it is accepted.  Blocks which are already
+	// known are reported distinctly from blocks which are rejected.
 	_, err = s.server.blockManager.ProcessBlock(block)
+	if err == errDuplicateBlock {
+		return "duplicate", nil
+	}
 	if err != nil {
 		return fmt.Sprintf("rejected: %s", err.Error()), nil
 	}
@@ -1976,11 +4227,157 @@ func handleSubmitBlock(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
 	return nil, nil
 }
 
+// handleTestMempoolAccept implements the testmempoolaccept command.
+func handleTestMempoolAccept(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
+	c := cmd.(*TestMempoolAcceptCmd)
+
+	// Deserialize all of the transactions up front so a malformed
+	// transaction results in an error for the whole request.
+	txns := make([]*btcutil.Tx, 0, len(c.RawTxs))
+	for _, rawTx := range c.RawTxs {
+		serializedTx, err := hex.DecodeString(rawTx)
+		if err != nil {
+			return nil, btcjson.ErrDecodeHexString
+		}
+		msgtx := btcwire.NewMsgTx()
+		err = msgtx.Deserialize(bytes.NewReader(serializedTx))
+		if err != nil {
+			err := btcjson.Error{
+				Code:    btcjson.ErrDeserialization.Code,
+				Message: "TX decode failed",
+			}
+			return nil, err
+		}
+		txns = append(txns, btcutil.NewTx(msgtx))
+	}
+
+	results := make([]TestMempoolAcceptResult, 0, len(txns))
+	for _, tx := range txns {
+		result := TestMempoolAcceptResult{TxID: tx.Sha().String()}
+		isOrphan, err := s.server.txMemPool.TestAcceptTransaction(tx)
+		switch {
+		case err != nil:
+			// Only rule errors are expected here.  Anything else
+			// means something actually went wrong.
+			if _, ok := err.(TxRuleError); !ok {
+				rpcsLog.Errorf("Failed to test transaction %v: "+
+					"%v", tx.Sha(), err)
+			}
+			result.RejectReason = err.Error()
+
+		case isOrphan:
+			result.RejectReason = "missing inputs"
+
+		default:
+			result.Allowed = true
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// bip16SwitchTime is the time after which blocks are validated with the
+// pay-to-script-hash (BIP0016) rules by verifychain.  The same time applies to
+// every network rather than only the main network since btcchain activates
+// BIP0016 by block timestamp on all of them and the network parameters do not
+// define a separate activation time.
+var bip16SwitchTime = time.Unix(1333238400, 0) // Apr 1 2012
+
+// fetchVerifyInputs loads the transactions referenced by the inputs of the
+// transactions in the passed block which are not already in the passed view
+// from the database.  The outputs of the loaded transactions are all marked
+// unspent since the view tracks the spends made by the verified blocks only.
+func fetchVerifyInputs(db btcdb.Db, block *btcutil.Block, view btcchain.TxStore) {
+	var missing []*btcwire.ShaHash
+	for _, tx := range block.Transactions()[1:] {
+		for _, txIn := range tx.MsgTx().TxIn {
+			hash := &txIn.PreviousOutpoint.Hash
+			if _, exists := view[*hash]; exists {
+				continue
+			}
+			view[*hash] = &btcchain.TxData{Hash: hash,
+				Err: btcdb.TxShaMissing}
+			missing = append(missing, hash)
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+
+	for _, reply := range db.FetchTxByShaList(missing) {
+		if reply.Err != nil || reply.Tx == nil {
+			continue
+		}
+		view[*reply.Sha] = &btcchain.TxData{
+			Tx:          btcutil.NewTx(reply.Tx),
+			Hash:        reply.Sha,
+			BlockHeight: reply.Height,
+			Spent:       make([]bool, len(reply.Tx.TxOut)),
+		}
+	}
+}
+
+// checkVerifyView compares the spent outputs in the passed view, which was
+// built by reconnecting the blocks after the passed height, with the spent
+// outputs recorded in the database.  The transactions created by the
+// reconnected blocks must match exactly, while outputs of earlier transactions
+// which were spent by the reconnected blocks must be spent in the database.
+func checkVerifyView(db btcdb.Db, view btcchain.TxStore, startHeight int64) error {
+	hashes := make([]*btcwire.ShaHash, 0, len(view))
+	for _, txD := range view {
+		if txD.Err == nil && txD.Tx != nil {
+			hashes = append(hashes, txD.Hash)
+		}
+	}
+
+	for _, reply := range db.FetchTxByShaList(hashes) {
+		if reply.Err != nil {
+			return fmt.Errorf("transaction %v is missing from the "+
+				"database: %v", reply.Sha, reply.Err)
+		}
+		txD := view[*reply.Sha]
+		if len(reply.TxSpent) != len(txD.Spent) {
+			return fmt.Errorf("transaction %v has %d outputs in "+
+				"the database, but %d in the block", reply.Sha,
+				len(reply.TxSpent), len(txD.Spent))
+		}
+		for i, spent := range txD.Spent {
+			dbSpent := reply.TxSpent[i]
+			if spent == dbSpent ||
+				(!spent && txD.BlockHeight <= startHeight) {
+				continue
+			}
+			return fmt.Errorf("output %v:%d is marked %s in the "+
+				"database, but is %s", reply.Sha, i,
+				spentString(dbSpent), spentString(spent))
+		}
+	}
+	return nil
+}
+
+// spentString returns a human-readable form of the passed spent flag.
+func spentString(spent bool) string {
+	if spent {
+		return "spent"
+	}
+	return "unspent"
+}
+
+// verifyChain re-validates the passed number of blocks from the tip of the main
+// chain downward at the passed level.  Each level includes the checks of the
+// levels below it.  Level 0 ensures the blocks can be read from the database,
+// level 1 performs the context-free block sanity checks, level 2 ensures the
+// block headers connect to the previous block, level 3 validates the
+// transaction scripts against the outputs they spend, and level 4 reconnects
+// the blocks in a scratch view of the spent outputs to check the transaction
+// inputs and that the view matches the database.
 func verifyChain(db btcdb.Db, level, depth int32) error {
 	_, curHeight64, err := db.NewestSha()
 	if err != nil {
 		rpcsLog.Errorf("Verify is unable to fetch current block "+
 			"height: %v", err)
+		return err
 	}
 	curHeight := int32(curHeight64)
 
@@ -2018,24 +4415,208 @@ func verifyChain(db btcdb.Db, level, depth int32) error {
 				return err
 			}
 		}
+
+		// Level 2 ensures the header connects to the previous block.
+		if level > 1 {
+			prevHeight := int64(height - 1)
+			prevSha, err := db.FetchBlockShaByHeight(prevHeight)
+			if err != nil {
+				rpcsLog.Errorf("Verify is unable to fetch "+
+					"block at height %d: %v", prevHeight,
+					err)
+				return err
+			}
+			header := &block.MsgBlock().Header
+			if !header.PrevBlock.IsEqual(prevSha) {
+				err := fmt.Errorf("block %v at height %d "+
+					"references previous block %v instead "+
+					"of %v", sha, height, header.PrevBlock,
+					prevSha)
+				rpcsLog.Errorf("Verify failed: %v", err)
+				return err
+			}
+		}
+	}
+
+	// Levels 3 and above reconnect the blocks from the oldest one in a
+	// scratch view of the outputs they spend.
+	if level > 2 {
+		err := verifyChainConnect(db, level, int64(finishHeight),
+			int64(curHeight))
+		if err != nil {
+			rpcsLog.Errorf("Verify failed: %v", err)
+			return err
+		}
 	}
 	rpcsLog.Infof("Chain verify completed successfully")
 
 	return nil
 }
 
-func handleVerifyChain(s *rpcServer, cmd btcjson.Cmd) (interface{}, error) {
+// verifyChainConnect implements verifyChain levels 3 and 4 by reconnecting the
+// blocks after the passed start height through the passed end height in a
+// scratch view.  Nothing is written to the database.
+func verifyChainConnect(db btcdb.Db, level int32, startHeight, endHeight int64) error {
+	view := make(btcchain.TxStore)
+	for height := startHeight + 1; height <= endHeight; height++ {
+		sha, err := db.FetchBlockShaByHeight(height)
+		if err != nil {
+			return err
+		}
+		block, err := db.FetchBlockBySha(sha)
+		if err != nil {
+			return err
+		}
+		fetchVerifyInputs(db, block, view)
+
+		var flags btcscript.ScriptFlags
+		if block.MsgBlock().Header.Timestamp.After(bip16SwitchTime) {
+			flags |= btcscript.ScriptBip16
+		}
+		for i, tx := range block.Transactions() {
+			// The coinbase does not have any inputs to check.
+			if i != 0 {
+				err := verifyTxInputs(tx, height, view, level,
+					flags)
+				if err != nil {
+					return fmt.Errorf("transaction %v in "+
+						"block %v at height %d: %v",
+						tx.Sha(), sha, height, err)
+				}
+			}
+			if err := spendTransaction(view, tx, height); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Level 4 also ensures the spent outputs in the view match those
+	// recorded in the database.
+	if level > 3 {
+		return checkVerifyView(db, view, startHeight)
+	}
+	return nil
+}
+
+// verifyTxInputs checks the inputs of the passed transaction against the
+// outputs they spend in the passed view for verifyChain.  The scripts are
+// validated at level 3 and above, while level 4 also performs the same input
+// checks as connecting the block to the main chain.
+func verifyTxInputs(tx *btcutil.Tx, height int64, view btcchain.TxStore, level int32, flags btcscript.ScriptFlags) error {
+	if level > 3 {
+		_, err := btcchain.CheckTransactionInputs(tx, height, view)
+		if err != nil {
+			return err
+		}
+	}
+	return btcchain.ValidateTransactionScripts(tx, view, flags)
+}
+
+// handleUptime implements the uptime command.
+func handleUptime(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
+	return int64(time.Since(s.server.startupTime).Seconds()), nil
+}
+
+// handleVerifyChain implements the verifychain command.
+func handleVerifyChain(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan bool) (interface{}, error) {
 	c := cmd.(*btcjson.VerifyChainCmd)
 
+	if c.CheckLevel < 0 || c.CheckLevel > 4 {
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrInvalidParameter.Code,
+			Message: "checklevel must be between 0 and 4",
+		}
+	}
+
 	err := verifyChain(s.server.db, c.CheckLevel, c.CheckDepth)
 	return err == nil, nil
 }
 
+// rpcCmdParsers maps standard commands which btcd parses itself, since the
+// commands provided by btcjson do not support all of their parameters, to the
+// functions used to parse them.
+var rpcCmdParsers = map[string]func(*btcjson.RawCmd) (btcjson.Cmd, error){
+	"getblock":         parseGetBlockCmd,
+	"getblocktemplate": parseGetBlockTemplateCmd,
+	"notifyblocks":     parseNotifyBlocksCmd,
+}
+
+// parseGetBlockCmd parses a getblock RawCmd into a btcjson.GetBlockCmd.  In
+// addition to the verbose and verbosetx booleans supported by btcjson, the
+// second parameter may be a verbosity level as used by the reference
+// implementation, where 0 returns the hex-encoded block and 1 also the ids of
+// its transactions.  A verbosity level of 2, which decodes the transactions
+// along with the outputs spent by their inputs, is parsed into a
+// GetBlockVerbosityCmd instead.
+func parseGetBlockCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) < 1 || len(r.Params) > 3 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+
+	var hash string
+	if err := json.Unmarshal(r.Params[0], &hash); err != nil {
+		return nil, fmt.Errorf("first parameter 'hash' must be a "+
+			"string: %v", err)
+	}
+	cmd, err := btcjson.NewGetBlockCmd(r.Id, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	var verbosity int
+	if len(r.Params) > 1 {
+		var verbose bool
+		if err := json.Unmarshal(r.Params[1], &verbose); err == nil {
+			cmd.Verbose = verbose
+		} else if err := json.Unmarshal(r.Params[1], &verbosity); err == nil &&
+			verbosity >= 0 && verbosity <= 2 {
+
+			cmd.Verbose = verbosity > 0
+		} else {
+			return nil, errors.New("second optional parameter " +
+				"'verbose' must be a bool or a verbosity level " +
+				"of 0, 1, or 2")
+		}
+	}
+	if verbosity == 2 {
+		if len(r.Params) > 2 {
+			return nil, errors.New("third optional parameter " +
+				"'verbosetx' may not be combined with a " +
+				"verbosity level of 2")
+		}
+		verbosityCmd, err := NewGetBlockVerbosityCmd(r.Id, hash)
+		if err != nil {
+			return nil, err
+		}
+		return verbosityCmd, nil
+	}
+	if len(r.Params) > 2 {
+		if err := json.Unmarshal(r.Params[2], &cmd.VerboseTx); err != nil {
+			return nil, fmt.Errorf("third optional parameter "+
+				"'verbosetx' must be a bool: %v", err)
+		}
+	}
+
+	return cmd, nil
+}
+
+// parseMarshaledCmd parses a marshaled command the same way as
+// btcjson.ParseMarshaledCmd except for the commands in rpcCmdParsers.
+func parseMarshaledCmd(b []byte) (btcjson.Cmd, error) {
+	var r btcjson.RawCmd
+	if err := json.Unmarshal(b, &r); err == nil {
+		if parser, ok := rpcCmdParsers[r.Method]; ok {
+			return parser(&r)
+		}
+	}
+	return btcjson.ParseMarshaledCmd(b)
+}
+
 // parseCmd parses a marshaled known command, returning any errors as a
 // btcjson.Error that can be used in replies.  The returned cmd may still
 // be non-nil if b is at least a valid marshaled JSON-RPC message.
 func parseCmd(b []byte) (btcjson.Cmd, *btcjson.Error) {
-	cmd, err := btcjson.ParseMarshaledCmd(b)
+	cmd, err := parseMarshaledCmd(b)
 	if err != nil {
 		jsonErr, ok := err.(btcjson.Error)
 		if !ok {
@@ -2052,7 +4633,7 @@ func parseCmd(b []byte) (btcjson.Cmd, *btcjson.Error) {
 // standardCmdReply checks that a parsed command is a standard
 // Bitcoin JSON-RPC command and runs the proper handler to reply to the
 // command.
-func standardCmdReply(cmd btcjson.Cmd, s *rpcServer) (reply btcjson.Reply) {
+func standardCmdReply(cmd btcjson.Cmd, s *rpcServer, closeChan <-chan bool) (reply btcjson.Reply) {
 	id := cmd.Id()
 	reply.Id = &id
 
@@ -2074,7 +4655,7 @@ func standardCmdReply(cmd btcjson.Cmd, s *rpcServer) (reply btcjson.Reply) {
 	return reply
 handled:
 
-	result, err := handler(s, cmd)
+	result, err := handler(s, cmd, closeChan)
 	if err != nil {
 		jsonErr, ok := err.(btcjson.Error)
 		if !ok {
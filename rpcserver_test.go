@@ -0,0 +1,313 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/conformal/btcjson"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestRPCServer returns an RPC server which is able to service the commands
+// that do not depend on the state of the chain or the peers.
+func newTestRPCServer() *rpcServer {
+	return &rpcServer{
+		server:      &server{startupTime: time.Now()},
+		rateLimiter: newRPCRateLimiter(0),
+	}
+}
+
+// batchReplyWant describes an expected reply to a request in a batch.  An id
+// of nil means the reply is expected to have no id and a code of 0 means the
+// reply is expected to succeed.
+type batchReplyWant struct {
+	id   interface{}
+	code int
+}
+
+// testUptimeRequest returns a marshaled uptime request with the passed id.
+func testUptimeRequest(id int) string {
+	return fmt.Sprintf(`{"jsonrpc":"1.0","id":%d,"method":"uptime",`+
+		`"params":[]}`, id)
+}
+
+// testBatch returns a batch of the passed marshaled requests.
+func testBatch(requests ...string) string {
+	return "[" + strings.Join(requests, ",") + "]"
+}
+
+// TestIsBatchRequest ensures batches of JSON-RPC requests are told apart from
+// single requests.
+func TestIsBatchRequest(t *testing.T) {
+	tests := []struct {
+		body string
+		want bool
+	}{
+		{`[]`, true},
+		{"[" + testUptimeRequest(1) + "]", true},
+		{" \t\r\n[]", true},
+		{testUptimeRequest(1), false},
+		{` {"method":"uptime"}`, false},
+		{``, false},
+		{" \n", false},
+	}
+
+	for i, test := range tests {
+		got := isBatchRequest([]byte(test.body))
+		if got != test.want {
+			t.Errorf("isBatchRequest #%d (%q): got %v, want %v", i,
+				test.body, got, test.want)
+		}
+	}
+}
+
+// TestJSONRPCBatchReply ensures batches are parsed and replied to in the order
+// of their requests, each request is handled independently, and malformed and
+// empty batches are replied to with a single error.
+func TestJSONRPCBatchReply(t *testing.T) {
+	uptime := testUptimeRequest
+	connCount := func(id int) string {
+		return fmt.Sprintf(`{"jsonrpc":"1.0","id":%d,`+
+			`"method":"getconnectioncount","params":[]}`, id)
+	}
+
+	tests := []struct {
+		name    string
+		body    string
+		isAdmin bool
+
+		// single is the code of the single error reply expected for the
+		// batch as a whole.  It is 0 when a reply for each request in
+		// the batch is expected instead.
+		single int
+		want   []batchReplyWant
+	}{
+		{
+			name:    "single request",
+			body:    testBatch(uptime(1)),
+			isAdmin: true,
+			want:    []batchReplyWant{{1, 0}},
+		},
+		{
+			name:    "replies in request order",
+			body:    testBatch(uptime(3), uptime(1), uptime(2)),
+			isAdmin: true,
+			want:    []batchReplyWant{{3, 0}, {1, 0}, {2, 0}},
+		},
+		{
+			name:    "mixed limited and unlimited commands",
+			body:    testBatch(uptime(1), connCount(2), uptime(3)),
+			isAdmin: false,
+			want: []batchReplyWant{
+				{1, 0},
+				{2, errLimitedUser.Code},
+				{3, 0},
+			},
+		},
+		{
+			name: "invalid request in batch",
+			body: testBatch(uptime(1), "42", uptime(2)),
+			want: []batchReplyWant{
+				{1, 0},
+				{nil, btcjson.ErrParse.Code},
+				{2, 0},
+			},
+		},
+		{
+			name:    "whitespace around requests",
+			body:    " [ " + uptime(1) + " ,\n " + uptime(2) + "] ",
+			isAdmin: true,
+			want:    []batchReplyWant{{1, 0}, {2, 0}},
+		},
+		{
+			name:   "empty batch",
+			body:   "[]",
+			single: btcjson.ErrInvalidRequest.Code,
+		},
+		{
+			name:   "malformed batch",
+			body:   "[" + uptime(1) + ",",
+			single: btcjson.ErrParse.Code,
+		},
+		{
+			name:   "trailing data after batch",
+			body:   testBatch(uptime(1)) + "]",
+			single: btcjson.ErrParse.Code,
+		},
+	}
+
+	s := newTestRPCServer()
+	for _, test := range tests {
+		replies := jsonRPCBatchReply([]byte(test.body), test.isAdmin, s,
+			nil)
+		if test.single != 0 {
+			checkBatchErrorReply(t, test.name, replies, test.single)
+			continue
+		}
+		checkBatchReplies(t, test.name, replies, test.want)
+	}
+}
+
+// checkBatchErrorReply ensures the passed reply to a batch is a single error
+// reply without an id and with the passed error code.
+func checkBatchErrorReply(t *testing.T, name string, replies interface{}, code int) {
+	reply, ok := replies.(btcjson.Reply)
+	if !ok {
+		t.Errorf("%s: unexpected reply type %T", name, replies)
+		return
+	}
+	if reply.Id != nil || reply.Error == nil || reply.Error.Code != code {
+		t.Errorf("%s: unexpected reply %+v, want error code %d", name,
+			reply, code)
+	}
+}
+
+// checkBatchReplies ensures the passed reply to a batch is made of the passed
+// expected replies in order.
+func checkBatchReplies(t *testing.T, name string, replies interface{}, want []batchReplyWant) {
+	got, ok := replies.([]btcjson.Reply)
+	if !ok {
+		t.Errorf("%s: unexpected reply type %T", name, replies)
+		return
+	}
+	if len(got) != len(want) {
+		t.Errorf("%s: unexpected number of replies - got %d, want %d",
+			name, len(got), len(want))
+		return
+	}
+	for i, reply := range got {
+		var id interface{}
+		if reply.Id != nil {
+			id = *reply.Id
+		}
+		if fmt.Sprint(id) != fmt.Sprint(want[i].id) {
+			t.Errorf("%s: reply %d: unexpected id - got %v, "+
+				"want %v", name, i, id, want[i].id)
+		}
+
+		var code int
+		if reply.Error != nil {
+			code = reply.Error.Code
+		}
+		if code != want[i].code {
+			t.Errorf("%s: reply %d: unexpected error - got %v, "+
+				"want code %d", name, i, reply.Error,
+				want[i].code)
+		}
+		if code == 0 && reply.Result == nil {
+			t.Errorf("%s: reply %d: missing result", name, i)
+		}
+	}
+}
+
+// TestJSONRPCReadBatchQuirks ensures batches are replied to with 200 OK unless
+// --rpcquirks is specified and the batch is malformed or empty, in which case
+// the status code Bitcoin Core uses for the error is used instead.
+func TestJSONRPCReadBatchQuirks(t *testing.T) {
+	savedCfg := cfg
+	defer func() {
+		cfg = savedCfg
+	}()
+
+	valid := testBatch(testUptimeRequest(1))
+	tests := []struct {
+		name   string
+		body   string
+		quirks bool
+		status int
+	}{
+		{"valid", valid, false, http.StatusOK},
+		{"valid with quirks", valid, true, http.StatusOK},
+		{"empty", "[]", false, http.StatusOK},
+		{"empty with quirks", "[]", true, http.StatusBadRequest},
+		{"malformed", "[{", false, http.StatusOK},
+		{"malformed with quirks", "[{", true,
+			http.StatusInternalServerError},
+	}
+
+	s := newTestRPCServer()
+	for _, test := range tests {
+		cfg = &config{RPCQuirks: test.quirks}
+
+		r, err := http.NewRequest("POST", "http://127.0.0.1/",
+			bytes.NewBufferString(test.body))
+		if err != nil {
+			t.Fatalf("%s: unable to create request: %v", test.name,
+				err)
+		}
+		w := httptest.NewRecorder()
+		jsonRPCRead(w, r, true, s)
+		if w.Code != test.status {
+			t.Errorf("%s: unexpected status code - got %d, want %d",
+				test.name, w.Code, test.status)
+		}
+	}
+}
+
+// TestParseGetBlockCmd ensures the verbose and verbosetx booleans keep their
+// meaning and only a verbosity level of 2 requests the decoding of the outputs
+// spent by the inputs of the transactions.
+func TestParseGetBlockCmd(t *testing.T) {
+	const hash = `"000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"`
+	tests := []struct {
+		params    string
+		verbose   bool
+		verboseTx bool
+		prevOuts  bool
+		err       bool
+	}{
+		{params: hash, verbose: true},
+		{params: hash + `,false`},
+		{params: hash + `,true,true`, verbose: true, verboseTx: true},
+		{params: hash + `,0`},
+		{params: hash + `,1`, verbose: true},
+		{params: hash + `,2`, verbose: true, verboseTx: true,
+			prevOuts: true},
+		{params: hash + `,2,true`, err: true},
+		{params: hash + `,3`, err: true},
+		{params: hash + `,"1"`, err: true},
+	}
+
+	for _, test := range tests {
+		var r btcjson.RawCmd
+		raw := `{"jsonrpc":"1.0","id":1,"method":"getblock",` +
+			`"params":[` + test.params + `]}`
+		if err := json.Unmarshal([]byte(raw), &r); err != nil {
+			t.Fatalf("%s: unable to unmarshal request: %v",
+				test.params, err)
+		}
+		cmd, err := parseGetBlockCmd(&r)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: unexpected success", test.params)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.params, err)
+			continue
+		}
+
+		c, ok := cmd.(*btcjson.GetBlockCmd)
+		prevOuts := !ok
+		if !ok {
+			c = cmd.(*GetBlockVerbosityCmd).GetBlockCmd
+		}
+		if c.Verbose != test.verbose || c.VerboseTx != test.verboseTx ||
+			prevOuts != test.prevOuts {
+
+			t.Errorf("%s: unexpected command - got verbose %v, "+
+				"verbosetx %v, prevouts %v, want %v, %v, %v",
+				test.params, c.Verbose, c.VerboseTx, prevOuts,
+				test.verbose, test.verboseTx, test.prevOuts)
+		}
+	}
+}
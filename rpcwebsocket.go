@@ -8,7 +8,6 @@ import (
 	"bytes"
 	"code.google.com/p/go.crypto/ripemd160"
 	"container/list"
-	"crypto/subtle"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
@@ -50,7 +49,9 @@ var wsHandlers = map[string]wsCommandHandler{
 	"notifynewtransactions": handleNotifyNewTransactions,
 	"notifyreceived":        handleNotifyReceived,
 	"notifyspent":           handleNotifySpent,
+	"notifytxremoved":       handleNotifyTxRemoved,
 	"rescan":                handleRescan,
+	"rescanblocks":          handleRescanBlocks,
 }
 
 // wsAsyncHandlers holds the websocket commands which should be run
@@ -58,7 +59,8 @@ var wsHandlers = map[string]wsCommandHandler{
 // operations to run concurrently (and one at a time) while still responding
 // to the majority of normal requests which can be answered quickly.
 var wsAsyncHandlers = map[string]bool{
-	"rescan": true,
+	"rescan":       true,
+	"rescanblocks": true,
 }
 
 // WebsocketHandler handles a new websocket client by creating a new wsClient,
@@ -67,12 +69,18 @@ var wsAsyncHandlers = map[string]bool{
 // server handler which runs each new connection in a new goroutine thereby
 // satisfying the requirement.
 func (s *rpcServer) WebsocketHandler(conn *websocket.Conn, remoteAddr string,
-	authenticated bool) {
+	authenticated bool, isAdmin bool) {
 
 	// Clear the read deadline that was set before the websocket hijacked
 	// the connection.
 	conn.SetReadDeadline(timeZeroVal)
 
+	// Limit the size of the messages the client may send.  The connection
+	// is closed when a larger message is received.
+	if cfg.MaxRPCRequestSize != 0 {
+		conn.SetReadLimit(cfg.MaxRPCRequestSize)
+	}
+
 	// Limit max number of websocket clients.
 	rpcsLog.Infof("New websocket client %s", remoteAddr)
 	if s.ntfnMgr.NumClients()+1 > cfg.RPCMaxWebsockets {
@@ -86,7 +94,7 @@ func (s *rpcServer) WebsocketHandler(conn *websocket.Conn, remoteAddr string,
 	// Create a new websocket client to handle the new websocket connection
 	// and wait for it to shutdown.  Once it has shutdown (and hence
 	// disconnected), remove it and any notifications it registered for.
-	client := newWebsocketClient(s, conn, remoteAddr, authenticated)
+	client := newWebsocketClient(s, conn, remoteAddr, authenticated, isAdmin)
 	s.ntfnMgr.AddClient(client)
 	client.Start()
 	client.WaitForShutdown()
@@ -222,6 +230,25 @@ func (m *wsNotificationManager) NotifyMempoolTx(tx *btcutil.Tx, isNew bool) {
 	}
 }
 
+// NotifyTxRemoved passes a transaction removed from the mempool for a reason
+// other than being mined to the notification manager for transaction removal
+// notification processing.
+func (m *wsNotificationManager) NotifyTxRemoved(tx *btcutil.Tx, reason txRemoveReason) {
+	n := &notificationTxRemovedFromMempool{
+		reason: reason,
+		tx:     tx,
+	}
+
+	// As NotifyTxRemoved will be called by mempool and the RPC server
+	// may no longer be running, use a select statement to unblock
+	// enqueueing the notification once the RPC server has begun
+	// shutting down.
+	select {
+	case m.queueNotification <- n:
+	case <-m.quit:
+	}
+}
+
 // Notification types
 type notificationBlockConnected btcutil.Block
 type notificationBlockDisconnected btcutil.Block
@@ -229,14 +256,23 @@ type notificationTxAcceptedByMempool struct {
 	isNew bool
 	tx    *btcutil.Tx
 }
+type notificationTxRemovedFromMempool struct {
+	reason txRemoveReason
+	tx     *btcutil.Tx
+}
 
 // Notification control requests
 type notificationRegisterClient wsClient
 type notificationUnregisterClient wsClient
-type notificationRegisterBlocks wsClient
+type notificationRegisterBlocks struct {
+	wsc      *wsClient
+	txDetail int
+}
 type notificationUnregisterBlocks wsClient
 type notificationRegisterNewMempoolTxs wsClient
 type notificationUnregisterNewMempoolTxs wsClient
+type notificationRegisterTxRemoved wsClient
+type notificationUnregisterTxRemoved wsClient
 type notificationRegisterSpent struct {
 	wsc *wsClient
 	op  *btcwire.OutPoint
@@ -253,6 +289,10 @@ type notificationUnregisterAddr struct {
 	wsc  *wsClient
 	addr string
 }
+type notificationClientRequests struct {
+	wsc   *wsClient
+	reply chan *wsClientRequests
+}
 
 // notificationHandler reads notifications and control messages from the queue
 // handler and processes one at a time.
@@ -269,6 +309,7 @@ func (m *wsNotificationManager) notificationHandler() {
 	// since it is quite a bit more efficient than using the entire struct.
 	blockNotifications := make(map[chan bool]*wsClient)
 	txNotifications := make(map[chan bool]*wsClient)
+	txRemovedNotifications := make(map[chan bool]*wsClient)
 	watchedOutPoints := make(map[btcwire.OutPoint]map[chan bool]*wsClient)
 	watchedAddrs := make(map[string]map[chan bool]*wsClient)
 
@@ -309,9 +350,15 @@ out:
 				}
 				m.notifyForTx(watchedOutPoints, watchedAddrs, n.tx, nil)
 
+			case *notificationTxRemovedFromMempool:
+				if len(txRemovedNotifications) != 0 {
+					m.notifyTxRemoved(txRemovedNotifications,
+						n.tx, n.reason)
+				}
+
 			case *notificationRegisterBlocks:
-				wsc := (*wsClient)(n)
-				blockNotifications[wsc.quit] = wsc
+				n.wsc.blockTxDetail = n.txDetail
+				blockNotifications[n.wsc.quit] = n.wsc
 
 			case *notificationUnregisterBlocks:
 				wsc := (*wsClient)(n)
@@ -327,6 +374,7 @@ out:
 				// the client itself.
 				delete(blockNotifications, wsc.quit)
 				delete(txNotifications, wsc.quit)
+				delete(txRemovedNotifications, wsc.quit)
 				for k := range wsc.spentRequests {
 					op := k
 					m.removeSpentRequest(watchedOutPoints, wsc, &op)
@@ -356,6 +404,17 @@ out:
 				wsc := (*wsClient)(n)
 				delete(txNotifications, wsc.quit)
 
+			case *notificationRegisterTxRemoved:
+				wsc := (*wsClient)(n)
+				txRemovedNotifications[wsc.quit] = wsc
+
+			case *notificationUnregisterTxRemoved:
+				wsc := (*wsClient)(n)
+				delete(txRemovedNotifications, wsc.quit)
+
+			case *notificationClientRequests:
+				n.reply <- newWSClientRequests(n.wsc)
+
 			default:
 				rpcsLog.Warn("Unhandled notification type")
 			}
@@ -383,10 +442,55 @@ func (m *wsNotificationManager) NumClients() (n int) {
 	return
 }
 
-// RegisterBlockUpdates requests block update notifications to the passed
-// websocket client.
-func (m *wsNotificationManager) RegisterBlockUpdates(wsc *wsClient) {
-	m.queueNotification <- (*notificationRegisterBlocks)(wsc)
+// wsClientRequests holds the addresses and outpoints a websocket client has
+// requested to be notified about.
+type wsClientRequests struct {
+	addrs     []string
+	outPoints []btcwire.OutPoint
+}
+
+// newWSClientRequests returns a copy of the addresses and outpoints the passed
+// websocket client has requested to be notified about.  It must be called
+// from the notification handler since the requests of the client are only
+// modified there.
+func newWSClientRequests(wsc *wsClient) *wsClientRequests {
+	requests := &wsClientRequests{
+		addrs:     make([]string, 0, len(wsc.addrRequests)),
+		outPoints: make([]btcwire.OutPoint, 0, len(wsc.spentRequests)),
+	}
+	for addr := range wsc.addrRequests {
+		requests.addrs = append(requests.addrs, addr)
+	}
+	for op := range wsc.spentRequests {
+		requests.outPoints = append(requests.outPoints, op)
+	}
+	return requests
+}
+
+// ClientRequests returns the addresses and outpoints the passed websocket
+// client has requested to be notified about.  No requests are returned if the
+// server has shut down.
+func (m *wsNotificationManager) ClientRequests(wsc *wsClient) *wsClientRequests {
+	reply := make(chan *wsClientRequests, 1)
+	m.queueNotification <- &notificationClientRequests{
+		wsc:   wsc,
+		reply: reply,
+	}
+	select {
+	case requests := <-reply:
+		return requests
+	case <-m.quit:
+		return &wsClientRequests{}
+	}
+}
+
+// RegisterBlockUpdates requests block update notifications with the passed
+// level of transaction detail to the passed websocket client.
+func (m *wsNotificationManager) RegisterBlockUpdates(wsc *wsClient, txDetail int) {
+	m.queueNotification <- &notificationRegisterBlocks{
+		wsc:      wsc,
+		txDetail: txDetail,
+	}
 }
 
 // UnregisterBlockUpdates removes block update notifications for the passed
@@ -395,30 +499,99 @@ func (m *wsNotificationManager) UnregisterBlockUpdates(wsc *wsClient) {
 	m.queueNotification <- (*notificationUnregisterBlocks)(wsc)
 }
 
-// notifyBlockConnected notifies websocket clients that have registered for
-// block updates when a block is connected to the main chain.
-func (*wsNotificationManager) notifyBlockConnected(clients map[chan bool]*wsClient,
-	block *btcutil.Block) {
+// The levels of detail about the transactions of a block which may be included
+// in blockconnected and blockdisconnected notifications as requested by the
+// txdetail parameter of notifyblocks.
+const (
+	// blockNtfnNoTxs includes no transaction details.
+	blockNtfnNoTxs = iota
 
+	// blockNtfnTxIDs includes the previous block hash and the hashes of
+	// the transactions in the block.
+	blockNtfnTxIDs
+
+	// blockNtfnRawTxs includes the serialized transactions in addition to
+	// the details of blockNtfnTxIDs.
+	blockNtfnRawTxs
+)
+
+// marshalBlockNtfn returns the marshalled blockconnected or blockdisconnected
+// notification, depending on the passed method, for the block with the passed
+// level of transaction detail.  The transactions are listed in block order.
+func marshalBlockNtfn(method string, block *btcutil.Block, txDetail int) ([]byte, error) {
 	hash, err := block.Sha()
 	if err != nil {
-		rpcsLog.Error("Bad block; connected block notification dropped")
-		return
+		return nil, err
 	}
+	hashStr := hash.String()
+	height := int32(block.Height())
 
-	// Notify interested websocket clients about the connected block.
-	ntfn := btcws.NewBlockConnectedNtfn(hash.String(), int32(block.Height()))
-	marshalledJSON, err := json.Marshal(ntfn)
+	if txDetail == blockNtfnNoTxs {
+		if method == "blockdisconnected" {
+			ntfn := btcws.NewBlockDisconnectedNtfn(hashStr, height)
+			return json.Marshal(ntfn)
+		}
+		ntfn := btcws.NewBlockConnectedNtfn(hashStr, height)
+		return json.Marshal(ntfn)
+	}
+
+	txns := block.Transactions()
+	txIDs := make([]string, 0, len(txns))
+	for _, tx := range txns {
+		txIDs = append(txIDs, tx.Sha().String())
+	}
+	prevHash := block.MsgBlock().Header.PrevBlock.String()
+	params := []interface{}{hashStr, height, prevHash, txIDs}
+	if txDetail == blockNtfnRawTxs {
+		rawTxs := make([]string, 0, len(txns))
+		for _, tx := range txns {
+			rawTx, err := messageToHex(tx.MsgTx())
+			if err != nil {
+				return nil, err
+			}
+			rawTxs = append(rawTxs, rawTx)
+		}
+		params = append(params, rawTxs)
+	}
+
+	raw, err := btcjson.NewRawCmd(nil, method, params)
 	if err != nil {
-		rpcsLog.Error("Failed to marshal block connected notification: "+
-			"%v", err)
-		return
+		return nil, err
 	}
+	return json.Marshal(raw)
+}
+
+// notifyBlock sends the blockconnected or blockdisconnected notification,
+// depending on the passed method, for the passed block to the passed clients.
+// Each notification is only created once for each level of transaction detail
+// requested by the clients.
+func notifyBlock(clients map[chan bool]*wsClient, method string, block *btcutil.Block) {
+	var marshalled [blockNtfnRawTxs + 1][]byte
 	for _, wsc := range clients {
-		wsc.QueueNotification(marshalledJSON)
+		txDetail := wsc.blockTxDetail
+		if marshalled[txDetail] == nil {
+			marshalledJSON, err := marshalBlockNtfn(method, block,
+				txDetail)
+			if err != nil {
+				rpcsLog.Errorf("Failed to marshal %s "+
+					"notification: %v", method, err)
+				return
+			}
+			marshalled[txDetail] = marshalledJSON
+		}
+		wsc.QueueNotification(marshalled[txDetail])
 	}
 }
 
+// notifyBlockConnected notifies websocket clients that have registered for
+// block updates when a block is connected to the main chain.
+func (*wsNotificationManager) notifyBlockConnected(clients map[chan bool]*wsClient,
+	block *btcutil.Block) {
+
+	// Notify interested websocket clients about the connected block.
+	notifyBlock(clients, "blockconnected", block)
+}
+
 // notifyBlockDisconnected notifies websocket clients that have registered for
 // block updates when a block is disconnected from the main chain (due to a
 // reorganize).
@@ -429,25 +602,8 @@ func (*wsNotificationManager) notifyBlockDisconnected(clients map[chan bool]*wsC
 		return
 	}
 
-	hash, err := block.Sha()
-	if err != nil {
-		rpcsLog.Error("Bad block; disconnected block notification " +
-			"dropped")
-		return
-	}
-
 	// Notify interested websocket clients about the disconnected block.
-	ntfn := btcws.NewBlockDisconnectedNtfn(hash.String(),
-		int32(block.Height()))
-	marshalledJSON, err := json.Marshal(ntfn)
-	if err != nil {
-		rpcsLog.Error("Failed to marshal block disconnected "+
-			"notification: %v", err)
-		return
-	}
-	for _, wsc := range clients {
-		wsc.QueueNotification(marshalledJSON)
-	}
+	notifyBlock(clients, "blockdisconnected", block)
 }
 
 // RegisterNewMempoolTxsUpdates requests notifications to the passed websocket
@@ -506,6 +662,37 @@ func (m *wsNotificationManager) notifyForNewTx(clients map[chan bool]*wsClient,
 	}
 }
 
+// RegisterTxRemovedUpdates requests notifications to the passed websocket
+// client when transactions are removed from the memory pool for a reason other
+// than being mined.
+func (m *wsNotificationManager) RegisterTxRemovedUpdates(wsc *wsClient) {
+	m.queueNotification <- (*notificationRegisterTxRemoved)(wsc)
+}
+
+// UnregisterTxRemovedUpdates removes notifications to the passed websocket
+// client when transactions are removed from the memory pool.
+func (m *wsNotificationManager) UnregisterTxRemovedUpdates(wsc *wsClient) {
+	m.queueNotification <- (*notificationUnregisterTxRemoved)(wsc)
+}
+
+// notifyTxRemoved notifies websocket clients that have registered for updates
+// when a transaction is removed from the memory pool for a reason other than
+// being mined.
+func (m *wsNotificationManager) notifyTxRemoved(clients map[chan bool]*wsClient,
+	tx *btcutil.Tx, reason txRemoveReason) {
+
+	ntfn := NewTxRemovedNtfn(tx.Sha().String(), reason.String())
+	marshalledJSON, err := json.Marshal(ntfn)
+	if err != nil {
+		rpcsLog.Errorf("Failed to marshal tx removed notification: "+
+			"%v", err)
+		return
+	}
+	for _, wsc := range clients {
+		wsc.QueueNotification(marshalledJSON)
+	}
+}
+
 // RegisterSpentRequest requests an notification when the passed outpoint is
 // confirmed spent (contained in a block connected to the main chain) for the
 // passed websocket client.  The request is automatically removed once the
@@ -889,10 +1076,20 @@ type wsClient struct {
 	// and therefore is allowed to communicated over the websocket.
 	authenticated bool
 
+	// isAdmin specifies whether a client may change the state of the
+	// server; false means its access is only to the limited set of RPC
+	// calls.
+	isAdmin bool
+
 	// verboseTxUpdates specifies whether a client has requested verbose
 	// information about all new transactions.
 	verboseTxUpdates bool
 
+	// blockTxDetail specifies the level of detail about the transactions
+	// of connected and disconnected blocks a client has requested.  Owned
+	// by the notification manager.
+	blockTxDetail int
+
 	// addrRequests is a set of addresses the caller has requested to be
 	// notified about.  It is maintained here so all requests can be removed
 	// when a wallet disconnects.  Owned by the notification manager.
@@ -941,13 +1138,14 @@ func (c *wsClient) handleMessage(msg []byte) {
 		login := authCmd.Username + ":" + authCmd.Passphrase
 		auth := "Basic " + base64.StdEncoding.EncodeToString([]byte(login))
 		authSha := fastsha256.Sum256([]byte(auth))
-		cmp := subtle.ConstantTimeCompare(authSha[:], c.server.authsha[:])
-		if cmp != 1 {
+		authenticated, isAdmin := c.server.authenticate(authSha)
+		if !authenticated {
 			rpcsLog.Warnf("Auth failure.")
 			c.Disconnect()
 			return
 		}
 		c.authenticated = true
+		c.isAdmin = isAdmin
 
 		// Marshal and send response.
 		reply, err := createMarshalledReply(authCmd.Id(), nil, nil)
@@ -994,6 +1192,33 @@ func (c *wsClient) handleMessage(msg []byte) {
 		return
 	}
 
+	// Reject commands which are not available to limited users.
+	if !c.isAdmin && !isLimitedCmd(cmd.Method()) {
+		reply, err := createMarshalledReply(cmd.Id(), nil,
+			&errLimitedUser)
+		if err != nil {
+			rpcsLog.Errorf("Failed to marshal reply for <%s> "+
+				"command: %v", cmd.Method(), err)
+			return
+		}
+		c.SendMessage(reply, nil)
+		return
+	}
+
+	// Reject commands which exceed the rate limit for the client's user
+	// without disconnecting it.
+	if !c.server.rateLimiter.Allow(c.isAdmin, cmd.Method()) {
+		reply, err := createMarshalledReply(cmd.Id(), nil,
+			&errRateLimited)
+		if err != nil {
+			rpcsLog.Errorf("Failed to marshal reply for <%s> "+
+				"command: %v", cmd.Method(), err)
+			return
+		}
+		c.SendMessage(reply, nil)
+		return
+	}
+
 	// When the command is marked as a long-running command, send it off
 	// to the asyncHander goroutine for processing.
 	if _, ok := wsAsyncHandlers[cmd.Method()]; ok {
@@ -1015,7 +1240,7 @@ func (c *wsClient) handleMessage(msg []byte) {
 	if !ok {
 		// No websocket-specific handler so handle like a legacy
 		// RPC connection.
-		response := standardCmdReply(cmd, c.server)
+		response := standardCmdReply(cmd, c.server, c.quit)
 		reply, err := json.Marshal(response)
 		if err != nil {
 			rpcsLog.Errorf("Failed to marshal reply for <%s> "+
@@ -1356,18 +1581,20 @@ func (c *wsClient) WaitForShutdown() {
 }
 
 // newWebsocketClient returns a new websocket client given the notification
-// manager, websocket connection, remote address, and whether or not the client
-// has already been authenticated (via HTTP Basic access authentication).  The
+// manager, websocket connection, remote address, whether or not the client
+// has already been authenticated (via HTTP Basic access authentication), and
+// whether or not the client has admin (as opposed to limited) access.  The
 // returned client is ready to start.  Once started, the client will process
 // incoming and outgoing messages in separate goroutines complete with queueing
 // and asynchrous handling for long-running operations.
 func newWebsocketClient(server *rpcServer, conn *websocket.Conn,
-	remoteAddr string, authenticated bool) *wsClient {
+	remoteAddr string, authenticated bool, isAdmin bool) *wsClient {
 
 	return &wsClient{
 		conn:          conn,
 		addr:          remoteAddr,
 		authenticated: authenticated,
+		isAdmin:       isAdmin,
 		server:        server,
 		addrRequests:  make(map[string]struct{}),
 		spentRequests: make(map[btcwire.OutPoint]struct{}),
@@ -1381,7 +1608,12 @@ func newWebsocketClient(server *rpcServer, conn *websocket.Conn,
 // handleNotifyBlocks implements the notifyblocks command extension for
 // websocket connections.
 func handleNotifyBlocks(wsc *wsClient, icmd btcjson.Cmd) (interface{}, *btcjson.Error) {
-	wsc.server.ntfnMgr.RegisterBlockUpdates(wsc)
+	cmd, ok := icmd.(*NotifyBlocksCmd)
+	if !ok {
+		return nil, &btcjson.ErrInternal
+	}
+
+	wsc.server.ntfnMgr.RegisterBlockUpdates(wsc, cmd.TxDetail)
 	return nil, nil
 }
 
@@ -1424,6 +1656,13 @@ func handleNotifyNewTransactions(wsc *wsClient, icmd btcjson.Cmd) (interface{},
 	return nil, nil
 }
 
+// handleNotifyTxRemoved implements the notifytxremoved command extension for
+// websocket connections.
+func handleNotifyTxRemoved(wsc *wsClient, icmd btcjson.Cmd) (interface{}, *btcjson.Error) {
+	wsc.server.ntfnMgr.RegisterTxRemovedUpdates(wsc)
+	return nil, nil
+}
+
 // handleNotifyReceived implements the notifyreceived command extension for
 // websocket connections.
 func handleNotifyReceived(wsc *wsClient, icmd btcjson.Cmd) (interface{}, *btcjson.Error) {
@@ -1458,7 +1697,7 @@ type rescanKeys struct {
 }
 
 // rescanBlock rescans all transactions in a single block.  This is a helper
-// function for handleRescan.
+// function for handleRescan and handleRescanBlocks.
 func rescanBlock(wsc *wsClient, lookups *rescanKeys, blk *btcutil.Block) {
 	for _, tx := range blk.Transactions() {
 		// Hexadecimal representation of this tx.  Only created if
@@ -1590,36 +1829,10 @@ func rescanBlock(wsc *wsClient, lookups *rescanKeys, blk *btcutil.Block) {
 	}
 }
 
-// handleRescan implements the rescan command extension for websocket
-// connections.
-func handleRescan(wsc *wsClient, icmd btcjson.Cmd) (interface{}, *btcjson.Error) {
-	cmd, ok := icmd.(*btcws.RescanCmd)
-	if !ok {
-		return nil, &btcjson.ErrInternal
-	}
-
-	outpoints := make([]*btcwire.OutPoint, 0, len(cmd.OutPoints))
-	for i := range cmd.OutPoints {
-		blockHash, err := btcwire.NewShaHashFromStr(cmd.OutPoints[i].Hash)
-		if err != nil {
-			return nil, &btcjson.Error{
-				Code:    btcjson.ErrParse.Code,
-				Message: err.Error(),
-			}
-		}
-		index := cmd.OutPoints[i].Index
-		outpoints = append(outpoints, btcwire.NewOutPoint(blockHash, index))
-	}
-
-	numAddrs := len(cmd.Addresses)
-	if numAddrs == 1 {
-		rpcsLog.Info("Beginning rescan for 1 address")
-	} else {
-		rpcsLog.Infof("Beginning rescan for %d addresses", numAddrs)
-	}
-
-	// Build lookup maps.
-	lookups := rescanKeys{
+// newRescanKeys returns the lookup maps used to rescan blocks for transactions
+// which pay to the passed addresses or spend the passed outpoints.
+func newRescanKeys(addresses []string, outPoints []btcws.OutPoint) (*rescanKeys, *btcjson.Error) {
+	lookups := &rescanKeys{
 		fallbacks:           map[string]struct{}{},
 		pubKeyHashes:        map[[ripemd160.Size]byte]struct{}{},
 		scriptHashes:        map[[ripemd160.Size]byte]struct{}{},
@@ -1629,7 +1842,7 @@ func handleRescan(wsc *wsClient, icmd btcjson.Cmd) (interface{}, *btcjson.Error)
 	}
 	var compressedPubkey [33]byte
 	var uncompressedPubkey [65]byte
-	for _, addrStr := range cmd.Addresses {
+	for _, addrStr := range addresses {
 		addr, err := btcutil.DecodeAddress(addrStr, activeNetParams.Params)
 		if err != nil {
 			jsonErr := btcjson.Error{
@@ -1671,9 +1884,39 @@ func handleRescan(wsc *wsClient, icmd btcjson.Cmd) (interface{}, *btcjson.Error)
 			lookups.fallbacks[addrStr] = struct{}{}
 		}
 	}
-	for _, outpoint := range outpoints {
+	for i := range outPoints {
+		blockHash, err := btcwire.NewShaHashFromStr(outPoints[i].Hash)
+		if err != nil {
+			return nil, &btcjson.Error{
+				Code:    btcjson.ErrParse.Code,
+				Message: err.Error(),
+			}
+		}
+		outpoint := btcwire.NewOutPoint(blockHash, outPoints[i].Index)
 		lookups.unspent[*outpoint] = struct{}{}
 	}
+	return lookups, nil
+}
+
+// handleRescan implements the rescan command extension for websocket
+// connections.
+func handleRescan(wsc *wsClient, icmd btcjson.Cmd) (interface{}, *btcjson.Error) {
+	cmd, ok := icmd.(*btcws.RescanCmd)
+	if !ok {
+		return nil, &btcjson.ErrInternal
+	}
+
+	numAddrs := len(cmd.Addresses)
+	if numAddrs == 1 {
+		rpcsLog.Info("Beginning rescan for 1 address")
+	} else {
+		rpcsLog.Infof("Beginning rescan for %d addresses", numAddrs)
+	}
+
+	lookups, jsonErr := newRescanKeys(cmd.Addresses, cmd.OutPoints)
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
 
 	minBlock := int64(cmd.BeginBlock)
 	maxBlock := int64(cmd.EndBlock)
@@ -1711,7 +1954,7 @@ func handleRescan(wsc *wsClient, icmd btcjson.Cmd) (interface{}, *btcjson.Error)
 					"for disconnected client", blk.Height())
 				return nil, nil
 			default:
-				rescanBlock(wsc, &lookups, blk)
+				rescanBlock(wsc, lookups, blk)
 			}
 
 			// Periodically notify the client of the progress
@@ -1745,3 +1988,82 @@ func handleRescan(wsc *wsClient, icmd btcjson.Cmd) (interface{}, *btcjson.Error)
 	rpcsLog.Info("Finished rescan")
 	return nil, nil
 }
+
+// newRescanBlocksKeys returns the keys to rescan for with the passed
+// rescanblocks command.  They consist of the addresses and outpoints passed
+// with the command along with those the client requested to be notified about
+// with notifyreceived and notifyspent, so a client which already registered
+// its addresses and outpoints doesn't need to pass them again.
+func newRescanBlocksKeys(cmd *RescanBlocksCmd, requests *wsClientRequests) (*rescanKeys, *btcjson.Error) {
+	addrs := make([]string, 0, len(cmd.Addresses)+len(requests.addrs))
+	addrs = append(addrs, cmd.Addresses...)
+	addrs = append(addrs, requests.addrs...)
+	lookups, jsonErr := newRescanKeys(addrs, cmd.OutPoints)
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+	for _, op := range requests.outPoints {
+		lookups.unspent[op] = struct{}{}
+	}
+	return lookups, nil
+}
+
+// handleRescanBlocks implements the rescanblocks command extension for
+// websocket connections.  Only one block is loaded from the database at a time
+// and its matching transactions are sent to the client before the next block
+// is loaded, so rescanning a large number of blocks does not require them to be
+// held in memory at once.
+func handleRescanBlocks(wsc *wsClient, icmd btcjson.Cmd) (interface{}, *btcjson.Error) {
+	cmd, ok := icmd.(*RescanBlocksCmd)
+	if !ok {
+		return nil, &btcjson.ErrInternal
+	}
+
+	// Ensure all of the block hashes are valid before starting the rescan.
+	blockHashes := make([]*btcwire.ShaHash, 0, len(cmd.BlockHashes))
+	for _, hashStr := range cmd.BlockHashes {
+		hash, err := btcwire.NewShaHashFromStr(hashStr)
+		if err != nil {
+			return nil, &btcjson.Error{
+				Code:    btcjson.ErrDecodeHexString.Code,
+				Message: err.Error(),
+			}
+		}
+		blockHashes = append(blockHashes, hash)
+	}
+
+	requests := wsc.server.ntfnMgr.ClientRequests(wsc)
+	lookups, jsonErr := newRescanBlocksKeys(cmd, requests)
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+
+	rpcsLog.Infof("Beginning rescan of %d blocks for %d addresses",
+		len(blockHashes), len(cmd.Addresses)+len(requests.addrs))
+
+	db := wsc.server.server.db
+	for _, hash := range blockHashes {
+		// Stop the rescan if the client requesting it has
+		// disconnected.
+		select {
+		case <-wsc.quit:
+			rpcsLog.Debugf("Stopped rescan at block %v for "+
+				"disconnected client", hash)
+			return nil, nil
+		default:
+		}
+
+		blk, err := db.FetchBlockBySha(hash)
+		if err != nil {
+			rpcsLog.Errorf("Error looking up block sha: %v", err)
+			return nil, &btcjson.Error{
+				Code:    btcjson.ErrBlockNotFound.Code,
+				Message: "Block not found: " + hash.String(),
+			}
+		}
+		rescanBlock(wsc, lookups, blk)
+	}
+
+	rpcsLog.Info("Finished rescan")
+	return nil, nil
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+	"github.com/conformal/btcws"
+	"testing"
+)
+
+// TestNewRescanBlocksKeys ensures the keys rescanned for by rescanblocks
+// include the addresses and outpoints the client registered for notifications
+// along with those passed with the command, including when the command only
+// relies on the registered ones.
+func TestNewRescanBlocksKeys(t *testing.T) {
+	const (
+		p2pkhAddr = "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2"
+		p2shAddr  = "3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy"
+		txHash    = "0e3e2357e806b6cdb1f70b54c3a3a17b6714ee1f0e68bebb44a74b1efd512098"
+	)
+	p2pkh, err := btcutil.DecodeAddress(p2pkhAddr, activeNetParams.Params)
+	if err != nil {
+		t.Fatalf("unable to decode address: %v", err)
+	}
+	p2sh, err := btcutil.DecodeAddress(p2shAddr, activeNetParams.Params)
+	if err != nil {
+		t.Fatalf("unable to decode address: %v", err)
+	}
+	hash, err := btcwire.NewShaHashFromStr(txHash)
+	if err != nil {
+		t.Fatalf("unable to decode hash: %v", err)
+	}
+	registeredOp := btcwire.NewOutPoint(hash, 0)
+	passedOp := btcwire.NewOutPoint(hash, 1)
+
+	tests := []struct {
+		name     string
+		cmd      *RescanBlocksCmd
+		requests *wsClientRequests
+		addrs    []btcutil.Address
+		ops      []*btcwire.OutPoint
+	}{
+		{
+			name: "registered filter only",
+			cmd:  NewRescanBlocksCmd(1, nil, nil, nil),
+			requests: &wsClientRequests{
+				addrs:     []string{p2pkhAddr},
+				outPoints: []btcwire.OutPoint{*registeredOp},
+			},
+			addrs: []btcutil.Address{p2pkh},
+			ops:   []*btcwire.OutPoint{registeredOp},
+		},
+		{
+			name: "passed keys only",
+			cmd: NewRescanBlocksCmd(1, nil, []string{p2shAddr},
+				[]btcws.OutPoint{{Hash: txHash, Index: 1}}),
+			requests: &wsClientRequests{},
+			addrs:    []btcutil.Address{p2sh},
+			ops:      []*btcwire.OutPoint{passedOp},
+		},
+		{
+			name: "passed and registered keys",
+			cmd: NewRescanBlocksCmd(1, nil, []string{p2shAddr},
+				[]btcws.OutPoint{{Hash: txHash, Index: 1}}),
+			requests: &wsClientRequests{
+				addrs:     []string{p2pkhAddr},
+				outPoints: []btcwire.OutPoint{*registeredOp},
+			},
+			addrs: []btcutil.Address{p2pkh, p2sh},
+			ops:   []*btcwire.OutPoint{registeredOp, passedOp},
+		},
+	}
+
+	for _, test := range tests {
+		lookups, jsonErr := newRescanBlocksKeys(test.cmd, test.requests)
+		if jsonErr != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, jsonErr)
+			continue
+		}
+
+		numAddrs := len(lookups.pubKeyHashes) +
+			len(lookups.scriptHashes)
+		if numAddrs != len(test.addrs) {
+			t.Errorf("%s: unexpected number of addresses - got "+
+				"%d, want %d", test.name, numAddrs,
+				len(test.addrs))
+		}
+		for _, addr := range test.addrs {
+			var ok bool
+			switch a := addr.(type) {
+			case *btcutil.AddressPubKeyHash:
+				_, ok = lookups.pubKeyHashes[*a.Hash160()]
+			case *btcutil.AddressScriptHash:
+				_, ok = lookups.scriptHashes[*a.Hash160()]
+			}
+			if !ok {
+				t.Errorf("%s: address %v is not rescanned for",
+					test.name, addr)
+			}
+		}
+
+		if len(lookups.unspent) != len(test.ops) {
+			t.Errorf("%s: unexpected number of outpoints - got "+
+				"%d, want %d", test.name, len(lookups.unspent),
+				len(test.ops))
+		}
+		for _, op := range test.ops {
+			if _, ok := lookups.unspent[*op]; !ok {
+				t.Errorf("%s: outpoint %v is not rescanned for",
+					test.name, op)
+			}
+		}
+	}
+}
+
+// TestNewWSClientRequests ensures the requests of a websocket client are
+// copied so they can be used outside of the notification handler.
+func TestNewWSClientRequests(t *testing.T) {
+	op := btcwire.OutPoint{Index: 1}
+	wsc := &wsClient{
+		addrRequests:  map[string]struct{}{"addr": {}},
+		spentRequests: map[btcwire.OutPoint]struct{}{op: {}},
+	}
+
+	requests := newWSClientRequests(wsc)
+	delete(wsc.addrRequests, "addr")
+	delete(wsc.spentRequests, op)
+	if len(requests.addrs) != 1 || requests.addrs[0] != "addr" {
+		t.Errorf("unexpected addresses - got %v, want [addr]",
+			requests.addrs)
+	}
+	if len(requests.outPoints) != 1 || requests.outPoints[0] != op {
+		t.Errorf("unexpected outpoints - got %v, want [%v]",
+			requests.outPoints, op)
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"net"
 	"runtime"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -31,16 +32,36 @@ const (
 )
 
 const (
-	// supportedServices describes which services are supported by the
-	// server.
-	supportedServices = btcwire.SFNodeNetwork
-
-	// connectionRetryInterval is the amount of time to wait in between
-	// retries when connecting to persistent peers.
-	connectionRetryInterval = time.Second * 10
-
-	// defaultMaxOutbound is the default number of max outbound peers.
-	defaultMaxOutbound = 8
+	// sfNodeBloom is the service flag which indicates a peer supports bloom
+	// filtering (BIP0111).  It is not defined by btcwire.
+	sfNodeBloom btcwire.ServiceFlag = 1 << 2
+
+	// maxConnectionRetryInterval is the maximum amount of time to wait in
+	// between retries when connecting to persistent peers.  The interval
+	// starts at the configured connection retry interval and doubles with
+	// each failed attempt up to this value.
+	maxConnectionRetryInterval = time.Minute * 5
+
+	// stableConnectionDuration is the minimum amount of time a connection
+	// to a persistent peer must stay up for the retry interval to be reset
+	// when it is disconnected.  Peers which disconnect sooner are retried
+	// with the backoff continuing from where it left off.
+	stableConnectionDuration = time.Minute * 5
+
+	// uploadTargetTimeframe is the length of each cycle the upload target
+	// applies to.  The cycles are fixed rather than a rolling window, so
+	// the count of bytes sent starts over once a cycle ends.
+	uploadTargetTimeframe = time.Hour * 24
+
+	// uploadTargetBuffer is the portion of the upload target which is
+	// reserved for relaying recent blocks once historical blocks are no
+	// longer served.  It allows for a day's worth of maximum size blocks.
+	uploadTargetBuffer = uint64(btcwire.MaxBlockPayload) * 144
+
+	// historicalBlockAge is the age after which a block is considered
+	// historical and is no longer served to peers which are not whitelisted
+	// once the upload target has been reached.
+	historicalBlockAge = time.Hour * 24 * 7
 )
 
 // broadcastMsg provides the ability to house a bitcoin message to be broadcast
@@ -64,16 +85,28 @@ type server struct {
 	nonce                uint64
 	listeners            []net.Listener
 	netParams            *btcnet.Params
-	started              int32      // atomic
-	shutdown             int32      // atomic
-	shutdownSched        int32      // atomic
-	bytesMutex           sync.Mutex // For the following two fields.
-	bytesReceived        uint64     // Total bytes received from all peers since start.
-	bytesSent            uint64     // Total bytes sent by all peers since start.
+	started              int32             // atomic
+	shutdown             int32             // atomic
+	shutdownSched        int32             // atomic
+	bytesMutex           sync.Mutex        // For the following six fields.
+	bytesReceived        uint64            // Total bytes received from all peers since start.
+	bytesSent            uint64            // Total bytes sent by all peers since start.
+	bytesRecvPerCmd      map[string]uint64 // Total bytes received per message command.
+	bytesSentPerCmd      map[string]uint64 // Total bytes sent per message command.
+	uploadCycleStart     time.Time         // Start of the current upload target cycle.
+	uploadCycleBytes     uint64            // Total bytes sent during the current upload target cycle.
 	addrManager          *AddrManager
 	rpcServer            *rpcServer
+	metricsServer        *metricsServer
 	blockManager         *blockManager
 	txMemPool            *txMemPool
+	feeEstimator         *feeEstimator
+	cpuMiner             *CPUMiner
+	addrIndex            *addrIndex
+	cfIndex              *cfIndex
+	timeSource           *medianTime
+	startupTime          time.Time           // Time the process started.
+	services             btcwire.ServiceFlag // Services advertised to peers.
 	modifyRebroadcastInv chan interface{}
 	newPeers             chan *peer
 	donePeers            chan *peer
@@ -81,6 +114,7 @@ type server struct {
 	wakeup               chan bool
 	query                chan interface{}
 	relayInv             chan *btcwire.InvVect
+	delayedRelayInv      chan *btcwire.InvVect
 	broadcast            chan broadcastMsg
 	wg                   sync.WaitGroup
 	quit                 chan bool
@@ -92,9 +126,10 @@ type peerState struct {
 	peers            *list.List
 	outboundPeers    *list.List
 	persistentPeers  *list.List
-	banned           map[string]time.Time
+	banned           map[string]*banEntry
 	outboundGroups   map[string]int
 	maxOutboundPeers int
+	maxInboundPeers  int
 }
 
 // randomUint16Number returns a random uint16 in a specified input range.  Note
@@ -118,8 +153,8 @@ func randomUint16Number(max uint16) uint16 {
 // AddRebroadcastInventory adds 'iv' to the list of inventories to be
 // rebroadcasted at random intervals until they show up in a block.
 func (s *server) AddRebroadcastInventory(iv *btcwire.InvVect) {
-	// Ignore if shutting down.
-	if atomic.LoadInt32(&s.shutdown) != 0 {
+	// Ignore if shutting down or rebroadcasting is disabled.
+	if atomic.LoadInt32(&s.shutdown) != 0 || cfg.RebroadcastInt == 0 {
 		return
 	}
 
@@ -129,8 +164,8 @@ func (s *server) AddRebroadcastInventory(iv *btcwire.InvVect) {
 // RemoveRebroadcastInventory removes 'iv' from the list of items to be
 // rebroadcasted if present.
 func (s *server) RemoveRebroadcastInventory(iv *btcwire.InvVect) {
-	// Ignore if shutting down.
-	if atomic.LoadInt32(&s.shutdown) != 0 {
+	// Ignore if shutting down or rebroadcasting is disabled.
+	if atomic.LoadInt32(&s.shutdown) != 0 || cfg.RebroadcastInt == 0 {
 		return
 	}
 
@@ -141,6 +176,12 @@ func (p *peerState) Count() int {
 	return p.peers.Len() + p.outboundPeers.Len() + p.persistentPeers.Len()
 }
 
+// InboundCount returns the number of inbound peers, which are the peers the
+// inbound limit (--maxinbound) applies to.
+func (p *peerState) InboundCount() int {
+	return p.peers.Len()
+}
+
 func (p *peerState) OutboundCount() int {
 	return p.outboundPeers.Len() + p.persistentPeers.Len()
 }
@@ -170,6 +211,26 @@ func (p *peerState) forAllPeers(closure func(p *peer)) {
 	p.forAllOutboundPeers(closure)
 }
 
+// calcMaxInboundPeers returns the maximum number of inbound peers for the
+// passed maximum number of outbound peers.  Unless the inbound limit was set
+// explicitly with --maxinbound, the slots needed by the outbound peers along
+// with the peers specified via --addpeer or --connect, which are connected to
+// in addition to them, are reserved.  The limit never exceeds --maxpeers.
+func calcMaxInboundPeers(maxOutbound int) int {
+	maxInbound := cfg.MaxInbound
+	if maxInbound == -1 {
+		maxInbound = cfg.MaxPeers - maxOutbound - len(cfg.AddPeers) -
+			len(cfg.ConnectPeers)
+		if maxInbound < 0 {
+			maxInbound = 0
+		}
+	}
+	if maxInbound > cfg.MaxPeers {
+		maxInbound = cfg.MaxPeers
+	}
+	return maxInbound
+}
+
 // handleAddPeerMsg deals with adding new peers.  It is invoked from the
 // peerHandler goroutine.
 func (s *server) handleAddPeerMsg(state *peerState, p *peer) bool {
@@ -192,16 +253,14 @@ func (s *server) handleAddPeerMsg(state *peerState, p *peer) bool {
 		p.Shutdown()
 		return false
 	}
-	if banEnd, ok := state.banned[host]; ok {
-		if time.Now().Before(banEnd) {
-			srvrLog.Debugf("Peer %s is banned for another %v - "+
-				"disconnecting", host, banEnd.Sub(time.Now()))
+	if !p.whitelisted {
+		if subnet, ban := bannedEntry(state.banned, host); ban != nil {
+			srvrLog.Debugf("Peer %s is banned by %s for another %v "+
+				"- disconnecting", host, subnet,
+				ban.until.Sub(time.Now()))
 			p.Shutdown()
 			return false
 		}
-
-		srvrLog.Infof("Peer %s is no longer banned", host)
-		delete(state.banned, host)
 	}
 
 	// TODO: Check for max peers from a single IP.
@@ -216,6 +275,17 @@ func (s *server) handleAddPeerMsg(state *peerState, p *peer) bool {
 		return false
 	}
 
+	// Limit the number of inbound peers so the slots reserved for outbound
+	// and manually added peers remain available to them.  This is only
+	// checked for new peers, so inbound peers which are already connected
+	// are never disconnected because of the limit.
+	if p.inbound && state.InboundCount() >= state.maxInboundPeers {
+		srvrLog.Infof("Max inbound peers reached [%d] - disconnecting "+
+			"peer %s", state.maxInboundPeers, p)
+		p.Shutdown()
+		return false
+	}
+
 	// Add the new peer and start it.
 	srvrLog.Debugf("New peer %s", p)
 	if p.inbound {
@@ -250,7 +320,13 @@ func (s *server) handleDonePeerMsg(state *peerState, p *peer) {
 			// persistent outbound connection.
 			if !p.inbound && p.persistent &&
 				atomic.LoadInt32(&s.shutdown) == 0 {
-				e.Value = newOutboundPeer(s, p.addr, true)
+				var retryCount int64
+				if time.Since(p.timeConnected) <
+					stableConnectionDuration {
+					retryCount = p.retryCount + 1
+				}
+				e.Value = newOutboundPeer(s, p.addr, true,
+					retryCount)
 				return
 			}
 			if !p.inbound {
@@ -274,10 +350,37 @@ func (s *server) handleBanPeerMsg(state *peerState, p *peer) {
 		return
 	}
 	direction := directionString(p.inbound)
+	if cfg.DisableBanning {
+		srvrLog.Debugf("Not banning peer %s (%s) since banning is "+
+			"disabled", host, direction)
+		return
+	}
+	if p.whitelisted {
+		srvrLog.Debugf("Not banning whitelisted peer %s (%s)", host,
+			direction)
+		return
+	}
+	key, err := parseBanKey(host)
+	if err != nil {
+		srvrLog.Debugf("can't ban peer %s %v", p.addr, err)
+		return
+	}
 	srvrLog.Infof("Banned peer %s (%s) for %v", host, direction,
 		cfg.BanDuration)
-	state.banned[host] = time.Now().Add(cfg.BanDuration)
+	now := time.Now()
+	state.banned[key] = &banEntry{
+		created: now,
+		until:   now.Add(cfg.BanDuration),
+	}
+	s.saveBans(state)
+}
 
+// saveBans saves the current bans to the ban list file so they survive a
+// restart.  It is invoked from the peerHandler goroutine.
+func (s *server) saveBans(state *peerState) {
+	if err := saveBanList(state.banned); err != nil {
+		srvrLog.Errorf("Unable to save ban list: %v", err)
+	}
 }
 
 // handleRelayInvMsg deals with relaying inventory to peers that are not already
@@ -288,6 +391,45 @@ func (s *server) handleRelayInvMsg(state *peerState, iv *btcwire.InvVect) {
 			return
 		}
 
+		// Only relay to the added peers marked as block relay peers
+		// when relaying is restricted to them.  Inbound peers are
+		// never marked, so they don't receive any relayed inventory.
+		if len(cfg.BlockRelayPeers) > 0 && !p.blockRelayPeer {
+			return
+		}
+
+		// Don't relay transactions to peers that are not whitelisted
+		// when running in blocks only mode.
+		if iv.Type == btcwire.InvTypeTx && cfg.BlocksOnly &&
+			!p.whitelisted {
+
+			return
+		}
+
+		if iv.Type == btcwire.InvTypeTx {
+			// Don't relay the transaction to the peer when it has
+			// transaction relaying disabled.
+			if p.RelayTxDisabled() {
+				return
+			}
+
+			// Don't relay the transaction if there is a bloom
+			// filter loaded and the transaction doesn't match it.
+			if p.filter.IsLoaded() {
+				tx, err := s.txMemPool.FetchTransaction(&iv.Hash)
+				if err != nil {
+					srvrLog.Warnf("Attempt to relay tx %s "+
+						"that is not in the memory pool",
+						iv.Hash)
+					return
+				}
+
+				if !p.filter.MatchTxAndUpdate(tx) {
+					return
+				}
+			}
+		}
+
 		// Queue the inventory to be relayed with the next batch.  It
 		// will be ignored if the peer is already known to have the
 		// inventory.
@@ -320,7 +462,7 @@ type getConnCountMsg struct {
 }
 
 type getPeerInfoMsg struct {
-	reply chan []*btcjson.GetPeerInfoResult
+	reply chan []*GetPeerInfoResult
 }
 
 type addNodeMsg struct {
@@ -329,13 +471,46 @@ type addNodeMsg struct {
 	reply     chan error
 }
 
-type delNodeMsg struct {
-	addr  string
+type removeNodeMsg struct {
+	cmp   func(*peer) bool
 	reply chan error
 }
 
+type disconnectNodeMsg struct {
+	cmp   func(*peer) bool
+	reply chan error
+}
+
+// addedNodesReply houses the persistent (added) peers along with the connected
+// peers keyed by their remote host.  The connected peers are included so the
+// connection status of an added node is reported even when it is connected via
+// a different peer, such as when the node connected to us inbound.
+type addedNodesReply struct {
+	added     []*peer
+	connected map[string]*peer
+}
+
 type getAddedNodesMsg struct {
-	reply chan []*peer
+	reply chan *addedNodesReply
+}
+
+type setBanMsg struct {
+	subnet *net.IPNet
+	until  time.Time
+	reply  chan error
+}
+
+type removeBanMsg struct {
+	subnet *net.IPNet
+	reply  chan error
+}
+
+type listBannedMsg struct {
+	reply chan map[string]banEntry
+}
+
+type clearBannedMsg struct {
+	reply chan error
 }
 
 // handleQuery is the central handler for all queries and commands from other
@@ -353,7 +528,7 @@ func (s *server) handleQuery(querymsg interface{}, state *peerState) {
 
 	case getPeerInfoMsg:
 		syncPeer := s.blockManager.SyncPeer()
-		infos := make([]*btcjson.GetPeerInfoResult, 0, state.peers.Len())
+		infos := make([]*GetPeerInfoResult, 0, state.peers.Len())
 		state.forAllPeers(func(p *peer) {
 			if !p.Connected() {
 				return
@@ -364,20 +539,24 @@ func (s *server) handleQuery(querymsg interface{}, state *peerState) {
 			// and we don't really care if they are raced to get the new
 			// version.
 			p.StatsMtx.Lock()
-			info := &btcjson.GetPeerInfoResult{
-				Addr:           p.addr,
-				Services:       fmt.Sprintf("%08d", p.services),
-				LastSend:       p.lastSend.Unix(),
-				LastRecv:       p.lastRecv.Unix(),
-				BytesSent:      p.bytesSent,
-				BytesRecv:      p.bytesReceived,
-				ConnTime:       p.timeConnected.Unix(),
-				Version:        p.protocolVersion,
-				SubVer:         p.userAgent,
-				Inbound:        p.inbound,
-				StartingHeight: p.lastBlock,
-				BanScore:       0,
-				SyncNode:       p == syncPeer,
+			info := &GetPeerInfoResult{
+				ID: p.id,
+				GetPeerInfoResult: &btcjson.GetPeerInfoResult{
+					Addr:           p.addr,
+					Services:       fmt.Sprintf("%08d", p.services),
+					LastSend:       p.lastSend.Unix(),
+					LastRecv:       p.lastRecv.Unix(),
+					BytesSent:      p.bytesSent,
+					BytesRecv:      p.bytesReceived,
+					ConnTime:       p.timeConnected.Unix(),
+					Version:        p.protocolVersion,
+					SubVer:         p.userAgent,
+					Inbound:        p.inbound,
+					StartingHeight: p.lastBlock,
+					BanScore:       int32(p.BanScore()),
+					SyncNode:       p == syncPeer,
+				},
+				TimeOffset: p.timeOffset,
 			}
 			info.PingTime = p.lastPingMicros
 			if p.lastPingNonce != 0 {
@@ -396,29 +575,32 @@ func (s *server) handleQuery(querymsg interface{}, state *peerState) {
 			for e := state.persistentPeers.Front(); e != nil; e = e.Next() {
 				peer := e.Value.(*peer)
 				if peer.addr == msg.addr {
-					msg.reply <- errors.New("peer already connected")
+					msg.reply <- errors.New("node has already " +
+						"been added")
 					return
 				}
 			}
 		}
 		// TODO(oga) if too many, nuke a non-perm peer.
 		if s.handleAddPeerMsg(state,
-			newOutboundPeer(s, msg.addr, msg.permanent)) {
+			newOutboundPeer(s, msg.addr, msg.permanent, 0)) {
 			msg.reply <- nil
 		} else {
 			msg.reply <- errors.New("failed to add peer")
 		}
 
-	case delNodeMsg:
+	case removeNodeMsg:
 		found := false
 		for e := state.persistentPeers.Front(); e != nil; e = e.Next() {
 			peer := e.Value.(*peer)
-			if peer.addr == msg.addr {
+			if msg.cmp(peer) {
 				// Keep group counts ok since we remove from
 				// the list now.
 				state.outboundGroups[GroupKey(peer.na)]--
 				// This is ok because we are not continuing
-				// to iterate so won't corrupt the loop.
+				// to iterate so won't corrupt the loop.  Since
+				// the peer is no longer in the list, it is not
+				// redialed once it is done.
 				state.persistentPeers.Remove(e)
 				peer.Disconnect()
 				found = true
@@ -429,18 +611,106 @@ func (s *server) handleQuery(querymsg interface{}, state *peerState) {
 		if found {
 			msg.reply <- nil
 		} else {
-			msg.reply <- errors.New("peer not found")
+			msg.reply <- errors.New("node has not been added")
+		}
+
+	case disconnectNodeMsg:
+		// Disconnect all connected peers which match.  Persistent peers
+		// are reconnected once they are done.
+		found := false
+		state.forAllPeers(func(p *peer) {
+			if p.Connected() && msg.cmp(p) {
+				p.Disconnect()
+				found = true
+			}
+		})
+
+		if found {
+			msg.reply <- nil
+		} else {
+			msg.reply <- errors.New("node is not connected")
 		}
 
 	// Request a list of the persistent (added) peers.
 	case getAddedNodesMsg:
-		// Respond with a slice of the relavent peers.
+		// Respond with a slice of the relavent peers along with all of
+		// the connected peers keyed by both the host they were reached
+		// at and their IP address.
 		peers := make([]*peer, 0, state.persistentPeers.Len())
 		for e := state.persistentPeers.Front(); e != nil; e = e.Next() {
 			peer := e.Value.(*peer)
 			peers = append(peers, peer)
 		}
-		msg.reply <- peers
+		connected := make(map[string]*peer)
+		state.forAllPeers(func(p *peer) {
+			if !p.Connected() {
+				return
+			}
+			host, _, err := net.SplitHostPort(p.addr)
+			if err != nil {
+				host = p.addr
+			}
+			if _, exists := connected[host]; !exists {
+				connected[host] = p
+			}
+			if p.na != nil {
+				ip := p.na.IP.String()
+				if _, exists := connected[ip]; !exists {
+					connected[ip] = p
+				}
+			}
+		})
+		msg.reply <- &addedNodesReply{added: peers, connected: connected}
+
+	// Ban an IP network and disconnect any connected peers it covers.
+	case setBanMsg:
+		subnet := msg.subnet.String()
+		if ban, ok := state.banned[subnet]; ok &&
+			time.Now().Before(ban.until) {
+			msg.reply <- errors.New("subnet has already been banned")
+			return
+		}
+		state.banned[subnet] = &banEntry{
+			created: time.Now(),
+			until:   msg.until,
+		}
+		s.saveBans(state)
+		state.forAllPeers(func(p *peer) {
+			if p.whitelisted || p.na == nil ||
+				!msg.subnet.Contains(p.na.IP) {
+				return
+			}
+			srvrLog.Infof("Disconnecting peer %s banned by %s", p,
+				subnet)
+			p.Disconnect()
+		})
+		msg.reply <- nil
+
+	case removeBanMsg:
+		subnet := msg.subnet.String()
+		if _, ok := state.banned[subnet]; !ok {
+			msg.reply <- errors.New("subnet has not been banned")
+			return
+		}
+		delete(state.banned, subnet)
+		s.saveBans(state)
+		msg.reply <- nil
+
+	// Respond with a copy of the unexpired bans.
+	case listBannedMsg:
+		now := time.Now()
+		banned := make(map[string]banEntry, len(state.banned))
+		for subnet, ban := range state.banned {
+			if now.Before(ban.until) {
+				banned[subnet] = *ban
+			}
+		}
+		msg.reply <- banned
+
+	case clearBannedMsg:
+		state.banned = make(map[string]*banEntry)
+		s.saveBans(state)
+		msg.reply <- nil
 	}
 }
 
@@ -466,12 +736,22 @@ func (s *server) listenHandler(listener net.Listener) {
 
 // seedFromDNS uses DNS seeding to populate the address manager with peers.
 func (s *server) seedFromDNS() {
-	// Nothing to do if DNS seeding is disabled.
-	if cfg.DisableDNSSeed {
+	// Nothing to do if DNS seeding is disabled.  The seeds only return
+	// IPv4 and IPv6 addresses, so also don't bother querying them when
+	// the onlynet option doesn't permit either.
+	if cfg.DisableDNSSeed ||
+		(!isNetAllowed("ipv4") && !isNetAllowed("ipv6")) {
 		return
 	}
 
-	for _, seeder := range activeNetParams.dnsSeeds {
+	// Use the seeds provided via the configuration instead of the
+	// built-in seeds for the network when there are any.
+	seeders := activeNetParams.dnsSeeds
+	if len(cfg.DNSSeeds) > 0 {
+		seeders = cfg.DNSSeeds
+	}
+
+	for _, seeder := range seeders {
 		seedpeers := dnsDiscover(seeder)
 		if len(seedpeers) == 0 {
 			continue
@@ -518,14 +798,22 @@ func (s *server) peerHandler() {
 		peers:            list.New(),
 		persistentPeers:  list.New(),
 		outboundPeers:    list.New(),
-		banned:           make(map[string]time.Time),
-		maxOutboundPeers: defaultMaxOutbound,
+		maxOutboundPeers: cfg.MaxOutbound,
 		outboundGroups:   make(map[string]int),
 	}
 	if cfg.MaxPeers < state.maxOutboundPeers {
 		state.maxOutboundPeers = cfg.MaxPeers
 	}
 
+	state.maxInboundPeers = calcMaxInboundPeers(state.maxOutboundPeers)
+
+	// Load the bans which were saved by a previous run.
+	banned, err := loadBanList()
+	if err != nil {
+		srvrLog.Warnf("Unable to load ban list: %v", err)
+	}
+	state.banned = banned
+
 	// Add peers discovered through DNS to the address manager.
 	s.seedFromDNS()
 
@@ -535,12 +823,24 @@ func (s *server) peerHandler() {
 		permanentPeers = cfg.AddPeers
 	}
 	for _, addr := range permanentPeers {
-		s.handleAddPeerMsg(state, newOutboundPeer(s, addr, true))
+		s.handleAddPeerMsg(state, newOutboundPeer(s, addr, true, 0))
 	}
 
 	// if nothing else happens, wake us up soon.
 	time.AfterFunc(10*time.Second, func() { s.wakeup <- true })
 
+	// Block inventory held back by the block relay delay is queued until
+	// the timer fires and then relayed all at once.
+	var pendingBlockInvs []*btcwire.InvVect
+	var blockRelayTimer <-chan time.Time
+	flushBlockInvs := func() {
+		for _, iv := range pendingBlockInvs {
+			s.handleRelayInvMsg(state, iv)
+		}
+		pendingBlockInvs = nil
+		blockRelayTimer = nil
+	}
+
 out:
 	for {
 		select {
@@ -560,6 +860,18 @@ out:
 		case invMsg := <-s.relayInv:
 			s.handleRelayInvMsg(state, invMsg)
 
+		// New block inventory to be relayed to other peers once the
+		// block relay delay expires.
+		case invMsg := <-s.delayedRelayInv:
+			pendingBlockInvs = append(pendingBlockInvs, invMsg)
+			if blockRelayTimer == nil {
+				blockRelayTimer = time.After(cfg.BlockRelayDelay)
+			}
+
+		// The block relay delay expired.
+		case <-blockRelayTimer:
+			flushBlockInvs()
+
 		// Message to broadcast to all connected peers except those
 		// which are excluded by the message.
 		case bmsg := <-s.broadcast:
@@ -574,10 +886,34 @@ out:
 
 		// Shutdown the peer handler.
 		case <-s.quit:
-			// Shutdown peers.
+			// Relay any block inventory that is still held back by
+			// the block relay delay so it is queued to the peers
+			// before they are drained.
+		drain:
+			for {
+				select {
+				case invMsg := <-s.delayedRelayInv:
+					pendingBlockInvs = append(pendingBlockInvs,
+						invMsg)
+				default:
+					break drain
+				}
+			}
+			flushBlockInvs()
+
+			// Shutdown peers once they have had a chance to send
+			// any queued messages.  This is done concurrently so
+			// the total time spent waiting is bounded by the
+			// shutdown timeout regardless of the number of peers.
+			var wg sync.WaitGroup
 			state.forAllPeers(func(p *peer) {
-				p.Shutdown()
+				wg.Add(1)
+				go func(p *peer) {
+					p.DrainAndShutdown(cfg.ShutdownTimeout)
+					wg.Done()
+				}(p)
 			})
+			wg.Wait()
 			break out
 		}
 
@@ -628,7 +964,12 @@ out:
 				break
 			}
 
-			// XXX if we have limited that address skip
+			// Skip addresses on networks the onlynet option
+			// doesn't permit connecting to, such as addresses
+			// which were learned before it was specified.
+			if !isNetAllowed(netAddressNetwork(addr.na)) {
+				continue
+			}
 
 			// only allow recent nodes (10mins) after we failed 30
 			// times
@@ -649,7 +990,7 @@ out:
 			// any failure will be due to banned peers etc. we have
 			// already checked that we have room for more peers.
 			if s.handleAddPeerMsg(state,
-				newOutboundPeer(s, addrStr, false)) {
+				newOutboundPeer(s, addrStr, false, 0)) {
 			}
 		}
 
@@ -663,6 +1004,13 @@ out:
 
 	s.blockManager.Stop()
 	s.addrManager.Stop()
+	s.feeEstimator.Save()
+	if s.addrIndex != nil {
+		s.addrIndex.Close()
+	}
+	if s.cfIndex != nil {
+		s.cfIndex.Close()
+	}
 	s.wg.Done()
 	srvrLog.Tracef("Peer handler done")
 }
@@ -677,12 +1025,50 @@ func (s *server) BanPeer(p *peer) {
 	s.banPeers <- p
 }
 
+// SetBan bans the passed IP network until the passed time and disconnects any
+// connected peers it covers which are not whitelisted.  An error is returned if
+// the network is already banned.
+func (s *server) SetBan(subnet *net.IPNet, until time.Time) error {
+	replyChan := make(chan error)
+	s.query <- setBanMsg{subnet: subnet, until: until, reply: replyChan}
+	return <-replyChan
+}
+
+// RemoveBan removes the ban of the passed IP network.  An error is returned if
+// the network is not banned.
+func (s *server) RemoveBan(subnet *net.IPNet) error {
+	replyChan := make(chan error)
+	s.query <- removeBanMsg{subnet: subnet, reply: replyChan}
+	return <-replyChan
+}
+
+// ListBanned returns the unexpired bans keyed by the banned IP network.
+func (s *server) ListBanned() map[string]banEntry {
+	replyChan := make(chan map[string]banEntry)
+	s.query <- listBannedMsg{reply: replyChan}
+	return <-replyChan
+}
+
+// ClearBanned removes all bans.
+func (s *server) ClearBanned() error {
+	replyChan := make(chan error)
+	s.query <- clearBannedMsg{reply: replyChan}
+	return <-replyChan
+}
+
 // RelayInventory relays the passed inventory to all connected peers that are
 // not already known to have it.
 func (s *server) RelayInventory(invVect *btcwire.InvVect) {
 	s.relayInv <- invVect
 }
 
+// RelayInventoryDelayed relays the passed inventory to all connected peers that
+// are not already known to have it once the configured block relay delay has
+// passed.  Inventory passed during the delay is relayed along with it.
+func (s *server) RelayInventoryDelayed(invVect *btcwire.InvVect) {
+	s.delayedRelayInv <- invVect
+}
+
 // BroadcastMessage sends msg to all peers currently connected to the server
 // except those in the passed peers to exclude.
 func (s *server) BroadcastMessage(msg btcwire.Message, exclPeers ...*peer) {
@@ -701,18 +1087,19 @@ func (s *server) ConnectedCount() int {
 	return <-replyChan
 }
 
-// AddedNodeInfo returns an array of btcjson.GetAddedNodeInfoResult structures
-// describing the persistent (added) nodes.
-func (s *server) AddedNodeInfo() []*peer {
-	replyChan := make(chan []*peer)
+// AddedNodeInfo returns the persistent (added) peers along with the connected
+// peers keyed by their remote host and IP address.
+func (s *server) AddedNodeInfo() ([]*peer, map[string]*peer) {
+	replyChan := make(chan *addedNodesReply)
 	s.query <- getAddedNodesMsg{reply: replyChan}
-	return <-replyChan
+	reply := <-replyChan
+	return reply.added, reply.connected
 }
 
 // PeerInfo returns an array of PeerInfo structures describing all connected
 // peers.
-func (s *server) PeerInfo() []*btcjson.GetPeerInfoResult {
-	replyChan := make(chan []*btcjson.GetPeerInfoResult)
+func (s *server) PeerInfo() []*GetPeerInfoResult {
+	replyChan := make(chan []*GetPeerInfoResult)
 
 	s.query <- getPeerInfoMsg{reply: replyChan}
 
@@ -730,32 +1117,142 @@ func (s *server) AddAddr(addr string, permanent bool) error {
 	return <-replyChan
 }
 
-// RemoveAddr removes `addr' from the list of persistent peers if present.
-// An error will be returned if the peer was not found.
-func (s *server) RemoveAddr(addr string) error {
+// RemoveNodeByAddr removes the persistent peer with the passed address from the
+// list of persistent peers if present and disconnects it.  The peer will not be
+// redialed.  An error will be returned if the peer was not found.
+func (s *server) RemoveNodeByAddr(addr string) error {
 	replyChan := make(chan error)
 
-	s.query <- delNodeMsg{addr: addr, reply: replyChan}
+	s.query <- removeNodeMsg{
+		cmp:   func(p *peer) bool { return p.addr == addr },
+		reply: replyChan,
+	}
+
+	return <-replyChan
+}
+
+// RemoveNodeByID removes the persistent peer with the passed id from the list
+// of persistent peers if present and disconnects it.  The peer will not be
+// redialed.  An error will be returned if the peer was not found.
+func (s *server) RemoveNodeByID(id int32) error {
+	replyChan := make(chan error)
+
+	s.query <- removeNodeMsg{
+		cmp:   func(p *peer) bool { return p.id == id },
+		reply: replyChan,
+	}
+
+	return <-replyChan
+}
+
+// DisconnectNodeByAddr disconnects the connected peers with the passed address.
+// Persistent peers are reconnected.  An error will be returned if no peer was
+// found.
+func (s *server) DisconnectNodeByAddr(addr string) error {
+	replyChan := make(chan error)
+
+	s.query <- disconnectNodeMsg{
+		cmp:   func(p *peer) bool { return p.addr == addr },
+		reply: replyChan,
+	}
+
+	return <-replyChan
+}
+
+// DisconnectNodeByID disconnects the connected peer with the passed id.
+// Persistent peers are reconnected.  An error will be returned if the peer was
+// not found.
+func (s *server) DisconnectNodeByID(id int32) error {
+	replyChan := make(chan error)
+
+	s.query <- disconnectNodeMsg{
+		cmp:   func(p *peer) bool { return p.id == id },
+		reply: replyChan,
+	}
 
 	return <-replyChan
 }
 
 // AddBytesSent adds the passed number of bytes to the total bytes sent counter
-// for the server.  It is safe for concurrent access.
-func (s *server) AddBytesSent(bytesSent uint64) {
+// for the server and to the counter for the passed message command.  It is safe
+// for concurrent access.
+func (s *server) AddBytesSent(command string, bytesSent uint64) {
 	s.bytesMutex.Lock()
 	defer s.bytesMutex.Unlock()
 
 	s.bytesSent += bytesSent
+	s.bytesSentPerCmd[command] += bytesSent
+	s.maybeResetUploadCycle(time.Now())
+	s.uploadCycleBytes += bytesSent
+}
+
+// maybeResetUploadCycle starts a new upload target cycle when the current one
+// has lasted for the upload target timeframe.  The new cycle starts at the
+// passed time rather than where the previous one ended, so an idle period in
+// between does not shorten it.
+//
+// This function MUST be called with the bytes mutex held.
+func (s *server) maybeResetUploadCycle(now time.Time) {
+	if now.Sub(s.uploadCycleStart) >= uploadTargetTimeframe {
+		s.uploadCycleStart = now
+		s.uploadCycleBytes = 0
+	}
+}
+
+// uploadTargetInfo describes the state of the upload target during the current
+// cycle.
+type uploadTargetInfo struct {
+	target           uint64
+	targetReached    bool
+	serveHistorical  bool
+	bytesLeftInCycle uint64
+	timeLeftInCycle  time.Duration
+}
+
+// UploadTargetInfo returns the state of the upload target during the current
+// cycle.  It is safe for concurrent access.
+func (s *server) UploadTargetInfo() *uploadTargetInfo {
+	s.bytesMutex.Lock()
+	defer s.bytesMutex.Unlock()
+
+	now := time.Now()
+	s.maybeResetUploadCycle(now)
+	info := &uploadTargetInfo{
+		target:          cfg.MaxUploadTarget * 1024 * 1024,
+		serveHistorical: true,
+	}
+	if info.target == 0 {
+		return info
+	}
+
+	info.targetReached = s.uploadCycleBytes >= info.target
+	info.serveHistorical = s.uploadCycleBytes+uploadTargetBuffer <
+		info.target
+	if !info.targetReached {
+		info.bytesLeftInCycle = info.target - s.uploadCycleBytes
+	}
+	cycleEnd := s.uploadCycleStart.Add(uploadTargetTimeframe)
+	info.timeLeftInCycle = cycleEnd.Sub(now)
+	return info
+}
+
+// ServeHistoricalBlocks returns whether or not historical blocks may be served
+// to peers which are not whitelisted.  They are no longer served once the
+// upload target, less the portion reserved for relaying recent blocks, has been
+// reached during the current cycle.  It is safe for concurrent access.
+func (s *server) ServeHistoricalBlocks() bool {
+	return s.UploadTargetInfo().serveHistorical
 }
 
 // AddBytesReceived adds the passed number of bytes to the total bytes received
-// counter for the server.  It is safe for concurrent access.
-func (s *server) AddBytesReceived(bytesReceived uint64) {
+// counter for the server and to the counter for the passed message command.
+// It is safe for concurrent access.
+func (s *server) AddBytesReceived(command string, bytesReceived uint64) {
 	s.bytesMutex.Lock()
 	defer s.bytesMutex.Unlock()
 
 	s.bytesReceived += bytesReceived
+	s.bytesRecvPerCmd[command] += bytesReceived
 }
 
 // NetTotals returns the sum of all bytes received and sent across the network
@@ -767,12 +1264,38 @@ func (s *server) NetTotals() (uint64, uint64) {
 	return s.bytesReceived, s.bytesSent
 }
 
+// NetTotalsPerCommand returns the bytes received and sent across the network
+// for all peers broken down by message command.  Bytes which could not be
+// attributed to a command, such as those of malformed messages, are tallied
+// under otherCommand.  The returned maps are copies and may be modified by the
+// caller.  It is safe for concurrent access.
+func (s *server) NetTotalsPerCommand() (map[string]uint64, map[string]uint64) {
+	s.bytesMutex.Lock()
+	defer s.bytesMutex.Unlock()
+
+	recv := make(map[string]uint64, len(s.bytesRecvPerCmd))
+	for command, bytes := range s.bytesRecvPerCmd {
+		recv[command] = bytes
+	}
+	sent := make(map[string]uint64, len(s.bytesSentPerCmd))
+	for command, bytes := range s.bytesSentPerCmd {
+		sent[command] = bytes
+	}
+	return recv, sent
+}
+
+// nextRebroadcastDelay returns a random delay between half of and the full
+// rebroadcast interval so rebroadcasts don't happen at predictable times.
+func nextRebroadcastDelay() time.Duration {
+	half := cfg.RebroadcastInt / 2
+	return half + half*time.Duration(randomUint16Number(1001))/1000
+}
+
 // rebroadcastHandler keeps track of user submitted inventories that we have
 // sent out but have not yet made it into a block. We periodically rebroadcast
 // them in case our peers restarted or otherwise lost track of them.
 func (s *server) rebroadcastHandler() {
-	// Wait 5 min before first tx rebroadcast.
-	timer := time.NewTimer(5 * time.Minute)
+	timer := time.NewTimer(nextRebroadcastDelay())
 	pendingInvs := make(map[btcwire.InvVect]struct{})
 
 out:
@@ -795,15 +1318,25 @@ out:
 		case <-timer.C:
 			// Any inventory we have has not made it into a block
 			// yet. We periodically resubmit them until they have.
+			// Transactions which left the memory pool for any
+			// other reason, such as a conflicting transaction
+			// being mined or being evicted, are no longer valid to
+			// relay, so stop tracking them.
 			for iv := range pendingInvs {
+				if !s.txMemPool.HaveTransaction(&iv.Hash) {
+					delete(pendingInvs, iv)
+					continue
+				}
+				if !s.txMemPool.IsTransactionInPool(&iv.Hash) {
+					// Orphans can't be relayed until
+					// their parents are known.
+					continue
+				}
 				ivCopy := iv
 				s.RelayInventory(&ivCopy)
 			}
 
-			// Process at a random time up to 30mins (in seconds)
-			// in the future.
-			timer.Reset(time.Second *
-				time.Duration(randomUint16Number(1800)))
+			timer.Reset(nextRebroadcastDelay())
 
 		case <-s.quit:
 			break out
@@ -852,14 +1385,19 @@ func (s *server) Start() {
 	}
 
 	if !cfg.DisableRPC {
-		s.wg.Add(1)
-
 		// Start the rebroadcastHandler, which ensures user tx received by
 		// the RPC server are rebroadcast until being included in a block.
-		go s.rebroadcastHandler()
+		if cfg.RebroadcastInt != 0 {
+			s.wg.Add(1)
+			go s.rebroadcastHandler()
+		}
 
 		s.rpcServer.Start()
 	}
+
+	if s.metricsServer != nil {
+		s.metricsServer.Start()
+	}
 }
 
 // Stop gracefully shuts down the server by stopping and disconnecting all
@@ -887,6 +1425,14 @@ func (s *server) Stop() error {
 		s.rpcServer.Stop()
 	}
 
+	// Stop serving metrics if enabled.
+	if s.metricsServer != nil {
+		s.metricsServer.Stop()
+	}
+
+	// Stop the CPU miner if it is running.
+	s.cpuMiner.Stop()
+
 	// Signal the remaining goroutines to quit.
 	close(s.quit)
 	return nil
@@ -962,6 +1508,14 @@ func parseListeners(addrs []string) ([]string, []string, bool, error) {
 			continue
 		}
 
+		// Strip the zone identifier of IPv6 link-local addresses such
+		// as fe80::1%eth0 since it is not part of the IP itself.  The
+		// zone is retained in the listen address so the bind happens
+		// on the correct interface.
+		if zoneIdx := strings.LastIndex(host, "%"); zoneIdx > 0 {
+			host = host[:zoneIdx]
+		}
+
 		// Parse the IP.
 		ip := net.ParseIP(host)
 		if ip == nil {
@@ -1009,7 +1563,7 @@ out:
 					continue out
 				}
 				na := btcwire.NewNetAddressIPPort(externalip, uint16(listenPort),
-					btcwire.SFNodeNetwork)
+					s.services)
 				s.addrManager.addLocalAddress(na, UpnpPrio)
 				srvrLog.Warnf("Successfully bound via UPnP to %s", NetAddressKey(na))
 				first = false
@@ -1054,37 +1608,29 @@ func newServer(listenAddrs []string, db btcdb.Db, netParams *btcnet.Params) (*se
 		discover := true
 		if len(cfg.ExternalIPs) != 0 {
 			discover = false
-			// if this fails we have real issues.
-			port, _ := strconv.ParseUint(
-				activeNetParams.DefaultPort, 10, 16)
 
+			// The addresses are advertised exactly as specified
+			// with the default port used when there isn't one.
+			// The optional score raises the priority of the
+			// address over the other external addresses.
 			for _, sip := range cfg.ExternalIPs {
-				eport := uint16(port)
-				host, portstr, err := net.SplitHostPort(sip)
+				host, eport, score, err := parseExternalIP(sip,
+					activeNetParams.DefaultPort)
 				if err != nil {
-					// no port, use default.
-					host = sip
-				} else {
-					port, err := strconv.ParseUint(
-						portstr, 10, 16)
-					if err != nil {
-						srvrLog.Warnf("Can not parse "+
-							"port from %s for "+
-							"externalip: %v", sip,
-							err)
-						continue
-					}
-					eport = uint16(port)
+					srvrLog.Warnf("Can not parse externalip "+
+						"%s: %v", sip, err)
+					continue
 				}
 				na, err := hostToNetAddress(host, eport,
-					btcwire.SFNodeNetwork)
+					cfg.services)
 				if err != nil {
 					srvrLog.Warnf("Not adding %s as "+
 						"externalip: %v", sip, err)
 					continue
 				}
 
-				amgr.addLocalAddress(na, ManualPrio)
+				amgr.addLocalAddress(na,
+					ManualPrio+addressPrio(score))
 			}
 		} else if discover && cfg.Upnp {
 			nat, err = Discover()
@@ -1110,7 +1656,7 @@ func newServer(listenAddrs []string, db btcdb.Db, netParams *btcnet.Params) (*se
 					continue
 				}
 				na := btcwire.NewNetAddressIPPort(ip,
-					uint16(port), btcwire.SFNodeNetwork)
+					uint16(port), cfg.services)
 				if discover {
 					amgr.addLocalAddress(na, InterfacePrio)
 				}
@@ -1128,7 +1674,9 @@ func newServer(listenAddrs []string, db btcdb.Db, netParams *btcnet.Params) (*se
 			listeners = append(listeners, listener)
 
 			if discover {
-				if na, err := deserialiseNetAddress(addr); err == nil {
+				na, err := deserialiseNetAddress(addr,
+					cfg.services)
+				if err == nil {
 					amgr.addLocalAddress(na, BoundPrio)
 				}
 			}
@@ -1143,7 +1691,9 @@ func newServer(listenAddrs []string, db btcdb.Db, netParams *btcnet.Params) (*se
 			}
 			listeners = append(listeners, listener)
 			if discover {
-				if na, err := deserialiseNetAddress(addr); err == nil {
+				na, err := deserialiseNetAddress(addr,
+					cfg.services)
+				if err == nil {
 					amgr.addLocalAddress(na, BoundPrio)
 				}
 			}
@@ -1159,15 +1709,22 @@ func newServer(listenAddrs []string, db btcdb.Db, netParams *btcnet.Params) (*se
 		listeners:            listeners,
 		netParams:            netParams,
 		addrManager:          amgr,
+		bytesRecvPerCmd:      make(map[string]uint64),
+		bytesSentPerCmd:      make(map[string]uint64),
+		uploadCycleStart:     time.Now(),
 		newPeers:             make(chan *peer, cfg.MaxPeers),
 		donePeers:            make(chan *peer, cfg.MaxPeers),
 		banPeers:             make(chan *peer, cfg.MaxPeers),
 		wakeup:               make(chan bool),
 		query:                make(chan interface{}),
 		relayInv:             make(chan *btcwire.InvVect, cfg.MaxPeers),
+		delayedRelayInv:      make(chan *btcwire.InvVect, cfg.MaxPeers),
 		broadcast:            make(chan broadcastMsg, cfg.MaxPeers),
 		quit:                 make(chan bool),
 		modifyRebroadcastInv: make(chan interface{}),
+		timeSource:           newMedianTime(cfg.MaxTimeOffset),
+		startupTime:          processStartTime,
+		services:             cfg.services,
 		nat:                  nat,
 		db:                   db,
 	}
@@ -1177,6 +1734,21 @@ func newServer(listenAddrs []string, db btcdb.Db, netParams *btcnet.Params) (*se
 	}
 	s.blockManager = bm
 	s.txMemPool = newTxMemPool(&s)
+	s.feeEstimator = newFeeEstimator()
+	s.feeEstimator.Load()
+	s.cpuMiner = newCPUMiner(&s)
+	if cfg.AddrIndex {
+		s.addrIndex, err = newAddrIndex(db)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.CFilters {
+		s.cfIndex, err = newCFIndex(db)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	if !cfg.DisableRPC {
 		s.rpcServer, err = newRPCServer(cfg.RPCListeners, &s)
@@ -1184,6 +1756,13 @@ func newServer(listenAddrs []string, db btcdb.Db, netParams *btcnet.Params) (*se
 			return nil, err
 		}
 	}
+	if len(cfg.MetricsListeners) > 0 {
+		s.metricsServer, err = newMetricsServer(cfg.MetricsListeners,
+			&s)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return &s, nil
 }
 
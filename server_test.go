@@ -0,0 +1,187 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"container/list"
+	"github.com/conformal/btcwire"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestUploadTargetCycle ensures the count of bytes sent toward the upload
+// target starts over once a cycle has lasted for the upload target timeframe.
+func TestUploadTargetCycle(t *testing.T) {
+	start := time.Unix(1400000000, 0)
+	s := &server{uploadCycleStart: start, uploadCycleBytes: 1000}
+
+	s.maybeResetUploadCycle(start.Add(uploadTargetTimeframe - time.Second))
+	if s.uploadCycleBytes != 1000 || !s.uploadCycleStart.Equal(start) {
+		t.Fatalf("cycle reset before the timeframe elapsed")
+	}
+
+	// The new cycle starts when the reset happens rather than where the
+	// previous cycle ended.
+	now := start.Add(uploadTargetTimeframe + time.Hour)
+	s.maybeResetUploadCycle(now)
+	if s.uploadCycleBytes != 0 {
+		t.Errorf("unexpected bytes after reset - got %d, want 0",
+			s.uploadCycleBytes)
+	}
+	if !s.uploadCycleStart.Equal(now) {
+		t.Errorf("unexpected cycle start - got %v, want %v",
+			s.uploadCycleStart, now)
+	}
+}
+
+// TestUploadTargetInfo ensures historical blocks stop being served once the
+// bytes sent during the cycle come within the reserved buffer of the target and
+// the target is reported as reached once the bytes sent meet it.
+func TestUploadTargetInfo(t *testing.T) {
+	savedCfg := cfg
+	defer func() {
+		cfg = savedCfg
+	}()
+
+	const mib = 1024 * 1024
+	tests := []struct {
+		name       string
+		target     uint64 // MiB
+		sent       uint64
+		reached    bool
+		historical bool
+		left       uint64
+	}{
+		{"disabled", 0, 1 << 40, false, true, 0},
+		{"nothing sent", 1000, 0, false, true, 1000 * mib},
+		{"below buffer", 1000, 1000*mib - uploadTargetBuffer - 1,
+			false, true, uploadTargetBuffer + 1},
+		{"within buffer", 1000, 1000*mib - uploadTargetBuffer,
+			false, false, uploadTargetBuffer},
+		{"reached", 1000, 1000 * mib, true, false, 0},
+		{"exceeded", 1000, 2000 * mib, true, false, 0},
+		{"minimum target", 138, 0, false, true, 138 * mib},
+	}
+
+	for _, test := range tests {
+		cfg = &config{MaxUploadTarget: test.target}
+		s := &server{uploadCycleStart: time.Now(),
+			uploadCycleBytes: test.sent}
+		info := s.UploadTargetInfo()
+		if info.target != test.target*mib {
+			t.Errorf("%s: unexpected target - got %d, want %d",
+				test.name, info.target, test.target*mib)
+		}
+		if info.targetReached != test.reached {
+			t.Errorf("%s: unexpected target reached - got %v, "+
+				"want %v", test.name, info.targetReached,
+				test.reached)
+		}
+		if info.serveHistorical != test.historical {
+			t.Errorf("%s: unexpected serve historical - got %v, "+
+				"want %v", test.name, info.serveHistorical,
+				test.historical)
+		}
+		if info.bytesLeftInCycle != test.left {
+			t.Errorf("%s: unexpected bytes left - got %d, want %d",
+				test.name, info.bytesLeftInCycle, test.left)
+		}
+	}
+}
+
+// TestCalcMaxInboundPeers ensures the slots needed by outbound and manually
+// added peers are only reserved when the inbound limit is not set explicitly,
+// and that an explicit limit of 0 is honored.
+func TestCalcMaxInboundPeers(t *testing.T) {
+	savedCfg := cfg
+	defer func() {
+		cfg = savedCfg
+	}()
+
+	tests := []struct {
+		name        string
+		maxPeers    int
+		maxInbound  int
+		maxOutbound int
+		addPeers    []string
+		want        int
+	}{
+		{"automatic", 125, -1, 8, nil, 117},
+		{"automatic with addpeer", 125, -1, 8, []string{"a", "b"}, 115},
+		{"automatic without room", 8, -1, 8, []string{"a"}, 0},
+		{"explicit", 125, 50, 8, nil, 50},
+		{"explicit limited by maxpeers", 125, 200, 8, nil, 125},
+		{"no inbound peers", 125, 0, 8, nil, 0},
+	}
+
+	for _, test := range tests {
+		cfg = &config{MaxPeers: test.maxPeers,
+			MaxInbound: test.maxInbound, AddPeers: test.addPeers}
+		got := calcMaxInboundPeers(test.maxOutbound)
+		if got != test.want {
+			t.Errorf("%s: unexpected max inbound peers - got %d, "+
+				"want %d", test.name, got, test.want)
+		}
+	}
+}
+
+// TestHandleAddPeerMsgInboundLimit ensures new inbound peers are rejected once
+// the inbound limit is reached while outbound peers are still accepted.
+func TestHandleAddPeerMsgInboundLimit(t *testing.T) {
+	savedCfg := cfg
+	defer func() {
+		cfg = savedCfg
+	}()
+	cfg = &config{MaxPeers: 10}
+
+	// newTestPeer returns a peer which is already marked as started so
+	// accepting it doesn't start its handlers.
+	na := btcwire.NewNetAddressIPPort(net.ParseIP("10.0.0.1"), 8333, 0)
+	newTestPeer := func(inbound bool) *peer {
+		return &peer{
+			addr:    "10.0.0.1:8333",
+			na:      na,
+			inbound: inbound,
+			started: 1,
+			quit:    make(chan bool),
+		}
+	}
+
+	s := &server{}
+	for _, maxInbound := range []int{0, 2} {
+		state := &peerState{
+			peers:            list.New(),
+			outboundPeers:    list.New(),
+			persistentPeers:  list.New(),
+			outboundGroups:   make(map[string]int),
+			maxOutboundPeers: 8,
+			maxInboundPeers:  maxInbound,
+		}
+
+		for i := 0; i < maxInbound; i++ {
+			if !s.handleAddPeerMsg(state, newTestPeer(true)) {
+				t.Fatalf("max %d: inbound peer %d rejected",
+					maxInbound, i)
+			}
+		}
+		p := newTestPeer(true)
+		if s.handleAddPeerMsg(state, p) {
+			t.Errorf("max %d: inbound peer beyond the limit "+
+				"accepted", maxInbound)
+		}
+		if p.disconnect == 0 {
+			t.Errorf("max %d: rejected inbound peer not "+
+				"disconnected", maxInbound)
+		}
+		if got := state.InboundCount(); got != maxInbound {
+			t.Errorf("max %d: unexpected inbound count - got %d, "+
+				"want %d", maxInbound, got, maxInbound)
+		}
+		if !s.handleAddPeerMsg(state, newTestPeer(false)) {
+			t.Errorf("max %d: outbound peer rejected", maxInbound)
+		}
+	}
+}
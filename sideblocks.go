@@ -0,0 +1,80 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// sideBlocksDirname is the name of the directory in the data directory the
+// side block store is kept in.
+const sideBlocksDirname = "sideblocks"
+
+// sideBlockStore keeps the blocks which are not part of the main chain, such
+// as side chain blocks and blocks disconnected by invalidateblock, on disk.
+// The block database only holds the main chain and the chain code only keeps
+// side chain blocks in memory until it is reset, so these blocks are stored
+// separately in order to be able to process them again without keeping them
+// all in memory.  Each block is stored in its own file named after its hash.
+// The side chains are only tracked in memory, so the store is emptied when it
+// is opened.
+type sideBlockStore struct {
+	dir string
+}
+
+// newSideBlockStore returns an empty side block store in the passed directory.
+// Any blocks left over in the directory from a previous run are removed.
+func newSideBlockStore(dir string) (*sideBlockStore, error) {
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &sideBlockStore{dir: dir}, nil
+}
+
+// path returns the path of the file the block with the passed hash is stored
+// in.
+func (s *sideBlockStore) path(hash *btcwire.ShaHash) string {
+	return filepath.Join(s.dir, hash.String())
+}
+
+// put adds the passed block to the store, replacing any previously stored copy
+// of it.
+func (s *sideBlockStore) put(block *btcutil.Block) error {
+	hash, err := block.Sha()
+	if err != nil {
+		return err
+	}
+	serialized, err := block.Bytes()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path(hash), serialized, 0600)
+}
+
+// fetch returns the block with the passed hash from the store.
+func (s *sideBlockStore) fetch(hash *btcwire.ShaHash) (*btcutil.Block, error) {
+	serialized, err := ioutil.ReadFile(s.path(hash))
+	if err != nil {
+		return nil, err
+	}
+	return btcutil.NewBlockFromBytes(serialized)
+}
+
+// remove removes the block with the passed hash from the store.  It is not an
+// error when the block is not in the store.
+func (s *sideBlockStore) remove(hash *btcwire.ShaHash) error {
+	err := os.Remove(s.path(hash))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -57,3 +57,37 @@ func addInterruptHandler(handler func()) {
 
 	addHandlerChannel <- handler
 }
+
+// reloadSignals defines the signals which request the debug level to be
+// reloaded from the configuration file.  It is only populated on platforms
+// that support such a signal (SIGHUP).
+var reloadSignals []os.Signal
+
+// reloadHandler listens for reload signals on the passed channel and re-reads
+// the debug level from the configuration file accordingly.  An invalid debug
+// level only results in a warning so the existing log levels remain in
+// effect.  It must be run as a goroutine.
+func reloadHandler(reloadChan <-chan os.Signal) {
+	for sig := range reloadChan {
+		btcdLog.Infof("Received %v.  Reloading debug level from %s",
+			sig, cfg.ConfigFile)
+		if err := reloadDebugLevel(); err != nil {
+			btcdLog.Warnf("Unable to reload debug level -- keeping "+
+				"existing log levels: %v", err)
+			continue
+		}
+		btcdLog.Infof("Debug level set to %s", cfg.DebugLevel)
+	}
+}
+
+// startReloadHandler starts listening for reload signals when the platform
+// supports them.
+func startReloadHandler() {
+	if len(reloadSignals) == 0 {
+		return
+	}
+
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, reloadSignals...)
+	go reloadHandler(reloadChan)
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	socks4Version       = 0x04
+	socks4CmdConnect    = 0x01
+	socks4Granted       = 0x5a
+	socks4Rejected      = 0x5b
+	socks4NoIdentd      = 0x5c
+	socks4IdentMismatch = 0x5d
+)
+
+var (
+	errSocks4aInvalidResponse = errors.New("Invalid SOCKS4a proxy response")
+	errSocks4aIPv6            = errors.New("SOCKS4a does not support IPv6 addresses")
+
+	socks4StatusErrors = map[byte]error{
+		socks4Rejected:      errors.New("SOCKS4a request rejected or failed"),
+		socks4NoIdentd:      errors.New("SOCKS4a request rejected because the proxy could not connect to identd"),
+		socks4IdentMismatch: errors.New("SOCKS4a request rejected because of an identd user id mismatch"),
+	}
+)
+
+// socks4aProxy dials connections through a SOCKS4a proxy.  Unlike SOCKS5, the
+// SOCKS4a protocol does not support username/password authentication.
+// Hostnames are passed through to the proxy for resolution.
+type socks4aProxy struct {
+	Addr string
+}
+
+// handshake asks the proxy to connect to the passed host and port over the
+// passed connection.  Hostnames are passed through to the proxy for
+// resolution.
+func (p *socks4aProxy) handshake(conn net.Conn, host string, port uint16) error {
+	// Build the connect request.  When the host is an IPv4 address, it is
+	// sent directly.  Otherwise, the SOCKS4a extension of an invalid IP of
+	// the form 0.0.0.x followed by the hostname is used so the proxy
+	// resolves the host.
+	//
+	// The request is of the form:
+	//  version (1) | command (1) | port (2) | ip (4) | user id | 0x00
+	//  [| hostname | 0x00]
+	buf := make([]byte, 8, 8+1+len(host)+1)
+	buf[0] = socks4Version
+	buf[1] = socks4CmdConnect
+	binary.BigEndian.PutUint16(buf[2:4], port)
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		buf[7] = 1
+	case ip.To4() != nil:
+		copy(buf[4:8], ip.To4())
+	default:
+		return errSocks4aIPv6
+	}
+	buf = append(buf, 0) // Empty user id.
+	if ip == nil {
+		buf = append(buf, host...)
+		buf = append(buf, 0)
+	}
+	if _, err := conn.Write(buf); err != nil {
+		return err
+	}
+
+	// The reply is of the form:
+	//  null (1) | status (1) | port (2) | ip (4)
+	reply := make([]byte, 8)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 0 {
+		return errSocks4aInvalidResponse
+	}
+	if reply[1] != socks4Granted {
+		if err, ok := socks4StatusErrors[reply[1]]; ok {
+			return err
+		}
+		return errSocks4aInvalidResponse
+	}
+	return nil
+}
+
+// Dial connects to the address on the named network through the proxy.  Only
+// the tcp networks are supported.
+func (p *socks4aProxy) Dial(network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("SOCKS4a does not support network %q",
+			network)
+	}
+
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+
+	// The whole exchange with the proxy, including connecting to it, must
+	// complete within the peer timeout so an unresponsive proxy or
+	// destination doesn't hold up the connection attempt indefinitely.
+	deadline := time.Now().Add(cfg.PeerTimeout)
+	conn, err := directDial("tcp", p.Addr)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(deadline)
+	if err := p.handshake(conn, host, uint16(port)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+// TestSocks4aHandshake ensures the SOCKS4a handshake sends the expected connect
+// request, passing hostnames through to the proxy, and handles the replies of
+// the proxy, including error replies, as expected.
+func TestSocks4aHandshake(t *testing.T) {
+	// Common requests and replies.
+	connectIPv4 := []byte{0x04, 0x01, 0x20, 0x8d, 127, 0, 0, 1, 0x00}
+	granted := []byte{0x00, 0x5a, 0, 0, 0, 0, 0, 0}
+
+	tests := []struct {
+		name   string
+		host   string
+		port   uint16
+		script []socks5Exchange
+		err    error
+	}{
+		{
+			name: "ipv4",
+			host: "127.0.0.1",
+			port: 8333,
+			script: []socks5Exchange{
+				{connectIPv4, granted},
+			},
+		},
+		{
+			name: "hostname",
+			host: "example.com",
+			port: 8333,
+			script: []socks5Exchange{
+				{
+					append(append([]byte{0x04, 0x01, 0x20,
+						0x8d, 0, 0, 0, 1, 0x00},
+						"example.com"...), 0x00),
+					granted,
+				},
+			},
+		},
+		{
+			name: "ipv6",
+			host: "::1",
+			port: 8333,
+			err:  errSocks4aIPv6,
+		},
+		{
+			name: "rejected",
+			host: "127.0.0.1",
+			port: 8333,
+			script: []socks5Exchange{
+				{connectIPv4, []byte{0x00, 0x5b, 0, 0, 0, 0, 0,
+					0}},
+			},
+			err: socks4StatusErrors[socks4Rejected],
+		},
+		{
+			name: "no identd",
+			host: "127.0.0.1",
+			port: 8333,
+			script: []socks5Exchange{
+				{connectIPv4, []byte{0x00, 0x5c, 0, 0, 0, 0, 0,
+					0}},
+			},
+			err: socks4StatusErrors[socks4NoIdentd],
+		},
+		{
+			name: "unknown status",
+			host: "127.0.0.1",
+			port: 8333,
+			script: []socks5Exchange{
+				{connectIPv4, []byte{0x00, 0x42, 0, 0, 0, 0, 0,
+					0}},
+			},
+			err: errSocks4aInvalidResponse,
+		},
+		{
+			name: "invalid reply version",
+			host: "127.0.0.1",
+			port: 8333,
+			script: []socks5Exchange{
+				{connectIPv4, []byte{0x04, 0x5a, 0, 0, 0, 0, 0,
+					0}},
+			},
+			err: errSocks4aInvalidResponse,
+		},
+		{
+			name: "truncated reply",
+			host: "127.0.0.1",
+			port: 8333,
+			script: []socks5Exchange{
+				{connectIPv4, []byte{0x00, 0x5a}},
+			},
+			err: io.ErrUnexpectedEOF,
+		},
+	}
+
+	for _, test := range tests {
+		client, server := net.Pipe()
+		done := runSocks5Proxy(server, test.script)
+
+		proxy := &socks4aProxy{}
+		err := proxy.handshake(client, test.host, test.port)
+		client.Close()
+		if err != test.err {
+			t.Errorf("%s: unexpected error: got %v, want %v",
+				test.name, err, test.err)
+		}
+		if err := <-done; err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+	}
+}
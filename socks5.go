@@ -0,0 +1,257 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/conformal/go-socks"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	socks5Version          = 0x05
+	socks5AuthNone         = 0x00
+	socks5AuthPassword     = 0x02
+	socks5AuthNoAcceptable = 0xff
+	socks5PasswordVersion  = 0x01
+	socks5CmdConnect       = 0x01
+	socks5AtypIPv4         = 0x01
+	socks5AtypDomain       = 0x03
+	socks5AtypIPv6         = 0x04
+	socks5Succeeded        = 0x00
+)
+
+var (
+	errSocks5InvalidResponse = errors.New("Invalid SOCKS5 proxy response")
+	errSocks5NoAuthMethod    = errors.New("SOCKS5 proxy does not support any offered authentication method")
+	errSocks5AuthFailed      = errors.New("SOCKS5 proxy authentication failed")
+	errSocks5HostTooLong     = errors.New("SOCKS5 hostname is too long")
+	errSocks5CredsTooLong    = errors.New("SOCKS5 username and password " +
+		"may not be longer than 255 bytes")
+
+	socks5StatusErrors = map[byte]error{
+		0x01: errors.New("SOCKS5 general server failure"),
+		0x02: errors.New("SOCKS5 connection not allowed by ruleset"),
+		0x03: errors.New("SOCKS5 network unreachable"),
+		0x04: errors.New("SOCKS5 host unreachable"),
+		0x05: errors.New("SOCKS5 connection refused"),
+		0x06: errors.New("SOCKS5 TTL expired"),
+		0x07: errors.New("SOCKS5 command not supported"),
+		0x08: errors.New("SOCKS5 address type not supported"),
+	}
+)
+
+// socks5Proxy dials connections through a SOCKS5 proxy.  It is used instead of
+// the go-socks package when a bind address (--bindaddr) was specified since
+// go-socks always connects to the proxy with net.Dial while the connection to
+// the proxy must originate from the bind address.  The returned connections report their
+// remote address as a socks.ProxiedAddr the same way as the connections
+// returned by go-socks.
+type socks5Proxy struct {
+	Addr     string
+	Username string
+	Password string
+}
+
+// socks5Conn is a connection made through a SOCKS5 proxy which reports the
+// proxied destination as its remote address.
+type socks5Conn struct {
+	net.Conn
+	remoteAddr *socks.ProxiedAddr
+}
+
+// RemoteAddr returns the address of the proxied destination.  It is part of the
+// net.Conn interface.
+func (c *socks5Conn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// authenticate negotiates the authentication method with the proxy over the
+// passed connection and authenticates with the credentials of the proxy when
+// the proxy requires them.
+func (p *socks5Proxy) authenticate(conn net.Conn) error {
+	// The lengths of the credentials are sent as a single byte, so reject
+	// longer ones before talking to the proxy rather than truncating them.
+	if len(p.Username) > 255 || len(p.Password) > 255 {
+		return errSocks5CredsTooLong
+	}
+
+	// The greeting is of the form:
+	//  version (1) | number of methods (1) | methods
+	methods := []byte{socks5AuthNone}
+	if p.Username != "" {
+		methods = append(methods, socks5AuthPassword)
+	}
+	buf := []byte{socks5Version, byte(len(methods))}
+	buf = append(buf, methods...)
+	if _, err := conn.Write(buf); err != nil {
+		return err
+	}
+
+	// The reply is of the form:
+	//  version (1) | method (1)
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != socks5Version {
+		return errSocks5InvalidResponse
+	}
+	switch reply[1] {
+	case socks5AuthNone:
+		return nil
+
+	case socks5AuthPassword:
+		if p.Username == "" {
+			return errSocks5InvalidResponse
+		}
+
+	case socks5AuthNoAcceptable:
+		return errSocks5NoAuthMethod
+
+	default:
+		return errSocks5InvalidResponse
+	}
+
+	// The username/password request is of the form:
+	//  version (1) | username length (1) | username | password length (1) |
+	//  password
+	buf = []byte{socks5PasswordVersion, byte(len(p.Username))}
+	buf = append(buf, p.Username...)
+	buf = append(buf, byte(len(p.Password)))
+	buf = append(buf, p.Password...)
+	if _, err := conn.Write(buf); err != nil {
+		return err
+	}
+
+	// The reply is of the form:
+	//  version (1) | status (1)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != socks5Succeeded {
+		return errSocks5AuthFailed
+	}
+	return nil
+}
+
+// handshake authenticates with the proxy over the passed connection and asks
+// it to connect to the passed host and port.  Hostnames are passed through to
+// the proxy for resolution.
+func (p *socks5Proxy) handshake(conn net.Conn, host string, port uint16) error {
+	if err := p.authenticate(conn); err != nil {
+		return err
+	}
+
+	// The request is of the form:
+	//  version (1) | command (1) | reserved (1) | address type (1) |
+	//  address | port (2)
+	buf := []byte{socks5Version, socks5CmdConnect, 0}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		if len(host) > 255 {
+			return errSocks5HostTooLong
+		}
+		buf = append(buf, socks5AtypDomain, byte(len(host)))
+		buf = append(buf, host...)
+	case ip.To4() != nil:
+		buf = append(buf, socks5AtypIPv4)
+		buf = append(buf, ip.To4()...)
+	default:
+		buf = append(buf, socks5AtypIPv6)
+		buf = append(buf, ip.To16()...)
+	}
+	var portBytes [2]byte
+	binary.BigEndian.PutUint16(portBytes[:], port)
+	buf = append(buf, portBytes[:]...)
+	if _, err := conn.Write(buf); err != nil {
+		return err
+	}
+
+	// The reply is of the form:
+	//  version (1) | status (1) | reserved (1) | address type (1) |
+	//  bound address | bound port (2)
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != socks5Version {
+		return errSocks5InvalidResponse
+	}
+	if reply[1] != socks5Succeeded {
+		if err, ok := socks5StatusErrors[reply[1]]; ok {
+			return err
+		}
+		return errSocks5InvalidResponse
+	}
+
+	// Discard the bound address and port since they are not needed.
+	var boundLen int
+	switch reply[3] {
+	case socks5AtypIPv4:
+		boundLen = net.IPv4len + 2
+	case socks5AtypIPv6:
+		boundLen = net.IPv6len + 2
+	case socks5AtypDomain:
+		if _, err := io.ReadFull(conn, reply[:1]); err != nil {
+			return err
+		}
+		boundLen = int(reply[0]) + 2
+	default:
+		return errSocks5InvalidResponse
+	}
+	_, err := io.ReadFull(conn, make([]byte, boundLen))
+	return err
+}
+
+// Dial connects to the address on the named network through the proxy.  Only
+// the tcp networks are supported.
+func (p *socks5Proxy) Dial(network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("SOCKS5 does not support network %q",
+			network)
+	}
+
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+
+	// The whole exchange with the proxy, including connecting to it, must
+	// complete within the peer timeout so an unresponsive proxy or
+	// destination doesn't hold up the connection attempt indefinitely.
+	deadline := time.Now().Add(cfg.PeerTimeout)
+	conn, err := directDial("tcp", p.Addr)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(deadline)
+	if err := p.handshake(conn, host, uint16(port)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+	return &socks5Conn{
+		Conn: conn,
+		remoteAddr: &socks.ProxiedAddr{
+			Net:  network,
+			Host: host,
+			Port: int(port),
+		},
+	}, nil
+}
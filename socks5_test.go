@@ -0,0 +1,291 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// socks5Exchange is a single step of a scripted SOCKS5 proxy.  The proxy reads
+// the expected request from the client, if any, and then writes the reply, if
+// any.
+type socks5Exchange struct {
+	request []byte
+	reply   []byte
+}
+
+// runSocks5Proxy runs the passed script against the client on the other end of
+// the passed connection and returns any mismatch with the expected requests on
+// the returned channel once the script completes.
+func runSocks5Proxy(conn net.Conn, script []socks5Exchange) chan error {
+	done := make(chan error, 1)
+	go func() {
+		defer conn.Close()
+		for i, step := range script {
+			if len(step.request) > 0 {
+				buf := make([]byte, len(step.request))
+				if _, err := io.ReadFull(conn, buf); err != nil {
+					done <- fmt.Errorf("step %d: unable to "+
+						"read request: %v", i, err)
+					return
+				}
+				if !bytes.Equal(buf, step.request) {
+					done <- fmt.Errorf("step %d: unexpected "+
+						"request: got %x, want %x", i,
+						buf, step.request)
+					return
+				}
+			}
+			if len(step.reply) > 0 {
+				if _, err := conn.Write(step.reply); err != nil {
+					done <- fmt.Errorf("step %d: unable to "+
+						"write reply: %v", i, err)
+					return
+				}
+			}
+		}
+		done <- nil
+	}()
+	return done
+}
+
+// TestSocks5Handshake ensures the SOCKS5 handshake sends the expected
+// greeting, authentication, and connect requests and handles the replies of
+// the proxy, including error replies, as expected.
+func TestSocks5Handshake(t *testing.T) {
+	// Common requests and replies.
+	greetNoAuth := []byte{0x05, 0x01, 0x00}
+	greetAuth := []byte{0x05, 0x02, 0x00, 0x02}
+	methodNone := []byte{0x05, 0x00}
+	methodPassword := []byte{0x05, 0x02}
+	connectIPv4 := []byte{0x05, 0x01, 0x00, 0x01, 127, 0, 0, 1, 0x20, 0x8d}
+	boundIPv4 := []byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0}
+
+	tests := []struct {
+		name   string
+		user   string
+		pass   string
+		host   string
+		port   uint16
+		script []socks5Exchange
+		err    error
+	}{
+		{
+			name: "no auth ipv4",
+			host: "127.0.0.1",
+			port: 8333,
+			script: []socks5Exchange{
+				{greetNoAuth, methodNone},
+				{connectIPv4, boundIPv4},
+			},
+		},
+		{
+			name: "no auth ipv6",
+			host: "::1",
+			port: 8333,
+			script: []socks5Exchange{
+				{greetNoAuth, methodNone},
+				{
+					append(append([]byte{0x05, 0x01, 0x00,
+						0x04}, net.IPv6loopback...),
+						0x20, 0x8d),
+					append(append([]byte{0x05, 0x00, 0x00,
+						0x04}, net.IPv6zero...), 0, 0),
+				},
+			},
+		},
+		{
+			name: "no auth hostname",
+			host: "example.com",
+			port: 8333,
+			script: []socks5Exchange{
+				{greetNoAuth, methodNone},
+				{
+					append(append([]byte{0x05, 0x01, 0x00,
+						0x03, 11}, "example.com"...),
+						0x20, 0x8d),
+					append(append([]byte{0x05, 0x00, 0x00,
+						0x03, 4}, "host"...), 0, 0),
+				},
+			},
+		},
+		{
+			name: "password auth",
+			user: "user",
+			pass: "pass",
+			host: "127.0.0.1",
+			port: 8333,
+			script: []socks5Exchange{
+				{greetAuth, methodPassword},
+				{
+					append(append([]byte{0x01, 4}, "user"...),
+						append([]byte{4}, "pass"...)...),
+					[]byte{0x01, 0x00},
+				},
+				{connectIPv4, boundIPv4},
+			},
+		},
+		{
+			name: "credentials offered but not required",
+			user: "user",
+			pass: "pass",
+			host: "127.0.0.1",
+			port: 8333,
+			script: []socks5Exchange{
+				{greetAuth, methodNone},
+				{connectIPv4, boundIPv4},
+			},
+		},
+		{
+			name: "password auth rejected",
+			user: "user",
+			pass: "wrong",
+			host: "127.0.0.1",
+			port: 8333,
+			script: []socks5Exchange{
+				{greetAuth, methodPassword},
+				{
+					append(append([]byte{0x01, 4}, "user"...),
+						append([]byte{5}, "wrong"...)...),
+					[]byte{0x01, 0x01},
+				},
+			},
+			err: errSocks5AuthFailed,
+		},
+		{
+			name: "password required without credentials",
+			host: "127.0.0.1",
+			port: 8333,
+			script: []socks5Exchange{
+				{greetNoAuth, methodPassword},
+			},
+			err: errSocks5InvalidResponse,
+		},
+		{
+			name: "no acceptable auth method",
+			host: "127.0.0.1",
+			port: 8333,
+			script: []socks5Exchange{
+				{greetNoAuth, []byte{0x05, 0xff}},
+			},
+			err: errSocks5NoAuthMethod,
+		},
+		{
+			name: "username too long",
+			user: strings.Repeat("u", 256),
+			pass: "pass",
+			host: "127.0.0.1",
+			port: 8333,
+			err:  errSocks5CredsTooLong,
+		},
+		{
+			name: "password too long",
+			user: "user",
+			pass: strings.Repeat("p", 256),
+			host: "127.0.0.1",
+			port: 8333,
+			err:  errSocks5CredsTooLong,
+		},
+		{
+			name: "hostname too long",
+			host: strings.Repeat("h", 256),
+			port: 8333,
+			script: []socks5Exchange{
+				{greetNoAuth, methodNone},
+			},
+			err: errSocks5HostTooLong,
+		},
+		{
+			name: "invalid greeting reply version",
+			host: "127.0.0.1",
+			port: 8333,
+			script: []socks5Exchange{
+				{greetNoAuth, []byte{0x04, 0x00}},
+			},
+			err: errSocks5InvalidResponse,
+		},
+		{
+			name: "connection refused",
+			host: "127.0.0.1",
+			port: 8333,
+			script: []socks5Exchange{
+				{greetNoAuth, methodNone},
+				{connectIPv4, []byte{0x05, 0x05, 0x00, 0x01}},
+			},
+			err: socks5StatusErrors[0x05],
+		},
+		{
+			name: "host unreachable",
+			host: "127.0.0.1",
+			port: 8333,
+			script: []socks5Exchange{
+				{greetNoAuth, methodNone},
+				{connectIPv4, []byte{0x05, 0x04, 0x00, 0x01}},
+			},
+			err: socks5StatusErrors[0x04],
+		},
+		{
+			name: "unknown status",
+			host: "127.0.0.1",
+			port: 8333,
+			script: []socks5Exchange{
+				{greetNoAuth, methodNone},
+				{connectIPv4, []byte{0x05, 0x42, 0x00, 0x01}},
+			},
+			err: errSocks5InvalidResponse,
+		},
+		{
+			name: "invalid connect reply version",
+			host: "127.0.0.1",
+			port: 8333,
+			script: []socks5Exchange{
+				{greetNoAuth, methodNone},
+				{connectIPv4, []byte{0x04, 0x00, 0x00, 0x01}},
+			},
+			err: errSocks5InvalidResponse,
+		},
+		{
+			name: "invalid bound address type",
+			host: "127.0.0.1",
+			port: 8333,
+			script: []socks5Exchange{
+				{greetNoAuth, methodNone},
+				{connectIPv4, []byte{0x05, 0x00, 0x00, 0x09}},
+			},
+			err: errSocks5InvalidResponse,
+		},
+		{
+			name: "truncated reply",
+			host: "127.0.0.1",
+			port: 8333,
+			script: []socks5Exchange{
+				{greetNoAuth, methodNone},
+				{connectIPv4, []byte{0x05, 0x00}},
+			},
+			err: io.ErrUnexpectedEOF,
+		},
+	}
+
+	for _, test := range tests {
+		client, server := net.Pipe()
+		done := runSocks5Proxy(server, test.script)
+
+		proxy := &socks5Proxy{Username: test.user, Password: test.pass}
+		err := proxy.handshake(client, test.host, test.port)
+		client.Close()
+		if err != test.err {
+			t.Errorf("%s: unexpected error: got %v, want %v",
+				test.name, err, test.err)
+		}
+		if err := <-done; err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+	}
+}